@@ -0,0 +1,68 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envAccessFormatHelper 设置时测试二进制以其值为访问日志格式运行 App，
+// 端口由 envAccessFormatPort 给出
+const (
+	envAccessFormatHelper = "SOCKS5_TEST_ACCESS_FORMAT_HELPER"
+	envAccessFormatPort   = "SOCKS5_TEST_ACCESS_FORMAT_PORT"
+)
+
+func TestAccessFormatHelperProcess(t *testing.T) {
+	format, ok := os.LookupEnv(envAccessFormatHelper)
+	if !ok {
+		t.Skip("helper process for TestAccessFormatStartupError")
+	}
+	cfg := DefaultConfig()
+	cfg.Port, _ = strconv.Atoi(os.Getenv(envAccessFormatPort))
+	cfg.AccessLog = os.DevNull
+	cfg.AccessLogFormat = format
+	New(cfg).Run()
+	os.Exit(0)
+}
+
+// 无法解析的模板、引用不存在字段的模板和未知的格式名都在启动时报错退出，不会开始监听
+func TestAccessFormatStartupError(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, format, want string
+	}{
+		{"bad action", "{{.Client} {{.Dst}}", "access log template: template: access-log:1: bad character U+007D '}'"},
+		{"unterminated if", "{{if .User}}{{.User}}", "access log template: template: access-log:1: unexpected EOF"},
+		{"unknown function", "{{upper .User}}", `access log template: template: access-log:1: function "upper" not defined`},
+		{"unknown field", "{{.Client}} {{.Bytes}}", "can't evaluate field Bytes"},
+		{"unknown format", "xml", `unknown access log format "xml"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			port := freePort(t)
+			cmd := exec.Command(exe, "-test.run=^TestAccessFormatHelperProcess$")
+			cmd.Env = append(os.Environ(), envAccessFormatHelper+"="+tc.format, envAccessFormatPort+"="+strconv.Itoa(port))
+			cmd.WaitDelay = time.Second
+			timer := time.AfterFunc(10*time.Second, func() { cmd.Process.Kill() })
+			defer timer.Stop()
+			out, err := cmd.CombinedOutput()
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+				t.Fatalf("helper exited with %v, want status 1:\n%s", err, out)
+			}
+			if !strings.Contains(string(out), "Config error: invalid access-log-format: ") || !strings.Contains(string(out), tc.want) {
+				t.Fatalf("output does not report %q:\n%s", tc.want, out)
+			}
+			if strings.Contains(string(out), "is listening") {
+				t.Fatalf("started listening with a bad format:\n%s", out)
+			}
+		})
+	}
+}
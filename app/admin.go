@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"socks5/internal/core"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adminMaxBody 管理接口请求体的上限
+const adminMaxBody = 1 << 20
+
+// adminAPI 管理接口：以 Bearer 令牌认证的 HTTP API，运行时修改白名单、黑名单和用户，
+// 每次修改都写入审计日志（User 为令牌对应的调用者）
+type adminAPI struct {
+	a      *App
+	tokens atomic.Pointer[map[string]string] // 令牌 -> 调用者名称，SIGHUP 时重新加载
+
+	// mu 串行化修改：读取当前列表、计算新列表、写回文件、应用到服务器期间不会丢失并发的修改
+	mu sync.Mutex
+}
+
+// adminCallerKey 请求 context 中保存调用者名称的键
+type adminCallerKey struct{}
+
+// loadAdminTokens 读取管理接口令牌文件，每行 名称 令牌，支持 # 注释和空行
+func loadAdminTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	names := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want name and token", path, line)
+		}
+		name, token := fields[0], fields[1]
+		if len(token) < 16 {
+			return nil, fmt.Errorf("%s:%d: token of %s is shorter than 16 characters", path, line, name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%s:%d: duplicate name %s", path, line, name)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("%s:%d: token of %s is already used", path, line, name)
+		}
+		names[name] = true
+		tokens[token] = name
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// listenAdmin 绑定管理接口，随服务端启动和停止
+func (a *App) listenAdmin() (net.Addr, error) {
+	tokens, err := loadAdminTokens(a.Config.AdminTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", a.Config.AdminListen)
+	if err != nil {
+		return nil, err
+	}
+	api := &adminAPI{a: a}
+	api.tokens.Store(&tokens)
+	a.admin = api
+	srv := &http.Server{Handler: api.handler(), ReadHeaderTimeout: 10 * time.Second}
+	a.Server.AddComponent(core.Component{Name: "admin", Stage: core.StageAccept, Start: func(ctx context.Context) error {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}, Stop: srv.Close})
+	return ln.Addr(), nil
+}
+
+// reloadTokens 重新加载令牌文件，失败时保留原有的令牌
+func (api *adminAPI) reloadTokens() error {
+	tokens, err := loadAdminTokens(api.a.Config.AdminTokensFile)
+	if err != nil {
+		return err
+	}
+	api.tokens.Store(&tokens)
+	log.Printf("Admin tokens reloaded: %d tokens\n", len(tokens))
+	return nil
+}
+
+func (api *adminAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /acl/{list}", api.getList)
+	mux.HandleFunc("PUT /acl/{list}", api.putList)
+	mux.HandleFunc("PATCH /acl/{list}", api.patchList)
+	mux.HandleFunc("GET /status", api.status)
+	mux.HandleFunc("GET /udp", api.udpAssociations)
+	mux.HandleFunc("GET /top-destinations", api.topDestinations)
+	mux.HandleFunc("GET /users", api.listUsers)
+	mux.HandleFunc("POST /users", api.createUser)
+	mux.HandleFunc("PATCH /users/{user}", api.updateUser)
+	mux.HandleFunc("DELETE /users/{user}", api.deleteUser)
+	return api.authenticate(mux)
+}
+
+// authenticate 检查 Authorization: Bearer 令牌，通过后把调用者名称放入请求 context，失败记入审计日志
+func (api *adminAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		name := ""
+		if ok {
+			// 逐个比较所有令牌，耗时不随匹配的位置变化
+			for t, n := range *api.tokens.Load() {
+				if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+					name = n
+				}
+			}
+		}
+		if name == "" {
+			api.a.Server.Audit.Log(&core.AuditEvent{Event: core.AuditAuthFailure, Client: r.RemoteAddr, Rule: "admin", Detail: r.Method + " " + r.URL.Path})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="socks5 admin"`)
+			writeAdminError(w, http.StatusUnauthorized, "unauthorized", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCallerKey{}, name)))
+	})
+}
+
+// adminCaller 返回 authenticate 认证的调用者名称
+func adminCaller(r *http.Request) string {
+	name, _ := r.Context().Value(adminCallerKey{}).(string)
+	return name
+}
+
+// ipList 管理接口可修改的白名单或黑名单
+type ipList struct {
+	name string // whitelist 或 blacklist，也用作审计日志的 Rule
+	file string // 持久化的文件，为空时不能持久化
+	get  func() []string
+	set  func([]string)
+}
+
+func (api *adminAPI) ipList(r *http.Request) (ipList, bool) {
+	s := api.a.Server
+	switch r.PathValue("list") {
+	case "whitelist":
+		return ipList{"whitelist", api.a.Config.WhitelistFile, s.Whitelist, s.SetWhitelist}, true
+	case "blacklist":
+		return ipList{"blacklist", api.a.Config.BlacklistFile, s.Blacklist, s.SetBlacklist}, true
+	}
+	return ipList{}, false
+}
+
+// ipListResponse 白名单或黑名单接口的应答
+type ipListResponse struct {
+	Entries   []string `json:"entries"`
+	Closed    *int     `json:"closed,omitempty"` // 修改后关闭的会话数
+	Persisted bool     `json:"persisted,omitempty"`
+}
+
+// ipListPut PUT 的请求体，替换整个列表
+type ipListPut struct {
+	Entries []string `json:"entries"`
+}
+
+// ipListPatch PATCH 的请求体，先删除再添加，删除不存在的条目不是错误
+type ipListPatch struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// adminError 管理接口的错误应答，Invalid 为无效的条目
+type adminError struct {
+	Error   string   `json:"error"`
+	Invalid []string `json:"invalid,omitempty"`
+}
+
+func (api *adminAPI) getList(w http.ResponseWriter, r *http.Request) {
+	l, ok := api.ipList(r)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "unknown list "+r.PathValue("list"), nil)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, ipListResponse{Entries: l.get()})
+}
+
+func (api *adminAPI) putList(w http.ResponseWriter, r *http.Request) {
+	var body ipListPut
+	api.modifyList(w, r, &body, func(cur []string) ([]string, string, []string) {
+		entries, invalid := normalizeIPEntries(body.Entries)
+		return entries, fmt.Sprintf("replace with %d entries", len(entries)), invalid
+	})
+}
+
+func (api *adminAPI) patchList(w http.ResponseWriter, r *http.Request) {
+	var body ipListPatch
+	api.modifyList(w, r, &body, func(cur []string) ([]string, string, []string) {
+		add, invalid := normalizeIPEntries(body.Add)
+		remove, invalidRemove := normalizeIPEntries(body.Remove)
+		entries := slices.DeleteFunc(cur, func(e string) bool { return slices.Contains(remove, e) })
+		entries = append(entries, add...)
+		slices.Sort(entries)
+		var detail []string
+		if len(add) > 0 {
+			detail = append(detail, "add "+strings.Join(add, ","))
+		}
+		if len(remove) > 0 {
+			detail = append(detail, "remove "+strings.Join(remove, ","))
+		}
+		return slices.Compact(entries), strings.Join(detail, "; "), append(invalid, invalidRemove...)
+	})
+}
+
+// modifyList 解析请求体，由 change 根据当前列表计算新列表，有无效条目时不做任何修改；
+// ?persist=true 时先写回文件，写入成功后才应用；应用后按新的访问控制关闭会话并写入审计日志
+func (api *adminAPI) modifyList(w http.ResponseWriter, r *http.Request, body any, change func(cur []string) (entries []string, detail string, invalid []string)) {
+	l, ok := api.ipList(r)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "unknown list "+r.PathValue("list"), nil)
+		return
+	}
+	persist := false
+	if v := r.URL.Query().Get("persist"); v != "" {
+		var err error
+		if persist, err = strconv.ParseBool(v); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid persist value "+strconv.Quote(v), nil)
+			return
+		}
+	}
+	if persist && l.file == "" {
+		writeAdminError(w, http.StatusBadRequest, "cannot persist: no -"+l.name+"-file configured", nil)
+		return
+	}
+	if !decodeAdminBody(w, r, body) {
+		return
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	entries, detail, invalid := change(l.get())
+	if len(invalid) > 0 {
+		writeAdminError(w, http.StatusBadRequest, "invalid entries", invalid)
+		return
+	}
+	if persist {
+		if err := writeIPListFile(l.file, entries); err != nil {
+			log.Printf("Admin API: cannot write %s: %v", l.file, err)
+			writeAdminError(w, http.StatusInternalServerError, "cannot write "+l.name+" file", nil)
+			return
+		}
+		detail += "; persisted"
+	}
+	l.set(entries)
+	closed := api.a.Server.SweepDenied("admin " + l.name + " change").Closed
+	caller := adminCaller(r)
+	log.Printf("Admin %s changed the %s: %s (%d entries)", caller, l.name, detail, len(entries))
+	api.a.Server.Audit.Log(&core.AuditEvent{Event: core.AuditAdmin, Client: r.RemoteAddr, User: caller, Rule: l.name, Detail: detail})
+	writeAdminJSON(w, http.StatusOK, ipListResponse{Entries: l.get(), Closed: &closed, Persisted: persist})
+}
+
+// normalizeIPEntries 规范化条目，返回有效的条目（已排序去重）和无效条目的说明
+func normalizeIPEntries(list []string) (entries, invalid []string) {
+	for _, e := range list {
+		n, err := core.NormalizeIPEntry(e)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		entries = append(entries, n)
+	}
+	slices.Sort(entries)
+	return slices.Compact(entries), invalid
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, msg string, invalid []string) {
+	writeAdminJSON(w, code, adminError{Error: msg, Invalid: invalid})
+}
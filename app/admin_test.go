@@ -0,0 +1,235 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// adminDo 以 token 调用管理接口，返回状态码和应答体
+func adminDo(addr, token, method, path, body string) (int, []byte, error) {
+	req, err := http.NewRequest(method, "http://"+addr+path, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+// connectEcho 不经 testing.T 经代理 CONNECT 到 echo 并收发一次，可在其他 goroutine 中使用
+func connectEcho(proxy, echo string) error {
+	c, err := net.DialTimeout("tcp", proxy, socks5test.Timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	if _, err := core.NewNegotiationRequest([]byte{core.MethodNone}).WriteTo(c); err != nil {
+		return err
+	}
+	if _, err := core.NewNegotiationReplyFrom(c); err != nil {
+		return fmt.Errorf("negotiation: %w", err)
+	}
+	atyp, addr, port, err := core.ParseAddress(echo)
+	if err != nil {
+		return err
+	}
+	if _, err := core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(c); err != nil {
+		return err
+	}
+	rp, err := core.NewReplyFrom(c)
+	if err != nil {
+		return fmt.Errorf("reply: %w", err)
+	}
+	if rp.Rep != core.RepSuccess {
+		return fmt.Errorf("REP %#x", rp.Rep)
+	}
+	if _, err := c.Write([]byte("ping\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		return fmt.Errorf("echo %q: %v", line, err)
+	}
+	return nil
+}
+
+// 并发的 PATCH 不丢失修改，每次修改各有一条带调用者的审计记录，期间不在名单变更范围内的客户端不受影响
+func TestAdminParallelPatchUnderTraffic(t *testing.T) {
+	dir := t.TempDir()
+	tokens, blacklist := filepath.Join(dir, "tokens"), filepath.Join(dir, "blacklist")
+	callers := []struct{ name, token string }{{"ops", "0123456789abcdef0123"}, {"bot", "abcdef0123456789abcd"}}
+	os.WriteFile(tokens, []byte("ops 0123456789abcdef0123\nbot abcdef0123456789abcd\n"), 0o600)
+	os.WriteFile(blacklist, nil, 0o600)
+	cfg := DefaultConfig()
+	cfg.AdminListen = "127.0.0.1:" + strconv.Itoa(freePort(t))
+	cfg.AdminTokensFile = tokens
+	cfg.Whitelist = "127.0.0.0/8"
+	cfg.BlacklistFile = blacklist
+	ta := startApp(t, cfg)
+	echo := socks5test.EchoTCPServer(t)
+
+	stop := make(chan struct{})
+	var relays atomic.Int64
+	var traffic sync.WaitGroup
+	trafficErrs := make(chan error, 64)
+	for range 4 {
+		traffic.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := connectEcho(ta.addr, echo); err != nil {
+					trafficErrs <- err
+					return
+				}
+				relays.Add(1)
+			}
+		})
+	}
+
+	// 偶数号加入黑名单，其中每 4 个写回文件；奇数号加入白名单；每 5 个随后再删除
+	const n = 40
+	entry := func(i int) (list, ip string) {
+		if i%2 == 0 {
+			return "blacklist", "192.0.2." + strconv.Itoa(i)
+		}
+		return "whitelist", "203.0.113." + strconv.Itoa(i)
+	}
+	var patches sync.WaitGroup
+	patchErrs := make(chan error, 2*n)
+	for i := range n {
+		patches.Go(func() {
+			list, ip := entry(i)
+			c := callers[i%len(callers)]
+			path := "/acl/" + list
+			if i%4 == 0 {
+				path += "?persist=true"
+			}
+			if code, b, err := adminDo(cfg.AdminListen, c.token, "PATCH", path, `{"add":["`+ip+`"]}`); err != nil || code != http.StatusOK {
+				patchErrs <- fmt.Errorf("add %s: %d %s %v", ip, code, b, err)
+				return
+			}
+			if i%5 == 0 {
+				if code, b, err := adminDo(cfg.AdminListen, c.token, "PATCH", "/acl/"+list, `{"remove":["`+ip+`"]}`); err != nil || code != http.StatusOK {
+					patchErrs <- fmt.Errorf("remove %s: %d %s %v", ip, code, b, err)
+				}
+			}
+		})
+	}
+	patches.Wait()
+	// 修改期间持续有转发经过代理
+	for start := relays.Load(); relays.Load() == start; {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	traffic.Wait()
+	close(patchErrs)
+	close(trafficErrs)
+	for err := range patchErrs {
+		t.Error(err)
+	}
+	for err := range trafficErrs {
+		t.Errorf("relay during admin changes: %v", err)
+	}
+
+	want := map[string][]string{"whitelist": {"127.0.0.0/8"}}
+	for i := range n {
+		if list, ip := entry(i); i%5 != 0 {
+			want[list] = append(want[list], ip)
+		}
+	}
+	for list, entries := range want {
+		slices.Sort(entries)
+		code, b, err := adminDo(cfg.AdminListen, callers[0].token, "GET", "/acl/"+list, "")
+		if err != nil || code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s %v", list, code, b, err)
+		}
+		var got ipListResponse
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.Entries, entries) {
+			t.Errorf("%s:\n got %q\nwant %q", list, got.Entries, entries)
+		}
+	}
+	for i := range n {
+		list, ip := entry(i)
+		in := i%5 != 0
+		switch addr := net.ParseIP(ip); list {
+		case "blacklist":
+			if ta.Server.IsDenied(addr) != in {
+				t.Errorf("IsDenied(%s) = %v, want %v", ip, !in, in)
+			}
+		case "whitelist":
+			if ta.Server.IsAllowed(addr) != in {
+				t.Errorf("IsAllowed(%s) = %v, want %v", ip, !in, in)
+			}
+		}
+	}
+
+	// 每次修改一条审计记录，调用者为所用令牌的名称
+	evs := ta.auditEvents()
+	for i := range n {
+		list, ip := entry(i)
+		details := []string{"add " + ip}
+		if i%4 == 0 {
+			details[0] += "; persisted"
+		}
+		if i%5 == 0 {
+			details = append(details, "remove "+ip)
+		}
+		for _, d := range details {
+			var got []core.AuditEvent
+			for _, ev := range evs {
+				if ev.Event == core.AuditAdmin && ev.Rule == list && ev.Detail == d {
+					got = append(got, ev)
+				}
+			}
+			if len(got) != 1 || got[0].User != callers[i%len(callers)].name || !strings.HasPrefix(got[0].Client, "127.0.0.1:") {
+				t.Errorf("audit records for %s %q: %+v", list, d, got)
+			}
+		}
+	}
+
+	// 写回文件的是写入时的整个列表，再写回一次后与生效的黑名单一致
+	if code, b, err := adminDo(cfg.AdminListen, callers[0].token, "PATCH", "/acl/blacklist?persist=true", `{"add":["198.51.100.0/24"]}`); err != nil || code != http.StatusOK {
+		t.Fatalf("persist: %d %s %v", code, b, err)
+	}
+	b, err := os.ReadFile(blacklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var persisted []string
+	for line := range strings.Lines(string(b)) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			persisted = append(persisted, line)
+		}
+	}
+	if wantFile := append(want["blacklist"], "198.51.100.0/24"); !slices.Equal(persisted, wantFile) || !slices.Equal(ta.Server.Blacklist(), wantFile) {
+		t.Fatalf("blacklist file %q, effective %q, want %q", persisted, ta.Server.Blacklist(), wantFile)
+	}
+}
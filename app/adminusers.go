@@ -0,0 +1,286 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"socks5/internal/core"
+	"strconv"
+	"strings"
+)
+
+// userCreate POST /users 的请求体，Password 和 Hash（bcrypt）二选一
+type userCreate struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Hash     string `json:"hash"`
+	TOTP     string `json:"totp"`  // base32 TOTP 密钥
+	Quota    string `json:"quota"` // 流量上限，如 10G
+}
+
+// userPatch PATCH /users/{user} 的请求体，只修改出现的字段；TOTP、Quota 为空字符串时取消
+type userPatch struct {
+	Password *string `json:"password"`
+	Hash     *string `json:"hash"`
+	TOTP     *string `json:"totp"`
+	Quota    *string `json:"quota"`
+}
+
+// userDeleted DELETE /users/{user} 的应答
+type userDeleted struct {
+	Deleted string `json:"deleted"`
+	Closed  int    `json:"closed"` // terminate=true 时关闭的会话数
+}
+
+// errNoUsersFile 没有用户文件时不能管理用户：修改无处保存，重启后丢失
+var errNoUsersFile = errors.New("user management requires -users-file")
+
+// adminStatusError 带 HTTP 状态码的错误
+type adminStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *adminStatusError) Error() string { return e.msg }
+
+func badRequest(format string, args ...any) error {
+	return &adminStatusError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func (api *adminAPI) listUsers(w http.ResponseWriter, r *http.Request) {
+	if api.a.users == nil {
+		writeAdminError(w, http.StatusConflict, errNoUsersFile.Error(), nil)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string][]core.UserInfo{"users": api.a.users.Users()})
+}
+
+func (api *adminAPI) createUser(w http.ResponseWriter, r *http.Request) {
+	var body userCreate
+	if !decodeAdminBody(w, r, &body) {
+		return
+	}
+	ok := api.modifyUsers(w, r, body.User, func(c *core.StaticCredentials) (string, error) {
+		if c.Has(body.User) {
+			return "", &adminStatusError{http.StatusConflict, fmt.Sprintf("user %q already exists", body.User)}
+		}
+		secret, kind, err := userSecret(body.User, body.Password, body.Hash)
+		if err != nil {
+			return "", err
+		}
+		if secret == "" {
+			return "", badRequest("password or hash is required")
+		}
+		if err := c.Set(body.User, secret); err != nil {
+			return "", badRequest("%v", err)
+		}
+		detail := []string{kind}
+		if body.TOTP != "" {
+			if err := c.SetTOTP(body.User, body.TOTP); err != nil {
+				return "", badRequest("%v", err)
+			}
+			detail = append(detail, "totp")
+		}
+		if body.Quota != "" {
+			q, err := api.userQuota(body.Quota)
+			if err != nil {
+				return "", err
+			}
+			c.SetQuota(body.User, q)
+			detail = append(detail, "quota "+body.Quota)
+		}
+		return "create " + body.User + " (" + strings.Join(detail, ", ") + ")", nil
+	})
+	if ok {
+		writeAdminJSON(w, http.StatusCreated, api.userInfo(body.User))
+	}
+}
+
+func (api *adminAPI) updateUser(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	var body userPatch
+	if !decodeAdminBody(w, r, &body) {
+		return
+	}
+	ok := api.modifyUsers(w, r, user, func(c *core.StaticCredentials) (string, error) {
+		if !c.Has(user) {
+			return "", &adminStatusError{http.StatusNotFound, fmt.Sprintf("user %q does not exist", user)}
+		}
+		var detail []string
+		if body.Password != nil || body.Hash != nil {
+			secret, kind, err := userSecret(user, deref(body.Password), deref(body.Hash))
+			if err != nil {
+				return "", err
+			}
+			if secret == "" {
+				return "", badRequest("password or hash must not be empty")
+			}
+			if err := c.Set(user, secret); err != nil {
+				return "", badRequest("%v", err)
+			}
+			detail = append(detail, kind)
+		}
+		if body.TOTP != nil {
+			if err := c.SetTOTP(user, *body.TOTP); err != nil {
+				return "", badRequest("%v", err)
+			}
+			detail = append(detail, "totp")
+		}
+		if body.Quota != nil {
+			var q int64
+			if *body.Quota != "" && *body.Quota != "0" {
+				var err error
+				if q, err = api.userQuota(*body.Quota); err != nil {
+					return "", err
+				}
+			}
+			c.SetQuota(user, q)
+			detail = append(detail, "quota "+cmp.Or(*body.Quota, "none"))
+		}
+		if len(detail) == 0 {
+			return "", badRequest("nothing to update")
+		}
+		return "update " + user + ": " + strings.Join(detail, ", "), nil
+	})
+	if ok {
+		writeAdminJSON(w, http.StatusOK, api.userInfo(user))
+	}
+}
+
+func (api *adminAPI) deleteUser(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	terminate := false
+	if v := r.URL.Query().Get("terminate"); v != "" {
+		var err error
+		if terminate, err = strconv.ParseBool(v); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid terminate value "+strconv.Quote(v), nil)
+			return
+		}
+	}
+	ok := api.modifyUsers(w, r, user, func(c *core.StaticCredentials) (string, error) {
+		if !c.Has(user) {
+			return "", &adminStatusError{http.StatusNotFound, fmt.Sprintf("user %q does not exist", user)}
+		}
+		c.Delete(user)
+		return "delete " + user, nil
+	})
+	if !ok {
+		return
+	}
+	res := userDeleted{Deleted: user}
+	// 清理结果由 Sweep 写入审计日志
+	if terminate {
+		res.Closed = api.a.Server.Sweep("admin delete user", func(ip net.IP, sess *core.Session) bool {
+			return sess != nil && sess.Method == core.MethodUsernamePassword && sess.CertUser == "" && sess.User == user
+		}).Closed
+	}
+	writeAdminJSON(w, http.StatusOK, res)
+}
+
+// modifyUsers 在用户表的副本上执行 change，原子地写回用户文件后才替换正在使用的用户表并更新配额，
+// 成功时写入审计日志并返回 true，由调用方写出应答；change 或写入失败时不做任何修改，已写出错误应答
+func (api *adminAPI) modifyUsers(w http.ResponseWriter, r *http.Request, user string, change func(c *core.StaticCredentials) (string, error)) bool {
+	a := api.a
+	if a.users == nil {
+		writeAdminError(w, http.StatusConflict, errNoUsersFile.Error(), nil)
+		return false
+	}
+	if err := core.ValidateUser(user, ""); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error(), nil)
+		return false
+	}
+
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+	c := a.users.Clone()
+	detail, err := change(c)
+	if err != nil {
+		code := http.StatusBadRequest
+		var se *adminStatusError
+		if errors.As(err, &se) {
+			code = se.code
+		}
+		writeAdminError(w, code, err.Error(), nil)
+		return false
+	}
+	if err := writeFileAtomic(a.Config.UsersFile, c.Format()); err != nil {
+		log.Printf("Admin API: cannot write %s: %v", a.Config.UsersFile, err)
+		writeAdminError(w, http.StatusInternalServerError, "cannot write users file", nil)
+		return false
+	}
+	a.users.Replace(c)
+	if a.Server.Quotas != nil {
+		a.Server.Quotas.SetLimits(a.quotaLimits())
+	}
+	caller := adminCaller(r)
+	log.Printf("Admin %s changed the users: %s", caller, detail)
+	a.Server.Audit.Log(&core.AuditEvent{Event: core.AuditAdmin, Client: r.RemoteAddr, User: caller, Rule: "users", Detail: detail})
+	return true
+}
+
+// userInfo 返回用户的设置
+func (api *adminAPI) userInfo(user string) core.UserInfo {
+	users := api.a.users.Users()
+	if i := slices.IndexFunc(users, func(u core.UserInfo) bool { return u.User == user }); i >= 0 {
+		return users[i]
+	}
+	return core.UserInfo{User: user}
+}
+
+// userSecret 检查请求中的密码或 bcrypt 散列（只能给出一个），返回要保存的值和审计中的说明
+func userSecret(user, password, hash string) (secret, kind string, err error) {
+	switch {
+	case password != "" && hash != "":
+		return "", "", badRequest("password and hash are mutually exclusive")
+	case hash != "":
+		if !core.IsPasswordHash(hash) {
+			return "", "", badRequest("hash of user %q is not a bcrypt hash", user)
+		}
+		secret, kind = hash, "hash"
+	case password != "":
+		// 以散列开头的明文密码在重新加载用户文件后会被当作散列
+		if core.IsPasswordHash(password) {
+			return "", "", badRequest("password of user %q looks like a bcrypt hash, send it as hash", user)
+		}
+		secret, kind = password, "password"
+	}
+	if err := core.ValidateUser(user, secret); err != nil {
+		return "", "", badRequest("%v", err)
+	}
+	return secret, kind, nil
+}
+
+// userQuota 解析流量上限，没有启用配额时不能设置
+func (api *adminAPI) userQuota(v string) (int64, error) {
+	if api.a.Server.Quotas == nil {
+		return 0, &adminStatusError{http.StatusConflict, "traffic quotas are not enabled; start with -quota or a quota= user in the users file"}
+	}
+	n, err := core.ParseBytes(v)
+	if err != nil {
+		return 0, badRequest("%v", err)
+	}
+	return n, nil
+}
+
+// decodeAdminBody 解析 JSON 请求体，不认识的字段视为错误，失败时已写出应答
+func decodeAdminBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid request body: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// refusedBeforeNegotiation 报告连接是否在方法协商应答之前就被关闭
+func refusedBeforeNegotiation(t *testing.T, proxy string) bool {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		return true
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	core.NewNegotiationRequest([]byte{core.MethodNone, core.MethodUsernamePassword}).WriteTo(c)
+	_, err = core.NewNegotiationReplyFrom(c)
+	return err != nil
+}
+
+// authRejected 报告用户名密码子协商是否失败
+func authRejected(t *testing.T, proxy, user, pass string) bool {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+	if rp, err := core.NewNegotiationReplyFrom(c); err != nil || rp.Method != core.MethodUsernamePassword {
+		return true
+	}
+	core.NewUserPassNegotiationRequest([]byte(user), []byte(pass)).WriteTo(c)
+	urp, err := core.NewUserPassNegotiationReplyFrom(c)
+	return err != nil || urp.Status != core.UserPassStatusSuccess
+}
+
+func TestAppRelay(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Username, cfg.Password = "alice", "secret"
+	cfg.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	ta := startApp(t, cfg)
+	auth := &socks5test.Auth{User: "alice", Password: "secret"}
+
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ta.addr, auth, echo)
+	socks5test.EchoTCP(t, c, []byte("through app"))
+	c.Close()
+
+	uecho := socks5test.EchoUDPServer(t)
+	ctl, relay := socks5test.Associate(t, ta.addr, auth)
+	socks5test.EchoUDP(t, relay, uecho, []byte("udp through app"))
+	ctl.Close()
+
+	if !authRejected(t, ta.addr, "alice", "wrong") {
+		t.Fatal("wrong password accepted")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		b, _ := os.ReadFile(cfg.AccessLog)
+		if strings.Count(string(b), "\n") >= 2 {
+			if !strings.Contains(string(b), `"user":"alice"`) || !strings.Contains(string(b), echo) {
+				t.Fatalf("access log:\n%s", b)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("access log has no records:\n%s", b)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAppWhitelistAndBlacklist(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	for _, tc := range []struct {
+		name    string
+		set     func(*Config)
+		refused bool
+	}{
+		{"whitelisted", func(c *Config) { c.Whitelist = "127.0.0.0/8" }, false},
+		{"not whitelisted", func(c *Config) { c.Whitelist = "192.0.2.1" }, true},
+		{"blacklisted", func(c *Config) { c.Blacklist = "127.0.0.1" }, true},
+		{"blacklist wins", func(c *Config) { c.Whitelist, c.Blacklist = "127.0.0.0/8", "127.0.0.1/32" }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tc.set(cfg)
+			ta := startApp(t, cfg)
+			if got := refusedBeforeNegotiation(t, ta.addr); got != tc.refused {
+				t.Fatalf("refused = %v, want %v", got, tc.refused)
+			}
+			if !tc.refused {
+				c := socks5test.Connect(t, ta.addr, nil, echo)
+				socks5test.EchoTCP(t, c, []byte("allowed"))
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestAppCommands(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	for _, tc := range []struct {
+		name         string
+		set          func(*Config)
+		connect, udp byte
+	}{
+		{"default", func(*Config) {}, core.RepSuccess, core.RepSuccess},
+		{"disable udp", func(c *Config) { c.DisableUDP = true }, core.RepSuccess, core.RepCommandNotSupported},
+		{"disable connect", func(c *Config) { c.DisableConnect = true }, core.RepCommandNotSupported, core.RepSuccess},
+		{"reject mode", func(c *Config) { c.Mode = "reject" }, core.RepServerFailure, core.RepServerFailure},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tc.set(cfg)
+			ta := startApp(t, cfg)
+			socks5test.ExpectRep(t, ta.addr, nil, core.CmdConnect, echo, tc.connect).Close()
+			socks5test.ExpectRep(t, ta.addr, nil, core.CmdUDP, "0.0.0.0:0", tc.udp).Close()
+			socks5test.ExpectRep(t, ta.addr, nil, core.CmdBind, echo, core.RepCommandNotSupported).Close()
+		})
+	}
+}
+
+func TestAppUsersFileReload(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, []byte("alice secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.UsersFile = users
+	ta := startApp(t, cfg)
+	echo := socks5test.EchoTCPServer(t)
+
+	if authRejected(t, ta.addr, "alice", "secret") {
+		t.Fatal("alice rejected")
+	}
+	if !authRejected(t, ta.addr, "bob", "hunter2") {
+		t.Fatal("bob accepted before being added")
+	}
+
+	if err := os.WriteFile(users, []byte("bob hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ta.reload()
+	ta.waitAudit(core.AuditReload, nil)
+	c := socks5test.Connect(t, ta.addr, &socks5test.Auth{User: "bob", Password: "hunter2"}, echo)
+	socks5test.EchoTCP(t, c, []byte("bob after reload"))
+	c.Close()
+	if !authRejected(t, ta.addr, "alice", "secret") {
+		t.Fatal("alice still accepted after being removed")
+	}
+}
+
+func TestAppCommandAuth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Username, cfg.Password = "alice", "secret"
+	cfg.CommandAuth = "connect=none,udp=required"
+	ta := startApp(t, cfg)
+	echo := socks5test.EchoTCPServer(t)
+	auth := &socks5test.Auth{User: "alice", Password: "secret"}
+
+	c := socks5test.Connect(t, ta.addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("anonymous connect"))
+	c.Close()
+	socks5test.ExpectRep(t, ta.addr, nil, core.CmdUDP, "0.0.0.0:0", core.RepNotAllowed).Close()
+
+	uecho := socks5test.EchoUDPServer(t)
+	ctl, relay := socks5test.Associate(t, ta.addr, auth)
+	socks5test.EchoUDP(t, relay, uecho, []byte("authenticated udp"))
+	ctl.Close()
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+)
+
+func TestAuditLifecycleAndAdmin(t *testing.T) {
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	os.WriteFile(tokens, []byte("ops 0123456789abcdef0123\n"), 0o600)
+	cfg := DefaultConfig()
+	cfg.AdminListen = "127.0.0.1:" + strconv.Itoa(freePort(t))
+	cfg.AdminTokensFile = tokens
+	ta := startApp(t, cfg)
+
+	ev := ta.waitAudit(core.AuditStartup, nil)
+	if !strings.Contains(ev.Detail, "mode proxy") || ev.Time.IsZero() {
+		t.Fatalf("startup %+v", ev)
+	}
+
+	ta.reload()
+	if ev := ta.waitAudit(core.AuditReload, nil); ev.Detail != "ok" {
+		t.Fatalf("config_reload %+v", ev)
+	}
+
+	patch := func(token string) *http.Response {
+		req, _ := http.NewRequest("PATCH", "http://"+cfg.AdminListen+"/acl/blacklist", strings.NewReader(`{"add":["192.0.2.7"]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := patch("wrong-token-wrong-token"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("bad token: %s", resp.Status)
+	}
+	ev = ta.waitAudit(core.AuditAuthFailure, func(ev core.AuditEvent) bool { return ev.Rule == "admin" })
+	if ev.Detail != "PATCH /acl/blacklist" || !strings.HasPrefix(ev.Client, "127.0.0.1:") || strings.Contains(ev.Detail+ev.User, "wrong-token") {
+		t.Fatalf("admin auth_failure %+v", ev)
+	}
+	if resp := patch("0123456789abcdef0123"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch: %s", resp.Status)
+	}
+	ev = ta.waitAudit(core.AuditAdmin, nil)
+	if ev.User != "ops" || ev.Rule != "blacklist" || ev.Detail != "add 192.0.2.7" || !strings.HasPrefix(ev.Client, "127.0.0.1:") {
+		t.Fatalf("admin %+v", ev)
+	}
+	// 管理接口的修改之后按新的访问控制清理会话
+	if ev := ta.waitAudit(core.AuditSweep, nil); ev.Rule != "admin blacklist change" {
+		t.Fatalf("session_sweep %+v", ev)
+	}
+}
+
+func TestAuditShutdownSignal(t *testing.T) {
+	// 先自行接收 SIGTERM，App 的 handleSignals 注册之前收到的信号不会结束测试进程
+	sig := make(chan os.Signal, 16)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	ta := startApp(t, DefaultConfig())
+	for stopped := false; !stopped; {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		select {
+		case <-ta.done:
+			stopped = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if ev := ta.waitAudit(core.AuditShutdown, nil); ev.Detail != "signal terminated" {
+		t.Fatalf("shutdown %+v", ev)
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+)
+
+// freePort 返回一个 TCP 和 UDP 在所有地址上都空闲的端口，App 按 Config.Port 在 0.0.0.0 上绑定
+func freePort(t *testing.T) int {
+	t.Helper()
+	for range 10 {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		pc, err := net.ListenPacket("udp", ":"+strconv.Itoa(port))
+		if err != nil {
+			continue
+		}
+		pc.Close()
+		return port
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+// testApp 在后台运行的 App
+type testApp struct {
+	*App
+	t     *testing.T
+	done  chan struct{}
+	addr  string // 代理的地址，127.0.0.1:端口
+	audit string // 审计日志文件
+}
+
+// startApp 以 cfg 在空闲端口上运行 App，等到开始服务后返回，测试结束时关闭；
+// 未指定审计日志时写入临时文件，用于判断启动完成
+func startApp(t *testing.T, cfg *Config) *testApp {
+	t.Helper()
+	cfg.Port = freePort(t)
+	if cfg.AuditLog == "" {
+		cfg.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	}
+	ta := &testApp{App: New(cfg), t: t, done: make(chan struct{}), addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Port)), audit: cfg.AuditLog}
+	go func() {
+		defer close(ta.done)
+		ta.Run()
+	}()
+	// 启动事件写入文件之后才读取 a.Server
+	ta.waitAudit(core.AuditStartup, nil)
+	select {
+	case <-ta.Server.Ready():
+	case <-ta.done:
+		t.Fatal("Run returned before serving")
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not ready")
+	}
+	t.Cleanup(ta.stop)
+	return ta
+}
+
+// stop 关闭服务端并等待 Run 返回
+func (ta *testApp) stop() {
+	ta.Server.Shutdown()
+	select {
+	case <-ta.done:
+	case <-time.After(10 * time.Second):
+		ta.t.Error("Run did not return after Shutdown")
+	}
+}
+
+// auditEvents 读取审计日志中的全部事件
+func (ta *testApp) auditEvents() []core.AuditEvent {
+	ta.t.Helper()
+	b, err := os.ReadFile(ta.audit)
+	if err != nil && !os.IsNotExist(err) {
+		ta.t.Fatal(err)
+	}
+	var evs []core.AuditEvent
+	for line := range bytes.Lines(b) {
+		var ev core.AuditEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			ta.t.Fatalf("audit record %q: %v", line, err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+// waitAudit 等待审计日志中出现 event 类型、满足 match 的事件并返回它，超时则失败
+func (ta *testApp) waitAudit(event string, match func(core.AuditEvent) bool) core.AuditEvent {
+	ta.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		evs := ta.auditEvents()
+		if i := slices.IndexFunc(evs, func(ev core.AuditEvent) bool {
+			return ev.Event == event && (match == nil || match(ev))
+		}); i >= 0 {
+			return evs[i]
+		}
+		select {
+		case <-ta.done:
+			ta.t.Fatalf("Run returned while waiting for a %s event", event)
+		default:
+		}
+		if time.Now().After(deadline) {
+			ta.t.Fatalf("no matching %s event in %+v", event, evs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
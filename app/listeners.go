@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"socks5/internal/core"
+	"strconv"
+	"strings"
+)
+
+// listenerSpec 监听器文件中的一行
+type listenerSpec struct {
+	label         string
+	addr          string
+	auth          string // server 与主监听器相同（默认），none 不认证，users 使用 usersFile
+	usersFile     string
+	whitelist     []string
+	whitelistFile string
+	commands      []byte // nil 表示与主监听器相同
+	egress        string // 空表示与主监听器相同，direct 直连，其他为路由文件中的上游名称
+	bind          net.IP // 直连出站使用的本地 IP
+	mark          int    // 直连出站套接字的 fwmark
+}
+
+// profileListener 已启动的附加监听器
+type profileListener struct {
+	spec    listenerSpec
+	profile *core.Profile
+	users   *core.StaticCredentials // users= 指定的用户文件，SIGHUP 时重新加载
+}
+
+// loadListenersFile 读取监听器文件，每行 名称 地址 [选项]，支持 # 注释和空行；选项：
+// auth=server|none、users=<用户文件>（用户名密码认证，格式同 -users-file）、whitelist=<逗号分隔的 IP 或 CIDR>、
+// whitelist-file=<白名单文件>、commands=<逗号分隔的 connect、bind、udp>、
+// egress=direct|<路由文件中的上游名称>、bind=<直连出站的本地 IP>、mark=<直连出站套接字的 fwmark>，设置了 bind 或 mark 而没有 egress 时为直连
+func loadListenersFile(path string) ([]listenerSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var specs []listenerSpec
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<name> <address> [options]\"", path, line)
+		}
+		spec := listenerSpec{label: fields[0], addr: fields[1], auth: "server"}
+		if slices.ContainsFunc(specs, func(o listenerSpec) bool { return o.label == spec.label }) {
+			return nil, fmt.Errorf("%s:%d: listener %q is already defined", path, line, spec.label)
+		}
+		for _, opt := range fields[2:] {
+			k, v, _ := strings.Cut(opt, "=")
+			switch k {
+			case "auth":
+				if v != "server" && v != "none" {
+					return nil, fmt.Errorf("%s:%d: invalid auth %q, must be server or none", path, line, v)
+				}
+				spec.auth = v
+			case "users":
+				spec.auth, spec.usersFile = "users", v
+			case "whitelist":
+				for e := range strings.SplitSeq(v, ",") {
+					if _, err := core.NormalizeIPEntry(e); err != nil {
+						return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+					}
+					spec.whitelist = append(spec.whitelist, e)
+				}
+			case "whitelist-file":
+				spec.whitelistFile = v
+			case "commands":
+				spec.commands = []byte{}
+				for name := range strings.SplitSeq(v, ",") {
+					cmd, ok := commandNames[strings.ToLower(name)]
+					if !ok {
+						return nil, fmt.Errorf("%s:%d: unknown command %q, must be connect, bind or udp", path, line, name)
+					}
+					spec.commands = append(spec.commands, cmd)
+				}
+			case "egress":
+				if v == "" {
+					return nil, fmt.Errorf("%s:%d: empty egress", path, line)
+				}
+				spec.egress = v
+			case "bind":
+				if spec.bind = net.ParseIP(v); spec.bind == nil {
+					return nil, fmt.Errorf("%s:%d: invalid bind IP %q", path, line, v)
+				}
+			case "mark":
+				n, err := strconv.ParseUint(v, 0, 32)
+				if err != nil || n == 0 {
+					return nil, fmt.Errorf("%s:%d: invalid mark %q, must be a positive 32-bit number", path, line, v)
+				}
+				spec.mark = int(n)
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown option %q", path, line, k)
+			}
+		}
+		if spec.egress == "" && (spec.bind != nil || spec.mark != 0) {
+			spec.egress = "direct"
+		}
+		specs = append(specs, spec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%s: no listeners", path)
+	}
+	return specs, nil
+}
+
+// loadWhitelist 合并 whitelist= 和 whitelist-file= 中的条目
+func (spec *listenerSpec) loadWhitelist() ([]string, error) {
+	entries := slices.Clone(spec.whitelist)
+	if spec.whitelistFile == "" {
+		return entries, nil
+	}
+	fileEntries, err := loadIPListFile(spec.whitelistFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, fileEntries...), nil
+}
+
+// listenProfiles 按监听器文件启动附加监听器，它们与主监听器共享出站、配额、统计和管理接口
+func (a *App) listenProfiles() error {
+	specs, err := loadListenersFile(a.Config.ListenersFile)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		pl, err := a.newProfileListener(spec)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", spec.label, err)
+		}
+		addr, err := a.Server.ListenProfile(spec.addr, pl.profile)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", spec.label, err)
+		}
+		a.listeners = append(a.listeners, pl)
+		names := make([]string, len(pl.profile.SupportedCommands))
+		for i, cmd := range pl.profile.SupportedCommands {
+			names[i] = core.CmdName(cmd)
+		}
+		whitelist := pl.profile.Whitelist()
+		log.Printf("Listener %s is listening on %s (auth: %s, commands: %s, whitelist: %d entries, egress: %s)\n", spec.label, addr, spec.auth, strings.Join(names, ", "), len(whitelist), spec.egressString())
+	}
+	return nil
+}
+
+// newProfileListener 按 spec 创建监听器的策略
+func (a *App) newProfileListener(spec listenerSpec) (*profileListener, error) {
+	s := a.Server
+	p := &core.Profile{Label: spec.label, SupportedCommands: s.SupportedCommands}
+	if spec.commands != nil {
+		p.SupportedCommands = spec.commands
+	}
+	if slices.Contains(p.SupportedCommands, core.CmdUDP) && a.Config.DisableUDP {
+		return nil, errors.New("command udp requires the UDP relay, which -disable-udp turns off")
+	}
+	pl := &profileListener{spec: spec, profile: p}
+	switch spec.auth {
+	case "server":
+		p.Method, p.Auth, p.UserName, p.Password = s.Method, s.Auth, s.UserName, s.Password
+	case "none":
+		p.Method = core.MethodNone
+	case "users":
+		users, err := core.LoadUsersFile(spec.usersFile)
+		if err != nil {
+			return nil, err
+		}
+		p.Method, p.Auth = core.MethodUsernamePassword, users
+		pl.users = users
+	}
+	whitelist, err := spec.loadWhitelist()
+	if err != nil {
+		return nil, err
+	}
+	p.SetWhitelist(whitelist)
+	if p.Egress, err = a.profileEgress(spec); err != nil {
+		return nil, err
+	}
+	if slices.Contains(p.SupportedCommands, core.CmdUDP) && p.Egress != nil && p.Egress.Upstream != nil {
+		if u, ok := p.Egress.Upstream.(core.UDPCapable); ok && !u.SupportsUDP() {
+			log.Printf("Warning: listener %s: upstream %s does not relay UDP, UDP ASSOCIATE requests will be refused", spec.label, spec.egress)
+		}
+	}
+	return pl, nil
+}
+
+// profileEgress 按 spec 创建监听器的出站设置，检查上游已在路由文件中定义、本地 IP 属于本机且被 -egress-allow 允许、
+// fwmark 可以设置；没有设置出站时返回 nil，与主监听器相同
+func (a *App) profileEgress(spec listenerSpec) (*core.ProfileEgress, error) {
+	if spec.egress == "" {
+		return nil, nil
+	}
+	e := &core.ProfileEgress{LocalIP: spec.bind, Mark: spec.mark}
+	if spec.egress != "direct" {
+		var h *core.HealthCheckedUpstream
+		if a.Server.Routes != nil {
+			h = a.Server.Routes.Upstream(spec.egress)
+		}
+		if h == nil {
+			return nil, fmt.Errorf("undefined upstream %q, upstreams are defined in -routes-file", spec.egress)
+		}
+		e.Upstream, e.Name = h, spec.egress
+	}
+	if spec.bind != nil {
+		if err := core.CheckLocalIP(spec.bind); err != nil {
+			return nil, err
+		}
+		if a.Server.AllowedEgress != nil {
+			if err := a.Server.AllowedEgress.CheckTable(&core.EgressTable{Default: spec.bind}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if spec.mark != 0 {
+		if err := core.CheckFwmark(spec.mark); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// egressString 返回日志中显示的出站设置
+func (spec *listenerSpec) egressString() string {
+	if spec.egress == "" {
+		return "default"
+	}
+	s := spec.egress
+	if spec.bind != nil {
+		s += " from " + spec.bind.String()
+	}
+	if spec.mark != 0 {
+		s += fmt.Sprintf(" mark %#x", spec.mark)
+	}
+	return s
+}
+
+// reloadListeners 重新加载监听器的白名单和 users= 用户文件；地址、认证方式和命令的变化需要重启
+func (a *App) reloadListeners() error {
+	specs, err := loadListenersFile(a.Config.ListenersFile)
+	if err != nil {
+		return err
+	}
+	type update struct {
+		pl        *profileListener
+		spec      listenerSpec
+		whitelist []string
+		users     *core.StaticCredentials
+	}
+	var updates []update
+	for _, pl := range a.listeners {
+		i := slices.IndexFunc(specs, func(s listenerSpec) bool { return s.label == pl.spec.label })
+		if i < 0 {
+			log.Printf("Warning: listener %s was removed from %s, restart to stop it", pl.spec.label, a.Config.ListenersFile)
+			continue
+		}
+		spec := specs[i]
+		if spec.addr != pl.spec.addr || spec.auth != pl.spec.auth || spec.usersFile != pl.spec.usersFile || !slices.Equal(spec.commands, pl.spec.commands) ||
+			spec.egress != pl.spec.egress || !spec.bind.Equal(pl.spec.bind) || spec.mark != pl.spec.mark {
+			log.Printf("Warning: address, auth, commands or egress of listener %s changed, restart to apply", spec.label)
+		}
+		u := update{pl: pl, spec: spec}
+		if u.whitelist, err = spec.loadWhitelist(); err != nil {
+			return fmt.Errorf("listener %s: %w", spec.label, err)
+		}
+		if pl.users != nil {
+			if u.users, err = core.LoadUsersFile(pl.spec.usersFile); err != nil {
+				return fmt.Errorf("listener %s: %w", spec.label, err)
+			}
+		}
+		updates = append(updates, u)
+	}
+	for _, spec := range specs {
+		if !slices.ContainsFunc(a.listeners, func(pl *profileListener) bool { return pl.spec.label == spec.label }) {
+			log.Printf("Warning: listener %s was added to %s, restart to start it", spec.label, a.Config.ListenersFile)
+		}
+	}
+	// 全部加载成功后才应用，失败时所有监听器保留原有的设置
+	for _, u := range updates {
+		u.pl.spec.whitelist, u.pl.spec.whitelistFile = u.spec.whitelist, u.spec.whitelistFile
+		u.pl.profile.SetWhitelist(u.whitelist)
+		if u.users != nil {
+			u.pl.users.Replace(u.users)
+		}
+	}
+	log.Printf("Listeners reloaded: %d listeners\n", len(updates))
+	a.Server.SweepDenied("listeners reload")
+	return nil
+}
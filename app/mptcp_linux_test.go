@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"socks5/internal/socks5test"
+)
+
+// dialMPTCP 启用 MPTCP 连接 addr，返回是否协商了 MPTCP
+func dialMPTCP(t *testing.T, addr string) bool {
+	t.Helper()
+	var d net.Dialer
+	d.SetMultipathTCP(true)
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	mp, err := c.(*net.TCPConn).MultipathTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mp
+}
+
+// -mptcp-listen 经 App 绑定的监听生效，-mptcp-dial 设置到服务端，内核不支持时退回普通 TCP
+func TestMPTCPConfig(t *testing.T) {
+	var lc net.ListenConfig
+	lc.SetMultipathTCP(true)
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := dialMPTCP(t, l.Addr().String())
+	l.Close()
+
+	echo := socks5test.EchoTCPServer(t)
+	for _, on := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.MPTCPListen, cfg.MPTCPDial = on, on
+		ta := startApp(t, cfg)
+		if ta.Server.ListenMPTCP != on || ta.Server.DialMPTCP != on {
+			t.Fatalf("MPTCP %v: ListenMPTCP %v, DialMPTCP %v", on, ta.Server.ListenMPTCP, ta.Server.DialMPTCP)
+		}
+		if got := dialMPTCP(t, ta.addr); got != (on && kernel) {
+			t.Fatalf("MPTCP %v: client negotiated %v, kernel support %v", on, got, kernel)
+		}
+		c := socks5test.Connect(t, ta.addr, nil, echo)
+		socks5test.EchoTCP(t, c, []byte("mptcp app"))
+		c.Close()
+		ta.stop()
+	}
+}
@@ -0,0 +1,19 @@
+package app
+
+import (
+	"os"
+	"strconv"
+)
+
+// writePidFile 将当前进程 PID 写入文件
+func writePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePidFile 删除 PID 文件，文件不存在时忽略
+func removePidFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package app
+
+import "log"
+
+// dropPrivileges 当前平台不支持降权，仅输出警告
+func dropPrivileges(username, group string) error {
+	log.Printf("Warning: dropping privileges is not supported on this platform, still running as current user")
+	return nil
+}
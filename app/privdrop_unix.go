@@ -0,0 +1,49 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package app
+
+import (
+	"fmt"
+	"log"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges 切换到指定的用户和组，并清空附加组
+// group 为空时使用用户的主组
+func dropPrivileges(username, group string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q for user %s", u.Uid, username)
+	}
+	gidStr := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q", gidStr)
+	}
+
+	// 顺序很重要：先清空附加组和切换组，最后切换用户
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid(%d): %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid(%d): %w", uid, err)
+	}
+	log.Printf("Dropped privileges to uid=%d gid=%d\n", uid, gid)
+	return nil
+}
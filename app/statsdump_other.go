@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package app
+
+import "os"
+
+// dumpSignals 当前平台没有 SIGUSR1，计数只能通过管理接口查看
+var dumpSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package app
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals 触发把计数写入日志的信号
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
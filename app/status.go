@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"socks5/internal/core"
+	"strconv"
+	"time"
+)
+
+// topDestinationsLimit GET /top-destinations 和统计转储默认列出的主机数
+const topDestinationsLimit = 50
+
+// statusReport GET /status 的应答，计数都取自同一个 StatsSnapshot，与 Stats 完整的计数一致
+type statusReport struct {
+	Version       string    `json:"version"`
+	Started       time.Time `json:"started"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Config        struct {
+		Listen      string `json:"listen"`
+		UDPListen   string `json:"udp_listen,omitempty"`
+		QUIC        string `json:"quic,omitempty"`
+		Transparent string `json:"transparent,omitempty"`
+		DNSListen   string `json:"dns_listen,omitempty"`
+		Auth        bool   `json:"auth"`
+		UDP         bool   `json:"udp"`
+		TLS         bool   `json:"tls"`
+	} `json:"config"`
+	Active struct {
+		Connections     int64 `json:"connections"` // 进行中的客户端连接，包括协商中的
+		TCPRelays       int   `json:"tcp_relays"`
+		UDPAssociations int   `json:"udp_associations"`
+	} `json:"active"`
+	Connections int64 `json:"connections_total"`
+	Bytes       struct {
+		Up   int64 `json:"up"`
+		Down int64 `json:"down"`
+	} `json:"bytes"`
+	HandshakeFailures struct {
+		Timeout  int64 `json:"timeout"`
+		Protocol int64 `json:"protocol"`
+		Auth     int64 `json:"auth"`
+	} `json:"handshake_failures"`
+	UDPDrops struct {
+		Malformed    int64 `json:"malformed"`
+		Unassociated int64 `json:"unassociated"`
+		QueueFull    int64 `json:"queue_full"`
+		ReplyDropped int64 `json:"reply_dropped"`
+		RateLimited  int64 `json:"rate_limited"`
+	} `json:"udp_drops"`
+	Bans  int                `json:"bans"`
+	Stats core.StatsSnapshot `json:"stats"`
+}
+
+// status 返回版本、运行时长、配置摘要和累计计数，只读取计数器和登记表，可以频繁轮询
+func (api *adminAPI) status(w http.ResponseWriter, r *http.Request) {
+	a, s := api.a, api.a.Server
+	st := s.StatsSnapshot()
+	var rep statusReport
+	rep.Version = Version
+	rep.Started = a.started
+	rep.UptimeSeconds = time.Since(a.started).Seconds()
+	if a.listener != nil {
+		rep.Config.Listen = a.listener.Addr().String()
+	}
+	if a.udpConn != nil {
+		rep.Config.UDPListen = a.udpConn.LocalAddr().String()
+	}
+	rep.Config.QUIC = a.Config.QUIC
+	rep.Config.Transparent = a.Config.Transparent
+	rep.Config.DNSListen = a.Config.DNSListen
+	rep.Config.Auth = s.Method == core.MethodUsernamePassword
+	rep.Config.UDP = a.udpConn != nil
+	rep.Config.TLS = s.TLSConfig != nil
+	rep.Active.Connections = s.ActiveConns()
+	rep.Active.TCPRelays, rep.Active.UDPAssociations = s.RelayCounts()
+	rep.Connections = st.Connections
+	rep.Bytes.Up, rep.Bytes.Down = st.BytesUp, st.BytesDown
+	rep.HandshakeFailures.Timeout = st.HandshakeTimeouts
+	rep.HandshakeFailures.Protocol = st.HandshakeErrors
+	rep.HandshakeFailures.Auth = st.AuthFailures
+	rep.UDPDrops.Malformed = st.UDPMalformed
+	rep.UDPDrops.Unassociated = st.UDPUnassociated
+	rep.UDPDrops.QueueFull = st.UDPQueueFull
+	rep.UDPDrops.ReplyDropped = st.UDPReplyDropped
+	rep.UDPDrops.RateLimited = st.UDPRateLimited
+	if s.Bans != nil {
+		rep.Bans = s.Bans.Len()
+	}
+	rep.Stats = st
+	writeAdminJSON(w, http.StatusOK, rep)
+}
+
+// udpAssociations 列出进行中的 UDP 关联和它们各个交换的计数，用于排查丢包的 UDP 流
+func (api *adminAPI) udpAssociations(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string][]core.UDPAssociationInfo{"associations": api.a.Server.UDPAssociations()})
+}
+
+// topDestinations 返回按转发字节数排列的目标主机，?limit=N 限制条数，0 表示全部
+func (api *adminAPI) topDestinations(w http.ResponseWriter, r *http.Request) {
+	t := api.a.Server.TopDestinations
+	if t == nil {
+		writeAdminError(w, http.StatusConflict, "top destinations are disabled (-top-destinations 0)", nil)
+		return
+	}
+	limit := topDestinationsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, "invalid limit value "+strconv.Quote(v), nil)
+			return
+		}
+		limit = n
+	}
+	writeAdminJSON(w, http.StatusOK, t.Top(limit))
+}
+
+// dumpStats 收到 SIGUSR1 时把完整的计数和目标主机排行写入日志，不需要启用管理接口
+func (a *App) dumpStats() {
+	dump := struct {
+		Stats           core.StatsSnapshot          `json:"stats"`
+		TopDestinations *core.TopDestinationsReport `json:"top_destinations,omitempty"`
+	}{Stats: a.Server.StatsSnapshot()}
+	if t := a.Server.TopDestinations; t != nil {
+		top := t.Top(topDestinationsLimit)
+		dump.TopDestinations = &top
+	}
+	b, err := json.Marshal(dump)
+	if err != nil {
+		log.Printf("Stats dump failed: %v", err)
+		return
+	}
+	log.Printf("Stats: %s\n", b)
+}
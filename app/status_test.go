@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/socks5test"
+)
+
+// getStatus 读取 GET /status，同时返回按通用 JSON 解码的结果用于检查字段
+func getStatus(t *testing.T, admin, token string) (statusReport, map[string]any) {
+	t.Helper()
+	code, b, err := adminDo(admin, token, "GET", "/status", "")
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("GET /status: %d %s %v", code, b, err)
+	}
+	var rep statusReport
+	var doc map[string]any
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return rep, doc
+}
+
+// waitStatus 轮询 /status 直到 cond 成立，计数在连接结束后异步更新
+func waitStatus(t *testing.T, admin, token string, what string, cond func(statusReport) bool) statusReport {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rep, _ := getStatus(t, admin, token)
+		if cond(rep) {
+			return rep
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %+v", what, rep)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sendGarbage 发送非 SOCKS5 数据，等待代理关闭连接
+func sendGarbage(t *testing.T, proxy string) {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.Read(make([]byte, 16))
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	os.WriteFile(tokens, []byte("ops "+token+"\n"), 0o600)
+	cfg := DefaultConfig()
+	cfg.AdminListen = "127.0.0.1:" + strconv.Itoa(freePort(t))
+	cfg.AdminTokensFile = tokens
+	cfg.Username, cfg.Password = "alice", "secret"
+	cfg.BanThreshold = 2
+	ta := startApp(t, cfg)
+	auth := &socks5test.Auth{User: "alice", Password: "secret"}
+
+	before, doc := getStatus(t, cfg.AdminListen, token)
+	for _, path := range []string{
+		"version", "started", "uptime_seconds",
+		"config.listen", "config.udp_listen", "config.auth", "config.udp", "config.tls",
+		"active.connections", "active.tcp_relays", "active.udp_associations",
+		"connections_total", "bytes.up", "bytes.down",
+		"handshake_failures.timeout", "handshake_failures.protocol", "handshake_failures.auth",
+		"udp_drops.malformed", "udp_drops.unassociated", "udp_drops.queue_full", "udp_drops.reply_dropped", "udp_drops.rate_limited",
+		"bans", "stats.connections",
+	} {
+		v := any(doc)
+		for key := range strings.SplitSeq(path, ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if v == nil {
+			t.Errorf("/status has no %s", path)
+		}
+	}
+	if before.Version != Version || before.UptimeSeconds < 0 || !strings.HasSuffix(before.Config.Listen, ":"+strconv.Itoa(cfg.Port)) ||
+		!before.Config.Auth || !before.Config.UDP || before.Config.TLS {
+		t.Fatalf("status before traffic: %+v", before)
+	}
+	if before.Connections != 0 || before.Bytes.Up != 0 || before.Bytes.Down != 0 || before.Bans != 0 {
+		t.Fatalf("counters before traffic: %+v", before)
+	}
+
+	// 保持一个 TCP 转发和一个 UDP 关联
+	echo, uecho := socks5test.EchoTCPServer(t), socks5test.EchoUDPServer(t)
+	msg := []byte(strings.Repeat("x", 1000))
+	c := socks5test.Connect(t, ta.addr, auth, echo)
+	defer c.Close()
+	socks5test.EchoTCP(t, c, msg)
+	ctl, relay := socks5test.Associate(t, ta.addr, auth)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay, uecho, []byte("udp"))
+	if !authRejected(t, ta.addr, "alice", "wrong") {
+		t.Fatal("wrong password accepted")
+	}
+	sendGarbage(t, ta.addr)
+	// 来源 IP 持有关联，数据报格式错误
+	pc, err := net.Dial("udp", ta.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Write([]byte{0xff})
+	pc.Close()
+
+	after := waitStatus(t, cfg.AdminListen, token, "counters after traffic", func(rep statusReport) bool {
+		return rep.Connections >= 4 && rep.Bytes.Up >= int64(len(msg)) && rep.Bytes.Down >= int64(len(msg)) &&
+			rep.HandshakeFailures.Auth >= 1 && rep.HandshakeFailures.Protocol >= 1 && rep.UDPDrops.Malformed >= 1
+	})
+	if after.Active.TCPRelays != 1 || after.Active.UDPAssociations != 1 || after.Active.Connections < 2 {
+		t.Fatalf("active after traffic: %+v", after.Active)
+	}
+	if after.UptimeSeconds <= before.UptimeSeconds || !after.Started.Equal(before.Started) {
+		t.Fatalf("uptime %v then %v, started %v then %v", before.UptimeSeconds, after.UptimeSeconds, before.Started, after.Started)
+	}
+	// 摘要与同一快照中的完整计数一致
+	st := after.Stats
+	if after.Connections != st.Connections || after.Bytes.Up != st.BytesUp || after.Bytes.Down != st.BytesDown ||
+		after.HandshakeFailures.Auth != st.AuthFailures || after.HandshakeFailures.Protocol != st.HandshakeErrors || after.UDPDrops.Malformed != st.UDPMalformed {
+		t.Fatalf("summary disagrees with stats: %+v", after)
+	}
+
+	// 转发结束后进行中的计数回落，第二次格式错误后来源被封禁
+	c.Close()
+	ctl.Close()
+	sendGarbage(t, ta.addr)
+	final := waitStatus(t, cfg.AdminListen, token, "after closing and a ban", func(rep statusReport) bool {
+		return rep.Active.TCPRelays == 0 && rep.Active.UDPAssociations == 0 && rep.Bans == 1
+	})
+	if final.Connections < after.Connections {
+		t.Fatalf("connections_total went from %d to %d", after.Connections, final.Connections)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package app
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// upgradeSignals 当前平台不支持升级，没有触发信号
+var upgradeSignals []os.Signal
+
+// inheritedListeners 当前平台不支持升级，总是返回 ok 为 false
+func inheritedListeners() (tcp, udp *os.File, ok bool) {
+	return nil, nil, false
+}
+
+func notifyReady() {}
+
+func (a *App) upgrade(l *net.TCPListener, pc *net.UDPConn) (int, error) {
+	return 0, errors.New("upgrade is not supported on this platform")
+}
@@ -0,0 +1,120 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 升级时传给新进程的环境变量：继承的监听（"tcp" 或 "tcp,udp"，依次为描述符 3、4），以及通知就绪的管道描述符
+const (
+	envListenFDs = "SOCKS5_LISTEN_FDS"
+	envReadyFD   = "SOCKS5_READY_FD"
+)
+
+// upgradeReadyTimeout 等待新进程就绪的最长时间，超时则放弃升级，继续服务
+const upgradeReadyTimeout = 30 * time.Second
+
+// upgradeSignals 触发升级的信号
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// inheritedListeners 返回升级时从父进程继承的 TCP 监听和 UDP 套接字文件，不是升级启动的进程 ok 为 false
+// 环境变量读取后即清除，以免再传给之后的子进程
+func inheritedListeners() (tcp, udp *os.File, ok bool) {
+	v, set := os.LookupEnv(envListenFDs)
+	if !set {
+		return nil, nil, false
+	}
+	os.Unsetenv(envListenFDs)
+	names := strings.Split(v, ",")
+	tcp = os.NewFile(3, "tcp")
+	if len(names) > 1 && names[1] == "udp" {
+		udp = os.NewFile(4, "udp")
+	}
+	return tcp, udp, true
+}
+
+// notifyReady 告诉父进程已开始服务，父进程随即停止接受连接
+func notifyReady() {
+	v, set := os.LookupEnv(envReadyFD)
+	if !set {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Printf("Warning: cannot notify the parent process: %v", err)
+	}
+	f.Close()
+}
+
+// upgrade 以相同的参数启动新的可执行文件，把 TCP 监听和 UDP 套接字交给它，等到它开始服务后返回其 PID；
+// 失败时新进程被结束，当前进程继续服务
+func (a *App) upgrade(l *net.TCPListener, pc *net.UDPConn) (int, error) {
+	if a.Config.QUIC != "" || a.Config.Transparent != "" || a.Config.DNSListen != "" || a.Config.AdminListen != "" || a.Config.ListenersFile != "" {
+		return 0, errors.New("upgrade does not hand over -quic, -transparent, -dns-listen, -admin-listen or -listeners-file listeners")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	tf, err := l.File()
+	if err != nil {
+		return 0, err
+	}
+	defer tf.Close()
+	files, names := []*os.File{tf}, "tcp"
+	if pc != nil {
+		uf, err := pc.File()
+		if err != nil {
+			return 0, err
+		}
+		defer uf.Close()
+		files, names = append(files, uf), "tcp,udp"
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	// 新进程从当前的用量继续计算配额，此后的用量由它保存
+	if a.Server.Quotas != nil {
+		if err := a.Server.Quotas.Save(); err != nil {
+			log.Printf("Failed to save quota state: %v", err)
+		}
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), envListenFDs+"="+names, envReadyFD+"="+strconv.Itoa(2+len(files)))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	r.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	var b [1]byte
+	if _, err := r.Read(b[:]); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("new process %d did not become ready: %w", cmd.Process.Pid, err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
@@ -0,0 +1,166 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package app
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"socks5/internal/socks5test"
+)
+
+// envUpgradeHelper 设置时测试二进制作为代理进程运行，值为 端口,PID 文件；
+// 升级启动的新进程以相同的参数和环境运行测试二进制，同样进入 TestUpgradeHelperProcess
+const envUpgradeHelper = "SOCKS5_TEST_UPGRADE_HELPER"
+
+func TestUpgradeHelperProcess(t *testing.T) {
+	v, ok := os.LookupEnv(envUpgradeHelper)
+	if !ok {
+		t.Skip("helper process for TestUpgradeHandover")
+	}
+	port, pidFile, _ := strings.Cut(v, ",")
+	cfg := DefaultConfig()
+	cfg.Port, _ = strconv.Atoi(port)
+	cfg.PidFile = pidFile
+	cfg.DrainTimeout = time.Minute
+	// handleSignals 开始接收之前到达的 SIGUSR2 被忽略而不是结束进程，测试会重发
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGUSR2)
+	New(cfg).Run()
+	os.Exit(0)
+}
+
+// readPid 读取 PID 文件，不存在或内容不完整时返回 0
+func readPid(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(string(b), "\n") {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+// waitPid 等待 PID 文件满足 cond 并返回其中的 PID
+func waitPid(t *testing.T, path string, cond func(int) bool) int {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if pid := readPid(path); cond(pid) {
+			return pid
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pid file %s: %d", path, readPid(path))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 父进程中已建立的转发在排空前一直可用，新连接由新进程接受，父进程在连接全部结束后退出
+func TestUpgradeHandover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts proxy processes")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	port, pidFile := freePort(t), filepath.Join(dir, "socks5.pid")
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	out, err := os.Create(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, "-test.run=^TestUpgradeHelperProcess$")
+	cmd.Env = append(os.Environ(), envUpgradeHelper+"="+strconv.Itoa(port)+","+pidFile)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	child := 0
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		if child != 0 {
+			syscall.Kill(child, syscall.SIGKILL)
+		}
+		if t.Failed() {
+			b, _ := os.ReadFile(out.Name())
+			t.Logf("proxy output:\n%s", b)
+		}
+	})
+	parent := cmd.Process.Pid
+	waitPid(t, pidFile, func(pid int) bool { return pid == parent })
+
+	echo := socks5test.EchoTCPServer(t)
+	old := socks5test.Connect(t, addr, nil, echo)
+	defer old.Close()
+	socks5test.EchoTCP(t, old, []byte("before upgrade"))
+	ctl, _ := socks5test.Associate(t, addr, nil)
+	defer ctl.Close()
+
+	// 写入 PID 文件时可能还没有开始接收信号，新进程写入 PID 文件之前重发；升级开始后多余的信号不起作用
+	deadline := time.Now().Add(10 * time.Second)
+	for child == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no new process after SIGUSR2")
+		}
+		if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+			t.Fatal(err)
+		}
+		for range 20 {
+			if pid := readPid(pidFile); pid != 0 && pid != parent {
+				child = pid
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 父进程停止接受之后随即关闭 UDP 关联，此后新连接都由新进程处理，父进程中的 TCP 转发不受影响
+	ctl.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := ctl.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("UDP association in the parent not closed on drain: %v", err)
+	}
+	fresh := socks5test.Connect(t, addr, nil, echo)
+	defer fresh.Close()
+	socks5test.EchoTCP(t, fresh, []byte("after upgrade"))
+	socks5test.EchoTCP(t, old, []byte("still draining"))
+	select {
+	case err := <-exited:
+		t.Fatalf("parent exited with a relay open: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 最后一个转发结束后父进程退出，不等到排空超时，也不删除新进程的 PID 文件
+	old.Close()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("parent exited with %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("parent still running after its last relay closed")
+	}
+	if pid := readPid(pidFile); pid != child {
+		t.Fatalf("pid file has %d after the parent exited, want %d", pid, child)
+	}
+	c := socks5test.Connect(t, addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("after parent exit"))
+	c.Close()
+	socks5test.EchoTCP(t, fresh, []byte("child relay"))
+
+	if err := syscall.Kill(child, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	waitPid(t, pidFile, func(pid int) bool { return pid == 0 })
+	child = 0
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// loadIPListFile 读取白名单或黑名单文件，每行一个 IP 或 CIDR，支持 # 注释和空行
+func loadIPListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(s); err != nil && net.ParseIP(s) == nil {
+			return nil, fmt.Errorf("%s:%d: invalid IP or CIDR %q", path, line, s)
+		}
+		entries = append(entries, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// loadWhitelist 合并 -whitelist 参数与 -whitelist-file 文件中的条目
+func (a *App) loadWhitelist() ([]string, error) {
+	entries := a.parseWhitelist()
+	if a.Config.WhitelistFile == "" {
+		return entries, nil
+	}
+	fileEntries, err := loadIPListFile(a.Config.WhitelistFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, fileEntries...), nil
+}
+
+// reloadWhitelist 重新加载白名单并应用到运行中的服务器
+func (a *App) reloadWhitelist() error {
+	whitelist, err := a.loadWhitelist()
+	if err != nil {
+		return err
+	}
+	a.Server.SetWhitelist(whitelist)
+	if len(whitelist) == 0 {
+		log.Println("Whitelist reloaded: empty, all IPs are allowed")
+	} else {
+		log.Printf("Whitelist reloaded: %d entries\n", len(whitelist))
+		// 白名单收紧后，已不在白名单中的客户端的会话随即关闭
+		a.Server.SweepDenied("whitelist reload")
+	}
+	return nil
+}
+
+// loadBlacklist 合并 -blacklist 参数与 -blacklist-file 文件中的条目
+func (a *App) loadBlacklist() ([]string, error) {
+	var entries []string
+	for p := range strings.SplitSeq(a.Config.Blacklist, ",") {
+		if s := strings.TrimSpace(p); s != "" {
+			entries = append(entries, s)
+		}
+	}
+	if a.Config.BlacklistFile == "" {
+		return entries, nil
+	}
+	fileEntries, err := loadIPListFile(a.Config.BlacklistFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, fileEntries...), nil
+}
+
+// reloadBlacklist 重新加载黑名单并应用到运行中的服务器，新列入黑名单的客户端的会话随即关闭
+func (a *App) reloadBlacklist() error {
+	blacklist, err := a.loadBlacklist()
+	if err != nil {
+		return err
+	}
+	a.Server.SetBlacklist(blacklist)
+	log.Printf("Blacklist reloaded: %d entries\n", len(blacklist))
+	if len(blacklist) > 0 {
+		a.Server.SweepDenied("blacklist reload")
+	}
+	return nil
+}
+
+// writeIPListFile 把条目原子地写回白名单或黑名单文件（先写临时文件再重命名），原有的注释不保留
+func writeIPListFile(path string, entries []string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# written by the admin API at %s\n", time.Now().Format(time.RFC3339))
+	for _, e := range entries {
+		b.WriteString(e)
+		b.WriteByte('\n')
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// writeFileAtomic 先写入同一目录下的临时文件再重命名为 path，读者不会看到写了一半的文件；
+// path 已存在时保留其权限，否则为 0600
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0o600)
+	if st, err := os.Stat(path); err == nil {
+		perm = st.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
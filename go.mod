@@ -2,4 +2,11 @@ module socks5
 
 go 1.25.5
 
-require github.com/txthinking/runnergroup v0.0.0-20250224021307-5864ffeb65ae
+require (
+	github.com/quic-go/quic-go v0.59.1
+	golang.org/x/crypto v0.41.0
+	golang.org/x/net v0.43.0
+	golang.org/x/sys v0.35.0
+)
+
+require golang.org/x/text v0.28.0 // indirect
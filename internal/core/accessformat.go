@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// AccessLogFormat 把一条访问记录格式化为一行追加到 b 之后（不含换行），可并发使用
+type AccessLogFormat interface {
+	AppendRecord(b []byte, rec *AccessRecord) ([]byte, error)
+}
+
+// clfTimeLayout Common Log Format 的时间格式
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// ParseAccessLogFormat 解析访问日志格式：json（默认，JSON Lines）、logfmt（key=value，键与 JSON 相同）、
+// clf（类似 Common Log Format），或包含 {{ 的 text/template 模板，以 AccessRecord 为数据，见 NewTemplateFormat
+func ParseAccessLogFormat(s string) (AccessLogFormat, error) {
+	switch s {
+	case "", "json":
+		return jsonFormat{}, nil
+	case "logfmt":
+		return logfmtFormat{}, nil
+	case "clf":
+		return clfFormat{}, nil
+	}
+	if !strings.Contains(s, "{{") {
+		return nil, fmt.Errorf("unknown access log format %q, expected json, logfmt, clf or a template", s)
+	}
+	return NewTemplateFormat(s)
+}
+
+// jsonFormat JSON Lines，字段见 AccessRecord 的 json 标签
+type jsonFormat struct{}
+
+func (jsonFormat) AppendRecord(b []byte, rec *AccessRecord) ([]byte, error) {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return b, err
+	}
+	return append(b, j...), nil
+}
+
+// clfFormat 类似 Common Log Format：客户端 IP - 用户 [时间] "命令 目标" 应答码 下行字节 上行字节 耗时毫秒，
+// 没有用户时为 -
+type clfFormat struct{}
+
+func (clfFormat) AppendRecord(b []byte, rec *AccessRecord) ([]byte, error) {
+	b = append(b, hostOf(rec.Client)...)
+	b = append(b, " - "...)
+	b = append(b, cmp.Or(rec.User, "-")...)
+	b = append(b, " ["...)
+	b = rec.Time.AppendFormat(b, clfTimeLayout)
+	b = append(b, "] \""...)
+	b = append(b, rec.Command...)
+	b = append(b, ' ')
+	b = append(b, rec.Dst...)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(rec.Rep), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, rec.BytesDown, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, rec.BytesUp, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, rec.DurationMS, 10)
+	return b, nil
+}
+
+// logfmtFormat key=value 对，键和省略空值的规则与 JSON 格式相同，udp 展开为 udp_ 前缀的键，meta 展开为 meta. 前缀的键
+type logfmtFormat struct{}
+
+func (logfmtFormat) AppendRecord(b []byte, rec *AccessRecord) ([]byte, error) {
+	b = append(b, "time="...)
+	b = rec.Time.AppendFormat(b, time.RFC3339Nano)
+	b = appendLogfmt(b, "client", rec.Client, false)
+	b = appendLogfmt(b, "user", rec.User, true)
+	b = appendLogfmt(b, "listener", rec.Listener, true)
+	b = appendLogfmt(b, "cmd", rec.Command, false)
+	b = appendLogfmt(b, "dst", rec.Dst, false)
+	b = appendLogfmt(b, "orig_dst", rec.OrigDst, true)
+	b = appendLogfmt(b, "sni", rec.SNI, true)
+	b = appendLogfmt(b, "http_host", rec.HTTPHost, true)
+	b = appendLogfmtInt(b, "rep", int64(rec.Rep), false)
+	b = appendLogfmt(b, "route", rec.Route, true)
+	b = appendLogfmt(b, "egress", rec.Egress, true)
+	b = appendLogfmt(b, "resolve", rec.Resolve, true)
+	b = appendLogfmtInt(b, "dial_ms", rec.DialMS, true)
+	b = appendLogfmtInt(b, "dial_tries", int64(rec.DialTries), true)
+	b = appendLogfmtInt(b, "bytes_up", rec.BytesUp, false)
+	b = appendLogfmtInt(b, "bytes_down", rec.BytesDown, false)
+	b = appendLogfmtInt(b, "duration_ms", rec.DurationMS, false)
+	b = appendLogfmt(b, "error", rec.Error, true)
+	if u := rec.UDP; u != nil {
+		b = appendLogfmtInt(b, "udp_exchanges", int64(u.Exchanges), false)
+		b = appendLogfmtInt(b, "udp_sent_packets", u.SentPackets, false)
+		b = appendLogfmtInt(b, "udp_recv_packets", u.RecvPackets, false)
+		b = appendLogfmtInt(b, "udp_dropped", u.Dropped, false)
+		b = appendLogfmtInt(b, "udp_reply_dropped", u.ReplyDropped, false)
+		b = appendLogfmtInt(b, "udp_rate_limited", u.RateLimited, false)
+	}
+	for _, k := range slices.Sorted(maps.Keys(rec.Meta)) {
+		v, ok := rec.Meta[k].(string)
+		if !ok {
+			j, err := json.Marshal(rec.Meta[k])
+			if err != nil {
+				return b, err
+			}
+			v = string(j)
+		}
+		b = appendLogfmt(b, "meta."+k, v, false)
+	}
+	return b, nil
+}
+
+// appendLogfmt 追加 " key=value"，值为空、含空白、=、引号、控制字符或非法 UTF-8 时加引号；omitEmpty 为 true 时空值不输出
+func appendLogfmt(b []byte, key, v string, omitEmpty bool) []byte {
+	if v == "" && omitEmpty {
+		return b
+	}
+	b = append(b, ' ')
+	b = append(b, key...)
+	b = append(b, '=')
+	if logfmtNeedsQuote(v) {
+		return strconv.AppendQuote(b, v)
+	}
+	return append(b, v...)
+}
+
+func appendLogfmtInt(b []byte, key string, v int64, omitZero bool) []byte {
+	if v == 0 && omitZero {
+		return b
+	}
+	b = append(b, ' ')
+	b = append(b, key...)
+	b = append(b, '=')
+	return strconv.AppendInt(b, v, 10)
+}
+
+func logfmtNeedsQuote(v string) bool {
+	if v == "" || !utf8.ValidString(v) {
+		return true
+	}
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFormat 用户提供的 text/template 模板
+type templateFormat struct {
+	t *template.Template
+}
+
+// NewTemplateFormat 以 text/template 模板 s 创建访问日志格式，数据为 *AccessRecord，可使用其所有字段：
+// .Client .User .Command .Dst .Rep .BytesUp .BytesDown .DurationMS .Route（路由规则）.Egress .Error 等；
+// 另有函数 dash（空字符串输出 -）、quote（加引号转义）、host（去掉地址的端口）、clftime（CLF 格式的时间）
+// 模板末尾的换行被忽略，每条记录占一行；模板无法解析或引用了不存在的字段时返回错误
+func NewTemplateFormat(s string) (AccessLogFormat, error) {
+	t, err := template.New("access-log").Funcs(template.FuncMap{
+		"dash":    func(s string) string { return cmp.Or(s, "-") },
+		"quote":   strconv.Quote,
+		"host":    hostOf,
+		"clftime": func(t time.Time) string { return t.Format(clfTimeLayout) },
+	}).Parse(strings.TrimRight(s, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("access log template: %w", err)
+	}
+	// 字段名错误要到执行时才发现，先以一条示例记录执行一次
+	sample := &AccessRecord{Time: time.Now(), Client: "127.0.0.1:1", Command: "CONNECT", Dst: "example.com:443", UDP: &UDPTotals{}}
+	if err := t.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("access log template: %w", err)
+	}
+	return templateFormat{t}, nil
+}
+
+func (f templateFormat) AppendRecord(b []byte, rec *AccessRecord) ([]byte, error) {
+	buf := bytes.NewBuffer(b)
+	if err := f.t.Execute(buf, rec); err != nil {
+		return b, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hostOf 返回 host:port 中的主机，不是该形式时原样返回
+func hostOf(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
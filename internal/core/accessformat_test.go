@@ -0,0 +1,76 @@
+package core
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// benchRecord 一条字段较全的记录，logfmt 需要引号转义的值和 meta 都有
+func benchRecord() *AccessRecord {
+	return &AccessRecord{
+		Time:       time.Date(2026, 10, 17, 4, 30, 0, 0, time.UTC),
+		Client:     "192.0.2.10:53122",
+		User:       "alice",
+		Command:    "CONNECT",
+		Dst:        "example.com:443",
+		SNI:        "example.com",
+		Route:      "direct",
+		Egress:     "198.51.100.1:40112",
+		Resolve:    "dns",
+		DialMS:     12,
+		DialTries:  1,
+		BytesUp:    1834,
+		BytesDown:  52311,
+		DurationMS: 2051,
+		Error:      "read tcp: connection reset by peer",
+		Meta:       map[string]any{"tenant": "acme", "tags": []string{"a", "b"}},
+	}
+}
+
+// BenchmarkAccessLogFormat 各格式把一条记录追加到复用的缓冲区的开销
+func BenchmarkAccessLogFormat(b *testing.B) {
+	for _, format := range []string{
+		"json",
+		"logfmt",
+		"clf",
+		"{{host .Client}} {{dash .User}} {{.Command}} {{.Dst}} {{.Rep}} {{.BytesUp}} {{.BytesDown}} {{.DurationMS}}",
+	} {
+		f, err := ParseAccessLogFormat(format)
+		if err != nil {
+			b.Fatal(err)
+		}
+		name := format
+		if _, ok := f.(templateFormat); ok {
+			name = "template"
+		}
+		b.Run(name, func(b *testing.B) {
+			rec := benchRecord()
+			buf := make([]byte, 0, 512)
+			b.ReportAllocs()
+			for range b.N {
+				if buf, err = f.AppendRecord(buf[:0], rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(len(buf)))
+		})
+	}
+}
+
+// BenchmarkAccessLoggerLog 并发写访问日志，格式化在锁外进行
+func BenchmarkAccessLoggerLog(b *testing.B) {
+	for _, format := range []string{"json", "logfmt", "clf"} {
+		b.Run(format, func(b *testing.B) {
+			l := NewAccessLogger(io.Discard)
+			l.Format, _ = ParseAccessLogFormat(format)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				rec := benchRecord()
+				for pb.Next() {
+					l.Log(rec)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// AccessRecord 访问日志中的一条记录，在会话结束时写出
+type AccessRecord struct {
+	Time       time.Time      `json:"time"`
+	Client     string         `json:"client"`
+	User       string         `json:"user,omitempty"`
+	Listener   string         `json:"listener,omitempty"` // 接受连接的附加监听器的 Label
+	Command    string         `json:"cmd"`
+	Dst        string         `json:"dst"`
+	OrigDst    string         `json:"orig_dst,omitempty"`  // 目标被改写时客户端请求的原始目标
+	SNI        string         `json:"sni,omitempty"`       // CONNECT 转发的 TLS ClientHello 中的服务器名
+	HTTPHost   string         `json:"http_host,omitempty"` // CONNECT 转发的明文 HTTP 请求的 Host，读取了但没有取到时为 "-"
+	Rep        byte           `json:"rep"`
+	Route      string         `json:"route,omitempty"`      // 路由表选择的出站方式
+	Egress     string         `json:"egress,omitempty"`     // 出站连接使用的本地地址
+	Resolve    string         `json:"resolve,omitempty"`    // 域名解析来源：static 或 dns
+	DialMS     int64          `json:"dial_ms,omitempty"`    // 出站拨号耗时（毫秒），成功和失败都记录
+	DialTries  int            `json:"dial_tries,omitempty"` // 直连依次尝试的目标地址数，成功时即第几个地址连接成功
+	BytesUp    int64          `json:"bytes_up"`
+	BytesDown  int64          `json:"bytes_down"`
+	DurationMS int64          `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	UDP        *UDPTotals     `json:"udp,omitempty"`  // UDP 关联所有交换的合计，此时 BytesUp、BytesDown 为交换的载荷字节
+	Meta       map[string]any `json:"meta,omitempty"` // 会话结束时 Session.Meta 的内容
+
+	meta *Metadata
+}
+
+// AccessLogger 按 Format 写访问日志，每条记录一行，可并发使用
+type AccessLogger struct {
+	Format AccessLogFormat // nil 为 JSON Lines，见 ParseAccessLogFormat
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLogger 创建写入 w 的访问日志
+func NewAccessLogger(w io.Writer) *AccessLogger {
+	return &AccessLogger{w: w}
+}
+
+// accessLogBufs 格式化记录使用的缓冲区，格式化在锁外进行，写出时才持有锁
+var accessLogBufs = sync.Pool{New: func() any { b := make([]byte, 0, 512); return &b }}
+
+// Log 写出一条记录，nil 的 AccessLogger 不做任何事
+func (l *AccessLogger) Log(rec *AccessRecord) {
+	if l == nil {
+		return
+	}
+	f := l.Format
+	if f == nil {
+		f = jsonFormat{}
+	}
+	bp := accessLogBufs.Get().(*[]byte)
+	defer accessLogBufs.Put(bp)
+	b, err := f.AppendRecord((*bp)[:0], rec)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b = append(b, '\n')
+	*bp = b
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+// newAccessRecord 为请求创建记录，填入客户端地址、用户和目标
+func newAccessRecord(c net.Conn, r *Request) *AccessRecord {
+	rec := &AccessRecord{
+		Time:    time.Now(),
+		Client:  c.RemoteAddr().String(),
+		Command: cmdName(r.Cmd),
+		Dst:     r.Address(),
+	}
+	if sess := SessionFromConn(c); sess != nil {
+		rec.User = sess.User
+		rec.OrigDst = sess.OrigDst
+		if sess.Profile != nil {
+			rec.Listener = sess.Profile.Label
+		}
+		rec.meta = sess.Meta
+	}
+	return rec
+}
+
+// finish 记录结束时间和错误后写出
+func (rec *AccessRecord) finish(l *AccessLogger, err error) {
+	if l == nil {
+		return
+	}
+	rec.DurationMS = time.Since(rec.Time).Milliseconds()
+	rec.Meta = rec.meta.Snapshot()
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	l.Log(rec)
+}
+
+// cmdName 返回命令的可读名称
+func cmdName(cmd byte) string {
+	switch cmd {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	case CmdUDP:
+		return "UDP"
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMECerts 通过 ACME（默认 Let's Encrypt）为 Hosts 中的域名自动申请和续期证书，保存在缓存目录中，
+// 到期前 30 天开始后台续期；TLS-ALPN-01 验证在 SOCKS 的 TLS 监听上完成，该监听须能从公网以 443 端口访问
+type ACMECerts struct {
+	Manager *autocert.Manager
+	Hosts   []string // 允许申请证书的域名，客户端不发送 SNI 时使用第一个
+
+	last atomic.Pointer[tls.Certificate] // 最近一次返回的证书，供 NotAfter 使用
+}
+
+// NewACMECerts 创建只为 hosts 申请证书的 ACMECerts，directoryURL 为空时使用 Let's Encrypt 的正式环境
+func NewACMECerts(hosts []string, cacheDir, email, directoryURL string) *ACMECerts {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+	return &ACMECerts{Manager: m, Hosts: hosts}
+}
+
+// GetCertificate 返回 SNI 对应的证书，必要时申请；没有 SNI 时使用 Hosts 的第一个，失败时输出日志
+func (a *ACMECerts) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" && len(a.Hosts) > 0 {
+		h := *hello
+		h.ServerName = a.Hosts[0]
+		hello = &h
+	}
+	cert, err := a.Manager.GetCertificate(hello)
+	if err != nil {
+		log.Printf("Warning: ACME certificate for %q: %v", hello.ServerName, err)
+		return nil, err
+	}
+	if !slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		if cert.Leaf == nil {
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				c := *cert
+				c.Leaf = leaf
+				cert = &c
+			}
+		}
+		a.last.Store(cert)
+	}
+	return cert, nil
+}
+
+// NotAfter 返回最近一次使用的证书的到期时间，还没有证书时返回零值
+func (a *ACMECerts) NotAfter() time.Time {
+	if cert := a.last.Load(); cert != nil && cert.Leaf != nil {
+		return cert.Leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+// isACMEChallenge 报告已完成握手的连接是否为 TLS-ALPN-01 验证
+func isACMEChallenge(tc *tls.Conn) bool {
+	return tc.ConnectionState().NegotiatedProtocol == acme.ALPNProto
+}
@@ -0,0 +1,191 @@
+package core_test
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"socks5/internal/core"
+)
+
+// addrCases 覆盖三种地址类型，包括端口边界、IPv4-mapped 和 UTF-8 域名
+var addrCases = []struct {
+	name     string
+	atyp     byte
+	addr     []byte // 域名不含长度字节
+	port     []byte
+	host     string
+	portNum  uint16
+	address  string
+	isDomain bool
+	ap       netip.AddrPort // 域名时为零值
+}{
+	{"ipv4", core.ATYPIPv4, []byte{192, 0, 2, 1}, []byte{0, 80}, "192.0.2.1", 80, "192.0.2.1:80", false, netip.MustParseAddrPort("192.0.2.1:80")},
+	{"ipv4 zero", core.ATYPIPv4, []byte{0, 0, 0, 0}, []byte{0, 0}, "0.0.0.0", 0, "0.0.0.0:0", false, netip.MustParseAddrPort("0.0.0.0:0")},
+	{"ipv4 max port", core.ATYPIPv4, []byte{255, 255, 255, 255}, []byte{0xff, 0xff}, "255.255.255.255", 65535, "255.255.255.255:65535", false, netip.MustParseAddrPort("255.255.255.255:65535")},
+	{"ipv6", core.ATYPIPv6, net.ParseIP("2001:db8::1"), []byte{0x01, 0xbb}, "2001:db8::1", 443, "[2001:db8::1]:443", false, netip.MustParseAddrPort("[2001:db8::1]:443")},
+	{"ipv6 loopback", core.ATYPIPv6, net.IPv6loopback, []byte{0, 53}, "::1", 53, "[::1]:53", false, netip.MustParseAddrPort("[::1]:53")},
+	// 16 字节的 IPv4-mapped 地址按 net.IP 的习惯显示为点分形式，AddrPort 保留 ATYP 给出的 16 字节形式
+	{"ipv4-mapped", core.ATYPIPv6, net.ParseIP("::ffff:192.0.2.9"), []byte{0x1f, 0x90}, "192.0.2.9", 8080, "192.0.2.9:8080", false, netip.MustParseAddrPort("[::ffff:192.0.2.9]:8080")},
+	{"domain", core.ATYPDomain, []byte("example.com"), []byte{0, 80}, "example.com", 80, "example.com:80", true, netip.AddrPort{}},
+	{"domain single byte", core.ATYPDomain, []byte("a"), []byte{0xff, 0xff}, "a", 65535, "a:65535", true, netip.AddrPort{}},
+	{"domain utf-8", core.ATYPDomain, []byte("例子.测试"), []byte{0x01, 0xbb}, "例子.测试", 443, "例子.测试:443", true, netip.AddrPort{}},
+	{"domain ip literal", core.ATYPDomain, []byte("10.0.0.1"), []byte{0, 22}, "10.0.0.1", 22, "10.0.0.1:22", true, netip.AddrPort{}},
+}
+
+func TestRequestAddress(t *testing.T) {
+	for _, tc := range addrCases {
+		t.Run(tc.name, func(t *testing.T) {
+			built := core.NewRequest(core.CmdConnect, tc.atyp, tc.addr, tc.port)
+			var buf bytes.Buffer
+			if _, err := built.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := core.NewRequestFrom(&buf)
+			if err != nil {
+				t.Fatalf("parse %x: %v", buf.Bytes(), err)
+			}
+			for _, r := range []*core.Request{built, parsed} {
+				if r.Host() != tc.host || r.Port() != tc.portNum || r.Address() != tc.address || r.IsDomain() != tc.isDomain {
+					t.Fatalf("Host %q Port %d Address %q IsDomain %v", r.Host(), r.Port(), r.Address(), r.IsDomain())
+				}
+				ap, ok := r.AddrPort()
+				if ok == tc.isDomain || ap != tc.ap {
+					t.Fatalf("AddrPort = %v, %v; want %v", ap, ok, tc.ap)
+				}
+			}
+		})
+	}
+}
+
+func TestDatagramAddress(t *testing.T) {
+	for _, tc := range addrCases {
+		t.Run(tc.name, func(t *testing.T) {
+			built := core.NewDatagram(tc.atyp, tc.addr, tc.port, []byte("payload"))
+			parsed, err := core.NewDatagramFromBytes(built.Bytes())
+			if err != nil {
+				t.Fatalf("parse %x: %v", built.Bytes(), err)
+			}
+			for _, d := range []*core.Datagram{built, parsed} {
+				if d.Host() != tc.host || d.Port() != tc.portNum || d.Address() != tc.address || d.IsDomain() != tc.isDomain {
+					t.Fatalf("Host %q Port %d Address %q IsDomain %v", d.Host(), d.Port(), d.Address(), d.IsDomain())
+				}
+				ap, ok := d.AddrPort()
+				if ok == tc.isDomain || ap != tc.ap {
+					t.Fatalf("AddrPort = %v, %v; want %v", ap, ok, tc.ap)
+				}
+				if string(d.Data) != "payload" {
+					t.Fatalf("Data = %q", d.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestReplyAddress(t *testing.T) {
+	for _, tc := range addrCases {
+		t.Run(tc.name, func(t *testing.T) {
+			built := core.NewReply(core.RepSuccess, tc.atyp, tc.addr, tc.port)
+			var buf bytes.Buffer
+			if _, err := built.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := core.NewReplyFrom(&buf)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			for _, r := range []*core.Reply{built, parsed} {
+				if r.Address() != tc.address {
+					t.Fatalf("Address = %q, want %q", r.Address(), tc.address)
+				}
+			}
+		})
+	}
+}
+
+// 长度字段不对的地址不会让访问方法越界
+func TestAddressMalformed(t *testing.T) {
+	r := &core.Request{Atyp: core.ATYPDomain}
+	if r.Host() != "" || r.Port() != 0 {
+		t.Fatalf("empty request: Host %q Port %d", r.Host(), r.Port())
+	}
+	if _, ok := r.AddrPort(); ok {
+		t.Fatal("AddrPort ok for a domain request")
+	}
+	d := &core.Datagram{Atyp: core.ATYPIPv4, DstAddr: []byte{1, 2, 3}, DstPort: []byte{1}}
+	if d.Port() != 0 {
+		t.Fatalf("Port of a 1-byte port field = %d", d.Port())
+	}
+	if ap, ok := d.AddrPort(); ok {
+		t.Fatalf("AddrPort of a 3-byte IPv4 address = %v", ap)
+	}
+}
+
+// strAddr 只有 String() 的 net.Addr，走按字符串解析的分支
+type strAddr string
+
+func (a strAddr) Network() string { return "test" }
+func (a strAddr) String() string  { return string(a) }
+
+func TestAddrToATYP(t *testing.T) {
+	var nilTCP *net.TCPAddr
+	var nilUDP *net.UDPAddr
+	for _, tc := range []struct {
+		name string
+		addr net.Addr
+		want string // 应答的 Address()，为空表示 AddrToATYP 返回错误
+		atyp byte
+	}{
+		{"tcp v4", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080}, "192.0.2.1:1080", core.ATYPIPv4},
+		{"tcp v4 4-byte", &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1}, "192.0.2.1:1", core.ATYPIPv4},
+		{"tcp v6", &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}, "[2001:db8::2]:443", core.ATYPIPv6},
+		{"tcp v6 zone", &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: "eth0"}, "[fe80::1]:80", core.ATYPIPv6},
+		{"tcp nil ip", &net.TCPAddr{Port: 9}, "0.0.0.0:9", core.ATYPIPv4},
+		{"tcp bad ip", &net.TCPAddr{IP: net.IP{1, 2, 3}, Port: 9}, "", 0},
+		{"tcp typed nil", nilTCP, "", 0},
+		{"udp v4", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}, "10.0.0.1:53", core.ATYPIPv4},
+		{"udp mapped", &net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.2"), Port: 53}, "10.0.0.2:53", core.ATYPIPv4},
+		{"udp v6 any", &net.UDPAddr{IP: net.IPv6zero, Port: 65535}, "[::]:65535", core.ATYPIPv6},
+		{"udp typed nil", nilUDP, "", 0},
+		{"nil", nil, "", 0},
+		{"string v4", strAddr("127.0.0.1:8080"), "127.0.0.1:8080", core.ATYPIPv4},
+		{"string v6", strAddr("[::1]:8080"), "[::1]:8080", core.ATYPIPv6},
+		{"string domain", strAddr("proxy.example:1080"), "proxy.example:1080", core.ATYPDomain},
+		{"string no port", strAddr("pipe"), "", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			atyp, addr, port, err := core.AddrToATYP(tc.addr)
+			r := core.NewReplyFromAddr(core.RepSuccess, tc.addr)
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("AddrToATYP = %d %x %x, want an error", atyp, addr, port)
+				}
+				// 无法转换的地址在应答中报告为 0.0.0.0:0
+				if r.Atyp != core.ATYPIPv4 || r.Address() != "0.0.0.0:0" {
+					t.Fatalf("NewReplyFromAddr reported %s", r.Address())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if atyp != tc.atyp {
+				t.Fatalf("ATYP = %d, want %d", atyp, tc.atyp)
+			}
+			if got := core.NewReply(core.RepSuccess, atyp, addr, port).Address(); got != tc.want {
+				t.Fatalf("NewReply(AddrToATYP) = %s, want %s", got, tc.want)
+			}
+			if r.Atyp != tc.atyp || r.Address() != tc.want {
+				t.Fatalf("NewReplyFromAddr = %d %s, want %d %s", r.Atyp, r.Address(), tc.atyp, tc.want)
+			}
+			// 应答在线路上往返后不变
+			var buf bytes.Buffer
+			r.WriteTo(&buf)
+			back, err := core.NewReplyFrom(&buf)
+			if err != nil || back.Address() != tc.want {
+				t.Fatalf("round trip = %v, %v", back, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 审计事件类型
+const (
+	AuditStartup     = "startup"
+	AuditShutdown    = "shutdown"
+	AuditReload      = "config_reload"
+	AuditAuthSuccess = "auth_success"
+	AuditAuthFailure = "auth_failure"
+	AuditBan         = "ban"
+	AuditUnban       = "unban"
+	AuditDenied      = "acl_denied"    // 白名单、路由表、目标过滤、SNI 或 Host 检查拒绝，Rule 为命中的规则
+	AuditAdmin       = "admin"         // 管理接口的操作
+	AuditSweep       = "session_sweep" // 访问控制变更后关闭进行中的会话，Rule 为原因，Detail 为关闭的数量、客户端和用户
+)
+
+// AuditEvent 审计日志中的一条记录，与访问日志不同，每个安全相关的事件在发生时立即写出
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Client string    `json:"client,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Dst    string    `json:"dst,omitempty"`
+	Rule   string    `json:"rule,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// AuditSink 审计记录的去向，实现它即可把审计事件发往文件以外的地方（syslog、消息队列等），须可并发调用
+type AuditSink interface {
+	WriteAudit(ev *AuditEvent) error
+}
+
+// AuditLogger 审计日志，nil 的 AuditLogger 不做任何事
+type AuditLogger struct {
+	sink AuditSink
+}
+
+// NewAuditLogger 创建写入 sink 的审计日志
+func NewAuditLogger(sink AuditSink) *AuditLogger {
+	return &AuditLogger{sink: sink}
+}
+
+// Log 写出一条事件，Time 为零时填入当前时间，写入失败只记录日志
+func (l *AuditLogger) Log(ev *AuditEvent) {
+	if l == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if err := l.sink.WriteAudit(ev); err != nil {
+		log.Printf("Audit log error: %v", err)
+	}
+}
+
+// event 写出事件的简便形式，client 为 nil 时不记录客户端
+func (l *AuditLogger) event(event string, client net.Addr, user, dst, rule, detail string) {
+	if l == nil {
+		return
+	}
+	ev := &AuditEvent{Event: event, User: user, Dst: dst, Rule: rule, Detail: detail}
+	if client != nil {
+		ev.Client = client.String()
+	}
+	l.Log(ev)
+}
+
+// jsonAuditSink 以 JSON Lines 格式写入 w
+type jsonAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONAuditSink 返回以 JSON Lines 格式写入 w 的 AuditSink
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{w: w}
+}
+
+func (s *jsonAuditSink) WriteAudit(ev *AuditEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(b)
+	return err
+}
+
+// RotatingFile 只追加写入的日志文件，超过 MaxSize 字节或打开超过 MaxAge 后，
+// 当前文件被重命名为 <路径>.<时间戳> 并重新创建；为 0 的限制不生效，可并发写入
+type RotatingFile struct {
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenRotatingFile 以追加方式打开 path，已有内容计入大小
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+// Write 写入 b，写入前按大小和时间检查是否需要轮转；一次写入不会被拆到两个文件中
+func (r *RotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && (r.MaxSize > 0 && r.size+int64(len(b)) > r.MaxSize || r.MaxAge > 0 && time.Since(r.opened) >= r.MaxAge) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate 关闭并重命名当前文件后重新创建，调用方持有锁
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	name := fmt.Sprintf("%s.%s", r.Path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(r.Path, name); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close 关闭当前文件
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// auditDenied 请求被路由表、DestinationFilter、SNIFilter 或 HostFilter 拒绝时写入审计日志，Rule 为拒绝它的规则
+func (s *Server) auditDenied(c net.Conn, r *Request, err error) {
+	if s.Audit == nil || err == nil {
+		return
+	}
+	var rule string
+	switch {
+	case errors.Is(err, ErrBlocked):
+		rule = "route"
+		if s.Routes != nil {
+			rule = "route " + s.Routes.Rule(r.Address())
+		}
+	case errors.Is(err, ErrDestinationDenied):
+		rule = "destination-filter"
+	case errors.Is(err, ErrSNIDenied):
+		rule = "sni-filter"
+	case errors.Is(err, ErrHostDenied):
+		rule = "host-filter"
+	default:
+		return
+	}
+	user := ""
+	if sess := SessionFromConn(c); sess != nil {
+		user = sess.User
+	}
+	s.Audit.event(AuditDenied, c.RemoteAddr(), user, r.Address(), rule, "")
+}
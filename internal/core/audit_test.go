@@ -0,0 +1,193 @@
+package core_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// auditSink 收集审计事件
+type auditSink struct {
+	mu     sync.Mutex
+	events []core.AuditEvent
+}
+
+func (s *auditSink) WriteAudit(ev *core.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *ev)
+	return nil
+}
+
+// wait 等待出现 event 类型、满足 match 的事件并返回它，超时则失败
+func (s *auditSink) wait(t *testing.T, event string, match func(ev core.AuditEvent) bool) core.AuditEvent {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		s.mu.Lock()
+		for _, ev := range s.events {
+			if ev.Event == event && (match == nil || match(ev)) {
+				s.mu.Unlock()
+				if ev.Time.IsZero() {
+					t.Fatalf("%s event without a time", event)
+				}
+				return ev
+			}
+		}
+		got := slices.Clone(s.events)
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("no matching %s event in %+v", event, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fromLoopback 报告审计记录的客户端是否为本机发起的连接
+func fromLoopback(ev core.AuditEvent) bool {
+	return strings.HasPrefix(ev.Client, "127.0.0.1:")
+}
+
+func TestAuditAuth(t *testing.T) {
+	sink := &auditSink{}
+	ts := socks5test.NewServer(t, socks5test.WithAuth("alice", "pw"), func(s *core.Server) {
+		s.Audit = core.NewAuditLogger(sink)
+	})
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: "alice", Password: "pw"}, echo)
+	ev := sink.wait(t, core.AuditAuthSuccess, nil)
+	if ev.User != "alice" || ev.Client != c.LocalAddr().String() {
+		t.Fatalf("auth_success %+v, want user alice from %s", ev, c.LocalAddr())
+	}
+	authFails(t, ts.Addr, &socks5test.Auth{User: "mallory", Password: "guess"})
+	ev = sink.wait(t, core.AuditAuthFailure, nil)
+	if ev.User != "mallory" || !fromLoopback(ev) || ev.Detail != "" {
+		t.Fatalf("auth_failure %+v, want user mallory from 127.0.0.1", ev)
+	}
+	if strings.Contains(ev.Detail+ev.Rule, "guess") {
+		t.Fatal("audit event contains the password")
+	}
+}
+
+func TestAuditDenied(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	for _, tc := range []struct {
+		name  string
+		setup func(s *core.Server)
+		rule  string
+		dst   bool // 事件是否记录目标
+	}{
+		{"whitelist", func(s *core.Server) { s.SetWhitelist([]string{"192.0.2.1"}) }, "whitelist", false},
+		{"blacklist", func(s *core.Server) { s.SetBlacklist([]string{"127.0.0.0/8"}) }, "blacklist", false},
+		{"route", func(s *core.Server) {
+			_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+			s.Routes = &core.RoutingTable{
+				Rules:   []core.RouteRule{{CIDR: lo, Route: &core.Route{Name: "block", Block: true}, Source: "routes:3"}},
+				Default: &core.Route{Name: "direct"},
+			}
+		}, "route routes:3", true},
+		{"destination filter", func(s *core.Server) {
+			s.Handle = core.Chain(&core.DefaultHandle{}, core.DestinationFilter(func(string) bool { return false }))
+		}, "destination-filter", true},
+		{"command auth", func(s *core.Server) {
+			s.CommandAuth = map[byte]core.AuthRequirement{core.CmdUDP: core.AuthNone}
+		}, "command-auth", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &auditSink{}
+			ts := socks5test.NewServer(t, func(s *core.Server) {
+				s.Audit = core.NewAuditLogger(sink)
+				tc.setup(s)
+			})
+			c, err := net.Dial("tcp", ts.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Write([]byte{core.Ver, 1, core.MethodNone})
+			atyp, addr, port, _ := core.ParseAddress(echo)
+			core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(c)
+			ev := sink.wait(t, core.AuditDenied, nil)
+			if ev.Rule != tc.rule || ev.Client != c.LocalAddr().String() {
+				t.Fatalf("acl_denied %+v, want rule %q from %s", ev, tc.rule, c.LocalAddr())
+			}
+			if tc.dst && ev.Dst != echo || !tc.dst && ev.Dst != "" {
+				t.Fatalf("acl_denied dst %q", ev.Dst)
+			}
+		})
+	}
+}
+
+func TestAuditBanAndSweep(t *testing.T) {
+	sink := &auditSink{}
+	audit := core.NewAuditLogger(sink)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Audit = audit
+		s.Bans = &core.BanTable{Threshold: 1, Audit: audit}
+	})
+	echo := socks5test.EchoTCPServer(t)
+	established := socks5test.Connect(t, ts.Addr, nil, echo)
+
+	c, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.Close()
+	ev := sink.wait(t, core.AuditBan, nil)
+	if ev.Client != "127.0.0.1" || ev.Rule != core.BanReasonMalformed || !strings.HasPrefix(ev.Detail, "until ") {
+		t.Fatalf("ban %+v", ev)
+	}
+
+	ts.SweepDenied("test ban")
+	ev = sink.wait(t, core.AuditSweep, nil)
+	if ev.Rule != "test ban" || ev.Detail != "closed 1; clients 127.0.0.1" || ev.Client != "" {
+		t.Fatalf("session_sweep %+v", ev)
+	}
+	established.SetReadDeadline(time.Now().Add(socks5test.Timeout))
+	if _, err := established.Read(make([]byte, 1)); err == nil {
+		t.Fatal("swept relay still open")
+	}
+
+	ts.Bans.Unban(net.IPv4(127, 0, 0, 1))
+	ev = sink.wait(t, core.AuditUnban, nil)
+	if ev.Client != "127.0.0.1" || ev.Rule != core.BanReasonMalformed {
+		t.Fatalf("unban %+v", ev)
+	}
+}
+
+func TestAuditJSONRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := core.OpenRotatingFile(path, 200, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l := core.NewAuditLogger(core.NewJSONAuditSink(f))
+	for i := range 5 {
+		l.Log(&core.AuditEvent{Event: core.AuditAdmin, Client: "127.0.0.1:1", User: "ops", Rule: "whitelist", Detail: strings.Repeat("x", i)})
+	}
+	files, _ := filepath.Glob(path + "*")
+	if len(files) < 3 {
+		t.Fatalf("got %d files, want the log rotated at 200 bytes", len(files))
+	}
+	for _, name := range files {
+		b, _ := os.ReadFile(name)
+		if len(b) > 200 {
+			t.Fatalf("%s is %d bytes", name, len(b))
+		}
+		for line := range strings.Lines(string(b)) {
+			if !strings.HasPrefix(line, `{"time":"`) || !strings.Contains(line, `"event":"admin","client":"127.0.0.1:1","user":"ops","rule":"whitelist"`) {
+				t.Fatalf("record %q", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrCredentialTooLong is the error when a configured username or password exceeds 255 bytes and can never be sent on the wire
+var ErrCredentialTooLong = errors.New("username or password longer than 255 bytes")
+
+// ErrCredentialNotUTF8 is the error when a configured username or password is not valid UTF-8
+var ErrCredentialNotUTF8 = errors.New("username or password is not valid UTF-8")
+
+// Authenticator 校验用户名密码子协商（RFC 1929）中的凭据，用户名和密码都可能为空
+type Authenticator interface {
+	Authenticate(user, password string) bool
+}
+
+// validateCredential 检查配置的用户名和密码能否在子协商中表示：ULEN、PLEN 只有一个字节，
+// 超长的凭据永远无法认证；配置来自命令行或文本文件，非法 UTF-8 多是编码错误
+// 错误信息不包含密码，超长的用户名只给出长度
+func validateCredential(user, password string) error {
+	if len(user) > 255 {
+		return fmt.Errorf("username is %d bytes: %w", len(user), ErrCredentialTooLong)
+	}
+	if !utf8.ValidString(user) {
+		return fmt.Errorf("username %q: %w", user, ErrCredentialNotUTF8)
+	}
+	if len(password) > 255 {
+		return fmt.Errorf("password of user %q is %d bytes: %w", user, len(password), ErrCredentialTooLong)
+	}
+	if !utf8.ValidString(password) {
+		return fmt.Errorf("password of user %q: %w", user, ErrCredentialNotUTF8)
+	}
+	return nil
+}
+
+// validateStoredPassword 在 validateCredential 之外检查 bcrypt 散列的格式，格式错误的散列永远无法认证
+func validateStoredPassword(user, password string) error {
+	if err := validateCredential(user, password); err != nil {
+		return err
+	}
+	if IsPasswordHash(password) {
+		if _, err := bcrypt.Cost([]byte(password)); err != nil {
+			return fmt.Errorf("password hash of user %q: %w", user, err)
+		}
+	}
+	return nil
+}
+
+// StaticCredentials 内存中的用户名密码表，可并发读写
+type StaticCredentials struct {
+	// AllowEmpty 为 true 时接受空用户名或空密码（RFC 1929 允许长度为 0，部分设备只发送用户名），
+	// 默认即使与表中一致也拒绝
+	AllowEmpty bool
+
+	mu    sync.Mutex
+	users map[string]*credential
+}
+
+// PreviousPasswordKey 以轮换中的旧密码认证时写入 Session.Meta 的键，值为旧密码失效的时间（RFC 3339）
+const PreviousPasswordKey = "previous_password"
+
+// credential 一个用户的密码（或 bcrypt 散列），以及可选的 TOTP 密钥和上次接受的验证码所在的步（防止重放）
+// 轮换密码期间 previous 在 previousUntil 之前仍然有效
+type credential struct {
+	password      string
+	previous      string
+	previousUntil time.Time
+	totp          []byte
+	lastStep      int64
+	quota         int64       // 用户文件中 quota= 的上限
+	udpRate       *PacketRate // 用户文件中 udp-pps= 的 UDP 数据报速率上限，nil 表示使用全局设置
+}
+
+// NewStaticCredentials 以 user -> password 表创建凭据，超过 255 字节的用户名或密码返回 ErrCredentialTooLong，
+// 非法 UTF-8 返回 ErrCredentialNotUTF8
+func NewStaticCredentials(users map[string]string) (*StaticCredentials, error) {
+	c := &StaticCredentials{users: make(map[string]*credential, len(users))}
+	for u, p := range users {
+		if err := c.Set(u, p); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Set 添加用户或修改密码，password 也可以是 bcrypt 散列（$2a$、$2b$、$2y$ 开头），已设置的 TOTP 密钥保留
+func (c *StaticCredentials) Set(user, password string) error {
+	if err := validateStoredPassword(user, password); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users == nil {
+		c.users = make(map[string]*credential)
+	}
+	if cr := c.users[user]; cr != nil {
+		if cr.totp != nil && len(password)+1+TOTPDigits > 255 {
+			return fmt.Errorf("password of user %q with TOTP code: %w", user, ErrCredentialTooLong)
+		}
+		cr.password = password
+		return nil
+	}
+	c.users[user] = &credential{password: password}
+	return nil
+}
+
+// SetTOTP 为已有用户设置 base32 的 TOTP 密钥，secret 为空时取消；设置后客户端的密码须为
+// "密码:验证码"，验证码按 RFC 6238 校验，前后各容忍一步的时钟偏差，同一验证码只能使用一次
+func (c *StaticCredentials) SetTOTP(user, secret string) error {
+	var key []byte
+	if secret != "" {
+		var err error
+		if key, err = decodeTOTPSecret(secret); err != nil {
+			return fmt.Errorf("user %q: %w", user, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr := c.users[user]
+	if cr == nil {
+		return fmt.Errorf("user %q does not exist", user)
+	}
+	if key != nil && len(cr.password)+1+TOTPDigits > 255 {
+		return fmt.Errorf("password of user %q with TOTP code: %w", user, ErrCredentialTooLong)
+	}
+	cr.totp, cr.lastStep = key, 0
+	return nil
+}
+
+// SetPrevious 为已有用户设置轮换期间仍然有效的旧密码，until 之后只接受当前密码，password 为空时取消
+func (c *StaticCredentials) SetPrevious(user, password string, until time.Time) error {
+	if err := validateStoredPassword(user, password); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr := c.users[user]
+	if cr == nil {
+		return fmt.Errorf("user %q does not exist", user)
+	}
+	if cr.totp != nil && len(password)+1+TOTPDigits > 255 {
+		return fmt.Errorf("previous password of user %q with TOTP code: %w", user, ErrCredentialTooLong)
+	}
+	cr.previous, cr.previousUntil = password, until
+	return nil
+}
+
+// Rotate 把已有用户的密码改为 password，原密码在 grace 内仍然有效，客户端可以逐个更新
+func (c *StaticCredentials) Rotate(user, password string, grace time.Duration) error {
+	if err := validateStoredPassword(user, password); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr := c.users[user]
+	if cr == nil {
+		return fmt.Errorf("user %q does not exist", user)
+	}
+	if cr.totp != nil && len(password)+1+TOTPDigits > 255 {
+		return fmt.Errorf("password of user %q with TOTP code: %w", user, ErrCredentialTooLong)
+	}
+	cr.previous, cr.previousUntil = cr.password, time.Now().Add(grace)
+	cr.password = password
+	return nil
+}
+
+// Delete 删除用户
+func (c *StaticCredentials) Delete(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, user)
+}
+
+// Has 返回用户是否存在
+func (c *StaticCredentials) Has(user string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.users[user]
+	return ok
+}
+
+// Len 返回用户数
+func (c *StaticCredentials) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.users)
+}
+
+// Authenticate 以常量时间比较密码，设置了 TOTP 的用户还要校验密码末尾的验证码
+// 轮换期间旧密码同样通过，到期与否按调用时的时间判断
+func (c *StaticCredentials) Authenticate(user, password string) bool {
+	ok, _ := c.check(user, password)
+	return ok
+}
+
+// AuthenticateSession 与 Authenticate 相同，以轮换中的旧密码通过时在 sess.Meta 中记录 PreviousPasswordKey，
+// 供访问日志和审计日志找出尚未更新密码的客户端
+func (c *StaticCredentials) AuthenticateSession(sess *Session, user, password string) bool {
+	ok, until := c.check(user, password)
+	if ok && !until.IsZero() {
+		sess.Meta.Set(PreviousPasswordKey, until.Format(time.RFC3339))
+	}
+	return ok
+}
+
+// check 校验凭据，以旧密码通过时返回旧密码失效的时间；bcrypt 散列的比较较慢，不持有锁
+func (c *StaticCredentials) check(user, password string) (bool, time.Time) {
+	if !c.AllowEmpty && (user == "" || password == "") {
+		return false, time.Time{}
+	}
+	c.mu.Lock()
+	cr, ok := c.users[user]
+	var saved credential
+	if ok {
+		saved = *cr
+	}
+	c.mu.Unlock()
+	if !ok {
+		return false, time.Time{}
+	}
+	pw := password
+	if saved.totp != nil {
+		i := strings.LastIndexByte(password, ':')
+		if i < 0 {
+			return false, time.Time{}
+		}
+		pw = password[:i]
+	}
+	now := time.Now()
+	var until time.Time
+	if !matchPassword(saved.password, pw) {
+		if saved.previous == "" || !now.Before(saved.previousUntil) || !matchPassword(saved.previous, pw) {
+			return false, time.Time{}
+		}
+		until = saved.previousUntil
+	}
+	if saved.totp != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// 比较期间用户被删除或重新设置了 TOTP 时按失败处理
+		if c.users[user] != cr || !bytes.Equal(cr.totp, saved.totp) {
+			return false, time.Time{}
+		}
+		step, ok := verifyTOTP(cr.totp, password[len(pw)+1:], now, cr.lastStep)
+		if !ok {
+			return false, time.Time{}
+		}
+		cr.lastStep = step
+	}
+	return true, until
+}
+
+// IsPasswordHash 报告配置的密码是否为 bcrypt 散列（$2a$、$2b$、$2y$ 开头），这样的密码按散列比较
+func IsPasswordHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// matchPassword 比较客户端发送的密码与配置的密码或 bcrypt 散列
+func matchPassword(stored, password string) bool {
+	if IsPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+}
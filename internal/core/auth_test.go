@@ -0,0 +1,73 @@
+package core_test
+
+import (
+	"bytes"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestUserPassEmptyFieldsRoundTrip(t *testing.T) {
+	for _, tc := range []struct{ user, pass string }{{"", ""}, {"", "p"}, {"u", ""}} {
+		var buf bytes.Buffer
+		if _, err := core.NewUserPassNegotiationRequest([]byte(tc.user), []byte(tc.pass)).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		want := append(append(append([]byte{core.UserPassVer, byte(len(tc.user))}, tc.user...), byte(len(tc.pass))), tc.pass...)
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("wire %x, want %x", buf.Bytes(), want)
+		}
+		r, err := core.NewUserPassNegotiationRequestFrom(&buf)
+		if err != nil {
+			t.Fatalf("parse %q/%q: %v", tc.user, tc.pass, err)
+		}
+		if string(r.Uname) != tc.user || string(r.Passwd) != tc.pass {
+			t.Fatalf("parsed %q/%q, want %q/%q", r.Uname, r.Passwd, tc.user, tc.pass)
+		}
+	}
+}
+
+func TestStaticCredentialsEmpty(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		name := "strict"
+		if allow {
+			name = "permissive"
+		}
+		t.Run(name, func(t *testing.T) {
+			creds, err := core.NewStaticCredentials(map[string]string{"": "anon-pass", "device": "", "full": "pw"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			creds.AllowEmpty = allow
+			ts := socks5test.NewServer(t, func(s *core.Server) {
+				s.Method = core.MethodUsernamePassword
+				s.Auth = creds
+			})
+			echo := socks5test.EchoTCPServer(t)
+			for _, tc := range []struct {
+				user, pass string
+				ok         bool // 与表中一致时是否接受
+			}{
+				{"", "anon-pass", allow},
+				{"device", "", allow},
+				{"full", "pw", true},
+				{"", "", false},        // 表中的空用户名有密码
+				{"device", "x", false}, // 表中的密码为空
+				{"full", "", false},
+			} {
+				if got := creds.Authenticate(tc.user, tc.pass); got != tc.ok {
+					t.Errorf("Authenticate(%q, %q) = %v, want %v", tc.user, tc.pass, got, tc.ok)
+				}
+				// 经过线路的结果与直接调用一致
+				if tc.ok {
+					c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: tc.user, Password: tc.pass}, echo)
+					socks5test.EchoTCP(t, c, []byte(tc.user+"/"+tc.pass))
+					c.Close()
+				} else if !authFails(t, ts.Addr, &socks5test.Auth{User: tc.user, Password: tc.pass}) {
+					t.Errorf("server accepted %q/%q", tc.user, tc.pass)
+				}
+			}
+		})
+	}
+}
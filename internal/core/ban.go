@@ -0,0 +1,237 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// BanReasonMalformed 反复发送非 SOCKS5 数据或格式错误的请求（扫描器、误连的 HTTP/TLS 客户端），
+// 同一来源不同原因的失败分别计数
+const BanReasonMalformed = "malformed"
+
+const (
+	DefaultBanThreshold = 20
+	DefaultBanWindow    = time.Minute
+	DefaultBanDuration  = 10 * time.Minute
+)
+
+// banPurgeInterval 清理过期条目的最小间隔
+const banPurgeInterval = time.Second
+
+// BanTable 按来源 IP 和原因统计失败次数：Window 内同一原因的失败达到 Threshold 次后，
+// 该来源被封禁 Duration，期间的连接在进入协商前直接关闭；封禁到期自动解除
+// 各项为 0 时使用对应的默认值，封禁和解除都会输出日志，可并发使用
+type BanTable struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+	// OnBan、OnUnban 非 nil 时在封禁和解除时调用，调用时不持有锁
+	OnBan   func(ip, reason string, until time.Time)
+	OnUnban func(ip, reason string)
+	// Audit 非 nil 时封禁和解除写入审计日志，Rule 为封禁原因
+	Audit *AuditLogger
+
+	mu        sync.Mutex
+	failures  map[banKey]*banCounter
+	bans      map[string]Ban
+	lastPurge time.Time
+}
+
+type banKey struct {
+	ip     string
+	reason string
+}
+
+// banCounter 一个窗口内的失败次数
+type banCounter struct {
+	start time.Time
+	n     int
+}
+
+// Ban 一条封禁记录
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// NewBanTable 使用默认阈值、窗口和封禁时长创建封禁表
+func NewBanTable() *BanTable {
+	return &BanTable{
+		Threshold: DefaultBanThreshold,
+		Window:    DefaultBanWindow,
+		Duration:  DefaultBanDuration,
+	}
+}
+
+// Fail 记录 ip 的一次 reason 类失败，达到阈值时封禁并返回 true
+func (t *BanTable) Fail(ip net.IP, reason string) bool {
+	key := banKey{unmapIP(ip).String(), reason}
+	now := time.Now()
+	t.mu.Lock()
+	expired := t.purge(now)
+	if t.failures == nil {
+		t.failures = make(map[banKey]*banCounter)
+		t.bans = make(map[string]Ban)
+	}
+	if b, ok := t.bans[key.ip]; ok && now.Before(b.Until) {
+		t.mu.Unlock()
+		t.notifyUnban(expired)
+		return false
+	}
+	threshold, window, duration := t.limits()
+	fc := t.failures[key]
+	if fc == nil || now.Sub(fc.start) >= window {
+		fc = &banCounter{start: now}
+		t.failures[key] = fc
+	}
+	fc.n++
+	var ban *Ban
+	if fc.n >= threshold {
+		delete(t.failures, key)
+		b := Ban{IP: key.ip, Reason: reason, Until: now.Add(duration)}
+		t.bans[key.ip] = b
+		ban = &b
+	}
+	t.mu.Unlock()
+	t.notifyUnban(expired)
+	if ban == nil {
+		return false
+	}
+	log.Printf("Banned %s for %s: %d %s failures within %s", ban.IP, duration, threshold, reason, window)
+	t.Audit.Log(&AuditEvent{Event: AuditBan, Client: ban.IP, Rule: reason, Detail: "until " + ban.Until.Format(time.RFC3339)})
+	if t.OnBan != nil {
+		t.OnBan(ban.IP, reason, ban.Until)
+	}
+	return true
+}
+
+// Add 手动封禁 ip，d 为 0 时使用 Duration；已封禁时延长到较晚的到期时间
+// 已建立的会话不受影响，需要时随后调用 Server.SweepDenied
+func (t *BanTable) Add(ip net.IP, reason string, d time.Duration) {
+	key := unmapIP(ip).String()
+	now := time.Now()
+	t.mu.Lock()
+	expired := t.purge(now)
+	if t.failures == nil {
+		t.failures = make(map[banKey]*banCounter)
+		t.bans = make(map[string]Ban)
+	}
+	_, _, duration := t.limits()
+	b := Ban{IP: key, Reason: reason, Until: now.Add(cmp.Or(d, duration))}
+	if old, ok := t.bans[key]; ok && old.Until.After(b.Until) {
+		b.Until = old.Until
+	}
+	t.bans[key] = b
+	t.mu.Unlock()
+	t.notifyUnban(expired)
+	log.Printf("Banned %s until %s: %s", b.IP, b.Until.Format(time.RFC3339), reason)
+	t.Audit.Log(&AuditEvent{Event: AuditBan, Client: b.IP, Rule: reason, Detail: "until " + b.Until.Format(time.RFC3339)})
+	if t.OnBan != nil {
+		t.OnBan(b.IP, reason, b.Until)
+	}
+}
+
+// Banned 返回 ip 是否处于封禁中
+func (t *BanTable) Banned(ip net.IP) bool {
+	key := unmapIP(ip).String()
+	now := time.Now()
+	t.mu.Lock()
+	expired := t.purge(now)
+	b, ok := t.bans[key]
+	t.mu.Unlock()
+	t.notifyUnban(expired)
+	return ok && now.Before(b.Until)
+}
+
+// Unban 立即解除 ip 的封禁并清空其失败计数
+func (t *BanTable) Unban(ip net.IP) {
+	key := unmapIP(ip).String()
+	t.mu.Lock()
+	b, ok := t.bans[key]
+	delete(t.bans, key)
+	for k := range t.failures {
+		if k.ip == key {
+			delete(t.failures, k)
+		}
+	}
+	t.mu.Unlock()
+	if ok {
+		t.notifyUnban([]Ban{b})
+	}
+}
+
+// Bans 返回当前的封禁记录
+func (t *BanTable) Bans() []Ban {
+	now := time.Now()
+	t.mu.Lock()
+	expired := t.purge(now)
+	bans := make([]Ban, 0, len(t.bans))
+	for _, b := range t.bans {
+		if now.Before(b.Until) {
+			bans = append(bans, b)
+		}
+	}
+	t.mu.Unlock()
+	t.notifyUnban(expired)
+	return bans
+}
+
+// Len 返回当前封禁的来源数
+func (t *BanTable) Len() int {
+	return len(t.Bans())
+}
+
+// limits 返回生效的阈值、窗口和封禁时长
+func (t *BanTable) limits() (int, time.Duration, time.Duration) {
+	return cmp.Or(t.Threshold, DefaultBanThreshold), cmp.Or(t.Window, DefaultBanWindow), cmp.Or(t.Duration, DefaultBanDuration)
+}
+
+// purge 删除到期的封禁和过期的失败计数，最多每 banPurgeInterval 执行一次，返回到期的封禁，调用方持有锁
+func (t *BanTable) purge(now time.Time) []Ban {
+	if now.Sub(t.lastPurge) < banPurgeInterval {
+		return nil
+	}
+	t.lastPurge = now
+	var expired []Ban
+	for ip, b := range t.bans {
+		if !now.Before(b.Until) {
+			delete(t.bans, ip)
+			expired = append(expired, b)
+		}
+	}
+	_, window, _ := t.limits()
+	for k, fc := range t.failures {
+		if now.Sub(fc.start) >= window {
+			delete(t.failures, k)
+		}
+	}
+	return expired
+}
+
+func (t *BanTable) notifyUnban(bans []Ban) {
+	for _, b := range bans {
+		log.Printf("Unbanned %s (%s)", b.IP, b.Reason)
+		t.Audit.Log(&AuditEvent{Event: AuditUnban, Client: b.IP, Rule: b.Reason})
+		if t.OnUnban != nil {
+			t.OnUnban(b.IP, b.Reason)
+		}
+	}
+}
+
+// recordMalformed 协商或请求阶段收到非 SOCKS5 数据或格式错误的请求时计入 Bans，
+// BanExemptWhitelisted 为 true 时白名单中的来源不计数
+func (s *Server) recordMalformed(c net.Conn, err error) {
+	if s.Bans == nil || !errors.Is(err, ErrVersion) && !errors.Is(err, ErrBadRequest) {
+		return
+	}
+	ip := remoteIP(c)
+	if ip == nil || s.BanExemptWhitelisted && s.inWhitelist(ip) {
+		return
+	}
+	s.Bans.Fail(ip, BanReasonMalformed)
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthQuantum 单次取令牌的最大字节数，大块写入被拆开排队，批量传输不会饿死交互流量
+const bandwidthQuantum = 16 * 1024
+
+// BandwidthLimiter 全局令牌桶，所有 TCP 转发和 UDP 交换共享，限制整个代理的总速率
+// 等待者按预约先后依次放行，每次最多 bandwidthQuantum 字节，相当于对各个流轮转
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒，0 表示不限速
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// 最近一个统计窗口的实际吞吐
+	total       int64
+	windowStart time.Time
+	windowBytes int64
+	throughput  float64
+}
+
+// NewBandwidthLimiter 创建速率为 bytesPerSec 的限速器，0 表示不限速
+func NewBandwidthLimiter(bytesPerSec int64) *BandwidthLimiter {
+	l := &BandwidthLimiter{}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate 在运行时调整速率，0 表示不限速，正在等待的流按原预约放行
+func (l *BandwidthLimiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.rate = float64(bytesPerSec)
+	// 允许约 100ms 的突发，至少一个 quantum
+	l.burst = max(l.rate/10, bandwidthQuantum)
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Rate 返回当前速率（字节/秒），0 表示不限速
+func (l *BandwidthLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// BandwidthStats 限速器的统计
+type BandwidthStats struct {
+	Rate        int64   `json:"rate"`        // 上限（字节/秒），0 表示不限速
+	Throughput  int64   `json:"throughput"`  // 最近一秒左右的实际速率（字节/秒）
+	Utilization float64 `json:"utilization"` // Throughput / Rate，不限速时为 0
+	Total       int64   `json:"total"`       // 累计放行的字节数
+}
+
+// Stats 返回当前上限、实际速率和利用率
+func (l *BandwidthLimiter) Stats() BandwidthStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	tp := l.throughput
+	st := BandwidthStats{Rate: int64(l.rate), Throughput: int64(tp), Total: l.total}
+	if l.rate > 0 {
+		st.Utilization = tp / l.rate
+	}
+	return st
+}
+
+// WaitN 等待发送 n 字节的配额，ctx 取消时返回其错误，nil 的限速器直接返回
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		q := min(n, bandwidthQuantum)
+		if d := l.reserve(q); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+		n -= q
+	}
+	return nil
+}
+
+// reserve 预约 n 字节，令牌可以透支，返回需要等待的时间
+func (l *BandwidthLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.advance(now)
+	l.total += int64(n)
+	l.windowBytes += int64(n)
+	if l.rate <= 0 {
+		return 0
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// advance 按经过的时间补充令牌并滚动统计窗口，调用方持有锁
+func (l *BandwidthLimiter) advance(now time.Time) {
+	if l.last.IsZero() {
+		l.last, l.windowStart = now, now
+	}
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	}
+	l.last = now
+	if el := now.Sub(l.windowStart); el >= time.Second {
+		l.throughput = float64(l.windowBytes) / el.Seconds()
+		l.windowStart, l.windowBytes = now, 0
+	}
+}
+
+// limitedWriter 写入前向限速器申请配额
+type limitedWriter struct {
+	w   io.Writer
+	l   *BandwidthLimiter
+	ctx context.Context
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if err := w.l.WaitN(w.ctx, len(b)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(b)
+}
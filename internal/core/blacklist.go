@@ -0,0 +1,76 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// ErrBlacklisted is the error when the client IP is in the blacklist
+var ErrBlacklisted = errors.New("in blacklist")
+
+// SetBlacklist 在运行时原子替换黑名单，黑名单中的来源即使在白名单中也被拒绝；正在进行的连接不受影响，需要时调用 SweepDenied
+func (s *Server) SetBlacklist(blackList []string) {
+	deniedIPs, deniedCIDRs := parseIPList("blacklist", blackList)
+	s.whitelistMu.Lock()
+	s.deniedIPs = deniedIPs
+	s.deniedCIDRs = deniedCIDRs
+	s.whitelistMu.Unlock()
+}
+
+// IsDenied 检查 IP 是否在黑名单中
+func (s *Server) IsDenied(ip net.IP) bool {
+	s.whitelistMu.RLock()
+	defer s.whitelistMu.RUnlock()
+	ip = unmapIP(ip)
+	if _, ok := s.deniedIPs[ip.String()]; ok {
+		return true
+	}
+	for _, ipNet := range s.deniedCIDRs {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whitelist 返回当前生效的白名单条目（规范化后的 IP 和 CIDR，已排序），为空表示允许所有 IP
+func (s *Server) Whitelist() []string {
+	s.whitelistMu.RLock()
+	defer s.whitelistMu.RUnlock()
+	return ipListEntries(s.AllowedIPs, s.AllowedCIDRs)
+}
+
+// Blacklist 返回当前生效的黑名单条目，形式与 Whitelist 相同
+func (s *Server) Blacklist() []string {
+	s.whitelistMu.RLock()
+	defer s.whitelistMu.RUnlock()
+	return ipListEntries(s.deniedIPs, s.deniedCIDRs)
+}
+
+func ipListEntries(ips map[string]struct{}, cidrs []*net.IPNet) []string {
+	entries := make([]string, 0, len(ips)+len(cidrs))
+	for ip := range ips {
+		entries = append(entries, ip)
+	}
+	for _, n := range cidrs {
+		entries = append(entries, n.String())
+	}
+	slices.Sort(entries)
+	return slices.Compact(entries)
+}
+
+// NormalizeIPEntry 把白名单或黑名单条目规范化为 SetWhitelist 记录的形式（IPv4-mapped 地址按 IPv4，CIDR 取网络地址），
+// 不是 IP 或 CIDR 时返回错误
+func NormalizeIPEntry(entry string) (string, error) {
+	s := strings.TrimSpace(entry)
+	if p, single, ok := parseIPEntry(s); ok {
+		if single {
+			return p.Addr().String(), nil
+		}
+		return p.String(), nil
+	}
+	return "", fmt.Errorf("invalid IP or CIDR %q", entry)
+}
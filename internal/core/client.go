@@ -1,8 +1,11 @@
 package core
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -18,17 +21,38 @@ type Client struct {
 	TCPTimeout    int
 	UDPTimeout    int
 	Dst           string
+	// TLSConfig is used to wrap the control connection when not nil (socks5s://)
+	TLSConfig *tls.Config
+	// ServerDialer opens the control connection to the server instead of DialTCP when not nil,
+	// e.g. a stream of a multiplexed transport
+	ServerDialer func() (net.Conn, error)
 }
 
-// This is just create a client, you need to use Dial to create conn
+// This is just create a client, you need to use Dial to create conn.
+// addr may be host:port, socks5://host:port or socks5s://host:port, the latter speaks SOCKS5 inside TLS.
+// A username or password longer than 255 bytes or not valid UTF-8 is rejected, see ErrCredentialTooLong.
 func NewClient(addr, username, password string, tcpTimeout, udpTimeout int) (*Client, error) {
+	if err := validateCredential(username, password); err != nil {
+		return nil, err
+	}
 	c := &Client{
-		Server:     addr,
 		UserName:   username,
 		Password:   password,
 		TCPTimeout: tcpTimeout,
 		UDPTimeout: udpTimeout,
 	}
+	switch {
+	case strings.HasPrefix(addr, "socks5s://"):
+		addr = strings.TrimPrefix(addr, "socks5s://")
+		h, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.TLSConfig = &tls.Config{ServerName: h}
+	case strings.HasPrefix(addr, "socks5://"):
+		addr = strings.TrimPrefix(addr, "socks5://")
+	}
+	c.Server = addr
 	return c, nil
 }
 
@@ -38,17 +62,23 @@ func (c *Client) Dial(network, addr string) (net.Conn, error) {
 
 // If you want to send address that expects to use to send UDP, just assign it to src, otherwise it will send zero address.
 // Recommend specifying the src address in a non-NAT environment, and leave it blank in other cases.
-func (c *Client) DialWithLocalAddr(network, src, dst string, remoteAddr net.Addr) (net.Conn, error) {
+func (c *Client) DialWithLocalAddr(network, src, dst string, remoteAddr net.Addr) (conn net.Conn, err error) {
 	c = &Client{
 		Server:        c.Server,
 		UserName:      c.UserName,
 		Password:      c.Password,
 		TCPTimeout:    c.TCPTimeout,
 		UDPTimeout:    c.UDPTimeout,
+		TLSConfig:     c.TLSConfig,
+		ServerDialer:  c.ServerDialer,
 		Dst:           dst,
 		RemoteAddress: remoteAddr,
 	}
-	var err error
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
 	if network == "tcp" {
 		var laddr net.Addr
 		if src != "" {
@@ -197,12 +227,20 @@ func (c *Client) SetWriteDeadline(t time.Time) error {
 }
 
 func (c *Client) Negotiate(laddr net.Addr) error {
+	// UserName、Password 可能在 NewClient 之后被直接修改，超长时长度字节会被截断，不连接服务端
+	if len(c.UserName) > 255 || len(c.Password) > 255 {
+		return ErrCredentialTooLong
+	}
 	src := ""
 	if laddr != nil {
 		src = laddr.String()
 	}
 	var err error
-	c.TCPConn, err = DialTCP("tcp", src, c.Server)
+	if c.ServerDialer != nil {
+		c.TCPConn, err = c.ServerDialer()
+	} else {
+		c.TCPConn, err = DialTCP("tcp", src, c.Server, DefaultDialTimeout)
+	}
 	if err != nil {
 		return err
 	}
@@ -211,8 +249,17 @@ func (c *Client) Negotiate(laddr net.Addr) error {
 			return err
 		}
 	}
+	if c.TLSConfig != nil {
+		tc := tls.Client(c.TCPConn, c.TLSConfig)
+		if err := tc.Handshake(); err != nil {
+			c.TCPConn.Close()
+			return err
+		}
+		c.TCPConn = tc
+	}
 	m := MethodNone
-	if c.UserName != "" && c.Password != "" {
+	// 只有用户名或只有密码时同样进行用户名密码认证，另一项以长度 0 发送
+	if c.UserName != "" || c.Password != "" {
 		m = MethodUsernamePassword
 	}
 	rq := NewNegotiationRequest([]byte{m})
@@ -242,6 +289,15 @@ func (c *Client) Negotiate(laddr net.Addr) error {
 	return nil
 }
 
+// ReplyError is returned by Client.Request when the server replies a failure REP
+type ReplyError struct {
+	Rep byte
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("Server replied failure REP 0x%02x", e.Rep)
+}
+
 func (c *Client) Request(r *Request) (*Reply, error) {
 	if _, err := r.WriteTo(c.TCPConn); err != nil {
 		return nil, err
@@ -251,7 +307,7 @@ func (c *Client) Request(r *Request) (*Reply, error) {
 		return nil, err
 	}
 	if rp.Rep != RepSuccess {
-		return nil, errors.New("Host unreachable")
+		return nil, &ReplyError{Rep: rp.Rep}
 	}
 	return rp, nil
 }
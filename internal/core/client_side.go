@@ -27,7 +27,7 @@ func (r *NegotiationRequest) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent NegotiationRequest: %#v %#v %#v\n", r.Ver, r.NMethods, r.Methods)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
@@ -42,13 +42,14 @@ func NewNegotiationReplyFrom(r io.Reader) (*NegotiationReply, error) {
 	if bb[0] != Ver {
 		return nil, ErrVersion
 	}
-	if Debug {
-		log.Printf("Got NegotiationReply: %#v %#v\n", bb[0], bb[1])
-	}
-	return &NegotiationReply{
+	nr := &NegotiationReply{
 		Ver:    bb[0],
 		Method: bb[1],
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", nr)
+	}
+	return nr, nil
 }
 
 // NewUserPassNegotiationRequest return user password negotiation request packet can be writed into server
@@ -69,7 +70,7 @@ func (r *UserPassNegotiationRequest) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent UserNameNegotiationRequest: %#v %#v %#v %#v %#v\n", r.Ver, r.Ulen, r.Uname, r.Plen, r.Passwd)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
@@ -84,13 +85,14 @@ func NewUserPassNegotiationReplyFrom(r io.Reader) (*UserPassNegotiationReply, er
 	if bb[0] != UserPassVer {
 		return nil, ErrUserPassVersion
 	}
-	if Debug {
-		log.Printf("Got UserPassNegotiationReply: %#v %#v \n", bb[0], bb[1])
-	}
-	return &UserPassNegotiationReply{
+	ur := &UserPassNegotiationReply{
 		Ver:    bb[0],
 		Status: bb[1],
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", ur)
+	}
+	return ur, nil
 }
 
 // NewRequest return request packet can be writed into server, dstaddr should not have domain length
@@ -115,7 +117,7 @@ func (r *Request) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent Request: %#v %#v %#v %#v %#v %#v\n", r.Ver, r.Cmd, r.Rsv, r.Atyp, r.DstAddr, r.DstPort)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
@@ -162,15 +164,16 @@ func NewReplyFrom(r io.Reader) (*Reply, error) {
 	if _, err := io.ReadFull(r, port); err != nil {
 		return nil, err
 	}
-	if Debug {
-		log.Printf("Got Reply: %#v %#v %#v %#v %#v %#v\n", bb[0], bb[1], bb[2], bb[3], addr, port)
-	}
-	return &Reply{
+	rp := &Reply{
 		Ver:     bb[0],
 		Rep:     bb[1],
 		Rsv:     bb[2],
 		Atyp:    bb[3],
 		BndAddr: addr,
 		BndPort: port,
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", rp)
+	}
+	return rp, nil
 }
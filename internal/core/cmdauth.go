@@ -0,0 +1,50 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAuthRequired is the error when an unauthenticated client sends a command that CommandAuth requires authentication for
+var ErrAuthRequired = errors.New("command requires authentication")
+
+// AuthRequirement 命令要求的认证级别
+type AuthRequirement byte
+
+const (
+	AuthNone     AuthRequirement = iota // 无认证的连接也可以使用
+	AuthRequired                        // 须经用户名密码或客户端证书认证
+)
+
+// Authenticated 报告会话是否经过认证：用户名密码子协商成功，或出示了已验证的客户端证书
+func (sess *Session) Authenticated() bool {
+	return sess.Method == MethodUsernamePassword || sess.CertUser != ""
+}
+
+// allowsAnonymous 报告 CommandAuth 中是否有命令允许无认证的连接
+func (s *Server) allowsAnonymous() bool {
+	for _, req := range s.CommandAuth {
+		if req == AuthNone {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommandAuth 按 CommandAuth 检查请求的命令，未认证的连接发送要求认证的命令时以 REP 0x02 拒绝，
+// 记录访问日志和审计日志；CommandAuth 中未列出的命令要求认证
+func (s *Server) checkCommandAuth(sess *Session, r *Request) error {
+	if s.CommandAuth == nil || sess.Authenticated() {
+		return nil
+	}
+	if req, ok := s.CommandAuth[r.Cmd]; ok && req == AuthNone {
+		return nil
+	}
+	err := fmt.Errorf("%s: %w", CmdName(r.Cmd), ErrAuthRequired)
+	rec := newAccessRecord(sess, r)
+	rec.Rep = RepNotAllowed
+	rec.finish(s.AccessLog, err)
+	s.Audit.event(AuditDenied, sess.RemoteAddr(), "", r.Address(), "command-auth", CmdName(r.Cmd))
+	r.Reject(sess, RepNotAllowed)
+	return &RequestError{clientError{Client: sess.RemoteAddr(), Err: err}}
+}
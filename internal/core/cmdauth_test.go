@@ -0,0 +1,127 @@
+package core_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// noReply 连接在应答之前被关闭
+const noReply = 0xff
+
+// requestWithMethods 提供 methods 进行协商，服务端选择用户名密码时以 auth 认证，然后发送 cmd 请求；
+// 返回服务端选择的方法和请求的应答码，协商或认证失败时应答码为 noReply
+func requestWithMethods(t *testing.T, proxy string, methods []byte, auth *socks5test.Auth, cmd byte, dst string) (byte, byte) {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	core.NewNegotiationRequest(methods).WriteTo(c)
+	rp, err := core.NewNegotiationReplyFrom(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch rp.Method {
+	case core.MethodUnsupportAll:
+		return rp.Method, noReply
+	case core.MethodUsernamePassword:
+		if auth == nil {
+			return rp.Method, noReply
+		}
+		core.NewUserPassNegotiationRequest([]byte(auth.User), []byte(auth.Password)).WriteTo(c)
+		if urp, err := core.NewUserPassNegotiationReplyFrom(c); err != nil || urp.Status != core.UserPassStatusSuccess {
+			return rp.Method, noReply
+		}
+	}
+	atyp, addr, port, err := core.ParseAddress(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.NewRequest(cmd, atyp, addr, port).WriteTo(c)
+	r, err := core.NewReplyFrom(c)
+	if err != nil {
+		return rp.Method, noReply
+	}
+	return rp.Method, r.Rep
+}
+
+func TestCommandAuthNegotiation(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	// 匿名客户端可以 CONNECT，UDP ASSOCIATE 要求认证
+	ts := socks5test.NewServer(t, socks5test.WithAuth("u", "p"), func(s *core.Server) {
+		s.CommandAuth = map[byte]core.AuthRequirement{core.CmdConnect: core.AuthNone, core.CmdUDP: core.AuthRequired}
+	})
+	user := &socks5test.Auth{User: "u", Password: "p"}
+	none, userpass := []byte{core.MethodNone}, []byte{core.MethodUsernamePassword}
+	both := []byte{core.MethodNone, core.MethodUsernamePassword}
+	for _, tc := range []struct {
+		name    string
+		methods []byte
+		auth    *socks5test.Auth
+		cmd     byte
+		method  byte // 服务端选择的方法
+		rep     byte
+	}{
+		// 只提供无认证的客户端以无认证继续，要求认证的命令在请求阶段被拒绝
+		{"anonymous connect", none, nil, core.CmdConnect, core.MethodNone, core.RepSuccess},
+		{"anonymous udp", none, nil, core.CmdUDP, core.MethodNone, core.RepNotAllowed},
+		// BIND 没有实现，先于认证要求报告不支持
+		{"anonymous bind", none, nil, core.CmdBind, core.MethodNone, core.RepCommandNotSupported},
+		// 同时提供两种方法时服务端选择用户名密码，认证后所有命令可用
+		{"both connect", both, user, core.CmdConnect, core.MethodUsernamePassword, core.RepSuccess},
+		{"both udp", both, user, core.CmdUDP, core.MethodUsernamePassword, core.RepSuccess},
+		{"userpass udp", userpass, user, core.CmdUDP, core.MethodUsernamePassword, core.RepSuccess},
+		// 选择了用户名密码就必须认证成功，不能退回无认证
+		{"both wrong password", both, &socks5test.Auth{User: "u", Password: "x"}, core.CmdConnect, core.MethodUsernamePassword, noReply},
+	} {
+		dst := echo
+		if tc.cmd == core.CmdUDP {
+			dst = "0.0.0.0:0"
+		}
+		method, rep := requestWithMethods(t, ts.Addr, tc.methods, tc.auth, tc.cmd, dst)
+		if method != tc.method || rep != tc.rep {
+			t.Errorf("%s: method 0x%02x rep 0x%02x, want 0x%02x 0x%02x", tc.name, method, rep, tc.method, tc.rep)
+		}
+	}
+
+	// 认证后的 UDP 关联能转发数据报
+	uecho := socks5test.EchoUDPServer(t)
+	ctl, relay := socks5test.Associate(t, ts.Addr, user)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay, uecho, []byte("authenticated"))
+}
+
+func TestCommandAuthAllRequired(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	// 没有命令允许匿名时与不设置 CommandAuth 相同，只提供无认证的客户端在协商阶段被拒绝
+	ts := socks5test.NewServer(t, socks5test.WithAuth("u", "p"), func(s *core.Server) {
+		s.CommandAuth = map[byte]core.AuthRequirement{core.CmdConnect: core.AuthRequired}
+	})
+	if method, _ := requestWithMethods(t, ts.Addr, []byte{core.MethodNone}, nil, core.CmdConnect, echo); method != core.MethodUnsupportAll {
+		t.Fatalf("anonymous client got method 0x%02x, want 0xff", method)
+	}
+	if _, rep := requestWithMethods(t, ts.Addr, []byte{core.MethodNone, core.MethodUsernamePassword}, &socks5test.Auth{User: "u", Password: "p"}, core.CmdConnect, echo); rep != core.RepSuccess {
+		t.Fatalf("authenticated connect rep 0x%02x", rep)
+	}
+}
+
+func TestCommandAuthWithoutCredentials(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	// 服务端不提供认证时要求认证的命令永远不可用
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.CommandAuth = map[byte]core.AuthRequirement{core.CmdConnect: core.AuthNone, core.CmdUDP: core.AuthRequired}
+	})
+	both := []byte{core.MethodNone, core.MethodUsernamePassword}
+	if method, rep := requestWithMethods(t, ts.Addr, both, nil, core.CmdConnect, echo); method != core.MethodNone || rep != core.RepSuccess {
+		t.Fatalf("connect: method 0x%02x rep 0x%02x", method, rep)
+	}
+	if _, rep := requestWithMethods(t, ts.Addr, both, nil, core.CmdUDP, "0.0.0.0:0"); rep != core.RepNotAllowed {
+		t.Fatalf("udp rep 0x%02x, want RepNotAllowed", rep)
+	}
+}
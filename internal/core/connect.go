@@ -1,57 +1,66 @@
 package core
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
 )
 
-func (r *Request) Connect(w io.Writer) (net.Conn, error) {
+// ErrRequestDeadline is the error when the request's context expires before the connection to the destination is established
+var ErrRequestDeadline = errors.New("request deadline exceeded")
+
+// Connect 通过出站拨号器连接目标地址并向客户端写回应答，失败时按错误类型选择 REP
+// ctx 的截止时间和 WithDialTimeout 设置的超时同时限制解析和建立连接，到期时应答 RepTTLExpired；
+// 拨号器返回时 ctx 已到期或被取消则不再使用建立的连接，不写成功应答
+func (r *Request) Connect(ctx context.Context, w io.Writer, d Dialer) (net.Conn, error) {
 	if Debug {
 		log.Println("Call:", r.Address())
 	}
-	rc, err := DialTCP("tcp", "", r.Address())
-	if err != nil {
-		var p *Reply
-		if r.Atyp == ATYPIPv4 || r.Atyp == ATYPDomain {
-			p = NewReply(RepHostUnreachable, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
-		} else {
-			p = NewReply(RepHostUnreachable, ATYPIPv6, []byte(net.IPv6zero), []byte{0x00, 0x00})
+	dctx := ctx
+	if t, ok := DialTimeoutFromContext(ctx); ok && t > 0 {
+		var cancel context.CancelFunc
+		dctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	rc, err := d.DialContext(dctx, "tcp", r.Address())
+	if cerr := dctx.Err(); cerr != nil {
+		if err == nil {
+			rc.Close()
+			err = context.Cause(dctx)
 		}
-		if _, err := p.WriteTo(w); err != nil {
-			return nil, err
+		if errors.Is(cerr, context.DeadlineExceeded) {
+			cerr = ErrRequestDeadline
 		}
-		return nil, err
+		err = fmt.Errorf("%w: %w", cerr, err)
 	}
-
-	// 优化：直接从结构体获取 IP/Port
-	var a byte
-	var addr, port []byte
-
-	if tcpAddr, ok := rc.LocalAddr().(*net.TCPAddr); ok {
-		if ip4 := tcpAddr.IP.To4(); ip4 != nil {
-			a = ATYPIPv4
-			addr = ip4
-		} else {
-			a = ATYPIPv6
-			addr = tcpAddr.IP
-		}
-		port = make([]byte, 2)
-		binary.BigEndian.PutUint16(port, uint16(tcpAddr.Port))
-	} else {
-		var err error
-		a, addr, port, err = ParseAddress(rc.LocalAddr().String())
-		if err != nil {
-			rc.Close()
+	if err != nil {
+		if err := r.Reject(w, RepForError(err)); err != nil {
 			return nil, err
 		}
-		if a == ATYPDomain {
-			addr = addr[1:]
+		var ue *UpstreamError
+		var he *HTTPStatusError
+		if errors.As(err, &ue) || errors.As(err, &he) {
+			return nil, err
 		}
+		return nil, fmt.Errorf("connect %s: %w", r.Address(), err)
 	}
 
-	p := NewReply(RepSuccess, a, addr, port)
+	p := NewReplyFromAddr(RepSuccess, rc.LocalAddr())
+	// 经 NAT64 连接 IPv4 目标时本地地址是 IPv6，按客户端请求的地址族应答，不暴露 IPv6 地址
+	nc := rc
+	if fc, ok := rc.(*faultConn); ok {
+		nc = fc.Conn
+	}
+	if _, ok := nc.(*nat64Conn); ok && r.Atyp == ATYPIPv4 && p.Atyp == ATYPIPv6 {
+		p = NewReply(RepSuccess, ATYPIPv4, net.IPv4zero.To4(), p.BndPort)
+	}
 	if _, err := p.WriteTo(w); err != nil {
 		rc.Close()
 		return nil, err
@@ -59,3 +68,77 @@ func (r *Request) Connect(w io.Writer) (net.Conn, error) {
 
 	return rc, nil
 }
+
+// Reject 向客户端写回失败应答，BND.ADDR 按请求的地址族填零地址
+func (r *Request) Reject(w io.Writer, rep byte) error {
+	var p *Reply
+	if r.Atyp == ATYPIPv4 || r.Atyp == ATYPDomain {
+		p = NewReply(rep, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
+	} else {
+		p = NewReply(rep, ATYPIPv6, []byte(net.IPv6zero), []byte{0x00, 0x00})
+	}
+	if sess, ok := w.(*Session); ok {
+		sess.rejected.Store(true)
+	}
+	_, err := p.WriteTo(w)
+	return err
+}
+
+// RepForError 将出站拨号错误映射为 SOCKS5 应答码
+func RepForError(err error) byte {
+	// 期限到期时不论拨号器返回了什么错误
+	if errors.Is(err, ErrRequestDeadline) {
+		return RepTTLExpired
+	}
+	if errors.Is(err, context.Canceled) {
+		return RepServerFailure
+	}
+	var re *ReplyError
+	if errors.As(err, &re) {
+		return re.Rep
+	}
+	var he *HTTPStatusError
+	if errors.As(err, &he) {
+		switch he.StatusCode {
+		case http.StatusProxyAuthRequired, http.StatusForbidden:
+			return RepNotAllowed
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return RepHostUnreachable
+		}
+		return RepServerFailure
+	}
+	var ce *ssh.OpenChannelError
+	if errors.As(err, &ce) && ce.Reason == ssh.Prohibited {
+		return RepNotAllowed
+	}
+	if errors.Is(err, ErrBlocked) || errors.Is(err, ErrDestinationDenied) {
+		return RepNotAllowed
+	}
+	if errors.Is(err, ErrLocalPortsExhausted) {
+		return RepServerFailure
+	}
+	if errors.Is(err, ErrEgressForbidden) {
+		return RepNetworkUnreachable
+	}
+	var ue *UpstreamError
+	if errors.As(err, &ue) {
+		if errors.Is(ue.Err, ErrUpstreamDown) {
+			return RepNetworkUnreachable
+		}
+		var oe *net.OpError
+		if errors.As(ue.Err, &oe) && oe.Op == "dial" {
+			return RepNetworkUnreachable
+		}
+		return RepServerFailure
+	}
+	var ne net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return RepConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return RepNetworkUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return RepTTLExpired
+	}
+	return RepHostUnreachable
+}
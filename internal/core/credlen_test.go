@@ -0,0 +1,76 @@
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestCredentialLengthBoundary(t *testing.T) {
+	max, over := strings.Repeat("u", 255), strings.Repeat("u", 256)
+	for _, tc := range []struct {
+		name       string
+		user, pass string
+		want       error
+	}{
+		{"255/255", max, max, nil},
+		{"256 user", over, "p", core.ErrCredentialTooLong},
+		{"256 password", "u", over, core.ErrCredentialTooLong},
+		{"invalid utf-8", "u", "\xff\xfe", core.ErrCredentialNotUTF8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			check := func(what string, err error) {
+				t.Helper()
+				if !errors.Is(err, tc.want) {
+					t.Errorf("%s = %v, want %v", what, err, tc.want)
+				}
+				if err != nil && strings.Contains(err.Error(), over) {
+					t.Errorf("%s error contains the over-long credential", what)
+				}
+			}
+			_, err := core.NewClassicServer("127.0.0.1:0", "127.0.0.1", tc.user, tc.pass, 0, 0, nil)
+			check("NewClassicServer", err)
+			_, err = core.NewClient("127.0.0.1:1080", tc.user, tc.pass, 0, 0)
+			check("NewClient", err)
+			_, err = core.NewStaticCredentials(map[string]string{tc.user: tc.pass})
+			check("NewStaticCredentials", err)
+			check("ValidateUser", core.ValidateUser(tc.user, tc.pass))
+
+			path := filepath.Join(t.TempDir(), "users")
+			os.WriteFile(path, []byte(fmt.Sprintf("# users\nok pw\n%s %s\n", tc.user, tc.pass)), 0o600)
+			_, err = core.LoadUsersFile(path)
+			check("LoadUsersFile", err)
+			if err != nil && !strings.Contains(err.Error(), path+":3:") {
+				t.Errorf("LoadUsersFile error %q does not name line 3", err)
+			}
+		})
+	}
+}
+
+// 255 字节的用户名和密码能完整通过子协商
+func TestCredentialMaxLengthOnWire(t *testing.T) {
+	user, pass := strings.Repeat("u", 255), strings.Repeat("p", 255)
+	ts := socks5test.NewServer(t, socks5test.WithAuth(user, pass))
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: user, Password: pass}, echo)
+	socks5test.EchoTCP(t, c, []byte("max"))
+	if !authFails(t, ts.Addr, &socks5test.Auth{User: user, Password: pass[:254]}) {
+		t.Fatal("254-byte prefix of the password was accepted")
+	}
+
+	// 客户端在连接前拒绝被直接改成超长的凭据
+	cl, err := core.NewClient(ts.Addr, user, pass, 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Password = pass + "p"
+	if err := cl.Negotiate(nil); !errors.Is(err, core.ErrCredentialTooLong) {
+		t.Fatalf("Negotiate with a 256-byte password = %v, want ErrCredentialTooLong", err)
+	}
+}
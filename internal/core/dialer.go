@@ -0,0 +1,343 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+var (
+	// ErrUDPNotSupported is the error when the outbound dialer can not relay UDP
+	ErrUDPNotSupported = errors.New("UDP is not supported by the outbound dialer")
+	// ErrUDPTransport is the error when UDP ASSOCIATE is requested over a transport that can not carry it
+	ErrUDPTransport = errors.New("UDP ASSOCIATE is not supported on this transport")
+)
+
+// Dialer 出站拨号器，Request.Connect 和 UDP 转发通过它建立到目标的连接
+// network 为 "tcp" 或 "udp"，addr 为 host:port（host 可能是域名）；UDP 返回已连接到目标的连接，
+// 每次 Write 发送一个数据报、每次 Read 读取一个数据报。用户态协议栈可通过 StackDialer 接入
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Resolver 域名解析器，*net.Resolver 满足该接口
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// UDPCapable 由不一定支持 UDP 的出站拨号器实现，返回 false 时 ASSOCIATE 请求会被拒绝
+type UDPCapable interface {
+	SupportsUDP() bool
+}
+
+// DirectDialer 直接连接目标地址
+type DirectDialer struct {
+	Timeout time.Duration // TCP 拨号超时，0 表示不限制
+	Egress  *EgressTable  // 按目标选择本地源地址，nil 表示由系统选择
+	// Resolver 解析域名目标，nil 时退回到已弃用的包级 DialTCP/DialUDP
+	Resolver Resolver
+	// NAT64 非 nil 时 IPv4 目标（字面量或解析结果）改为连接前缀下合成的 IPv6 地址
+	NAT64 *NAT64
+	// Family 出站地址族策略，默认 FamilyAny 时域名目标解析出的地址按地址族交替排列
+	Family AddrFamily
+	// DSCP 非 0 时为出站套接字设置 IP_TOS 或 IPV6_TCLASS，ctx 携带 WithDSCP 设置的值时代替它
+	DSCP int
+	// LocalPorts 非 nil 时出站套接字的本地端口从该范围中选取
+	LocalPorts *PortRange
+	// AllowedEgress 非 nil 时每个目标地址在拨号前查路由，源地址不被允许的地址被跳过，都被跳过时返回 ErrEgressForbidden
+	AllowedEgress *EgressAllowlist
+	// Mark 非 0 时为出站套接字设置 fwmark（SO_MARK，仅 Linux），设置失败时拨号失败
+	Mark int
+	// MultipathTCP 为 true 时 TCP 连接使用 Multipath TCP，不支持时退回普通 TCP
+	MultipathTCP bool
+	// FastOpen 为 true 时 TCP 连接设置 TCP_FASTOPEN_CONNECT（仅 Linux）：有目标的 cookie 时 SYN 携带第一次写的数据，
+	// 目标不支持时内核自动按普通握手连接；此时连接错误可能在第一次写时才返回。内核拒绝时照常拨号
+	FastOpen bool
+	// Stats 非 nil 时 FastOpen 的尝试和被拒绝次数计入 TFOAttempts、TFORefused
+	Stats *ServerStats
+}
+
+func (d *DirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.DialFrom(ctx, network, d.Egress.LocalAddr(addr), addr)
+}
+
+// DialFrom 使用指定的本地地址（ip:port）拨号，laddr 为空时由系统选择
+// ctx 携带 WithDialTimeout 设置的超时时代替 d.Timeout，ctx 的截止时间更早时以它为准；域名目标经 Resolver 解析后依次尝试各个地址，见 dialAddrs
+func (d *DirectDialer) DialFrom(ctx context.Context, network, laddr, addr string) (net.Conn, error) {
+	timeout := d.Timeout
+	if t, ok := DialTimeoutFromContext(ctx); ok {
+		timeout = t
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		left := time.Until(dl)
+		if left <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	dscp := d.DSCP
+	if v, ok := DSCPFromContext(ctx); ok {
+		dscp = v
+	}
+	if d.Resolver == nil && d.NAT64 == nil && d.Family == FamilyAny && dscp == 0 && d.LocalPorts == nil && d.AllowedEgress == nil && d.Mark == 0 && !d.MultipathTCP && !d.FastOpen {
+		if network == "udp" {
+			return DialUDP("udp", laddr, addr)
+		}
+		return DialTCP(network, laddr, addr, timeout)
+	}
+	nd := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	nd.SetMultipathTCP(d.MultipathTCP)
+	if dscp != 0 {
+		nd.Control = dscpControl(dscp)
+	}
+	if d.Mark != 0 {
+		nd.Control = fwmarkControl(d.Mark, nd.Control)
+	}
+	if d.FastOpen {
+		nd.Control = fastOpenDialControl(d.Stats, nd.Control)
+	}
+	var localIP net.IP
+	if laddr != "" {
+		ap, err := netip.ParseAddrPort(laddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address %q: %w", laddr, err)
+		}
+		localIP = ap.Addr().AsSlice()
+		if network == "udp" {
+			nd.LocalAddr = net.UDPAddrFromAddrPort(ap)
+		} else {
+			nd.LocalAddr = net.TCPAddrFromAddrPort(ap)
+		}
+	}
+	dial := nd.DialContext
+	if d.LocalPorts != nil {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.LocalPorts.dial(ctx, *nd, network, addr)
+		}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.NAT64 == nil && d.Family == FamilyAny && d.AllowedEgress == nil && net.ParseIP(host) != nil {
+		return dial(ctx, network, addr)
+	}
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		r := d.Resolver
+		if r == nil {
+			r = defaultResolver()
+		}
+		if ips, err = lookupIPAddr(ctx, r, host); err != nil {
+			return nil, err
+		}
+	}
+	if d.NAT64 != nil {
+		ips = d.NAT64.mapIPs(ips)
+	}
+	if ips = d.Family.filter(ips); len(ips) == 0 {
+		return nil, &FamilyError{Host: host, Family: d.Family}
+	}
+	if d.Family == FamilyAny {
+		ips = interleaveFamilies(ips)
+	}
+	return d.dialAddrs(ctx, dial, network, localIP, ips, port, deadline)
+}
+
+// lookupIPAddr 解析域名，没有结果时返回 *net.DNSError
+func lookupIPAddr(ctx context.Context, r Resolver, host string) ([]net.IPAddr, error) {
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// resolveUDPAddr 使用 r 解析 UDP 地址，r 为 nil 时退回到已弃用的包级 Resolve
+func resolveUDPAddr(ctx context.Context, r Resolver, addr string) (*net.UDPAddr, error) {
+	if r == nil {
+		a, err := resolve("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return a.(*net.UDPAddr), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := net.LookupPort("udp", port)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p))), nil
+	}
+	ips, err := lookupIPAddr(ctx, r, host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ips[0].IP, Port: p, Zone: ips[0].Zone}, nil
+}
+
+// UpstreamError 表示与上游代理之间的失败（不可达、认证失败、协议错误），区别于目标地址的失败
+type UpstreamError struct {
+	Upstream string
+	Err      error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream %s: %v", e.Upstream, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// dialer 返回服务端使用的出站拨号器，未设置 Dialer 或上游不可用且允许回落时按服务端的超时、出站策略和解析器直接拨号
+func (s *Server) dialer() Dialer {
+	if s.Dialer != nil && !fallbackToDirect(s.Dialer) {
+		return s.Dialer
+	}
+	return s.directDialer()
+}
+
+// fallbackToDirect 判断上游是否被标记为不可用且允许回落到直连
+func fallbackToDirect(d Dialer) bool {
+	h, ok := d.(*HealthCheckedUpstream)
+	return ok && h.Fallback && h.Down()
+}
+
+type dialTimeoutKey struct{}
+
+// WithDialTimeout 返回覆盖出站拨号超时的 context，d 为 0 表示不限制
+// Request.Connect 对任意拨号器按它设置截止时间，DirectDialer 用它代替自身的 Timeout；
+// 中间件设置的值优先于路由规则的 timeout 选项
+func WithDialTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, dialTimeoutKey{}, d)
+}
+
+// DialTimeoutFromContext 取出 WithDialTimeout 设置的超时
+func DialTimeoutFromContext(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(dialTimeoutKey{}).(time.Duration)
+	return d, ok
+}
+
+// resolver 返回 s.Resolver，未设置或为 net.DefaultResolver 时使用 defaultResolver，服务端不使用已弃用的包级 DialTCP 和 DialUDP；
+// 按 ResolveTimeout 和 NegativeCache 限制解析时间、缓存失败结果
+func (s *Server) resolver() Resolver {
+	r := s.Resolver
+	if r == nil || r == Resolver(net.DefaultResolver) {
+		r = defaultResolver()
+	}
+	return s.NegativeCache.Resolver(r, s.ResolveTimeout)
+}
+
+// defaultResolver 返回 net.DefaultResolver，嵌入方设置了包级 Resolve 时返回经它解析的 hookResolver，
+// 替换不会被 Server 和 DirectDialer 的默认解析器绕过
+func defaultResolver() Resolver {
+	if Resolve != nil {
+		return hookResolver{}
+	}
+	return net.DefaultResolver
+}
+
+// hookResolver 以包级 Resolve 实现 Resolver，每次只得到一个地址
+type hookResolver struct{}
+
+func (hookResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	a, err := Resolve("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return []net.IPAddr{{IP: a.IP, Zone: a.Zone}}, nil
+	case *net.UDPAddr:
+		return []net.IPAddr{{IP: a.IP, Zone: a.Zone}}, nil
+	}
+	h, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return nil, err
+	}
+	ip, err := netip.ParseAddr(h)
+	if err != nil {
+		return nil, err
+	}
+	return []net.IPAddr{{IP: ip.AsSlice(), Zone: ip.Zone()}}, nil
+}
+
+// directDialer 返回按服务端配置直连的拨号器，配置了静态映射时先查映射
+func (s *Server) directDialer() *DirectDialer {
+	return &DirectDialer{
+		Timeout:       time.Duration(s.DialTimeout) * time.Second,
+		Egress:        s.Egress,
+		Resolver:      s.Hosts.Resolver(s.resolver()),
+		NAT64:         s.NAT64,
+		Family:        s.Family,
+		DSCP:          s.DSCP,
+		LocalPorts:    s.LocalPorts,
+		AllowedEgress: s.AllowedEgress,
+		MultipathTCP:  s.DialMPTCP,
+		FastOpen:      s.DialFastOpen,
+		Stats:         &s.Stats,
+	}
+}
+
+// route 按路由表为目标选择拨号器，未配置路由表时返回 s.dialer() 和 nil；
+// 会话所属的附加监听器设置了 Egress 时改用它，不查路由表
+func (s *Server) route(sess *Session, addr string) (Dialer, *Route) {
+	if p := profileOf(sess); p != nil && p.Egress != nil {
+		return s.profileRoute(p.Egress)
+	}
+	if s.Routes == nil {
+		return s.dialer(), nil
+	}
+	r := s.Routes.Match(addr)
+	switch {
+	case r.Block:
+		return blockDialer{}, r
+	case r.Dialer == nil:
+		d := s.directDialer()
+		if r.Resolver != nil {
+			d.Resolver = s.Hosts.Resolver(s.NegativeCache.Resolver(r.Resolver, s.ResolveTimeout))
+		}
+		return d, r
+	}
+	if fallbackToDirect(r.Dialer) {
+		return s.directDialer(), &Route{Name: r.Name + ">direct"}
+	}
+	return r.Dialer, r
+}
+
+// blockDialer 拒绝所有连接，用于被路由表拒绝的目标
+type blockDialer struct{}
+
+func (blockDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return nil, ErrBlocked
+}
+
+// SupportsUDP 判断出站拨号器能否转发 UDP，配置了路由表时只要有一条路由支持即可
+func (s *Server) SupportsUDP() bool {
+	if s.Routes != nil {
+		return s.Routes.supportsUDP()
+	}
+	if u, ok := s.Dialer.(UDPCapable); ok {
+		return u.SupportsUDP()
+	}
+	return true
+}
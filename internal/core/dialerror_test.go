@@ -0,0 +1,90 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// dialErrorCall OnDialError 的一次调用
+type dialErrorCall struct {
+	sess    *core.Session
+	dst     string
+	rep     byte
+	err     error
+	elapsed time.Duration
+}
+
+func TestOnDialError(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	calls := make(chan dialErrorCall, 4)
+	ts := socks5test.NewServer(t, socks5test.WithAuth("dave", "pw"), func(s *core.Server) {
+		// TCP 被拒绝，UDP 网络不可达，都在 delay 之后失败
+		s.Dialer = dialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			time.Sleep(delay)
+			errno := syscall.ECONNREFUSED
+			if network == "udp" {
+				errno = syscall.ENETUNREACH
+			}
+			return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("connect", errno)}
+		})
+		s.OnDialError = func(sess *core.Session, dst string, rep byte, err error, elapsed time.Duration) {
+			calls <- dialErrorCall{sess, dst, rep, err, elapsed}
+		}
+	})
+	auth := &socks5test.Auth{User: "dave", Password: "pw"}
+	next := func() dialErrorCall {
+		t.Helper()
+		select {
+		case c := <-calls:
+			return c
+		case <-time.After(3 * time.Second):
+			t.Fatal("OnDialError not called")
+		}
+		return dialErrorCall{}
+	}
+
+	socks5test.ExpectRep(t, ts.Addr, auth, core.CmdConnect, "192.0.2.10:8443", core.RepConnectionRefused)
+	c := next()
+	if c.sess == nil || c.sess.User != "dave" {
+		t.Fatalf("TCP session = %+v, want user dave", c.sess)
+	}
+	if c.dst != "192.0.2.10:8443" || c.rep != core.RepConnectionRefused || !errors.Is(c.err, syscall.ECONNREFUSED) {
+		t.Fatalf("TCP call = %q %s %v", c.dst, core.RepName(c.rep), c.err)
+	}
+	if c.elapsed < delay {
+		t.Fatalf("TCP elapsed %s, want at least %s", c.elapsed, delay)
+	}
+
+	ctl, relay := socks5test.Associate(t, ts.Addr, auth)
+	defer ctl.Close()
+	uc := listenUDP(t)
+	udpSend(t, uc, relay, "[2001:db8::9]:53", []byte("query"))
+	c = next()
+	if c.sess == nil || c.sess.User != "dave" {
+		t.Fatalf("UDP session = %+v, want the association's session", c.sess)
+	}
+	if c.dst != "[2001:db8::9]:53" || c.rep != core.RepNetworkUnreachable || !errors.Is(c.err, syscall.ENETUNREACH) {
+		t.Fatalf("UDP call = %q %s %v", c.dst, core.RepName(c.rep), c.err)
+	}
+	if c.elapsed < delay {
+		t.Fatalf("UDP elapsed %s, want at least %s", c.elapsed, delay)
+	}
+	// UDP 不发送应答
+	if d := udpRecv(t, uc, 100*time.Millisecond); d != nil {
+		t.Fatalf("client received %s after a failed UDP dial", d)
+	}
+
+	select {
+	case c := <-calls:
+		t.Fatalf("unexpected extra call %+v", c)
+	default:
+	}
+}
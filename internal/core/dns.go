@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSTimeout 单次 DNS 查询的默认超时时间
+const DefaultDNSTimeout = 5 * time.Second
+
+// DNSExchanger 由能转发原始 DNS 报文的解析器实现，DNS 转发模式使用它
+type DNSExchanger interface {
+	Exchange(ctx context.Context, q []byte) ([]byte, error)
+}
+
+// NewResolver 按描述创建域名解析器：
+//
+//	https://dns.example/dns-query   DNS-over-HTTPS
+//	tcp://1.1.1.1,8.8.8.8:53        通过 TCP 轮流使用这些 DNS 服务器
+//	1.1.1.1,8.8.8.8:53              通过 UDP 轮流使用这些 DNS 服务器
+//
+// 服务器未写端口时使用 53，timeout 为单次查询超时
+func NewResolver(spec string, timeout time.Duration) (Resolver, error) {
+	if strings.HasPrefix(spec, "https://") {
+		return &DoHResolver{URL: spec, Client: &http.Client{Timeout: timeout}}, nil
+	}
+	network := "udp"
+	if s, ok := strings.CutPrefix(spec, "tcp://"); ok {
+		network, spec = "tcp", s
+	} else if s, ok := strings.CutPrefix(spec, "udp://"); ok {
+		spec = s
+	}
+	var servers []string
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if net.ParseIP(s) != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			return nil, fmt.Errorf("invalid DNS server %q: %w", s, err)
+		}
+		servers = append(servers, s)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no DNS server in %q", spec)
+	}
+	return NewDNSResolver(servers, network, timeout), nil
+}
+
+// DNSResolver 只使用指定的 DNS 服务器解析域名，每次查询从下一个服务器开始轮换，失败或超时时依次尝试其余服务器
+type DNSResolver struct {
+	Servers []string // host:port
+	Network string   // "udp" 或 "tcp"，UDP 应答被截断时改用 TCP 重试
+	Timeout time.Duration
+	Egress  *EgressTable // 按服务器地址选择查询使用的本地源地址
+
+	next atomic.Uint32
+}
+
+// NewDNSResolver 创建使用指定服务器的解析器
+func NewDNSResolver(servers []string, network string, timeout time.Duration) *DNSResolver {
+	return &DNSResolver{Servers: servers, Network: network, Timeout: timeout}
+}
+
+// LookupIPAddr 并发查询 A 和 AAAA 记录，任一查询有结果即成功
+func (r *DNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return lookupBoth(ctx, host, strings.Join(r.Servers, ","), r.Exchange)
+}
+
+// Exchange 依次向各服务器发送查询，返回第一个有效应答
+func (r *DNSResolver) Exchange(ctx context.Context, q []byte) ([]byte, error) {
+	start := int(r.next.Add(1) - 1)
+	var err error
+	for i := range r.Servers {
+		server := r.Servers[(start+i)%len(r.Servers)]
+		var b []byte
+		b, err = r.exchangeWith(ctx, r.Network, server, q)
+		if err == nil && r.Network == "udp" && len(b) > 2 && b[2]&0x02 != 0 {
+			// TC 标志：应答被截断，改用 TCP
+			b, err = r.exchangeWith(ctx, "tcp", server, q)
+		}
+		if err == nil {
+			return b, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// exchangeWith 向一个服务器发送查询并读取应答，TCP 使用两字节长度前缀
+func (r *DNSResolver) exchangeWith(ctx context.Context, network, server string, q []byte) ([]byte, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	if laddr := r.Egress.LocalIP(server); laddr != nil {
+		if network == "udp" {
+			d.LocalAddr = &net.UDPAddr{IP: laddr}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: laddr}
+		}
+	}
+	c, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		c.SetDeadline(dl)
+	}
+	if network == "udp" {
+		if _, err := c.Write(q); err != nil {
+			return nil, err
+		}
+		b := make([]byte, 4096)
+		for {
+			n, err := c.Read(b)
+			if err != nil {
+				return nil, err
+			}
+			// 忽略 ID 不匹配的应答
+			if n >= 2 && b[0] == q[0] && b[1] == q[1] {
+				return b[:n], nil
+			}
+		}
+	}
+	msg := make([]byte, 2+len(q))
+	binary.BigEndian.PutUint16(msg, uint16(len(q)))
+	copy(msg[2:], q)
+	if _, err := c.Write(msg); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(c, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(c, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DoHResolver 通过 DNS-over-HTTPS（RFC 8484，POST application/dns-message）解析域名
+type DoHResolver struct {
+	URL    string
+	Client *http.Client
+}
+
+// LookupIPAddr 并发查询 A 和 AAAA 记录，任一查询有结果即成功
+func (r *DoHResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return lookupBoth(ctx, host, r.URL, r.Exchange)
+}
+
+// Exchange 通过 HTTP POST 发送一次查询
+func (r *DoHResolver) Exchange(ctx context.Context, q []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(q))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+// lookupBoth 通过 exchange 并发查询 A 和 AAAA 记录，server 只用于错误信息
+func lookupBoth(ctx context.Context, host, server string, exchange func(context.Context, []byte) ([]byte, error)) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	var (
+		wg   sync.WaitGroup
+		ips  [2][]net.IPAddr
+		errs [2]error
+	)
+	for i, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips[i], errs[i] = dnsQuery(ctx, host, t, exchange)
+		}()
+	}
+	wg.Wait()
+	all := append(ips[0], ips[1]...)
+	if len(all) > 0 {
+		return all, nil
+	}
+	if err := errors.Join(errs[0], errs[1]); err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, Server: server, IsTimeout: isTimeout(err)}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+}
+
+// dnsQuery 构造一次查询并解析应答中的地址，NXDOMAIN 返回空结果
+func dnsQuery(ctx context.Context, host string, t dnsmessage.Type, exchange func(context.Context, []byte) ([]byte, error)) ([]net.IPAddr, error) {
+	name, err := dnsmessage.NewName(dnsName(host))
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	q, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	b, err := exchange(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.Unpack(b); err != nil {
+		return nil, err
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, fmt.Errorf("DNS server returned %v", msg.RCode)
+	}
+	var ips []net.IPAddr
+	for _, a := range msg.Answers {
+		switch rr := a.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IPAddr{IP: net.IP(rr.A[:])})
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IPAddr{IP: net.IP(rr.AAAA[:])})
+		}
+	}
+	return ips, nil
+}
+
+// dnsName 返回以点结尾的完整域名
+func dnsName(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
@@ -0,0 +1,294 @@
+package core_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// stubDNS 本地 DNS 服务器，在同一端口上提供 UDP 和 TCP，按 records 应答，其他名字返回 NXDOMAIN
+type stubDNS struct {
+	addr     string
+	records  map[string][]net.IP // 以点结尾的名字
+	truncate atomic.Bool         // UDP 应答只带 TC 标志，迫使客户端改用 TCP
+	udpQ     atomic.Int64
+	tcpQ     atomic.Int64
+}
+
+func newStubDNS(t *testing.T, records map[string][]net.IP) *stubDNS {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("TCP port of the UDP stub is taken: %v", err)
+	}
+	t.Cleanup(func() { pc.Close(); l.Close() })
+	d := &stubDNS{addr: pc.LocalAddr().String(), records: records}
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			d.udpQ.Add(1)
+			pc.WriteTo(d.answer(b[:n], d.truncate.Load()), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var n [2]byte
+				if _, err := io.ReadFull(c, n[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(n[:]))
+				if _, err := io.ReadFull(c, q); err != nil {
+					return
+				}
+				d.tcpQ.Add(1)
+				a := d.answer(q, false)
+				c.Write(binary.BigEndian.AppendUint16(nil, uint16(len(a))))
+				c.Write(a)
+			}()
+		}
+	}()
+	return d
+}
+
+func (d *stubDNS) answer(q []byte, truncate bool) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(q); err != nil || len(m.Questions) != 1 {
+		return nil
+	}
+	m.Header.Response = true
+	m.Header.Truncated = truncate
+	if truncate {
+		b, _ := m.Pack()
+		return b
+	}
+	question := m.Questions[0]
+	ips, ok := d.records[question.Name.String()]
+	if !ok {
+		m.Header.RCode = dnsmessage.RCodeNameError
+	}
+	for _, ip := range ips {
+		h := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+			h.Type = dnsmessage.TypeA
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if ip.To4() == nil && question.Type == dnsmessage.TypeAAAA {
+			h.Type = dnsmessage.TypeAAAA
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}})
+		}
+	}
+	b, _ := m.Pack()
+	return b
+}
+
+var stubRecords = map[string][]net.IP{
+	"echo.test.": {net.IPv4(127, 0, 0, 1), net.ParseIP("::1")},
+	"v4.test.":   {net.IPv4(192, 0, 2, 7)},
+}
+
+func lookupStrings(t *testing.T, r core.Resolver, host string) ([]string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := r.LookupIPAddr(ctx, host)
+	var out []string
+	for _, ip := range ips {
+		out = append(out, ip.IP.String())
+	}
+	slices.Sort(out)
+	return out, err
+}
+
+func TestDNSResolverUDP(t *testing.T) {
+	d := newStubDNS(t, stubRecords)
+	r, err := core.NewResolver(d.addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := lookupStrings(t, r, "echo.test")
+	if err != nil || !slices.Equal(got, []string{"127.0.0.1", "::1"}) {
+		t.Fatalf("echo.test = %v, %v", got, err)
+	}
+	got, err = lookupStrings(t, r, "v4.test.")
+	if err != nil || !slices.Equal(got, []string{"192.0.2.7"}) {
+		t.Fatalf("v4.test = %v, %v", got, err)
+	}
+	_, err = lookupStrings(t, r, "missing.test")
+	var de *net.DNSError
+	if !errors.As(err, &de) || !de.IsNotFound {
+		t.Fatalf("missing.test error = %v, want not found", err)
+	}
+	if d.tcpQ.Load() != 0 {
+		t.Fatalf("%d TCP queries in UDP mode", d.tcpQ.Load())
+	}
+}
+
+func TestDNSResolverTCP(t *testing.T) {
+	d := newStubDNS(t, stubRecords)
+	r, err := core.NewResolver("tcp://"+d.addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lookupStrings(t, r, "v4.test"); err != nil || !slices.Equal(got, []string{"192.0.2.7"}) {
+		t.Fatalf("v4.test = %v, %v", got, err)
+	}
+	if d.udpQ.Load() != 0 || d.tcpQ.Load() != 2 {
+		t.Fatalf("queries udp %d tcp %d, want 0 and 2", d.udpQ.Load(), d.tcpQ.Load())
+	}
+}
+
+func TestDNSResolverTruncatedRetriesTCP(t *testing.T) {
+	d := newStubDNS(t, stubRecords)
+	d.truncate.Store(true)
+	r := core.NewDNSResolver([]string{d.addr}, "udp", time.Second)
+	if got, err := lookupStrings(t, r, "v4.test"); err != nil || !slices.Equal(got, []string{"192.0.2.7"}) {
+		t.Fatalf("v4.test = %v, %v", got, err)
+	}
+	if d.udpQ.Load() != 2 || d.tcpQ.Load() != 2 {
+		t.Fatalf("queries udp %d tcp %d, want 2 and 2", d.udpQ.Load(), d.tcpQ.Load())
+	}
+}
+
+func TestDNSResolverRotationAndFailover(t *testing.T) {
+	a := newStubDNS(t, stubRecords)
+	b := newStubDNS(t, stubRecords)
+	r := core.NewDNSResolver([]string{a.addr, b.addr}, "udp", time.Second)
+	for range 4 {
+		if _, err := lookupStrings(t, r, "v4.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.udpQ.Load() == 0 || b.udpQ.Load() == 0 {
+		t.Fatalf("queries not rotated: %d and %d", a.udpQ.Load(), b.udpQ.Load())
+	}
+
+	// 第一个服务器不应答：超时后改用下一个
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+	r = core.NewDNSResolver([]string{dead.LocalAddr().String(), a.addr}, "udp", 200*time.Millisecond)
+	start := time.Now()
+	if got, err := lookupStrings(t, r, "v4.test"); err != nil || !slices.Equal(got, []string{"192.0.2.7"}) {
+		t.Fatalf("failover lookup = %v, %v", got, err)
+	}
+	if el := time.Since(start); el > 2*time.Second {
+		t.Fatalf("failover took %v", el)
+	}
+}
+
+func TestDNSResolverTimeout(t *testing.T) {
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+	r := core.NewDNSResolver([]string{dead.LocalAddr().String()}, "udp", 100*time.Millisecond)
+	_, err = lookupStrings(t, r, "v4.test")
+	var de *net.DNSError
+	if !errors.As(err, &de) || !de.IsTimeout {
+		t.Fatalf("error = %v, want a timeout DNSError", err)
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	d := &stubDNS{records: stubRecords}
+	var posts atomic.Int64
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		posts.Add(1)
+		q, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(d.answer(q, false))
+	}))
+	defer hs.Close()
+	r := &core.DoHResolver{URL: hs.URL, Client: hs.Client()}
+	if got, err := lookupStrings(t, r, "echo.test"); err != nil || !slices.Equal(got, []string{"127.0.0.1", "::1"}) {
+		t.Fatalf("echo.test = %v, %v", got, err)
+	}
+	if posts.Load() != 2 {
+		t.Fatalf("%d POSTs, want 2 (A and AAAA)", posts.Load())
+	}
+
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+	if _, err := lookupStrings(t, &core.DoHResolver{URL: broken.URL}, "echo.test"); err == nil {
+		t.Fatal("lookup through a failing DoH server succeeded")
+	}
+}
+
+func TestNewResolverSpec(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		network string
+		servers []string
+	}{
+		{"1.1.1.1", "udp", []string{"1.1.1.1:53"}},
+		{"udp://1.1.1.1, 8.8.8.8:5353", "udp", []string{"1.1.1.1:53", "8.8.8.8:5353"}},
+		{"tcp://::1", "tcp", []string{"[::1]:53"}},
+	} {
+		r, err := core.NewResolver(tc.spec, time.Second)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.spec, err)
+		}
+		dr, ok := r.(*core.DNSResolver)
+		if !ok || dr.Network != tc.network || !slices.Equal(dr.Servers, tc.servers) {
+			t.Fatalf("%q = %+v", tc.spec, r)
+		}
+	}
+	if r, err := core.NewResolver("https://dns.example/dns-query", time.Second); err != nil {
+		t.Fatal(err)
+	} else if _, ok := r.(*core.DoHResolver); !ok {
+		t.Fatalf("https spec = %T", r)
+	}
+	for _, spec := range []string{"", " , ", "tcp://", "example.com"} {
+		if _, err := core.NewResolver(spec, time.Second); err == nil {
+			t.Fatalf("NewResolver(%q) succeeded", spec)
+		}
+	}
+}
+
+// CONNECT 的域名目标经服务端的解析器解析
+func TestConnectUsesResolver(t *testing.T) {
+	d := newStubDNS(t, map[string][]net.IP{"echo.test.": {net.IPv4(127, 0, 0, 1)}})
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.Resolver = core.NewDNSResolver([]string{d.addr}, "udp", time.Second) })
+	echo := socks5test.EchoTCPServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+	c := socks5test.Connect(t, ts.Addr, nil, net.JoinHostPort("echo.test", port))
+	socks5test.EchoTCP(t, c, []byte("resolved by the stub"))
+	if d.udpQ.Load() == 0 {
+		t.Fatal("stub DNS server was not queried")
+	}
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, net.JoinHostPort("missing.test", port), core.RepHostUnreachable)
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSMaxOutstanding DNS 转发同时处理的查询上限
+const DefaultDNSMaxOutstanding = 1024
+
+// DNSForwarder 在独立的 UDP 端口上应答普通（非 SOCKS 封装）的 DNS 查询
+// 静态映射中的 A/AAAA 查询直接应答，其余报文原样交给 Exchanger 转发
+type DNSForwarder struct {
+	Exchanger      DNSExchanger
+	Hosts          *Hosts
+	Timeout        time.Duration // 单个查询的超时
+	MaxOutstanding int           // 同时处理的查询上限，超过时应答 SERVFAIL
+
+	mu          sync.Mutex
+	outstanding map[dnsQueryKey]struct{}
+}
+
+// dnsQueryKey 标识一个未完成的查询，客户端重传的相同查询不会重复转发
+type dnsQueryKey struct {
+	client string
+	id     uint16
+}
+
+// ListenDNS 在 addr 上启动 DNS 转发，作为组件随 Serve 启动、随 Shutdown 停止
+// 上游使用服务端 Resolver（需要实现 DNSExchanger），否则使用 /etc/resolv.conf 中的服务器，都按出站策略选择源地址
+func (s *Server) ListenDNS(addr string, timeout time.Duration) (net.Addr, error) {
+	ex, ok := s.Resolver.(DNSExchanger)
+	if !ok {
+		servers, err := systemDNSServers("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		r := NewDNSResolver(servers, "udp", timeout)
+		r.Egress = s.Egress
+		ex = r
+	}
+	f := &DNSForwarder{Exchanger: ex, Hosts: s.Hosts, Timeout: timeout, MaxOutstanding: DefaultDNSMaxOutstanding}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.AddComponent(Component{
+		Name:  "dns",
+		Stage: StageAccept,
+		Start: func(ctx context.Context) error {
+			return s.serveErr(f.Serve(pc))
+		},
+		Stop: pc.Close,
+	})
+	return pc.LocalAddr(), nil
+}
+
+// Serve 读取查询并并发应答，直到 pc 被关闭
+func (f *DNSForwarder) Serve(pc net.PacketConn) error {
+	f.mu.Lock()
+	if f.outstanding == nil {
+		f.outstanding = make(map[dnsQueryKey]struct{})
+	}
+	f.mu.Unlock()
+	b := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(b[:n])
+		if err != nil || h.Response {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		key := dnsQueryKey{client: addr.String(), id: h.ID}
+		f.mu.Lock()
+		_, dup := f.outstanding[key]
+		full := len(f.outstanding) >= f.MaxOutstanding
+		if !dup && !full {
+			f.outstanding[key] = struct{}{}
+		}
+		f.mu.Unlock()
+		if dup {
+			continue
+		}
+		if full {
+			if resp := dnsErrorResponse(h, q, dnsmessage.RCodeServerFailure); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+			continue
+		}
+		query := append([]byte(nil), b[:n]...)
+		go func() {
+			defer func() {
+				f.mu.Lock()
+				delete(f.outstanding, key)
+				f.mu.Unlock()
+			}()
+			resp, err := f.answer(h, q, query)
+			if err != nil {
+				if Debug {
+					log.Printf("DNS query %s %s from %s: %v\n", q.Type, q.Name, addr, err)
+				}
+				resp = dnsErrorResponse(h, q, dnsmessage.RCodeServerFailure)
+			}
+			if resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// answer 先查静态映射，未命中时转发原始查询，应答的 ID 改回客户端的 ID
+func (f *DNSForwarder) answer(h dnsmessage.Header, q dnsmessage.Question, query []byte) ([]byte, error) {
+	if resp := f.hostsResponse(h, q); resp != nil {
+		return resp, nil
+	}
+	ctx := context.Background()
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	resp, err := f.Exchanger.Exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 {
+		return nil, errors.New("short DNS response")
+	}
+	resp[0], resp[1] = byte(h.ID>>8), byte(h.ID)
+	return resp, nil
+}
+
+// hostsResponse 为静态映射中的 A/AAAA 查询构造应答，未命中时返回 nil
+func (f *DNSForwarder) hostsResponse(h dnsmessage.Header, q dnsmessage.Question) []byte {
+	if f.Hosts == nil || q.Class != dnsmessage.ClassINET || (q.Type != dnsmessage.TypeA && q.Type != dnsmessage.TypeAAAA) {
+		return nil
+	}
+	ips, ok := f.Hosts.Lookup(q.Name.String())
+	if !ok {
+		return nil
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60}
+	for _, ip := range ips {
+		if ip4 := ip.IP.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if ip4 == nil && q.Type == dnsmessage.TypeAAAA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip.IP.To16())}})
+		}
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// dnsErrorResponse 构造只含问题部分的错误应答
+func dnsErrorResponse(h dnsmessage.Header, q dnsmessage.Question, rcode dnsmessage.RCode) []byte {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: h.ID, Response: true, OpCode: h.OpCode, RecursionDesired: h.RecursionDesired, RCode: rcode},
+		Questions: []dnsmessage.Question{q},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// systemDNSServers 读取 resolv.conf 中的 nameserver
+func systemDNSServers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var servers []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, errors.New(path + ": no nameserver found")
+	}
+	return servers, nil
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrDraining is the error when a UDP association is closed because the server stopped accepting to hand over to another process
+var ErrDraining = errors.New("server is draining")
+
+// drainPollInterval Drain 检查进行中连接数的间隔
+const drainPollInterval = 100 * time.Millisecond
+
+// ActiveConns 返回进行中的客户端连接数（包括协商中、转发中和持有 UDP 关联的控制连接）
+func (s *Server) ActiveConns() int64 {
+	return s.active.Load()
+}
+
+// Drain 停止接受新连接和新数据报（StageAccept 的组件），让进行中的 TCP 会话继续，
+// 在 timeout 内等待它们结束后调用 Shutdown 关闭剩下的；timeout 为 0 时不等待
+// UDP 关联立即以 ErrDraining 关闭：接管监听的进程读取同一个 UDP 套接字，旧进程的关联无法再收到数据报，
+// 客户端需要向新进程重新 ASSOCIATE
+func (s *Server) Drain(timeout time.Duration) error {
+	if err := s.stopStage(StageAccept); err != nil {
+		log.Printf("Drain: %v", err)
+	}
+	s.relays.m.Range(func(k, _ any) bool {
+		if r := k.(*relay); r.udp {
+			r.close(ErrDraining)
+		}
+		return true
+	})
+	deadline := time.Now().Add(timeout)
+	for s.ActiveConns() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if n := s.ActiveConns(); n > 0 {
+		log.Printf("Drain timeout after %s, closing %d connections", timeout, n)
+	}
+	return s.Shutdown()
+}
+
+// ListenFiles 由继承的文件描述符恢复 TCP 监听和 UDP 套接字（如升级时从父进程传来的），代替 Listen，之后同样交给 Serve
+// udp 为 nil 时不提供 UDP 转发；文件在恢复后关闭，监听使用各自复制的描述符
+func (s *Server) ListenFiles(tcp, udp *os.File) (*net.TCPListener, *net.UDPConn, error) {
+	fl, err := net.FileListener(tcp)
+	tcp.Close()
+	if err != nil {
+		if udp != nil {
+			udp.Close()
+		}
+		return nil, nil, err
+	}
+	l, ok := fl.(*net.TCPListener)
+	if !ok {
+		fl.Close()
+		if udp != nil {
+			udp.Close()
+		}
+		return nil, nil, errors.New("inherited listener is not TCP")
+	}
+	if udp == nil {
+		return l, nil, nil
+	}
+	fpc, err := net.FilePacketConn(udp)
+	udp.Close()
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	pc, ok := fpc.(*net.UDPConn)
+	if !ok {
+		fpc.Close()
+		l.Close()
+		return nil, nil, errors.New("inherited packet socket is not UDP")
+	}
+	s.markUDP(pc)
+	return l, pc, nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrDSCPUnsupported is the error when setting the IP TOS or traffic class is not supported on this platform
+var ErrDSCPUnsupported = errors.New("setting DSCP is not supported on this platform")
+
+// dscpNames 常用的 DSCP 名称（RFC 2474、2597、3246、5865）
+var dscpNames = map[string]int{
+	"BE": 0, "DF": 0, "EF": 46, "VA": 44,
+	"CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+}
+
+// ParseDSCP 解析 DSCP 名称（EF、AF11、CS1 等，不区分大小写）或 0-63 的数值
+func ParseDSCP(s string) (int, error) {
+	if v, ok := dscpNames[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, expected a name like EF or AF11 or a number 0-63", s)
+	}
+	return v, nil
+}
+
+type dscpKey struct{}
+
+// WithDSCP 返回覆盖出站套接字 DSCP 的 context，DirectDialer 用它代替自身的 DSCP，
+// 值为 0 时不设置（使用系统默认的 0）
+func WithDSCP(ctx context.Context, dscp int) context.Context {
+	return context.WithValue(ctx, dscpKey{}, dscp)
+}
+
+// DSCPFromContext 取出 WithDSCP 设置的 DSCP
+func DSCPFromContext(ctx context.Context) (int, bool) {
+	v, ok := ctx.Value(dscpKey{}).(int)
+	return v, ok
+}
+
+// dscpFor 按用户、路由规则的顺序选择覆盖 Server.DSCP 的值，都没有设置时返回 false
+func (s *Server) dscpFor(sess *Session, route *Route) (int, bool) {
+	if sess != nil {
+		if v, ok := s.UserDSCP[sess.User]; ok {
+			return v, true
+		}
+	}
+	if route != nil && route.DSCP != 0 {
+		return route.DSCP, true
+	}
+	return 0, false
+}
+
+// dscpWarnOnce 设置失败只警告一次，之后的连接照常建立、不带标记
+var dscpWarnOnce sync.Once
+
+// markSocket 为套接字设置 DSCP，失败时记录一次警告并继续使用未标记的套接字
+func markSocket(network string, rc syscall.RawConn, dscp int) {
+	if err := setDSCP(network, rc, dscp); err != nil {
+		dscpWarnOnce.Do(func() {
+			log.Printf("Warning: cannot set DSCP %d on %s sockets, traffic is not marked: %v", dscp, network, err)
+		})
+	}
+}
+
+// dscpControl 返回为新建套接字设置 DSCP 的 net.Dialer.Control
+func dscpControl(dscp int) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		markSocket(network, rc, dscp)
+		return nil
+	}
+}
+
+// markUDP 为服务端的 UDP 套接字设置 Server.DSCP，所有应答数据报共用这个套接字，因此只能使用全局的值
+func (s *Server) markUDP(pc *net.UDPConn) {
+	if s.DSCP == 0 {
+		return
+	}
+	rc, err := pc.SyscallConn()
+	if err != nil {
+		log.Printf("Warning: cannot set DSCP on the UDP socket: %v", err)
+		return
+	}
+	network := "udp4"
+	if la, ok := pc.LocalAddr().(*net.UDPAddr); ok && la.IP.To4() == nil {
+		network = "udp6"
+	}
+	markSocket(network, rc, s.DSCP)
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDSCP 设置 IP_TOS，IPv6 套接字设置 IPV6_TCLASS，并尽量同时设置 IP_TOS 以覆盖经该套接字发出的 IPv4 映射地址流量
+func setDSCP(network string, rc syscall.RawConn, dscp int) error {
+	tos := dscp << 2
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+			unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+			return
+		}
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,198 @@
+package core_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// tos 读取套接字的 DSCP：IPv4 套接字为 IP_TOS，IPv6 套接字为 IPV6_TCLASS，去掉低两位的 ECN
+func tos(t *testing.T, rc syscall.RawConn, v6 bool) int {
+	t.Helper()
+	var v int
+	var err error
+	rc.Control(func(fd uintptr) { v, err = sockTOS(int(fd), v6) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func sockTOS(fd int, v6 bool) (int, error) {
+	var v int
+	var err error
+	if v6 {
+		v, err = unix.GetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS)
+	} else {
+		v, err = unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS)
+	}
+	return v >> 2, err
+}
+
+func connTOS(t *testing.T, c net.Conn, v6 bool) int {
+	t.Helper()
+	rc, err := c.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tos(t, rc, v6)
+}
+
+// outboundFD 在本进程的套接字中找到连向 peer 的那个，即服务端的出站连接
+func outboundFD(t *testing.T, peer string) (int, bool) {
+	t.Helper()
+	ap, err := net.ResolveTCPAddr("tcp", peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot list file descriptors: %v", err)
+	}
+	for _, e := range ents {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		sa, err := unix.Getpeername(fd)
+		if err != nil {
+			continue
+		}
+		if in4, ok := sa.(*unix.SockaddrInet4); ok && in4.Port == ap.Port && net.IP(in4.Addr[:]).Equal(ap.IP) {
+			return fd, true
+		}
+	}
+	return 0, false
+}
+
+// outboundTOS 返回服务端连向 peer 的出站连接的 DSCP
+func outboundTOS(t *testing.T, peer string) int {
+	t.Helper()
+	fd, ok := outboundFD(t, peer)
+	if !ok {
+		t.Fatalf("no outbound socket to %s", peer)
+	}
+	v, err := sockTOS(fd, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// waitOutboundClosed 等待服务端连向 peer 的出站连接关闭
+func waitOutboundClosed(t *testing.T, peer string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := outboundFD(t, peer); !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbound socket to %s still open", peer)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDSCPDirectDialer(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	uecho := socks5test.EchoUDPServer(t)
+	d := &core.DirectDialer{DSCP: 46}
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		network string
+		addr    string
+		want    int
+	}{
+		{"tcp", context.Background(), "tcp", echo, 46},
+		{"udp", context.Background(), "udp", uecho, 46},
+		{"tcp override", core.WithDSCP(context.Background(), 10), "tcp", echo, 10},
+		{"udp override", core.WithDSCP(context.Background(), 10), "udp", uecho, 10},
+		// WithDSCP 为 0 时不标记
+		{"tcp zero", core.WithDSCP(context.Background(), 0), "tcp", echo, 0},
+	} {
+		c, err := d.DialContext(tc.ctx, tc.network, tc.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := connTOS(t, c, false); got != tc.want {
+			t.Errorf("%s: DSCP %d, want %d", tc.name, got, tc.want)
+		}
+		c.Close()
+	}
+
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := connTOS(t, c, true); got != 46 {
+		t.Errorf("IPv6 traffic class DSCP %d, want 46", got)
+	}
+}
+
+func TestDSCPServerSockets(t *testing.T) {
+	echoes := make([]string, 4)
+	for i := range echoes {
+		echoes[i] = socks5test.EchoTCPServer(t)
+	}
+	_, p, _ := net.SplitHostPort(echoes[1])
+	routes := filepath.Join(t.TempDir(), "routes")
+	os.WriteFile(routes, []byte("route 127.0.0.1/32 "+p+" direct dscp=AF41\ndefault direct\n"), 0o600)
+	rt, err := core.LoadRoutesFile(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := socks5test.NewServer(t, socks5test.WithAuth("bulk", "pw"), func(s *core.Server) {
+		s.DSCP = 46
+		s.Routes = rt
+		s.UserDSCP = map[string]int{"voice": 44}
+		s.Auth, _ = core.NewStaticCredentials(map[string]string{"bulk": "pw", "voice": "pw"})
+	})
+
+	// UDP 应答套接字只能使用全局的值
+	if got := connTOS(t, ts.UDPConn, false); got != 46 {
+		t.Errorf("UDP reply socket DSCP %d, want 46", got)
+	}
+
+	for _, tc := range []struct {
+		name string
+		user string
+		dst  string
+		want int
+	}{
+		{"global", "bulk", echoes[0], 46},
+		{"route", "bulk", echoes[1], 34},
+		{"user over global", "voice", echoes[2], 44},
+		{"user over route", "voice", echoes[1], 44},
+	} {
+		c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: tc.user, Password: "pw"}, tc.dst)
+		socks5test.EchoTCP(t, c, []byte(tc.name))
+		if got := outboundTOS(t, tc.dst); got != tc.want {
+			t.Errorf("%s: outbound DSCP %d, want %d", tc.name, got, tc.want)
+		}
+		c.Close()
+		waitOutboundClosed(t, tc.dst)
+	}
+}
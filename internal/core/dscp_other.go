@@ -0,0 +1,9 @@
+//go:build !linux
+
+package core
+
+import "syscall"
+
+func setDSCP(network string, rc syscall.RawConn, dscp int) error {
+	return ErrDSCPUnsupported
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// EgressRule 将目标网段或域名后缀映射到出站使用的本地 IP
+type EgressRule struct {
+	CIDR    *net.IPNet // 匹配 IP 字面量目标
+	Suffix  string     // 匹配域名目标，如 ".corp.example" 或 "corp.example"
+	LocalIP net.IP
+}
+
+// EgressTable 按顺序匹配的出站源地址策略表，未匹配时使用 Default
+// CIDR 规则只匹配 IP 字面量目标，域名后缀规则只匹配域名目标（不做预解析）
+type EgressTable struct {
+	Rules   []EgressRule
+	Default net.IP
+}
+
+// LoadEgressFile 读取出站策略文件，每行 "<CIDR|域名后缀|default> <本地IP>"，支持 # 注释和空行
+// 所有本地 IP 都会在加载时尝试绑定，不属于本机的地址直接报错
+func LoadEgressFile(path string) (*EgressTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &EgressTable{}
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<destination> <local IP>\"", path, line)
+		}
+		ip := net.ParseIP(fields[1])
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d: invalid local IP %q", path, line, fields[1])
+		}
+		if err := CheckLocalIP(ip); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if fields[0] == "default" {
+			t.Default = ip
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(fields[0]); err == nil {
+			t.Rules = append(t.Rules, EgressRule{CIDR: unmapIPNet(ipNet), LocalIP: ip})
+			continue
+		}
+		if ip1 := net.ParseIP(fields[0]); ip1 != nil {
+			bits := 8 * len(ip1.To16())
+			if ip1.To4() != nil {
+				ip1, bits = ip1.To4(), 32
+			}
+			t.Rules = append(t.Rules, EgressRule{CIDR: &net.IPNet{IP: ip1, Mask: net.CIDRMask(bits, bits)}, LocalIP: ip})
+			continue
+		}
+		t.Rules = append(t.Rules, EgressRule{Suffix: strings.ToLower(fields[0]), LocalIP: ip})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// CheckLocalIP 通过尝试绑定确认 IP 属于本机
+func CheckLocalIP(ip net.IP) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return fmt.Errorf("local IP %s is not usable: %w", ip, err)
+	}
+	return pc.Close()
+}
+
+// LocalIP 返回目标地址（host:port）应使用的本地 IP，没有匹配且无默认值时返回 nil
+func (t *EgressTable) LocalIP(addr string) net.IP {
+	if t == nil {
+		return nil
+	}
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return t.Default
+	}
+	ip := unmapIP(net.ParseIP(h))
+	h = strings.ToLower(strings.TrimSuffix(h, "."))
+	for _, r := range t.Rules {
+		if ip != nil && r.CIDR != nil && r.CIDR.Contains(ip) {
+			return r.LocalIP
+		}
+		if ip == nil && r.Suffix != "" && matchDomainSuffix(h, r.Suffix) {
+			return r.LocalIP
+		}
+	}
+	return t.Default
+}
+
+// LocalAddr 返回目标地址应使用的本地地址（ip:0），未配置时返回空字符串
+func (t *EgressTable) LocalAddr(addr string) string {
+	ip := t.LocalIP(addr)
+	if ip == nil {
+		return ""
+	}
+	return net.JoinHostPort(ip.String(), "0")
+}
+
+// matchDomainSuffix 判断 host 是否等于后缀或为其子域名，后缀可带或不带前导点
+func matchDomainSuffix(host, suffix string) bool {
+	suffix = strings.TrimPrefix(suffix, ".")
+	return host == suffix || strings.HasSuffix(host, "."+suffix)
+}
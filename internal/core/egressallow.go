@@ -0,0 +1,139 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEgressForbidden is the error when traffic to the destination would leave through a local address or interface that is not allowed
+var ErrEgressForbidden = errors.New("egress interface is not allowed")
+
+// egressIfaceCacheTTL 本地地址到接口名映射的缓存时间，接口地址变化后最多这么久生效
+const egressIfaceCacheTTL = 10 * time.Second
+
+// EgressAllowlist 允许出站使用的本地接口和地址：拨号前按目标查路由，得到内核将使用的源地址（或 EgressTable 指定的地址），
+// 该地址属于 Interfaces 中的接口或落在 Prefixes 中才允许连接，否则换下一个目标地址，全部被禁止时请求以 REP 0x03 失败
+// 源地址按所属接口判断，依赖策略路由使源地址与出口一致（Linux 默认的弱主机模型下两者通常相同）
+type EgressAllowlist struct {
+	Interfaces []string
+	Prefixes   []netip.Prefix
+
+	mu      sync.Mutex
+	ifaces  map[netip.Addr]string
+	fetched time.Time
+}
+
+// ParseEgressAllowlist 解析逗号分隔的接口名、IP 或 CIDR
+func ParseEgressAllowlist(s string) (*EgressAllowlist, error) {
+	a := &EgressAllowlist{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "":
+			continue
+		case strings.Contains(f, "/"):
+			p, err := netip.ParsePrefix(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid egress prefix %q", f)
+			}
+			a.Prefixes = append(a.Prefixes, p.Masked())
+		default:
+			if ip, err := netip.ParseAddr(f); err == nil {
+				a.Prefixes = append(a.Prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+				continue
+			}
+			if _, err := net.InterfaceByName(f); err != nil {
+				return nil, fmt.Errorf("egress interface %q: %w", f, err)
+			}
+			a.Interfaces = append(a.Interfaces, f)
+		}
+	}
+	if len(a.Interfaces) == 0 && len(a.Prefixes) == 0 {
+		return nil, errors.New("egress allowlist is empty")
+	}
+	return a, nil
+}
+
+// Allowed 检查本地地址是否允许用于出站，返回它所属的接口名（未找到时为空）
+func (a *EgressAllowlist) Allowed(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	addr = addr.Unmap()
+	iface := a.interfaceOf(addr)
+	if iface != "" && slices.Contains(a.Interfaces, iface) {
+		return iface, true
+	}
+	for _, p := range a.Prefixes {
+		if p.Contains(addr) {
+			return iface, true
+		}
+	}
+	return iface, false
+}
+
+// CheckTable 检查出站策略表中的本地地址是否都被允许
+func (a *EgressAllowlist) CheckTable(t *EgressTable) error {
+	ips := []net.IP{t.Default}
+	for _, r := range t.Rules {
+		ips = append(ips, r.LocalIP)
+	}
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		if iface, ok := a.Allowed(ip); !ok {
+			return fmt.Errorf("egress address %s (interface %q): %w", ip, iface, ErrEgressForbidden)
+		}
+	}
+	return nil
+}
+
+// interfaceOf 返回拥有该地址的接口名，接口列表缓存 egressIfaceCacheTTL
+func (a *EgressAllowlist) interfaceOf(ip netip.Addr) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ifaces == nil || time.Since(a.fetched) >= egressIfaceCacheTTL {
+		a.ifaces = map[netip.Addr]string{}
+		a.fetched = time.Now()
+		ifs, err := net.Interfaces()
+		if err != nil {
+			log.Printf("Warning: cannot list network interfaces: %v", err)
+		}
+		for _, ifi := range ifs {
+			addrs, _ := ifi.Addrs()
+			for _, ad := range addrs {
+				if n, ok := ad.(*net.IPNet); ok {
+					if x, ok := netip.AddrFromSlice(n.IP); ok {
+						a.ifaces[x.Unmap()] = ifi.Name
+					}
+				}
+			}
+		}
+	}
+	return a.ifaces[ip]
+}
+
+// check 查路由得到连接 dst 将使用的源地址（local 非 nil 时为该地址）并检查是否允许，不发送任何数据
+func (a *EgressAllowlist) check(network string, local, dst net.IP) error {
+	c, err := net.DialUDP("udp", &net.UDPAddr{IP: local}, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return err
+	}
+	src := c.LocalAddr().(*net.UDPAddr).IP
+	c.Close()
+	iface, ok := a.Allowed(src)
+	if ok {
+		return nil
+	}
+	log.Printf("Warning: refusing %s to %s: local address %s on interface %q is not an allowed egress", network, dst, src, iface)
+	return fmt.Errorf("%s via %s (interface %q): %w", dst, src, iface, ErrEgressForbidden)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrorLogSampler 对已知会大量重复的错误日志抽样：拨号失败按目标、认证失败按来源 IP 分别计数，
+// 同一键每个窗口只逐条输出前 Burst 条，其余的只计数，窗口结束后每个键输出一行汇总；
+// 最多跟踪 Max 个键，满时淘汰最久没有出现的，被淘汰的键立即输出汇总。其他错误和审计日志不受影响，可并发使用
+type ErrorLogSampler struct {
+	Window time.Duration
+	Burst  int
+	Max    int
+
+	mu      sync.Mutex
+	start   time.Time
+	entries map[sampleKey]*list.Element // 值为 *sampleEntry
+	lru     list.List                   // 最近出现的在前
+
+	loopOnce sync.Once
+}
+
+// sampleKey 错误的类别和目标或来源
+type sampleKey struct {
+	class   string // "dial errors to"、"auth failures from" 或 "failed handshakes from"
+	subject string
+}
+
+type sampleEntry struct {
+	key sampleKey
+	n   int
+}
+
+// sampleSummary 一个键在窗口内被抑制的条数
+type sampleSummary struct {
+	key        sampleKey
+	suppressed int
+}
+
+// NewErrorLogSampler 创建错误日志抽样，window 不大于 0 时为 1m，max 不大于 0 时为 10000
+func NewErrorLogSampler(window time.Duration, burst, max int) *ErrorLogSampler {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if max <= 0 {
+		max = 10000
+	}
+	return &ErrorLogSampler{Window: window, Burst: burst, Max: max, start: time.Now(), entries: make(map[sampleKey]*list.Element)}
+}
+
+// sampleKeyOf 返回抽样的键，不是已知会大量重复的错误时返回 false
+func sampleKeyOf(err error) (sampleKey, bool) {
+	var de *DialError
+	var ae *AuthError
+	switch {
+	case errors.As(err, &de):
+		return sampleKey{"dial errors to", de.Dst}, true
+	case errors.As(err, &ae):
+		src := "unknown"
+		if ae.Client != nil {
+			src = hostOf(ae.Client.String())
+		}
+		return sampleKey{"auth failures from", src}, true
+	}
+	return sampleKey{}, false
+}
+
+// record 记录一次 key 的错误，返回是否应逐条输出，以及已结束的窗口和被淘汰的键中有条目被抑制的汇总
+func (l *ErrorLogSampler) record(key sampleKey) (bool, []sampleSummary, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sums, elapsed := l.roll(time.Now())
+	el, ok := l.entries[key]
+	if ok {
+		l.lru.MoveToFront(el)
+	} else {
+		if len(l.entries) >= l.Max {
+			old := l.lru.Back()
+			l.lru.Remove(old)
+			e := old.Value.(*sampleEntry)
+			delete(l.entries, e.key)
+			if e.n > l.Burst {
+				sums = append(sums, sampleSummary{e.key, e.n - max(l.Burst, 0)})
+			}
+		}
+		el = l.lru.PushFront(&sampleEntry{key: key})
+		l.entries[key] = el
+	}
+	e := el.Value.(*sampleEntry)
+	e.n++
+	return e.n <= l.Burst, sums, elapsed
+}
+
+// roll 窗口已结束时清空计数，返回被抑制过的键的汇总和窗口实际的长度，调用方持有 l.mu
+func (l *ErrorLogSampler) roll(now time.Time) ([]sampleSummary, time.Duration) {
+	elapsed := now.Sub(l.start)
+	if elapsed < l.Window {
+		return nil, elapsed
+	}
+	var sums []sampleSummary
+	for _, el := range l.entries {
+		if e := el.Value.(*sampleEntry); e.n > l.Burst {
+			sums = append(sums, sampleSummary{e.key, e.n - max(l.Burst, 0)})
+		}
+	}
+	slices.SortFunc(sums, func(a, b sampleSummary) int { return cmp.Compare(b.suppressed, a.suppressed) })
+	l.start = now
+	clear(l.entries)
+	l.lru.Init()
+	return sums, elapsed
+}
+
+// logSampleSummaries 为每个被抑制过的键输出一行汇总
+func logSampleSummaries(sums []sampleSummary, elapsed time.Duration) {
+	for _, sum := range sums {
+		log.Printf("Warning: suppressed %d similar %s %s in last %s", sum.suppressed, sum.key.class, sum.key.subject, elapsed.Round(time.Second))
+	}
+}
+
+// rollLoop 每隔 Window 结束一次窗口并输出汇总，之后不再出现的键的汇总也不会一直等到下一条错误，直到 ctx 取消
+func (l *ErrorLogSampler) rollLoop(ctx context.Context) {
+	t := time.NewTicker(l.Window)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			l.mu.Lock()
+			sums, elapsed := l.roll(time.Now())
+			l.mu.Unlock()
+			logSampleSummaries(sums, elapsed)
+		}
+	}
+}
+
+// sample 记录一次 key 的错误并输出已有的汇总，返回是否应逐条输出；第一次调用时启动 rollLoop，直到 ctx 取消
+func (l *ErrorLogSampler) sample(ctx context.Context, key sampleKey) bool {
+	l.loopOnce.Do(func() { go l.rollLoop(ctx) })
+	verbose, sums, elapsed := l.record(key)
+	logSampleSummaries(sums, elapsed)
+	return verbose
+}
+
+// sampleError 设置了 ErrorLogSampler 且 err 是已知会大量重复的错误时按键抽样，返回 true 表示 err 被抑制、不应输出，
+// 被抑制的条数计入 Stats.ErrorLogSuppressed
+func (s *Server) sampleError(err error) bool {
+	l := s.ErrorLogSampler
+	if l == nil {
+		return false
+	}
+	key, ok := sampleKeyOf(err)
+	if !ok {
+		return false
+	}
+	if l.sample(s.Context(), key) {
+		return false
+	}
+	s.Stats.ErrorLogSuppressed.Add(1)
+	return true
+}
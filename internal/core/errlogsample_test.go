@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 满时淘汰最久没有出现的键，被淘汰的键有条目被抑制时立即汇总
+func TestErrorLogSamplerEviction(t *testing.T) {
+	l := NewErrorLogSampler(time.Hour, 1, 2)
+	a, b, c, d := sampleKey{"dial errors to", "a:1"}, sampleKey{"dial errors to", "b:1"}, sampleKey{"dial errors to", "c:1"}, sampleKey{"dial errors to", "d:1"}
+	for i, want := range []bool{true, false, false} {
+		if verbose, sums, _ := l.record(a); verbose != want || sums != nil {
+			t.Fatalf("record a #%d = %v, %v", i+1, verbose, sums)
+		}
+	}
+	l.record(b)
+	// 再次出现的 a 移到最前，最久没有出现的是 b
+	l.record(a)
+	verbose, sums, _ := l.record(c)
+	if !verbose || sums != nil {
+		t.Fatalf("evicting b (not suppressed): %v, %+v", verbose, sums)
+	}
+	if _, ok := l.entries[b]; ok || len(l.entries) != 2 {
+		t.Fatalf("b not evicted: %d entries", len(l.entries))
+	}
+	l.record(c)
+	verbose, sums, _ = l.record(d)
+	if !verbose || !slices.Equal(sums, []sampleSummary{{a, 3}}) {
+		t.Fatalf("evicting a: %v, %+v", verbose, sums)
+	}
+	// 被淘汰后再出现的键重新计数
+	l.record(a)
+	if e := l.entries[a].Value.(*sampleEntry); e.n != 1 {
+		t.Fatalf("a counts %d after eviction, want 1", e.n)
+	}
+}
+
+// 窗口结束时按被抑制的条数从多到少汇总并清空计数
+func TestErrorLogSamplerRoll(t *testing.T) {
+	l := NewErrorLogSampler(time.Minute, 2, 0)
+	if l.Max != 10000 {
+		t.Fatalf("default Max = %d", l.Max)
+	}
+	a, b, c := sampleKey{"auth failures from", "192.0.2.1"}, sampleKey{"auth failures from", "192.0.2.2"}, sampleKey{"dial errors to", "c:1"}
+	for range 4 {
+		l.record(a)
+	}
+	for range 7 {
+		l.record(b)
+	}
+	l.record(c)
+	start := l.start
+	if sums, _ := l.roll(start.Add(time.Minute - time.Second)); sums != nil || len(l.entries) != 3 {
+		t.Fatalf("rolled before the window ended: %+v", sums)
+	}
+	sums, elapsed := l.roll(start.Add(90 * time.Second))
+	if !slices.Equal(sums, []sampleSummary{{b, 5}, {a, 2}}) || elapsed != 90*time.Second {
+		t.Fatalf("roll = %+v, %v", sums, elapsed)
+	}
+	if len(l.entries) != 0 || l.lru.Len() != 0 || !l.start.Equal(start.Add(90*time.Second)) {
+		t.Fatalf("not cleared: %d entries, %d in LRU, start %v", len(l.entries), l.lru.Len(), l.start)
+	}
+	if verbose, _, _ := l.record(b); !verbose {
+		t.Fatal("first error of a new window suppressed")
+	}
+}
+
+// 被抑制的拨号和认证失败计入 Stats.ErrorLogSuppressed，窗口结束时由 rollLoop 输出汇总
+func TestSampleErrorSuppressedCounter(t *testing.T) {
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	}()
+	s := &Server{ErrorLogSampler: NewErrorLogSampler(100*time.Millisecond, 2, 0)}
+	s.Context()
+	defer s.cancel()
+
+	dial := &DialError{Dst: "example.com:443"}
+	var got []bool
+	for range 5 {
+		got = append(got, s.sampleError(dial))
+	}
+	if !slices.Equal(got, []bool{false, false, true, true, true}) {
+		t.Fatalf("sampleError = %v", got)
+	}
+	// 认证失败按来源 IP 计数，端口不同也是同一来源
+	for port := range 3 {
+		s.sampleError(&AuthError{clientError: clientError{Client: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000 + port}}})
+	}
+	if s.sampleError(errors.New("other")) {
+		t.Fatal("unrelated error suppressed")
+	}
+	if n := s.Stats.ErrorLogSuppressed.Load(); n != 4 {
+		t.Fatalf("ErrorLogSuppressed = %d, want 4", n)
+	}
+	time.Sleep(300 * time.Millisecond)
+	// 换回原来的输出之后 rollLoop 不再写 buf
+	log.SetOutput(out)
+	logged := buf.String()
+	for _, want := range []string{"suppressed 3 similar dial errors to example.com:443 in last ", "suppressed 1 similar auth failures from 192.0.2.1 in last "} {
+		if !strings.Contains(logged, want) {
+			t.Fatalf("no %q in:\n%s", want, logged)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+)
+
+// 服务端处理客户端时返回的错误按阶段分类，都用 %w 包装原始错误，errors.Is 仍可匹配 ErrVersion、ErrUserPassAuth 等
+
+// clientError 分类错误的公共部分
+type clientError struct {
+	Client net.Addr // 客户端地址，未知时为 nil
+	Err    error
+}
+
+func (e *clientError) Unwrap() error { return e.Err }
+
+func (e *clientError) format(stage string) string {
+	if e.Client == nil {
+		return fmt.Sprintf("%s: %v", stage, e.Err)
+	}
+	return fmt.Sprintf("%s from %s: %v", stage, e.Client, e.Err)
+}
+
+// HandshakeError 方法协商失败：客户端发送了非 SOCKS5 数据、没有可接受的方法或连接中断
+type HandshakeError struct{ clientError }
+
+func (e *HandshakeError) Error() string { return e.format("handshake") }
+
+// AuthError 认证失败，User 为客户端提供的用户名
+type AuthError struct {
+	clientError
+	User string
+}
+
+func (e *AuthError) Error() string { return e.format(fmt.Sprintf("auth %q", e.User)) }
+
+// RequestError 请求无法读取、格式错误或命令不被支持
+type RequestError struct{ clientError }
+
+func (e *RequestError) Error() string { return e.format("request") }
+
+// DialError 连接目标失败，Dst 为请求的目标
+type DialError struct {
+	clientError
+	Dst string
+}
+
+func (e *DialError) Error() string { return e.format("dial " + e.Dst) }
+
+// RelayError 转发过程中的错误
+type RelayError struct{ clientError }
+
+func (e *RelayError) Error() string { return e.format("relay") }
+
+// clientAddr 返回 rw 的对端地址，rw 不是 net.Conn 时返回 nil
+func clientAddr(rw any) net.Addr {
+	if c, ok := rw.(net.Conn); ok {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// LogServeError 按分类输出 ServeConn 等返回的错误：握手、请求格式和转发错误多由扫描器或客户端断开引起，只在调试模式输出
+func LogServeError(err error) {
+	var he *HandshakeError
+	var re *RequestError
+	var le *RelayError
+	if errors.As(err, &he) || errors.As(err, &re) || errors.As(err, &le) {
+		if Debug {
+			log.Println(err)
+		}
+		return
+	}
+	log.Println(err)
+}
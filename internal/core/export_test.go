@@ -0,0 +1,4 @@
+package core
+
+// UDPBufsOut 返回已取出、尚未归还的 UDP 缓冲区数，供外部测试检查泄漏
+func (r *Resources) UDPBufsOut() int64 { return r.udpOut.Load() }
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// AddrFamily 出站连接的地址族策略
+type AddrFamily int
+
+const (
+	FamilyAny        AddrFamily = iota // 不限制，两个地址族的地址交替尝试
+	FamilyIPv4                         // 只使用 IPv4
+	FamilyIPv6                         // 只使用 IPv6
+	FamilyPreferIPv4                   // 先依次尝试 IPv4 地址，失败后再尝试 IPv6
+	FamilyPreferIPv6                   // 先依次尝试 IPv6 地址，失败后再尝试 IPv4
+)
+
+var familyNames = map[AddrFamily]string{
+	FamilyAny:        "any",
+	FamilyIPv4:       "ipv4",
+	FamilyIPv6:       "ipv6",
+	FamilyPreferIPv4: "prefer-ipv4",
+	FamilyPreferIPv6: "prefer-ipv6",
+}
+
+// ParseAddrFamily 解析 any、ipv4、ipv6、prefer-ipv4、prefer-ipv6
+func ParseAddrFamily(s string) (AddrFamily, error) {
+	for f, name := range familyNames {
+		if s == name {
+			return f, nil
+		}
+	}
+	return FamilyAny, fmt.Errorf("unknown address family policy %q", s)
+}
+
+func (f AddrFamily) String() string {
+	if name, ok := familyNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("AddrFamily(%d)", int(f))
+}
+
+// filter 按策略过滤并排序地址，不修改 ips
+func (f AddrFamily) filter(ips []net.IPAddr) []net.IPAddr {
+	switch f {
+	case FamilyIPv4, FamilyIPv6:
+		var out []net.IPAddr
+		for _, ip := range ips {
+			if (ip.IP.To4() != nil) == (f == FamilyIPv4) {
+				out = append(out, ip)
+			}
+		}
+		return out
+	case FamilyPreferIPv4, FamilyPreferIPv6:
+		out := append([]net.IPAddr(nil), ips...)
+		sort.SliceStable(out, func(i, j int) bool {
+			return (out[i].IP.To4() != nil) == (f == FamilyPreferIPv4) && (out[j].IP.To4() != nil) != (f == FamilyPreferIPv4)
+		})
+		return out
+	}
+	return ips
+}
+
+// FamilyError 表示目标没有地址族策略允许的地址
+type FamilyError struct {
+	Host   string
+	Family AddrFamily
+}
+
+func (e *FamilyError) Error() string {
+	return fmt.Sprintf("%s has no address allowed by the %s address family policy", e.Host, e.Family)
+}
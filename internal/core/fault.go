@@ -0,0 +1,208 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFaultInjected is the error when the fault injector refuses a request instead of handling it
+var ErrFaultInjected = errors.New("fault injected")
+
+// FaultConfig 故障注入的概率（0 到 1）和时长，零值不注入任何故障
+type FaultConfig struct {
+	// DelayProb 的请求在处理（拨号和应答）前额外等待 [0, Delay) 中的随机时长
+	DelayProb float64
+	Delay     time.Duration
+	// RejectProb 的请求不拨号，直接以 Reps 中随机的一个应答失败，Reps 为空时使用 RepServerFailure
+	RejectProb float64
+	Reps       []byte
+	// UDPDropProb 的客户端数据报被丢弃
+	UDPDropProb float64
+	// KillProb 的 TCP 出站连接在建立后 [0, KillAfter) 中的随机时刻被关闭，转发随之结束
+	KillProb  float64
+	KillAfter time.Duration
+	// Seed 随机数种子，相同的种子和相同的请求顺序得到相同的注入结果，0 表示随机选择
+	Seed uint64
+}
+
+// FaultStats 已注入的故障数
+type FaultStats struct {
+	Delayed    int64 `json:"delayed"`
+	Rejected   int64 `json:"rejected"`
+	UDPDropped int64 `json:"udp_dropped"`
+	Killed     int64 `json:"killed"`
+}
+
+// FaultInjector 用于弹性测试的故障注入：Middleware 注入延迟、失败应答和 UDP 丢包，
+// Dialer 包装出站拨号器以中断已建立的 TCP 转发；设置 Server.Faults 后两者由服务端自动套用，
+// 也可自行用 Server.Use 和 Server.Dialer 组合
+type FaultInjector struct {
+	cfg  FaultConfig
+	seed uint64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	delayed    atomic.Int64
+	rejected   atomic.Int64
+	udpDropped atomic.Int64
+	killed     atomic.Int64
+}
+
+// NewFaultInjector 按 cfg 创建 FaultInjector
+func NewFaultInjector(cfg FaultConfig) *FaultInjector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &FaultInjector{cfg: cfg, seed: seed, rnd: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Seed 返回使用的随机数种子，用于复现
+func (f *FaultInjector) Seed() uint64 {
+	return f.seed
+}
+
+// Stats 返回已注入的故障数
+func (f *FaultInjector) Stats() FaultStats {
+	return FaultStats{
+		Delayed:    f.delayed.Load(),
+		Rejected:   f.rejected.Load(),
+		UDPDropped: f.udpDropped.Load(),
+		Killed:     f.killed.Load(),
+	}
+}
+
+// hit 以概率 p 返回 true
+func (f *FaultInjector) hit(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rnd.Float64() < p
+}
+
+// duration 返回 [0, d) 中的随机时长
+func (f *FaultInjector) duration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Duration(f.rnd.Int64N(int64(d)))
+}
+
+// rep 返回随机选择的注入应答码
+func (f *FaultInjector) rep() byte {
+	if len(f.cfg.Reps) == 0 {
+		return RepServerFailure
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg.Reps[f.rnd.IntN(len(f.cfg.Reps))]
+}
+
+// Middleware 返回注入请求延迟、失败应答和 UDP 丢包的中间件
+func (f *FaultInjector) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return &HandlerFuncs{
+			Next: next,
+			TCP: func(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+				if f.hit(f.cfg.DelayProb) {
+					f.delayed.Add(1)
+					t := time.NewTimer(f.duration(f.cfg.Delay))
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						t.Stop()
+						return ctx.Err()
+					}
+				}
+				if f.hit(f.cfg.RejectProb) {
+					f.rejected.Add(1)
+					rep := f.rep()
+					var w io.Writer = c
+					if sess := SessionFromConn(c); sess != nil && sess.Transparent {
+						w = io.Discard
+					}
+					if err := r.Reject(w, rep); err != nil {
+						return err
+					}
+					return fmt.Errorf("%s %s: %s: %w", cmdName(r.Cmd), r.Address(), RepName(rep), ErrFaultInjected)
+				}
+				return callTCP(ctx, next, s, c, r)
+			},
+			UDP: func(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+				if f.hit(f.cfg.UDPDropProb) {
+					f.udpDropped.Add(1)
+					if Debug {
+						log.Printf("UDP %s from %s: dropped: %v", d.Address(), addr, ErrFaultInjected)
+					}
+					return nil
+				}
+				return callUDP(ctx, next, s, addr, d)
+			},
+		}
+	}
+}
+
+// Dialer 包装 d，按 KillProb 为 TCP 出站连接安排随机时刻的关闭
+func (f *FaultInjector) Dialer(d Dialer) Dialer {
+	return &faultDialer{Dialer: d, f: f}
+}
+
+type faultDialer struct {
+	Dialer
+	f *FaultInjector
+}
+
+func (d *faultDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.Dialer.DialContext(ctx, network, addr)
+	if err != nil || network != "tcp" || !d.f.hit(d.f.cfg.KillProb) {
+		return c, err
+	}
+	fc := &faultConn{Conn: c}
+	fc.timer = time.AfterFunc(d.f.duration(d.f.cfg.KillAfter), func() {
+		if fc.closed.CompareAndSwap(false, true) {
+			d.f.killed.Add(1)
+			fc.Conn.Close()
+		}
+	})
+	return fc, nil
+}
+
+// SupportsUDP 透传被包装拨号器的 UDPCapable
+func (d *faultDialer) SupportsUDP() bool {
+	u, ok := d.Dialer.(UDPCapable)
+	return !ok || u.SupportsUDP()
+}
+
+// faultConn 安排了注入关闭的出站连接，先被关闭时取消注入
+type faultConn struct {
+	net.Conn
+	timer  *time.Timer
+	closed atomic.Bool
+}
+
+func (c *faultConn) Close() error {
+	c.timer.Stop()
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+// CloseWrite 半关闭被包装的连接，使转发结束时仍能半关闭
+func (c *faultConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
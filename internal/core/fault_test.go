@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// discardConn 丢弃写入的应答
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// pipeDialer 返回 net.Pipe 的一端，对端被丢弃
+type pipeDialer struct{}
+
+func (pipeDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	c, s := net.Pipe()
+	s.Close()
+	return c, nil
+}
+
+// faultDecisions 依次交替发出 n 个请求、数据报和拨号，返回每一步的注入结果和最终的计数
+func faultDecisions(t *testing.T, cfg FaultConfig, n int) ([]string, FaultStats) {
+	t.Helper()
+	f := NewFaultInjector(cfg)
+	h := f.Middleware()(&HandlerFuncs{
+		TCP: func(context.Context, *Server, net.Conn, *Request) error { return nil },
+		UDP: func(context.Context, *Server, *net.UDPAddr, *Datagram) error { return nil },
+	}).(*HandlerFuncs)
+	d := f.Dialer(pipeDialer{})
+	s := &Server{}
+	r := NewRequest(CmdConnect, ATYPIPv4, []byte{192, 0, 2, 1}, []byte{0, 80})
+	dg := NewDatagram(ATYPIPv4, []byte{192, 0, 2, 1}, []byte{0, 53}, []byte("q"))
+	var out []string
+	for range n {
+		err := h.TCPHandleContext(context.Background(), s, discardConn{}, r)
+		switch {
+		case err == nil:
+			out = append(out, "pass")
+		case errors.Is(err, ErrFaultInjected):
+			out = append(out, err.Error())
+		default:
+			t.Fatalf("TCP: %v", err)
+		}
+		before := f.Stats().UDPDropped
+		if err := h.UDPHandleContext(context.Background(), s, &net.UDPAddr{}, dg); err != nil {
+			t.Fatalf("UDP: %v", err)
+		}
+		out = append(out, fmt.Sprintf("udp dropped %v", f.Stats().UDPDropped > before))
+		c, err := d.DialContext(context.Background(), "tcp", "192.0.2.1:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, kill := c.(*faultConn)
+		out = append(out, fmt.Sprintf("kill %v", kill))
+		c.Close()
+	}
+	return out, f.Stats()
+}
+
+// injected 返回以 rep 注入失败时请求的错误
+func injected(rep byte) string {
+	return "CONNECT 192.0.2.1:80: " + RepName(rep) + ": " + ErrFaultInjected.Error()
+}
+
+// 相同的种子和相同的调用顺序得到相同的延迟、应答、丢包和中断决定，不同的种子得到不同的决定
+func TestFaultInjectorSeedReproducible(t *testing.T) {
+	cfg := FaultConfig{
+		DelayProb: 0.3, Delay: time.Microsecond,
+		RejectProb: 0.5, Reps: []byte{RepServerFailure, RepConnectionRefused, RepHostUnreachable},
+		UDPDropProb: 0.5,
+		KillProb:    0.5, KillAfter: time.Hour,
+		Seed: 42,
+	}
+	first, firstStats := faultDecisions(t, cfg, 100)
+	second, secondStats := faultDecisions(t, cfg, 100)
+	if !slices.Equal(first, second) || firstStats != secondStats {
+		t.Fatalf("seed 42 gave different decisions:\n%q %+v\n%q %+v", first, firstStats, second, secondStats)
+	}
+	// 每种故障和每个应答码都出现过，也都有未注入的情况
+	for _, want := range []string{"pass", "udp dropped true", "udp dropped false", "kill true", "kill false",
+		injected(RepServerFailure), injected(RepConnectionRefused), injected(RepHostUnreachable)} {
+		if !slices.Contains(first, want) {
+			t.Fatalf("no %q in %q", want, first)
+		}
+	}
+	if firstStats.Delayed == 0 || firstStats.Killed != 0 {
+		t.Fatalf("stats %+v", firstStats)
+	}
+
+	cfg.Seed = 43
+	if other, _ := faultDecisions(t, cfg, 100); slices.Equal(first, other) {
+		t.Fatal("seeds 42 and 43 gave the same decisions")
+	}
+	// 种子为 0 时随机选择，Seed 返回实际使用的种子，用它可以复现
+	cfg.Seed = 0
+	f := NewFaultInjector(cfg)
+	if f.Seed() == 0 {
+		t.Fatal("Seed() = 0")
+	}
+	cfg.Seed = f.Seed()
+	a, _ := faultDecisions(t, cfg, 50)
+	b, _ := faultDecisions(t, cfg, 50)
+	if !slices.Equal(a, b) {
+		t.Fatal("the reported seed does not reproduce the decisions")
+	}
+}
+
+// 概率为 0 时从不注入，为 1 时总是注入
+func TestFaultInjectorProbabilityBounds(t *testing.T) {
+	out, st := faultDecisions(t, FaultConfig{Delay: time.Microsecond, KillAfter: time.Hour, Seed: 1}, 200)
+	for _, d := range out {
+		if d != "pass" && d != "udp dropped false" && d != "kill false" {
+			t.Fatalf("zero probabilities injected %q", d)
+		}
+	}
+	if st != (FaultStats{}) {
+		t.Fatalf("zero probabilities: stats %+v", st)
+	}
+
+	out, st = faultDecisions(t, FaultConfig{DelayProb: 1, Delay: time.Microsecond, RejectProb: 1, UDPDropProb: 1, KillProb: 1, KillAfter: time.Hour, Seed: 1}, 200)
+	for i := 0; i < len(out); i += 3 {
+		if out[i] != injected(RepServerFailure) || out[i+1] != "udp dropped true" || out[i+2] != "kill true" {
+			t.Fatalf("probability 1 let step %d through: %q", i/3, out[i:i+3])
+		}
+	}
+	if st != (FaultStats{Delayed: 200, Rejected: 200, UDPDropped: 200}) {
+		t.Fatalf("probability 1: stats %+v", st)
+	}
+
+	// 注入的关闭确实发生并计数
+	f := NewFaultInjector(FaultConfig{KillProb: 1, KillAfter: time.Nanosecond, Seed: 1})
+	c, err := f.Dialer(pipeDialer{}).DialContext(context.Background(), "tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for f.Stats().Killed == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) || f.Stats().Killed != 1 {
+		t.Fatalf("killed connection: read %v, stats %+v", err, f.Stats())
+	}
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 调试日志只通过这些 String 方法输出协议报文：密码等凭据一律不输出，连长度也不显示
+
+// CmdName 返回命令的名称，如 CONNECT、BIND、UDP ASSOCIATE
+func CmdName(cmd byte) string {
+	switch cmd {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	case CmdUDP:
+		return "UDP ASSOCIATE"
+	}
+	return fmt.Sprintf("CMD(0x%02x)", cmd)
+}
+
+// RepName 返回应答码的名称，如 RepSuccess、RepNotAllowed
+func RepName(rep byte) string {
+	switch rep {
+	case RepSuccess:
+		return "RepSuccess"
+	case RepServerFailure:
+		return "RepServerFailure"
+	case RepNotAllowed:
+		return "RepNotAllowed"
+	case RepNetworkUnreachable:
+		return "RepNetworkUnreachable"
+	case RepHostUnreachable:
+		return "RepHostUnreachable"
+	case RepConnectionRefused:
+		return "RepConnectionRefused"
+	case RepTTLExpired:
+		return "RepTTLExpired"
+	case RepCommandNotSupported:
+		return "RepCommandNotSupported"
+	case RepAddressNotSupported:
+		return "RepAddressNotSupported"
+	}
+	return fmt.Sprintf("REP(0x%02x)", rep)
+}
+
+// ATYPName 返回地址类型的名称
+func ATYPName(atyp byte) string {
+	switch atyp {
+	case ATYPIPv4:
+		return "IPv4"
+	case ATYPDomain:
+		return "Domain"
+	case ATYPIPv6:
+		return "IPv6"
+	}
+	return fmt.Sprintf("ATYP(0x%02x)", atyp)
+}
+
+// MethodName 返回认证方法的名称
+func MethodName(m byte) string {
+	switch m {
+	case MethodNone:
+		return "None"
+	case MethodGSSAPI:
+		return "GSSAPI"
+	case MethodUsernamePassword:
+		return "UsernamePassword"
+	case MethodUnsupportAll:
+		return "NoAcceptable"
+	}
+	return fmt.Sprintf("METHOD(0x%02x)", m)
+}
+
+func (r *NegotiationRequest) String() string {
+	ms := make([]string, len(r.Methods))
+	for i, m := range r.Methods {
+		ms[i] = MethodName(m)
+	}
+	return fmt.Sprintf("NegotiationRequest{Ver: %d, Methods: [%s]}", r.Ver, strings.Join(ms, " "))
+}
+
+func (r *NegotiationReply) String() string {
+	return fmt.Sprintf("NegotiationReply{Ver: %d, Method: %s}", r.Ver, MethodName(r.Method))
+}
+
+// String 不输出密码
+func (r *UserPassNegotiationRequest) String() string {
+	return fmt.Sprintf("UserPassNegotiationRequest{Ver: %d, User: %q, Password: <redacted>}", r.Ver, r.Uname)
+}
+
+func (r *UserPassNegotiationReply) String() string {
+	status := "Success"
+	if r.Status != UserPassStatusSuccess {
+		status = "Failure"
+	}
+	return fmt.Sprintf("UserPassNegotiationReply{Ver: %d, Status: %s}", r.Ver, status)
+}
+
+func (r *Request) String() string {
+	return fmt.Sprintf("Request{Ver: %d, Cmd: %s, Atyp: %s, Dst: %s}", r.Ver, CmdName(r.Cmd), ATYPName(r.Atyp), r.Address())
+}
+
+func (r *Reply) String() string {
+	return fmt.Sprintf("Reply{Ver: %d, Rep: %s, Atyp: %s, Bnd: %s}", r.Ver, RepName(r.Rep), ATYPName(r.Atyp), r.Address())
+}
+
+func (d *Datagram) String() string {
+	return fmt.Sprintf("Datagram{Frag: %d, Atyp: %s, Dst: %s, Data: %d bytes}", d.Frag, ATYPName(d.Atyp), d.Address(), len(d.Data))
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrFwmarkUnsupported is the error when setting the socket fwmark is not supported on this platform
+var ErrFwmarkUnsupported = errors.New("setting fwmark is not supported on this platform")
+
+// fwmarkControl 返回先执行 control（可为 nil）再为新建套接字设置 fwmark 的 net.Dialer.Control；
+// 与 DSCP 不同，设置失败时拨号失败，否则流量会绕过按 fwmark 选择的策略路由
+func fwmarkControl(mark int, control func(network, address string, rc syscall.RawConn) error) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, rc); err != nil {
+				return err
+			}
+		}
+		if err := setFwmark(rc, mark); err != nil {
+			return fmt.Errorf("set fwmark %d: %w", mark, err)
+		}
+		return nil
+	}
+}
+
+// CheckFwmark 在一个临时套接字上设置 fwmark，确认平台支持且进程有权限（Linux 上需要 CAP_NET_ADMIN）
+func CheckFwmark(mark int) error {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	rc, err := pc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if err := setFwmark(rc, mark); err != nil {
+		return fmt.Errorf("cannot set fwmark %d: %w", mark, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package core
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setFwmark 设置 SO_MARK，策略路由（ip rule fwmark）据此为套接字选择路由表
+func setFwmark(rc syscall.RawConn, mark int) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
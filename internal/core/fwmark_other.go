@@ -0,0 +1,9 @@
+//go:build !linux
+
+package core
+
+import "syscall"
+
+func setFwmark(rc syscall.RawConn, mark int) error {
+	return ErrFwmarkUnsupported
+}
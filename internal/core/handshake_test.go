@@ -0,0 +1,69 @@
+package core_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestHandshakeTimeoutReapsStalledClients(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	ts := socks5test.NewServer(t, socks5test.WithAuth("u", "p"), func(s *core.Server) { s.HandshakeTimeout = timeout })
+	for i, tc := range []struct {
+		name string
+		send func(c net.Conn)
+	}{
+		{"silent", func(net.Conn) {}},
+		{"partial greeting", func(c net.Conn) { c.Write([]byte{core.Ver, 2}) }},
+		{"partial credentials", func(c net.Conn) {
+			core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+			core.NewNegotiationReplyFrom(c)
+			c.Write([]byte{0x01, 1, 'u'})
+		}},
+		{"partial request", func(c net.Conn) {
+			core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+			core.NewNegotiationReplyFrom(c)
+			core.NewUserPassNegotiationRequest([]byte("u"), []byte("p")).WriteTo(c)
+			core.NewUserPassNegotiationReplyFrom(c)
+			c.Write([]byte{core.Ver, core.CmdConnect, 0x00})
+		}},
+		// 每次只发一个字节也不能延长时限
+		{"slowloris", func(c net.Conn) {
+			for _, b := range []byte{core.Ver, 1, core.MethodUsernamePassword, 0x01, 1, 'u', 1, 'p', core.Ver, core.CmdConnect} {
+				if _, err := c.Write([]byte{b}); err != nil {
+					return
+				}
+				time.Sleep(timeout / 4)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", ts.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			start := time.Now()
+			go tc.send(c)
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 64)
+			for {
+				if _, err := c.Read(buf); err != nil {
+					break
+				}
+			}
+			if d := time.Since(start); d < timeout || d > timeout+time.Second {
+				t.Fatalf("connection closed after %s, want about %s", d, timeout)
+			}
+			waitCounter(t, &ts.Stats.HandshakeTimeouts, int64(i+1))
+		})
+	}
+
+	// 时限只覆盖协商和请求，之后空闲的转发不受影响
+	c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: "u", Password: "p"}, socks5test.EchoTCPServer(t))
+	time.Sleep(2 * timeout)
+	socks5test.EchoTCP(t, c, []byte("still open"))
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// DefaultHandshakeLogWindow 握手错误按来源聚合的默认窗口
+const DefaultHandshakeLogWindow = time.Minute
+
+// DefaultHandshakeLogThreshold 每个来源每个窗口内默认逐条输出的握手错误数
+const DefaultHandshakeLogThreshold = 5
+
+// DefaultHandshakeLogSources 握手错误聚合最多跟踪的来源数，超出时淘汰最久没有出现的来源
+const DefaultHandshakeLogSources = 10000
+
+// handshakeSampler 返回按来源 IP 聚合协商和请求阶段错误的 ErrorLogSampler：扫描器、误连的 TLS 客户端
+// 会产生大量相同的错误，第一次使用时按 HandshakeLogWindow、HandshakeLogThreshold 创建
+func (s *Server) handshakeSampler() *ErrorLogSampler {
+	s.hsLogOnce.Do(func() {
+		window := cmp.Or(s.HandshakeLogWindow, DefaultHandshakeLogWindow)
+		threshold := cmp.Or(s.HandshakeLogThreshold, DefaultHandshakeLogThreshold)
+		s.hsLog = NewErrorLogSampler(window, threshold, DefaultHandshakeLogSources)
+	})
+	return s.hsLog
+}
+
+// LogServeError 与包级 LogServeError 相同，但协商和请求阶段的错误按来源 IP 聚合：
+// 每个来源每 HandshakeLogWindow 只在调试模式逐条输出前 HandshakeLogThreshold 条，
+// 超出的条数计入 Stats.HandshakeLogSuppressed，窗口结束或来源被淘汰时对超过阈值的来源输出一行汇总；
+// 拨号和认证失败在设置了 ErrorLogSampler 时按其抽样
+func (s *Server) LogServeError(err error) {
+	var he *HandshakeError
+	var re *RequestError
+	var client net.Addr
+	switch {
+	case errors.As(err, &he):
+		client = he.Client
+	case errors.As(err, &re):
+		client = re.Client
+	default:
+		if !s.sampleError(err) {
+			LogServeError(err)
+		}
+		return
+	}
+	src := "unknown"
+	if client != nil {
+		src = hostOf(client.String())
+	}
+	if !s.handshakeSampler().sample(s.Context(), sampleKey{"failed handshakes from", src}) {
+		s.Stats.HandshakeLogSuppressed.Add(1)
+		return
+	}
+	if Debug {
+		log.Println(err)
+	}
+}
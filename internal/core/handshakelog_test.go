@@ -0,0 +1,56 @@
+package core_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// garbage 发送非 SOCKS5 数据，等待代理关闭连接
+func garbage(t *testing.T, proxy string) {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.Read(make([]byte, 16))
+}
+
+// 扫描器的一阵错误之后没有新的错误，汇总也在窗口结束时输出
+func TestHandshakeLogSummaryAfterSilence(t *testing.T) {
+	buf := captureLog(t, true)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.HandshakeLogWindow = 300 * time.Millisecond
+		s.HandshakeLogThreshold = 2
+	})
+	for range 5 {
+		garbage(t, ts.Addr)
+	}
+	waitCounter(t, &ts.Stats.HandshakeLogSuppressed, 3)
+	const summary = "suppressed 3 similar failed handshakes from 127.0.0.1 in last "
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), summary) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary after the window:\n%s", buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 阈值以内的错误在调试模式下逐条输出
+	if n := strings.Count(buf.String(), "127.0.0.1"); n != 3 {
+		t.Fatalf("%d lines mention the source, want 2 errors and 1 summary:\n%s", n, buf)
+	}
+
+	// 新窗口重新计数
+	garbage(t, ts.Addr)
+	time.Sleep(50 * time.Millisecond)
+	if n := ts.Stats.HandshakeLogSuppressed.Load(); n != 3 {
+		t.Fatalf("HandshakeLogSuppressed = %d in a new window, want 3", n)
+	}
+}
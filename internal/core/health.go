@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMaxFailures   = 3
+	DefaultProbeInterval = 10 * time.Second
+)
+
+// Prober 由可以主动探测可用性的上游实现
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// HealthCheckedUpstream 为上游记录健康状态：连续 MaxFailures 次上游失败（*UpstreamError）或探测失败后标记为不可用，
+// 后台每隔 ProbeInterval 探测一次，探测成功后自动恢复
+// 不可用期间的请求立即返回包装了 ErrUpstreamDown 的 *UpstreamError，Fallback 为 true 时由服务端改为直连
+type HealthCheckedUpstream struct {
+	Dialer        Dialer
+	Name          string // 日志中使用的名称
+	Fallback      bool   // 不可用时回落到直连，false 表示直接失败（fail-closed）
+	MaxFailures   int
+	ProbeInterval time.Duration
+
+	mu          sync.Mutex
+	failures    int
+	down        atomic.Bool
+	transitions atomic.Uint64
+	once        sync.Once
+	stop        chan struct{}
+}
+
+// NewHealthCheckedUpstream 使用默认阈值和探测间隔包装上游
+func NewHealthCheckedUpstream(name string, d Dialer, fallback bool) *HealthCheckedUpstream {
+	return &HealthCheckedUpstream{
+		Dialer:        d,
+		Name:          name,
+		Fallback:      fallback,
+		MaxFailures:   DefaultMaxFailures,
+		ProbeInterval: DefaultProbeInterval,
+		stop:          make(chan struct{}),
+	}
+}
+
+func (h *HealthCheckedUpstream) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	h.once.Do(func() { go h.probeLoop() })
+	if h.down.Load() {
+		return nil, &UpstreamError{Upstream: h.Name, Err: ErrUpstreamDown}
+	}
+	c, err := h.Dialer.DialContext(ctx, network, addr)
+	var ue *UpstreamError
+	switch {
+	case err == nil:
+		h.result(nil)
+	case errors.As(err, &ue) && ctx.Err() == nil:
+		h.result(err)
+	}
+	return c, err
+}
+
+func (h *HealthCheckedUpstream) SupportsUDP() bool {
+	if u, ok := h.Dialer.(UDPCapable); ok {
+		return u.SupportsUDP()
+	}
+	return true
+}
+
+// Down 判断上游当前是否被标记为不可用
+func (h *HealthCheckedUpstream) Down() bool {
+	return h.down.Load()
+}
+
+// Transitions 返回健康状态切换的次数
+func (h *HealthCheckedUpstream) Transitions() uint64 {
+	return h.transitions.Load()
+}
+
+// Close 停止后台探测，并关闭被包装的上游（如果它实现了 Close）
+func (h *HealthCheckedUpstream) Close() error {
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	if c, ok := h.Dialer.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// result 根据一次拨号或探测的结果更新健康状态
+func (h *HealthCheckedUpstream) result(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.failures = 0
+		if h.down.Swap(false) {
+			h.transitions.Add(1)
+			log.Printf("Upstream %s is healthy again\n", h.Name)
+		}
+		return
+	}
+	h.failures++
+	if h.failures >= h.MaxFailures && !h.down.Swap(true) {
+		h.transitions.Add(1)
+		mode := "failing requests"
+		if h.Fallback {
+			mode = "falling back to direct"
+		}
+		log.Printf("Upstream %s is down after %d consecutive failures, %s: %v\n", h.Name, h.failures, mode, err)
+	}
+}
+
+// probeLoop 定期探测上游，被包装的上游未实现 Prober 时不做主动探测，不可用期间让一次真实请求通过作为探测
+func (h *HealthCheckedUpstream) probeLoop() {
+	t := time.NewTicker(h.ProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+		}
+		p, ok := h.Dialer.(Prober)
+		if !ok {
+			if h.down.Load() {
+				h.mu.Lock()
+				h.failures = h.MaxFailures - 1
+				h.down.Store(false)
+				h.mu.Unlock()
+			}
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout)
+		err := p.Probe(ctx)
+		cancel()
+		if err != nil && Debug {
+			log.Printf("Upstream %s probe failed: %v\n", h.Name, err)
+		}
+		h.result(err)
+	}
+}
+
+// probeTCP 探测能否与 addr 建立 TCP 连接
+func probeTCP(ctx context.Context, addr string) error {
+	var nd net.Dialer
+	c, err := nd.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
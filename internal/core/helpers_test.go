@@ -0,0 +1,184 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+)
+
+// startServer 在随机端口上启动 s，返回 Serve 的结果通道
+func startServer(t *testing.T, s *core.Server) <-chan error {
+	t.Helper()
+	l, pc, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l, pc) }()
+	select {
+	case <-s.Ready():
+	case err := <-done:
+		t.Fatalf("Serve: %v", err)
+	}
+	return done
+}
+
+func newTestServer(t *testing.T) *core.Server {
+	t.Helper()
+	s, err := core.NewClassicServer("127.0.0.1:0", "127.0.0.1", "", "", 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func waitServe(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+		return nil
+	}
+}
+
+// udpSend 从 uc 经中继 relay 向 dst 发送 msg
+func udpSend(t *testing.T, uc *net.UDPConn, relay *net.UDPAddr, dst string, msg []byte) {
+	t.Helper()
+	atyp, addr, port, err := core.ParseAddress(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atyp == core.ATYPDomain {
+		addr = addr[1:]
+	}
+	if _, err := uc.WriteToUDP(core.NewDatagram(atyp, addr, port, msg).Bytes(), relay); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// udpRecv 在 timeout 内从 uc 读取一个应答数据报，超时返回 nil
+func udpRecv(t *testing.T, uc *net.UDPConn, timeout time.Duration) *core.Datagram {
+	t.Helper()
+	uc.SetReadDeadline(time.Now().Add(timeout))
+	defer uc.SetReadDeadline(time.Time{})
+	b := make([]byte, 64*1024)
+	n, err := uc.Read(b)
+	if err != nil {
+		return nil
+	}
+	d, err := core.NewDatagramFromBytes(b[:n])
+	if err != nil {
+		t.Fatalf("reply datagram: %v", err)
+	}
+	return d
+}
+
+// listenUDP 在 127.0.0.1 的随机端口上打开 UDP 套接字，测试结束时关闭
+func listenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { uc.Close() })
+	return uc
+}
+
+// staticResolver 按固定的表解析域名，表中没有的名字返回 NXDOMAIN
+type staticResolver map[string][]net.IP
+
+func (r staticResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	out := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		out[i] = net.IPAddr{IP: ip}
+	}
+	return out, nil
+}
+
+// syncBuffer 可并发写入的 bytes.Buffer，用于收集服务端各协程的日志
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// captureLog 把标准 log 的输出收集到返回的缓冲区，并按 debug 设置 core.Debug，测试结束时恢复；
+// 须在启动服务端之前调用，使恢复发生在服务端退出之后
+func captureLog(t *testing.T, debug bool) *syncBuffer {
+	buf := &syncBuffer{}
+	out, flags, old := log.Writer(), log.Flags(), core.Debug
+	log.SetOutput(buf)
+	if debug != old {
+		core.Debug = debug
+	}
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		// Shutdown 不等待仍在协商中的连接协程，值未改变时不写入，以免与它们读取 Debug 竞争
+		if core.Debug != old {
+			core.Debug = old
+		}
+	})
+	return buf
+}
+
+// waitAccessLog 等待 buf 中出现至少 n 条 JSON 访问日志并返回它们，超时则失败
+func waitAccessLog(t *testing.T, buf *syncBuffer, n int) []core.AccessRecord {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		var recs []core.AccessRecord
+		for line := range strings.Lines(buf.String()) {
+			var rec core.AccessRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("access log line %q: %v", line, err)
+			}
+			recs = append(recs, rec)
+		}
+		if len(recs) >= n {
+			return recs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d access log records, want %d:\n%s", len(recs), n, buf.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitCounter 等待计数器达到 want，超时则失败
+func waitCounter(t *testing.T, c *atomic.Int64, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for c.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := c.Load(); got != want {
+		t.Fatalf("counter = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// HoneypotPasswordKey Session.Meta 中记录客户端密码的键，HoneypotCredentials.RecordPasswords 为 true 时才写入
+const HoneypotPasswordKey = "password"
+
+// SessionAuthenticator 可选接口：Authenticator 同时实现它时 Negotiate 改为调用 AuthenticateSession，
+// 可以把认证相关的信息记录到 sess.Meta，进而写入访问日志
+type SessionAuthenticator interface {
+	AuthenticateSession(sess *Session, user, password string) bool
+}
+
+// HoneypotCredentials 接受任何用户名和密码的 Authenticator，用于蜜罐：用户名照常记为 Session.User，
+// 密码属于敏感数据，只有 RecordPasswords 为 true 时才以 HoneypotPasswordKey 记入 Session.Meta
+// 客户端不提供用户名密码认证方法时以无认证方式继续，不拒绝任何探测
+type HoneypotCredentials struct {
+	RecordPasswords bool
+}
+
+func (h *HoneypotCredentials) Authenticate(user, password string) bool {
+	return true
+}
+
+func (h *HoneypotCredentials) AuthenticateSession(sess *Session, user, password string) bool {
+	if h.RecordPasswords {
+		sess.Meta.Set(HoneypotPasswordKey, password)
+	}
+	return true
+}
+
+// HoneypotHandle 蜜罐 Handler：请求照常解析并写入访问日志（客户端、用户、命令、目标，以及按需记录的密码），
+// 然后以 Rep 应答失败，从不连接任何目标；UDP ASSOCIATE 同样在请求时被拒绝，不会有数据报被转发
+// 访问日志为 nil 时每个请求输出一行日志
+type HoneypotHandle struct {
+	Rep byte // 为 0（成功）时使用 RepConnectionRefused，看起来像目标不可达而不是代理拒绝
+}
+
+func (h *HoneypotHandle) TCPHandle(s *Server, c net.Conn, r *Request) error {
+	return h.TCPHandleContext(s.Context(), s, c, r)
+}
+
+func (h *HoneypotHandle) UDPHandle(s *Server, addr *net.UDPAddr, d *Datagram) error {
+	return h.UDPHandleContext(s.Context(), s, addr, d)
+}
+
+func (h *HoneypotHandle) TCPHandleContext(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+	rep := h.Rep
+	if rep == RepSuccess {
+		rep = RepConnectionRefused
+	}
+	if s.AccessLog == nil {
+		user := ""
+		if sess := SessionFromConn(c); sess != nil {
+			user = sess.User
+		}
+		log.Printf("Honeypot: %s from %s user %q to %s", CmdName(r.Cmd), c.RemoteAddr(), user, r.Address())
+	}
+	return rejectRequest(s, c, r, rep)
+}
+
+// UDPHandleContext 丢弃数据报：UDP ASSOCIATE 已被拒绝，这里只会收到未关联来源的数据报
+func (h *HoneypotHandle) UDPHandleContext(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+	if Debug {
+		log.Printf("Honeypot: UDP %s from %s dropped", d.Address(), addr)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Hosts 静态域名映射，在直连拨号的任何 DNS 解析之前查询，可在运行时重新加载
+type Hosts struct {
+	Path   string
+	Strict bool // 为 true 时未匹配的域名直接解析失败，不再查询 DNS
+
+	mu      sync.RWMutex
+	entries map[string]*hostEntry // 精确域名，或以 "*." 开头的通配子域名
+}
+
+// hostEntry 一个域名对应的多个地址，每次查询轮换起始地址
+type hostEntry struct {
+	ips  []net.IPAddr
+	next atomic.Uint32
+}
+
+// LoadHostsFile 读取 hosts 格式的文件：每行 "<IP> <域名> [域名...]"，支持 # 注释，
+// 同一域名出现多次时地址依次累加，"*.example.com" 匹配 example.com 的所有子域名（不含其本身）
+func LoadHostsFile(path string) (*Hosts, error) {
+	h := &Hosts{Path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload 重新读取文件，失败时保留原有映射
+func (h *Hosts) Reload() error {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := map[string]*hostEntry{}
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected \"<IP> <name> [name...]\"", h.Path, line)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return fmt.Errorf("%s:%d: invalid IP %q", h.Path, line, fields[0])
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+				return fmt.Errorf("%s:%d: invalid name %q, only a leading \"*.\" is allowed", h.Path, line, name)
+			}
+			e, ok := entries[name]
+			if !ok {
+				e = &hostEntry{}
+				entries[name] = e
+			}
+			e.ips = append(e.ips, net.IPAddr{IP: ip})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", h.Path, err)
+	}
+	h.mu.Lock()
+	h.entries = entries
+	h.mu.Unlock()
+	return nil
+}
+
+// Len 返回映射的域名数
+func (h *Hosts) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.entries)
+}
+
+// Lookup 查找域名的静态地址，先精确匹配再由近到远匹配通配项，多个地址时按轮换顺序返回
+func (h *Hosts) Lookup(host string) ([]net.IPAddr, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	h.mu.RLock()
+	e, ok := h.entries[host]
+	for s := host; !ok; {
+		i := strings.IndexByte(s, '.')
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+		e, ok = h.entries["*."+s]
+	}
+	h.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	n := len(e.ips)
+	start := int(e.next.Add(1)-1) % n
+	ips := make([]net.IPAddr, 0, n)
+	ips = append(ips, e.ips[start:]...)
+	return append(ips, e.ips[:start]...), true
+}
+
+// Resolver 返回先查静态映射、未匹配时使用 next 的解析器，h 为 nil 时直接返回 next
+// next 为 nil 时使用系统解析器
+func (h *Hosts) Resolver(next Resolver) Resolver {
+	if h == nil {
+		return next
+	}
+	if next == nil {
+		next = net.DefaultResolver
+	}
+	return &hostsResolver{hosts: h, next: next}
+}
+
+type hostsResolver struct {
+	hosts *Hosts
+	next  Resolver
+}
+
+// LookupIPAddr 静态映射命中时在 ctx 携带的 DialInfo 中记录 "static"，否则记录 "dns"
+func (r *hostsResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	info := DialInfoFromContext(ctx)
+	if ips, ok := r.hosts.Lookup(host); ok {
+		if info != nil {
+			info.Resolve = "static"
+		}
+		return ips, nil
+	}
+	if r.hosts.Strict {
+		return nil, &net.DNSError{Err: "not in static hosts", Name: host, Server: r.hosts.Path, IsNotFound: true}
+	}
+	if info != nil {
+		info.Resolve = "dns"
+	}
+	return r.next.LookupIPAddr(ctx, host)
+}
+
+// DialInfo 由拨号路径填写的附加信息，通过 context 传递
+type DialInfo struct {
+	Resolve  string // 域名的解析来源："static" 或 "dns"，未解析时为空
+	Attempts int    // DirectDialer 依次尝试的目标地址数，成功时即成功的那一次；未经多地址拨号时为 0
+}
+
+type dialInfoKey struct{}
+
+// WithDialInfo 返回携带 info 的 context
+func WithDialInfo(ctx context.Context, info *DialInfo) context.Context {
+	return context.WithValue(ctx, dialInfoKey{}, info)
+}
+
+// DialInfoFromContext 取出 ctx 携带的 DialInfo，没有时返回 nil
+func DialInfoFromContext(ctx context.Context) *DialInfo {
+	info, _ := ctx.Value(dialInfoKey{}).(*DialInfo)
+	return info
+}
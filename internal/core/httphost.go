@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrHostDenied is the error when HostFilter rejects the Host header of a relayed plaintext HTTP request
+var ErrHostDenied = errors.New("HTTP host is denied")
+
+// defaultHTTPHostPorts HTTPHostPorts 为 nil 时读取 HTTP 请求头的目标端口
+var defaultHTTPHostPorts = []uint16{80}
+
+// httpHostPorts 返回读取 HTTP 请求头的目标端口
+func (s *Server) httpHostPorts() []uint16 {
+	if s.HTTPHostPorts == nil {
+		return defaultHTTPHostPorts
+	}
+	return s.HTTPHostPorts
+}
+
+// maxPeekHTTPHeader 读取 HTTP 请求头的上限，超过时放弃
+const maxPeekHTTPHeader = 8 * 1024
+
+// peekHTTPHost 在 timeout 内读取客户端的第一个 HTTP 请求头（可能分多个 TCP 段到达）并取出 Host，
+// 返回已读取的字节（调用方必须先转发给目标，流水线中后续的请求也在其中）；
+// 首字节不像 HTTP 方法、请求头超过 maxPeekHTTPHeader、超时或格式不对时立即停止，Host 为空
+func peekHTTPHost(c net.Conn, timeout time.Duration) ([]byte, string) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	buf := make([]byte, maxPeekHTTPHeader)
+	n := 0
+	for {
+		m, err := c.Read(buf[n:])
+		n += m
+		if n > 0 && (buf[0] < 'A' || buf[0] > 'Z') {
+			return buf[:n], ""
+		}
+		if bytes.Contains(buf[:n], []byte("\r\n\r\n")) || bytes.Contains(buf[:n], []byte("\n\n")) {
+			break
+		}
+		if err != nil || n == len(buf) {
+			return buf[:n], ""
+		}
+	}
+	buf = buf[:n]
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		return buf, ""
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" || !validDomain([]byte(host)) {
+		return buf, ""
+	}
+	return buf, host
+}
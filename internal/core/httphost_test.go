@@ -0,0 +1,136 @@
+package core_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// hostPeekServer 启动对 echo 的端口读取 HTTP 请求头的服务端，代替测试中无法监听的 80 端口
+func hostPeekServer(t *testing.T, echo string, peek time.Duration) (*socks5test.Server, *syncBuffer) {
+	t.Helper()
+	_, p, _ := net.SplitHostPort(echo)
+	port, _ := strconv.Atoi(p)
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.HTTPHostPeek = peek
+		s.HTTPHostPorts = []uint16{uint16(port)}
+		s.HostFilter = func(addr string) bool { return !strings.HasPrefix(addr, "blocked.example:") }
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	return ts, buf
+}
+
+// relayOnce 经代理把 parts 依次发给回显服务，段之间间隔 gap，检查回显与发送的完全一致，返回这条连接的访问日志
+func relayOnce(t *testing.T, ts *socks5test.Server, buf *syncBuffer, echo string, gap time.Duration, parts ...[]byte) core.AccessRecord {
+	t.Helper()
+	n := len(waitAccessLog(t, buf, 0))
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	want := bytes.Join(parts, nil)
+	for i, p := range parts {
+		if i > 0 {
+			time.Sleep(gap)
+		}
+		c.Write(p)
+	}
+	c.SetReadDeadline(time.Now().Add(socks5test.Timeout))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("echo %q, %v; want %q", got, err, want)
+	}
+	c.Close()
+	return waitAccessLog(t, buf, n+1)[n]
+}
+
+func TestHTTPHostPeek(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	ts, buf := hostPeekServer(t, echo, 300*time.Millisecond)
+
+	for _, tc := range []struct {
+		name  string
+		gap   time.Duration
+		parts []string
+		host  string
+	}{
+		{"single", 0, []string{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"}, "example.com"},
+		{"port stripped", 0, []string{"GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n"}, "example.com"},
+		// 同一个 TCP 段里的多个请求：取第一个的 Host，全部原样转发
+		{"pipelined", 0, []string{"GET /a HTTP/1.1\r\nHost: first.example\r\n\r\nGET /b HTTP/1.1\r\nHost: second.example\r\n\r\n"}, "first.example"},
+		{"pipelined with body", 0, []string{"POST /a HTTP/1.1\r\nHost: first.example\r\nContent-Length: 4\r\n\r\nbodyGET /b HTTP/1.1\r\nHost: second.example\r\n\r\n"}, "first.example"},
+		// 请求头分多个段到达
+		{"split", 50 * time.Millisecond, []string{"GET / HTTP/1.1\r\nHo", "st: split.exa", "mple\r\n\r\n"}, "split.example"},
+		{"no host header", 0, []string{"GET / HTTP/1.0\r\n\r\n"}, "-"},
+		{"invalid host", 0, []string{"GET / HTTP/1.1\r\nHost: a b\r\n\r\n"}, "-"},
+		// 80 端口上的二进制流量：首字节不像 HTTP 方法，立即转发
+		{"binary", 0, []string{"\x00\x01\x02\xff\xfe\r\n\r\n"}, "-"},
+		{"tls", 0, []string{"\x16\x03\x01\x00\x05hello"}, "-"},
+	} {
+		var parts [][]byte
+		for _, p := range tc.parts {
+			parts = append(parts, []byte(p))
+		}
+		if rec := relayOnce(t, ts, buf, echo, tc.gap, parts...); rec.HTTPHost != tc.host || rec.Error != "" {
+			t.Errorf("%s: http_host %q, error %q; want %q", tc.name, rec.HTTPHost, rec.Error, tc.host)
+		}
+	}
+}
+
+func TestHTTPHostPeekBounded(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	const peek = 200 * time.Millisecond
+	ts, buf := hostPeekServer(t, echo, peek)
+
+	// 像 HTTP 方法开头但请求头一直不结束的流量最多被延迟 peek，之后照常转发
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	c.SetReadDeadline(time.Now().Add(socks5test.Timeout))
+	start := time.Now()
+	c.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	got := make([]byte, len("SSH-2.0-OpenSSH_9.6\r\n"))
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != "SSH-2.0-OpenSSH_9.6\r\n" {
+		t.Fatalf("echo %q, %v", got, err)
+	}
+	if d := time.Since(start); d < peek/2 || d > peek+time.Second {
+		t.Fatalf("unterminated header delayed %v, want about %v", d, peek)
+	}
+	c.Close()
+	if rec := waitAccessLog(t, buf, 1)[0]; rec.HTTPHost != "-" || rec.Error != "" {
+		t.Fatalf("http_host %q, error %q; want \"-\"", rec.HTTPHost, rec.Error)
+	}
+
+	// 超过上限的请求头放弃读取，全部转发
+	huge := "GET / HTTP/1.1\r\nX-Pad: " + strings.Repeat("a", 10*1024) + "\r\nHost: big.example\r\n\r\n"
+	if rec := relayOnce(t, ts, buf, echo, 0, []byte(huge)); rec.HTTPHost != "-" {
+		t.Fatalf("oversized header: http_host %q, want \"-\"", rec.HTTPHost)
+	}
+
+	// HostFilter 拒绝时请求不被转发
+	c = socks5test.Connect(t, ts.Addr, nil, echo)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: blocked.example\r\n\r\n"))
+	c.SetReadDeadline(time.Now().Add(socks5test.Timeout))
+	if n, err := c.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("denied request echoed %d bytes", n)
+	}
+	if rec := waitAccessLog(t, buf, 3)[2]; rec.HTTPHost != "blocked.example" || !strings.Contains(rec.Error, core.ErrHostDenied.Error()) {
+		t.Fatalf("denied record: http_host %q, error %q", rec.HTTPHost, rec.Error)
+	}
+}
+
+func TestHTTPHostPeekOtherPorts(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	buf := &syncBuffer{}
+	// HTTPHostPorts 为 nil 时只读取 80 端口
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.HTTPHostPeek = time.Second
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	if rec := relayOnce(t, ts, buf, echo, 0, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); rec.HTTPHost != "" {
+		t.Fatalf("http_host %q on a non-80 port, want empty", rec.HTTPHost)
+	}
+}
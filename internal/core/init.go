@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"net"
 	"time"
 )
@@ -11,41 +12,74 @@ func init() {
 	// log.SetFlags(log.LstdFlags | log.Lshortfile)
 }
 
-var Resolve func(network string, addr string) (net.Addr, error) = func(network string, addr string) (net.Addr, error) {
+// Resolve 解析地址，nil 表示使用默认的解析；设置后 Server 和 DirectDialer 在未设置 Resolver（或为 net.DefaultResolver）时经它解析域名目标
+//
+// Deprecated: 包级变量会被所有使用者共享，请设置 Server.Resolver 或 DirectDialer.Resolver
+var Resolve func(network string, addr string) (net.Addr, error)
+
+// resolve 经 Resolve 解析地址，Resolve 为 nil 时按 network 用 net.ResolveTCPAddr 或 net.ResolveUDPAddr 解析
+func resolve(network string, addr string) (net.Addr, error) {
+	if Resolve != nil {
+		return Resolve(network, addr)
+	}
 	if network == "tcp" {
 		return net.ResolveTCPAddr("tcp", addr)
 	}
 	return net.ResolveUDPAddr("udp", addr)
 }
 
-// 优化：使用 net.Dialer 支持 Happy Eyeballs 和超时控制
-var DialTCP func(network string, laddr, raddr string) (net.Conn, error) = func(network string, laddr, raddr string) (net.Conn, error) {
+// DefaultDialTimeout 出站 TCP 拨号的默认超时时间
+const DefaultDialTimeout = 10 * time.Second
+
+// 优化：使用 net.Dialer 支持 Happy Eyeballs 和超时控制，timeout 为 0 表示不限制
+// 只在 Client 和 Resolver 为 nil 的 DirectDialer 中使用，Server 不使用
+//
+// Deprecated: 包级变量会被所有使用者共享，请设置 Server.Dialer、Server.Resolver 或 DirectDialer.Resolver
+var DialTCP func(network string, laddr, raddr string, timeout time.Duration) (net.Conn, error) = func(network string, laddr, raddr string, timeout time.Duration) (net.Conn, error) {
 	dialer := &net.Dialer{
-		Timeout:   10 * time.Second,
+		Timeout:   timeout,
 		KeepAlive: 30 * time.Second,
 	}
+	// 设置了 Resolve 时域名目标经它解析，否则交给 net.Dialer 解析以保留 Happy Eyeballs
+	if host, _, err := net.SplitHostPort(raddr); err == nil && net.ParseIP(host) == nil && Resolve != nil {
+		a, err := Resolve(network, raddr)
+		if err != nil {
+			return nil, err
+		}
+		raddr = a.String()
+	}
 	if laddr != "" {
+		// 本地地址无效时返回错误，不退回到系统选择的地址，出口 IP 固定不会悄悄失效
 		local, err := net.ResolveTCPAddr(network, laddr)
-		if err == nil {
-			dialer.LocalAddr = local
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address %q: %w", laddr, err)
 		}
+		dialer.LocalAddr = local
 	}
 	return dialer.Dial(network, raddr)
 }
 
 // 优化：简化 UDP Dial
+// 只在 Client 和 Resolver 为 nil 的 DirectDialer 中使用，Server 不使用
+//
+// Deprecated: 包级变量会被所有使用者共享，请设置 Server.Dialer、Server.Resolver 或 DirectDialer.Resolver
 var DialUDP func(network string, laddr, raddr string) (net.Conn, error) = func(network string, laddr, raddr string) (net.Conn, error) {
 	var la, ra *net.UDPAddr
 	var err error
 	if laddr != "" {
 		la, err = net.ResolveUDPAddr(network, laddr)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid local address %q: %w", laddr, err)
 		}
 	}
-	ra, err = net.ResolveUDPAddr(network, raddr)
+	a, err := resolve(network, raddr)
 	if err != nil {
 		return nil, err
 	}
+	if ra, _ = a.(*net.UDPAddr); ra == nil {
+		if ra, err = net.ResolveUDPAddr(network, a.String()); err != nil {
+			return nil, err
+		}
+	}
 	return net.DialUDP(network, la, ra)
 }
@@ -0,0 +1,199 @@
+package core_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// needIPv6 没有 IPv6 回环地址时跳过测试
+func needIPv6(t *testing.T) {
+	t.Helper()
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	l.Close()
+}
+
+func TestParseAddressIPv6(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		atyp byte
+		want string // 报文中的地址，为空表示解析出错
+	}{
+		{"[::1]:80", core.ATYPIPv6, "[::1]:80"},
+		{"[2001:db8::1]:65535", core.ATYPIPv6, "[2001:db8::1]:65535"},
+		{"[fe80::1%eth0]:80", core.ATYPIPv6, "[fe80::1]:80"},
+		{"[fe80::1%25eth0]:443", core.ATYPIPv6, "[fe80::1]:443"},
+		{"[::ffff:192.0.2.1]:53", core.ATYPIPv4, "192.0.2.1:53"},
+		{"[::ffff:c000:201]:53", core.ATYPIPv4, "192.0.2.1:53"},
+		{"[::]:0", core.ATYPIPv6, "[::]:0"},
+		{"::1:80", 0, ""},
+		{"[::1]:65536", 0, ""},
+		{"[::1]:-1", 0, ""},
+		{"[::1]:", 0, ""},
+		{"[::1]:http", 0, ""},
+	} {
+		atyp, addr, port, err := core.ParseAddress(tc.in)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("ParseAddress(%q) = %d %x %x, want an error", tc.in, atyp, addr, port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAddress(%q): %v", tc.in, err)
+			continue
+		}
+		if got := core.NewReply(core.RepSuccess, atyp, addr, port).Address(); atyp != tc.atyp || got != tc.want {
+			t.Errorf("ParseAddress(%q) = %s %s, want %s %s", tc.in, core.ATYPName(atyp), got, core.ATYPName(tc.atyp), tc.want)
+		}
+	}
+}
+
+// v6Server 在 [::1] 上启动服务端
+func v6Server(t *testing.T) *socks5test.Server {
+	return socks5test.NewServer(t, func(s *core.Server) {
+		s.Addr = "[::1]:0"
+		s.ServerAddr = &net.UDPAddr{IP: net.IPv6loopback}
+	})
+}
+
+func TestConnectIPv6(t *testing.T) {
+	needIPv6(t)
+	ts := v6Server(t)
+	if ip := ts.TCPAddr().(*net.TCPAddr).IP; ip.To4() != nil {
+		t.Fatalf("server bound to %s", ip)
+	}
+	echo4 := socks5test.EchoTCPServer(t)
+	_, port4, _ := net.SplitHostPort(echo4)
+	echo6 := echoTCP6(t)
+	_, port6, _ := net.SplitHostPort(echo6)
+
+	for _, tc := range []struct {
+		dst string
+		v4  bool // 目标实际是 IPv4，绑定地址也是 IPv4
+	}{
+		{echo6, false},
+		{net.JoinHostPort("::1%lo", port6), false}, // 区域标识被去掉
+		{net.JoinHostPort("::ffff:127.0.0.1", port4), true},
+	} {
+		c, rp := socks5test.Handshake(t, ts.Addr, nil, core.CmdConnect, tc.dst)
+		if rp.Rep != core.RepSuccess {
+			t.Fatalf("CONNECT %s: %s", tc.dst, core.RepName(rp.Rep))
+		}
+		if (rp.Atyp == core.ATYPIPv4) != tc.v4 {
+			t.Fatalf("CONNECT %s bound %s", tc.dst, rp.Address())
+		}
+		socks5test.EchoTCP(t, c, []byte("v6 "+tc.dst))
+		c.Close()
+	}
+
+	// 报文中 16 字节的 IPv4-mapped 地址（ATYP 0x04）同样连到 IPv4 目标
+	c, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	core.NewNegotiationRequest([]byte{core.MethodNone}).WriteTo(c)
+	core.NewNegotiationReplyFrom(c)
+	p, _ := strconv.Atoi(port4)
+	core.NewRequest(core.CmdConnect, core.ATYPIPv6, net.ParseIP("::ffff:127.0.0.1"), []byte{byte(p >> 8), byte(p)}).WriteTo(c)
+	if rp, err := core.NewReplyFrom(c); err != nil || rp.Rep != core.RepSuccess {
+		t.Fatalf("CONNECT to a 16-byte v4-mapped address: %v %v", rp, err)
+	}
+	socks5test.EchoTCP(t, c, []byte("mapped on the wire"))
+}
+
+// echoTCP6 在 [::1] 上启动 TCP 回显服务
+func echoTCP6(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 32*1024)
+				for {
+					n, err := c.Read(b)
+					if err != nil {
+						return
+					}
+					c.Write(b[:n])
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestUDPReplyIPv6(t *testing.T) {
+	needIPv6(t)
+	ts := v6Server(t)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	if relay.IP.To4() != nil {
+		t.Fatalf("relay advertised at %s", relay)
+	}
+	echo6, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo6.Close()
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			n, a, err := echo6.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			echo6.WriteToUDP(b[:n], a)
+		}
+	}()
+	echo4 := socks5test.EchoUDPServer(t)
+	a4, _ := net.ResolveUDPAddr("udp", echo4)
+	p6 := echo6.LocalAddr().(*net.UDPAddr).Port
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	for _, tc := range []struct {
+		name  string
+		atyp  byte
+		addr  net.IP
+		port  int
+		reply string // 应答数据报头中的来源
+	}{
+		{"v6", core.ATYPIPv6, net.IPv6loopback, p6, net.JoinHostPort("::1", strconv.Itoa(p6))},
+		// 来源按远端的实际地址写出，IPv4-mapped 目标的应答以 IPv4 报头返回
+		{"v4-mapped", core.ATYPIPv6, net.ParseIP("::ffff:127.0.0.1"), a4.Port, echo4},
+		{"v4", core.ATYPIPv4, net.IPv4(127, 0, 0, 1).To4(), a4.Port, echo4},
+	} {
+		pkt := core.NewDatagram(tc.atyp, tc.addr, []byte{byte(tc.port >> 8), byte(tc.port)}, []byte(tc.name)).Bytes()
+		if _, err := uc.WriteToUDP(pkt, relay); err != nil {
+			t.Fatal(err)
+		}
+		d := udpRecv(t, uc, 2*time.Second)
+		if d == nil {
+			t.Fatalf("%s: no reply", tc.name)
+		}
+		if string(d.Data) != tc.name || d.Address() != tc.reply {
+			t.Fatalf("%s: reply %q from %s, want from %s", tc.name, d.Data, d.Address(), tc.reply)
+		}
+	}
+}
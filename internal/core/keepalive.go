@@ -0,0 +1,21 @@
+package core
+
+import (
+	"log"
+	"net"
+)
+
+// setClientKeepAlive 按 ClientKeepAlive 设置接受的客户端 TCP 连接（含 TLS 之下的）的 keepalive，
+// 为 nil 时保持监听器的默认设置；其他传输层的连接不受影响
+func (s *Server) setClientKeepAlive(c net.Conn) {
+	if s.ClientKeepAlive == nil {
+		return
+	}
+	tc, ok := underlyingConn(c).(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tc.SetKeepAliveConfig(*s.ClientKeepAlive); err != nil && Debug {
+		log.Printf("Keepalive on %s: %v", tc.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+)
+
+// ErrAlreadyServing is the error when Serve is called on a server that is already serving
+var ErrAlreadyServing = errors.New("server is already serving")
+
+// Stage 组件在关闭顺序中所处的阶段，关闭时按阶段从小到大依次停止，
+// 同一阶段的组件一起停止，全部的 Start 返回后才进入下一阶段
+type Stage int
+
+const (
+	// StageAccept 监听器等接受新连接、新数据报的组件，最先停止
+	StageAccept Stage = iota
+	// StageDrain 等待已接受的工作处理完，如已入队的数据报
+	StageDrain
+	// StageJanitor 后台的清理、保存和检查任务
+	StageJanitor
+	// StageSocket 最后关闭的套接字，排空阶段的应答仍可经它们发出
+	StageSocket
+)
+
+// Component 随 Serve 启动、随 Shutdown 停止的组件
+type Component struct {
+	// Name 组件名，加在 Start 返回的错误之前
+	Name  string
+	Stage Stage
+	// Start 阻塞运行，ctx 为服务端的生命周期 context；返回非 nil 错误时关闭整个服务端，Serve 返回第一个这样的错误，
+	// 返回 nil 表示组件正常结束，不影响其他组件。为 nil 时组件只在关闭时执行 Stop
+	Start func(ctx context.Context) error
+	// Stop 使 Start 返回，可为 nil（Start 须在 ctx 取消后返回）；没有 Start 的组件的 Stop 可以阻塞，用于排空
+	Stop func() error
+}
+
+// component 已注册的组件
+type component struct {
+	Component
+	started bool
+	stopped bool          // 已执行过 Stop，此后 Start 返回的错误不再视为致命
+	done    chan struct{} // Start 返回后关闭
+}
+
+// lifecycle 管理 AddComponent 注册的组件：Serve 启动它们，第一个致命错误或 Shutdown 按阶段停止它们
+type lifecycle struct {
+	mu       sync.Mutex
+	comps    []*component
+	serving  bool // Serve 已被调用
+	running  bool
+	stopping bool
+	err      error // 第一个致命错误
+	stopErr  error // Stop 返回的错误
+
+	stopOnce sync.Once
+	stopped  chan struct{} // 关闭流程结束后关闭
+}
+
+func (l *lifecycle) init() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped == nil {
+		l.stopped = make(chan struct{})
+	}
+}
+
+// AddComponent 注册组件：Serve 之前注册的在 Serve 时启动，Serve 期间注册的立即启动，
+// 关闭开始后注册的不再启动，只立即执行 Stop
+func (s *Server) AddComponent(c Component) {
+	l := &s.lc
+	l.init()
+	comp := &component{Component: c, done: make(chan struct{})}
+	l.mu.Lock()
+	if l.stopping {
+		l.mu.Unlock()
+		if c.Stop != nil {
+			c.Stop()
+		}
+		return
+	}
+	l.comps = append(l.comps, comp)
+	start := l.running
+	if start {
+		comp.started = true
+	}
+	l.mu.Unlock()
+	if start {
+		s.startComponent(comp)
+	}
+}
+
+// startComponent 在新协程中运行组件的 Start，返回错误时开始关闭
+func (s *Server) startComponent(c *component) {
+	if c.Start == nil {
+		close(c.done)
+		return
+	}
+	go func() {
+		defer close(c.done)
+		if err := c.Start(s.Context()); err != nil && !s.lc.isStopped(c) {
+			if c.Name != "" {
+				err = fmt.Errorf("%s: %w", c.Name, err)
+			}
+			s.lc.fail(err)
+			go s.stopComponents()
+		}
+	}()
+}
+
+func (l *lifecycle) isStopped(c *component) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return c.stopped
+}
+
+// fail 记录第一个致命错误
+func (l *lifecycle) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// claim 标记服务端开始 Serve，已经调用过时返回 ErrAlreadyServing；
+// Serve 在修改任何字段、注册任何组件之前调用，第二次 Serve 不会影响正在服务的监听器和套接字
+func (l *lifecycle) claim() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.serving {
+		return ErrAlreadyServing
+	}
+	l.serving = true
+	return nil
+}
+
+// runComponents 启动已注册的组件并阻塞到关闭流程结束，返回第一个致命错误，调用方已经 claim
+func (s *Server) runComponents() error {
+	l := &s.lc
+	l.init()
+	l.mu.Lock()
+	if l.stopping {
+		l.mu.Unlock()
+		<-l.stopped
+		return nil
+	}
+	l.running = true
+	var comps []*component
+	for _, c := range l.comps {
+		if !c.stopped {
+			c.started = true
+			comps = append(comps, c)
+		}
+	}
+	l.mu.Unlock()
+	for _, c := range comps {
+		s.startComponent(c)
+	}
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// stopComponents 开始关闭（执行关闭钩子并取消 context），再按阶段停止组件并等待，可并发多次调用，都在关闭完成后返回
+func (s *Server) stopComponents() error {
+	l := &s.lc
+	l.init()
+	l.stopOnce.Do(func() {
+		l.mu.Lock()
+		l.stopping = true
+		comps := slices.Clone(l.comps)
+		l.mu.Unlock()
+		s.beginShutdown()
+
+		slices.SortStableFunc(comps, func(a, b *component) int { return int(a.Stage - b.Stage) })
+		var errs []error
+		for i := 0; i < len(comps); {
+			j := i
+			for j < len(comps) && comps[j].Stage == comps[i].Stage {
+				j++
+			}
+			if err := l.stop(comps[i:j]); err != nil {
+				errs = append(errs, err)
+			}
+			i = j
+		}
+		l.mu.Lock()
+		l.stopErr = errors.Join(errs...)
+		l.mu.Unlock()
+		close(l.stopped)
+	})
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopErr
+}
+
+// stop 执行尚未停止的组件的 Stop，再等待它们的 Start 返回；
+// 监听器或套接字已被关闭（如组件因此出错）时 Stop 返回的 net.ErrClosed 不算停止失败
+func (l *lifecycle) stop(comps []*component) error {
+	var errs []error
+	for _, c := range comps {
+		l.mu.Lock()
+		skip := c.stopped
+		c.stopped = true
+		l.mu.Unlock()
+		if !skip && c.Stop != nil {
+			if err := c.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, c := range comps {
+		l.mu.Lock()
+		started := c.started
+		l.mu.Unlock()
+		if started {
+			<-c.done
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopStage 提前停止 stage 阶段的组件而不关闭服务端，用于停止接受新连接、让进行中的会话继续；
+// 返回时这些组件的 Start 已返回，之后的 Shutdown 不再对它们执行 Stop
+func (s *Server) stopStage(stage Stage) error {
+	l := &s.lc
+	l.init()
+	l.mu.Lock()
+	var comps []*component
+	for _, c := range l.comps {
+		if c.Stage == stage {
+			comps = append(comps, c)
+		}
+	}
+	l.mu.Unlock()
+	return l.stop(comps)
+}
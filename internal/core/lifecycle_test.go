@@ -0,0 +1,201 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestShutdownTwice(t *testing.T) {
+	s := newTestServer(t)
+	done := startServer(t, s)
+	var hooks int
+	s.RegisterOnShutdownDone(func() { hooks++ })
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("first Shutdown: %v", err)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("second Shutdown: %v", err)
+	}
+	if err := waitServe(t, done); err != nil {
+		t.Fatalf("Serve after Shutdown = %v, want nil", err)
+	}
+	if hooks != 1 {
+		t.Fatalf("shutdown-done hook ran %d times, want 1", hooks)
+	}
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	s := newTestServer(t)
+	done := startServer(t, s)
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	for i := range errs {
+		wg.Go(func() { errs[i] = s.Shutdown() })
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Shutdown #%d: %v", i, err)
+		}
+	}
+	if err := waitServe(t, done); err != nil {
+		t.Fatalf("Serve = %v, want nil", err)
+	}
+	if !s.ShuttingDown() {
+		t.Fatal("ShuttingDown() = false after Shutdown")
+	}
+}
+
+func TestServeErrorDuringStart(t *testing.T) {
+	s := newTestServer(t)
+	boom := errors.New("boom")
+	janitorStopped := make(chan struct{})
+	s.AddComponent(core.Component{Name: "broken", Stage: core.StageJanitor, Start: func(context.Context) error { return boom }})
+	s.AddComponent(core.Component{
+		Name:  "janitor",
+		Stage: core.StageJanitor,
+		Start: func(ctx context.Context) error {
+			<-ctx.Done()
+			close(janitorStopped)
+			return nil
+		},
+	})
+	var began, finished bool
+	s.RegisterOnShutdown(func() { began = true })
+	s.RegisterOnShutdownDone(func() { finished = true })
+	l, pc, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Serve(l, pc)
+	if !errors.Is(err, boom) || !strings.HasPrefix(err.Error(), "broken: ") {
+		t.Fatalf("Serve = %v, want broken: boom", err)
+	}
+	select {
+	case <-janitorStopped:
+	default:
+		t.Fatal("other components still running after a fatal error")
+	}
+	if !began || !finished {
+		t.Fatalf("shutdown hooks: begin %v, done %v", began, finished)
+	}
+	if _, err := net.DialTimeout("tcp", l.Addr().String(), time.Second); err == nil {
+		t.Fatal("listener still accepting after a fatal error")
+	}
+	// 出错后的 Shutdown 仍可调用
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("Shutdown after fatal error: %v", err)
+	}
+}
+
+func TestShutdownStageOrder(t *testing.T) {
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var order []string
+	record := func(ev string) {
+		mu.Lock()
+		order = append(order, ev)
+		mu.Unlock()
+	}
+	// 注册顺序与阶段顺序相反，停止顺序只取决于阶段；每个阶段的 Start 返回后才进入下一阶段
+	for _, c := range []struct {
+		name  string
+		stage core.Stage
+	}{{"socket", core.StageSocket}, {"janitor", core.StageJanitor}, {"drain", core.StageDrain}, {"accept", core.StageAccept}} {
+		stop := make(chan struct{})
+		s.AddComponent(core.Component{
+			Name:  c.name,
+			Stage: c.stage,
+			Start: func(context.Context) error {
+				<-stop
+				time.Sleep(10 * time.Millisecond)
+				record(c.name + " returned")
+				return nil
+			},
+			Stop: func() error {
+				record("stop " + c.name)
+				close(stop)
+				return nil
+			},
+		})
+	}
+	done := startServer(t, s)
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	waitServe(t, done)
+	want := []string{
+		"stop accept", "accept returned",
+		"stop drain", "drain returned",
+		"stop janitor", "janitor returned",
+		"stop socket", "socket returned",
+	}
+	if !slices.Equal(order, want) {
+		t.Fatalf("stop order:\n got %q\nwant %q", order, want)
+	}
+}
+
+func TestShutdownStopError(t *testing.T) {
+	s := newTestServer(t)
+	bad := errors.New("close failed")
+	s.AddComponent(core.Component{Name: "bad", Stage: core.StageSocket, Stop: func() error { return bad }})
+	done := startServer(t, s)
+	if err := s.Shutdown(); !errors.Is(err, bad) {
+		t.Fatalf("Shutdown = %v, want %v", err, bad)
+	}
+	if err := waitServe(t, done); err != nil {
+		t.Fatalf("Serve = %v, want nil: Stop errors are reported by Shutdown only", err)
+	}
+}
+
+func TestServeTwice(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoUDPServer(t)
+	tcpAddr, udpAddr, udpConn, serverAddr := ts.TCPAddr(), ts.Server.UDPAddr(), ts.UDPConn, ts.ServerAddr.String()
+
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	pc2, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc2.Close()
+	if err := ts.Serve(l2, pc2); !errors.Is(err, core.ErrAlreadyServing) {
+		t.Fatalf("second Serve = %v, want ErrAlreadyServing", err)
+	}
+	if ts.TCPAddr() != tcpAddr || ts.Server.UDPAddr() != udpAddr || ts.UDPConn != udpConn || ts.ServerAddr.String() != serverAddr {
+		t.Fatal("second Serve changed the bound addresses or the UDP socket")
+	}
+
+	// 第二个监听器没有被服务：连接能进入队列，但收不到方法协商的应答
+	c, err := net.Dial("tcp", l2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte{core.Ver, 1, core.MethodNone})
+	c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := c.Read(make([]byte, 2)); err == nil {
+		t.Fatalf("second listener answered %d bytes", n)
+	}
+
+	// 第一个服务端的 UDP 应答仍从原来的套接字发出
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	if relay.String() != serverAddr {
+		t.Fatalf("ASSOCIATE advertised %s, want %s", relay, serverAddr)
+	}
+	socks5test.EchoUDP(t, relay, echo, []byte("still here"))
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrLifetimeExceeded is the error when a session is closed because it reached MaxSessionDuration,
+// the user's UserMaxSessionDuration or its route's limit
+var ErrLifetimeExceeded = errors.New("lifetime exceeded")
+
+// sessionCloseGrace 会话到期半关闭后等待双方自行结束的时间，之后强制关闭
+const sessionCloseGrace = 5 * time.Second
+
+// lifetime 一个 TCP 转发或 UDP 关联的最长存续定时器
+type lifetime struct {
+	mu      sync.Mutex
+	expire  *time.Timer
+	grace   *time.Timer
+	expired bool
+	stopped bool
+}
+
+// maxSessionDuration 返回服务端、用户和路由规则的限制中最小的非 0 值，都未设置时返回 0
+func (s *Server) maxSessionDuration(sess *Session, route *Route) time.Duration {
+	d := s.MaxSessionDuration
+	limit := func(v time.Duration) {
+		if v > 0 && (d <= 0 || v < d) {
+			d = v
+		}
+	}
+	if sess != nil {
+		limit(s.UserMaxSessionDuration[sess.User])
+	}
+	if route != nil {
+		limit(route.MaxSessionDuration)
+	}
+	return max(d, 0)
+}
+
+// startLifetime 在最长存续时间到期时先半关闭 conns 的写方向，宽限期后强制关闭，未设置时返回 nil
+// sess 非 nil 时记录到期时间，供 Session.Remaining 查询
+func (s *Server) startLifetime(sess *Session, route *Route, conns ...net.Conn) *lifetime {
+	d := s.maxSessionDuration(sess, route)
+	if d == 0 {
+		return nil
+	}
+	if sess != nil {
+		sess.setDeadline(time.Now().Add(d))
+	}
+	lt := &lifetime{}
+	lt.expire = time.AfterFunc(d, func() {
+		lt.mu.Lock()
+		defer lt.mu.Unlock()
+		if lt.stopped {
+			return
+		}
+		lt.expired = true
+		for _, c := range conns {
+			closeWrite(c)
+		}
+		lt.grace = time.AfterFunc(sessionCloseGrace, func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		})
+	})
+	return lt
+}
+
+// stop 清理定时器，返回会话是否因到期而结束，nil 的 lifetime 返回 false
+func (lt *lifetime) stop() bool {
+	if lt == nil {
+		return false
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.stopped = true
+	lt.expire.Stop()
+	if lt.grace != nil {
+		lt.grace.Stop()
+	}
+	return lt.expired
+}
+
+// closeWrite 半关闭连接的写方向，不支持半关闭的连接直接关闭
+func closeWrite(c net.Conn) {
+	if sess, ok := c.(*Session); ok {
+		c = sess.Conn
+	}
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// countRelayEnd 按转发结束的原因计入 Stats.IdleTimeouts、Stats.IdleReaped 或 Stats.LifetimeExpired
+func (s *Server) countRelayEnd(err error) {
+	switch {
+	case errors.Is(err, ErrIdleReaped):
+		s.Stats.IdleReaped.Add(1)
+	case errors.Is(err, ErrLifetimeExceeded):
+		s.Stats.LifetimeExpired.Add(1)
+	case errors.Is(err, ErrIdleTimeout):
+		s.Stats.IdleTimeouts.Add(1)
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is the error when a request is refused because file descriptor usage is above the load shedding high-water mark
+var ErrOverloaded = errors.New("server is shedding load: file descriptor usage is too high")
+
+// ErrFDUsageUnsupported is the error when the file descriptor usage of the process can not be determined on this platform
+var ErrFDUsageUnsupported = errors.New("file descriptor usage is not available on this platform")
+
+const (
+	DefaultLoadShedHigh     = 0.9
+	DefaultLoadShedLow      = 0.8
+	DefaultLoadShedInterval = time.Second
+)
+
+// LoadShedder 按文件描述符用量卸载负载：用量达到上限的 High 比例后新的 CONNECT 和 UDP ASSOCIATE 请求以 REP 0x01 拒绝，
+// 已有的会话和其他监听（管理接口等）不受影响，降到 Low 比例以下后恢复；状态变化输出日志并计入 ServerStats
+// 用量最多每 Interval 读取一次；Usage 为 nil 时读取本进程的打开文件数和 RLIMIT_NOFILE 软限制（仅 Linux），
+// 读取失败时不卸载；各项为 0 时使用对应的默认值，可并发使用
+type LoadShedder struct {
+	High     float64
+	Low      float64
+	Interval time.Duration
+	Usage    func() (used, limit int, err error)
+
+	mu       sync.Mutex
+	shedding bool
+	checked  time.Time
+	warned   bool
+}
+
+// Shedding 返回当前是否在卸载负载，距上次读取超过 Interval 时先重新读取用量；
+// changed 为 true 表示这次调用改变了状态
+func (l *LoadShedder) Shedding() (shedding, changed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.checked.IsZero() && now.Sub(l.checked) < cmp.Or(l.Interval, DefaultLoadShedInterval) {
+		return l.shedding, false
+	}
+	l.checked = now
+	usage := l.Usage
+	if usage == nil {
+		usage = fdUsage
+	}
+	used, limit, err := usage()
+	if err != nil || limit <= 0 {
+		if !l.warned {
+			l.warned = true
+			log.Printf("Warning: load shedding is disabled, cannot read file descriptor usage: %v", cmp.Or(err, fmt.Errorf("limit is %d", limit)))
+		}
+		return l.shedding, false
+	}
+	ratio := float64(used) / float64(limit)
+	switch {
+	case !l.shedding && ratio >= cmp.Or(l.High, DefaultLoadShedHigh):
+		l.shedding = true
+		log.Printf("Warning: %d of %d file descriptors in use, refusing new requests until usage drops below %.0f%%", used, limit, 100*cmp.Or(l.Low, DefaultLoadShedLow))
+		return true, true
+	case l.shedding && ratio < cmp.Or(l.Low, DefaultLoadShedLow):
+		l.shedding = false
+		log.Printf("%d of %d file descriptors in use, accepting new requests again", used, limit)
+		return false, true
+	}
+	return l.shedding, false
+}
+
+// shedLoad 在卸载负载时以 REP 0x01 拒绝请求并记录访问日志，返回 true 表示请求已被拒绝
+// 单个被拒绝的请求不输出日志，只计入 Stats.LoadShedRequests
+func (s *Server) shedLoad(sess *Session, r *Request) bool {
+	if s.LoadShed == nil {
+		return false
+	}
+	shedding, changed := s.LoadShed.Shedding()
+	if changed && shedding {
+		s.Stats.LoadShedEvents.Add(1)
+	}
+	if !shedding {
+		return false
+	}
+	s.Stats.LoadShedRequests.Add(1)
+	rec := newAccessRecord(sess, r)
+	rec.Rep = RepServerFailure
+	rec.finish(s.AccessLog, ErrOverloaded)
+	if !sess.Transparent {
+		r.Reject(sess, RepServerFailure)
+	}
+	return true
+}
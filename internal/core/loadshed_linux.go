@@ -0,0 +1,42 @@
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// fdUsage 返回 /proc/self/fd 中的条目数和 RLIMIT_NOFILE 的软限制
+func fdUsage() (int, int, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, 0, err
+	}
+	// 减去读取目录本身占用的描述符
+	return len(names) - 1, int(min(rl.Cur, 1<<31-1)), nil
+}
+
+// RaiseFDLimit 把 RLIMIT_NOFILE 的软限制提高到硬限制，返回调整后的软限制
+func RaiseFDLimit() (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	if rl.Cur >= rl.Max {
+		return rl.Cur, nil
+	}
+	cur := rl.Cur
+	rl.Cur = rl.Max
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return cur, err
+	}
+	return rl.Cur, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package core
+
+func fdUsage() (int, int, error) {
+	return 0, 0, ErrFDUsageUnsupported
+}
+
+// RaiseFDLimit 在不支持的平台上不做调整
+func RaiseFDLimit() (uint64, error) {
+	return 0, ErrFDUsageUnsupported
+}
@@ -0,0 +1,105 @@
+package core_test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// fakeUsage 可在测试中修改的文件描述符用量，上限为 100
+type fakeUsage struct {
+	used atomic.Int64
+	fail atomic.Bool
+}
+
+func (u *fakeUsage) usage() (int, int, error) {
+	if u.fail.Load() {
+		return 0, 0, errors.New("no /proc")
+	}
+	return int(u.used.Load()), 100, nil
+}
+
+func TestLoadShedderThresholds(t *testing.T) {
+	u := &fakeUsage{}
+	l := &core.LoadShedder{High: 0.9, Low: 0.8, Interval: time.Nanosecond, Usage: u.usage}
+	for _, step := range []struct {
+		used              int64
+		shedding, changed bool
+	}{
+		{50, false, false},
+		{89, false, false},
+		{90, true, true},
+		{95, true, false},
+		{85, true, false}, // 在两个水位之间保持卸载
+		{80, true, false},
+		{79, false, true},
+		{85, false, false}, // 在两个水位之间保持正常
+		{100, true, true},
+	} {
+		u.used.Store(step.used)
+		time.Sleep(time.Microsecond)
+		if shedding, changed := l.Shedding(); shedding != step.shedding || changed != step.changed {
+			t.Fatalf("at %d%%: shedding %v changed %v, want %v %v", step.used, shedding, changed, step.shedding, step.changed)
+		}
+	}
+	// 读取失败时保持当前状态
+	u.fail.Store(true)
+	if shedding, changed := l.Shedding(); !shedding || changed {
+		t.Fatalf("usage error: shedding %v changed %v, want the previous state", shedding, changed)
+	}
+
+	// 两次读取之间不超过 Interval 时不重新读取
+	cached := &core.LoadShedder{High: 0.9, Low: 0.8, Interval: time.Hour, Usage: u.usage}
+	u.fail.Store(false)
+	u.used.Store(95)
+	cached.Shedding()
+	u.used.Store(10)
+	if shedding, _ := cached.Shedding(); !shedding {
+		t.Fatal("usage re-read before Interval elapsed")
+	}
+}
+
+func TestLoadShedRequests(t *testing.T) {
+	logs := captureLog(t, false)
+	u := &fakeUsage{}
+	u.used.Store(10)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.LoadShed = &core.LoadShedder{High: 0.9, Low: 0.8, Interval: time.Nanosecond, Usage: u.usage}
+	})
+	echo := socks5test.EchoTCPServer(t)
+	established := socks5test.Connect(t, ts.Addr, nil, echo)
+
+	u.used.Store(92)
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, echo, core.RepServerFailure)
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdUDP, "0.0.0.0:0", core.RepServerFailure)
+	// 已有的会话不受影响
+	socks5test.EchoTCP(t, established, []byte("still relayed"))
+	if ev, req := ts.Stats.LoadShedEvents.Load(), ts.Stats.LoadShedRequests.Load(); ev != 1 || req != 2 {
+		t.Fatalf("events %d, requests %d; want 1, 2", ev, req)
+	}
+
+	u.used.Store(85)
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, echo, core.RepServerFailure)
+	u.used.Store(70)
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("resumed"))
+
+	u.used.Store(99)
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, echo, core.RepServerFailure)
+	if ev, req := ts.Stats.LoadShedEvents.Load(), ts.Stats.LoadShedRequests.Load(); ev != 2 || req != 4 {
+		t.Fatalf("events %d, requests %d; want 2, 4", ev, req)
+	}
+
+	out := logs.String()
+	if n := strings.Count(out, "file descriptors in use, refusing new requests until usage drops below 80%"); n != 2 {
+		t.Fatalf("logged %d shedding transitions, want 2:\n%s", n, out)
+	}
+	if n := strings.Count(out, "70 of 100 file descriptors in use, accepting new requests again"); n != 1 {
+		t.Fatalf("logged %d recoveries, want 1:\n%s", n, out)
+	}
+}
@@ -0,0 +1,99 @@
+package core_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestDialInvalidLocalAddr(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	udpEcho := socks5test.EchoUDPServer(t)
+	for _, laddr := range []string{"not-an-ip:0", "127.0.0.1:99999", "127.0.0.1"} {
+		if c, err := core.DialTCP("tcp", laddr, echo, time.Second); err == nil || !strings.Contains(err.Error(), "invalid local address") {
+			if c != nil {
+				c.Close()
+			}
+			t.Errorf("DialTCP from %q = %v, want an invalid local address error", laddr, err)
+		}
+		if c, err := core.DialUDP("udp", laddr, udpEcho); err == nil || !strings.Contains(err.Error(), "invalid local address") {
+			if c != nil {
+				c.Close()
+			}
+			t.Errorf("DialUDP from %q = %v, want an invalid local address error", laddr, err)
+		}
+	}
+}
+
+func TestDialLocalAddrInUse(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if c, err := core.DialTCP("tcp", l.Addr().String(), echo, time.Second); !errors.Is(err, syscall.EADDRINUSE) {
+		if c != nil {
+			c.Close()
+		}
+		t.Fatalf("DialTCP from a listening address = %v, want EADDRINUSE", err)
+	}
+	pc := listenUDP(t)
+	if c, err := core.DialUDP("udp", pc.LocalAddr().String(), socks5test.EchoUDPServer(t)); !errors.Is(err, syscall.EADDRINUSE) {
+		if c != nil {
+			c.Close()
+		}
+		t.Fatalf("DialUDP from a bound address = %v, want EADDRINUSE", err)
+	}
+}
+
+// 交换结束后之前的本地地址被占用：新交换改用系统选择的地址，并记录警告
+func TestUDPSourceFallback(t *testing.T) {
+	logs := captureLog(t, false)
+	ts := socks5test.NewServer(t)
+	peer := whoamiUDP(t).String()
+	uc := listenUDP(t)
+
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	udpSend(t, uc, relay, peer, []byte("first"))
+	d := udpRecv(t, uc, 2*time.Second)
+	if d == nil {
+		t.Fatal("no reply")
+	}
+	first := string(d.Data)
+	ctl.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for exchanges(ts.Server) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ua, err := net.ResolveUDPAddr("udp", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	squat, err := net.ListenUDP("udp", ua)
+	if err != nil {
+		t.Fatalf("rebind %s: %v", first, err)
+	}
+	defer squat.Close()
+
+	ctl, relay = socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	udpSend(t, uc, relay, peer, []byte("second"))
+	d = udpRecv(t, uc, 2*time.Second)
+	if d == nil {
+		t.Fatal("no reply after the previous local address was taken")
+	}
+	if string(d.Data) == first {
+		t.Fatalf("second exchange used %s, which is taken", first)
+	}
+	if out := logs.String(); !strings.Contains(out, "bind to previous local address "+first+" failed") {
+		t.Fatalf("no fallback warning in the log:\n%s", out)
+	}
+}
@@ -0,0 +1,84 @@
+package core_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// tagSession 在 TCP 请求中写入 tenant，在 UDP 数据报中累加 datagrams，两者共用控制连接的 Metadata
+func tagSession(next core.Handler) core.Handler {
+	return &core.HandlerFuncs{
+		Next: next,
+		TCP: func(ctx context.Context, s *core.Server, c net.Conn, r *core.Request) error {
+			m := core.MetadataFromContext(ctx)
+			m.Set("tenant", "acme")
+			m.Set("cmd", core.CmdName(r.Cmd))
+			return pass(next).TCPHandleContext(ctx, s, c, r)
+		},
+		UDP: func(ctx context.Context, s *core.Server, addr *net.UDPAddr, d *core.Datagram) error {
+			m := core.MetadataFromContext(ctx)
+			n, _ := m.Get("datagrams")
+			c, _ := n.(int)
+			m.Set("datagrams", c+1)
+			return pass(next).UDPHandleContext(ctx, s, addr, d)
+		},
+	}
+}
+
+func TestMetadataInAccessLog(t *testing.T) {
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Use(tagSession)
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	c := socks5test.Connect(t, ts.Addr, nil, socks5test.EchoTCPServer(t))
+	socks5test.EchoTCP(t, c, []byte("meta"))
+	c.Close()
+	recs := waitAccessLog(t, buf, 1)
+	if m := recs[0].Meta; m["tenant"] != "acme" || m["cmd"] != "CONNECT" {
+		t.Fatalf("CONNECT meta = %v", m)
+	}
+
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	echo := socks5test.EchoUDPServer(t)
+	// 同一个客户端套接字发出的数据报才属于这个关联
+	uc := listenUDP(t)
+	for range 3 {
+		udpSend(t, uc, relay, echo, []byte("counted"))
+		if udpRecv(t, uc, 2*time.Second) == nil {
+			t.Fatal("no echo through the association")
+		}
+	}
+	ctl.Close()
+	recs = waitAccessLog(t, buf, 2)
+	m := recs[1].Meta
+	// JSON 中的数字解码为 float64
+	if m["tenant"] != "acme" || m["cmd"] != "UDP ASSOCIATE" || m["datagrams"] != float64(3) {
+		t.Fatalf("UDP ASSOCIATE meta = %v", m)
+	}
+}
+
+func TestMetadataNil(t *testing.T) {
+	m := core.MetadataFromContext(context.Background())
+	m.Set("k", 1)
+	m.Delete("k")
+	if _, ok := m.Get("k"); ok || len(m.Snapshot()) != 0 {
+		t.Fatal("nil Metadata stored a value")
+	}
+}
+
+func TestMetadataSnapshotIsCopy(t *testing.T) {
+	m := &core.Metadata{}
+	m.Set("a", "1")
+	snap := m.Snapshot()
+	m.Set("b", "2")
+	m.Delete("a")
+	if len(snap) != 1 || snap["a"] != "1" {
+		t.Fatalf("snapshot changed with the store: %v", snap)
+	}
+}
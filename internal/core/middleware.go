@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrDestinationDenied is the error when a DestinationFilter middleware rejects the destination
+var ErrDestinationDenied = errors.New("destination is denied by the filter")
+
+// Middleware 包装一个 Handler，在其前后加入处理逻辑，或不调用它直接返回
+type Middleware func(Handler) Handler
+
+// Chain 将中间件依次套在 h 外层，第一个中间件在最外层，最先处理请求
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use 追加中间件，套在 ListenAndServe 传入的或 Handle 中设置的处理器外层，需在 Serve 之前调用
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// handler 返回套上中间件的 Handle，Handle 为 nil 时使用 DefaultHandle；设置了 Faults 时其中间件在最外层
+func (s *Server) handler() Handler {
+	s.chainOnce.Do(func() {
+		h := s.Handle
+		if h == nil {
+			h = &DefaultHandle{}
+		}
+		mw := s.middleware
+		if s.Faults != nil {
+			mw = append([]Middleware{s.Faults.Middleware()}, mw...)
+		}
+		s.chained = Chain(h, mw...)
+	})
+	return s.chained
+}
+
+// HandlerFuncs 用函数实现 Handler 和 ContextHandler，便于编写中间件，未设置的函数交给 Next 处理
+type HandlerFuncs struct {
+	Next Handler
+	TCP  func(ctx context.Context, s *Server, c net.Conn, r *Request) error
+	UDP  func(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error
+}
+
+func (h *HandlerFuncs) TCPHandle(s *Server, c net.Conn, r *Request) error {
+	return h.TCPHandleContext(s.Context(), s, c, r)
+}
+
+func (h *HandlerFuncs) UDPHandle(s *Server, addr *net.UDPAddr, d *Datagram) error {
+	return h.UDPHandleContext(s.Context(), s, addr, d)
+}
+
+func (h *HandlerFuncs) TCPHandleContext(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+	if h.TCP == nil {
+		return callTCP(ctx, h.Next, s, c, r)
+	}
+	return h.TCP(ctx, s, c, r)
+}
+
+func (h *HandlerFuncs) UDPHandleContext(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+	if h.UDP == nil {
+		return callUDP(ctx, h.Next, s, addr, d)
+	}
+	return h.UDP(ctx, s, addr, d)
+}
+
+// LogRequests 记录每个 TCP 请求的命令、目标和处理耗时，l 为 nil 时使用标准 log
+// CONNECT 的耗时包含整个转发过程
+func LogRequests(l *log.Logger) Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(next Handler) Handler {
+		return &HandlerFuncs{
+			Next: next,
+			TCP: func(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+				start := time.Now()
+				err := callTCP(ctx, next, s, c, r)
+				d := time.Since(start).Round(time.Millisecond)
+				if err != nil {
+					l.Printf("%s %s from %s failed after %s: %v", cmdName(r.Cmd), r.Address(), c.RemoteAddr(), d, err)
+				} else {
+					l.Printf("%s %s from %s done in %s", cmdName(r.Cmd), r.Address(), c.RemoteAddr(), d)
+				}
+				return err
+			},
+		}
+	}
+}
+
+// DestinationFilter 只放行 allow 返回 true 的目标（host:port）：
+// 拒绝的 CONNECT 以 REP 0x02 应答并返回 ErrDestinationDenied，拒绝的 UDP 数据报被丢弃，UDP ASSOCIATE 请求本身不检查
+func DestinationFilter(allow func(addr string) bool) Middleware {
+	return func(next Handler) Handler {
+		return &HandlerFuncs{
+			Next: next,
+			TCP: func(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+				if r.Cmd == CmdConnect && !allow(r.Address()) {
+					var w io.Writer = c
+					if sess := SessionFromConn(c); sess != nil && sess.Transparent {
+						w = io.Discard
+					}
+					if err := r.Reject(w, RepNotAllowed); err != nil {
+						return err
+					}
+					return fmt.Errorf("connect %s: %w", r.Address(), ErrDestinationDenied)
+				}
+				return callTCP(ctx, next, s, c, r)
+			},
+			UDP: func(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+				if !allow(d.Address()) {
+					if Debug {
+						log.Printf("UDP %s from %s: %v", d.Address(), addr, ErrDestinationDenied)
+					}
+					return nil
+				}
+				return callUDP(ctx, next, s, addr, d)
+			},
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// trace 记录中间件进入和退出的顺序
+type trace struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (tr *trace) add(ev string) {
+	tr.mu.Lock()
+	tr.events = append(tr.events, ev)
+	tr.mu.Unlock()
+}
+
+func (tr *trace) get() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return slices.Clone(tr.events)
+}
+
+// pass 把请求连同 ctx 交给 next，next 不必实现 ContextHandler
+func pass(next core.Handler) *core.HandlerFuncs { return &core.HandlerFuncs{Next: next} }
+
+// traced 返回记录 name 进入和退出的中间件
+func (tr *trace) traced(name string) core.Middleware {
+	return func(next core.Handler) core.Handler {
+		return &core.HandlerFuncs{
+			Next: next,
+			TCP: func(ctx context.Context, s *core.Server, c net.Conn, r *core.Request) error {
+				tr.add("tcp > " + name)
+				defer tr.add("tcp < " + name)
+				return pass(next).TCPHandleContext(ctx, s, c, r)
+			},
+			UDP: func(ctx context.Context, s *core.Server, addr *net.UDPAddr, d *core.Datagram) error {
+				tr.add("udp " + name)
+				return pass(next).UDPHandleContext(ctx, s, addr, d)
+			},
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	tr := &trace{}
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.Use(tr.traced("a"), tr.traced("b")); s.Use(tr.traced("c")) })
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("ordered"))
+	c.Close()
+	// CONNECT 在转发结束后才返回，等最外层的中间件退出
+	want := []string{"tcp > a", "tcp > b", "tcp > c", "tcp < c", "tcp < b", "tcp < a"}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.get()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := tr.get(); !slices.Equal(got, want) {
+		t.Fatalf("TCP order:\n got %q\nwant %q", got, want)
+	}
+
+	tr2 := &trace{}
+	ts2 := socks5test.NewServer(t, func(s *core.Server) { s.Use(tr2.traced("a"), tr2.traced("b")) })
+	ctl, relay := socks5test.Associate(t, ts2.Addr, nil)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay, socks5test.EchoUDPServer(t), []byte("ordered datagram"))
+	got := slices.DeleteFunc(tr2.get(), func(ev string) bool { return ev[:3] == "tcp" })
+	if len(got) < 2 || got[0] != "udp a" || got[1] != "udp b" {
+		t.Fatalf("UDP order: %q", got)
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	tr := &trace{}
+	h := core.Chain(&core.HandlerFuncs{
+		UDP: func(context.Context, *core.Server, *net.UDPAddr, *core.Datagram) error {
+			tr.add("udp handler")
+			return nil
+		},
+	}, tr.traced("outer"), tr.traced("inner"))
+	if err := h.UDPHandle(newTestServer(t), nil, &core.Datagram{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.get(), []string{"udp outer", "udp inner", "udp handler"}; !slices.Equal(got, want) {
+		t.Fatalf("Chain order %q, want %q", got, want)
+	}
+}
+
+// errBlocked 短路的中间件返回的错误
+var errBlocked = errors.New("blocked by middleware")
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	tr := &trace{}
+	block := func(next core.Handler) core.Handler {
+		return &core.HandlerFuncs{
+			Next: next,
+			TCP: func(ctx context.Context, s *core.Server, c net.Conn, r *core.Request) error {
+				if r.Cmd == core.CmdConnect {
+					if err := r.Reject(c, core.RepNotAllowed); err != nil {
+						return err
+					}
+					return errBlocked
+				}
+				return pass(next).TCPHandleContext(ctx, s, c, r)
+			},
+		}
+	}
+	var dials atomic.Int32
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Use(tr.traced("outer"), block, tr.traced("inner"))
+		s.Dialer = dialerFunc(func(context.Context, string, string) (net.Conn, error) {
+			dials.Add(1)
+			return nil, errors.New("must not dial")
+		})
+	})
+	c := socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, "192.0.2.1:80", core.RepNotAllowed)
+	// 拒绝应答之后服务端关闭连接
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("read %d bytes after the rejection", n)
+	}
+	if got, want := tr.get(), []string{"tcp > outer", "tcp < outer"}; !slices.Equal(got, want) {
+		t.Fatalf("events %q, want %q: inner middleware must not run", got, want)
+	}
+	if n := dials.Load(); n != 0 {
+		t.Fatalf("dialed %d times after short-circuit", n)
+	}
+}
+
+// dialerFunc 用函数实现 Dialer
+type dialerFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f dialerFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
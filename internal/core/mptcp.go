@@ -0,0 +1,20 @@
+package core
+
+import (
+	"log"
+	"net"
+)
+
+// logMPTCP 记录 TCP 连接是否实际协商了 Multipath TCP，dir 为 from（接受的连接）或 to（出站连接）
+func logMPTCP(dir string, c net.Conn) {
+	tc, ok := underlyingConn(c).(*net.TCPConn)
+	if !ok {
+		return
+	}
+	mp, err := tc.MultipathTCP()
+	if err != nil {
+		log.Printf("MPTCP %s %s: %v", dir, tc.RemoteAddr(), err)
+		return
+	}
+	log.Printf("MPTCP %s %s: %v", dir, tc.RemoteAddr(), mp)
+}
@@ -0,0 +1,180 @@
+package core_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// listenMPTCP 在回环地址上监听，mptcp 为 true 时启用 Multipath TCP，接受的连接原样回显
+func listenMPTCP(t *testing.T, mptcp bool) (string, <-chan *net.TCPConn) {
+	t.Helper()
+	var lc net.ListenConfig
+	lc.SetMultipathTCP(mptcp)
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepted := make(chan *net.TCPConn, 8)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c.(*net.TCPConn)
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 512)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					c.Write(buf[:n])
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+// multipath 返回连接是否协商了 MPTCP，查询失败则测试失败
+func multipath(t *testing.T, c net.Conn) bool {
+	t.Helper()
+	mp, err := c.(*net.TCPConn).MultipathTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mp
+}
+
+// kernelMPTCP 用标准库在两端都启用 MPTCP 建立一次连接，返回内核是否支持，作为下面各项的期望
+func kernelMPTCP(t *testing.T) bool {
+	t.Helper()
+	addr, accepted := listenMPTCP(t, true)
+	var d net.Dialer
+	d.SetMultipathTCP(true)
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-accepted
+	mp := multipath(t, c)
+	t.Logf("kernel MPTCP support: %v", mp)
+	return mp
+}
+
+func TestMPTCPDirectDialer(t *testing.T) {
+	want := kernelMPTCP(t)
+	for _, tc := range []struct {
+		name          string
+		dial, listen  bool
+		wantMultipath bool
+	}{
+		{"both", true, true, want},
+		{"plain listener", true, false, false},
+		{"disabled", false, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, accepted := listenMPTCP(t, tc.listen)
+			d := &core.DirectDialer{Timeout: time.Second, MultipathTCP: tc.dial}
+			c, err := d.DialContext(context.Background(), "tcp", addr)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer c.Close()
+			if got := multipath(t, c); got != tc.wantMultipath {
+				t.Fatalf("outbound MultipathTCP() = %v, want %v", got, tc.wantMultipath)
+			}
+			if got := multipath(t, <-accepted); got != tc.wantMultipath {
+				t.Fatalf("accepted MultipathTCP() = %v, want %v", got, tc.wantMultipath)
+			}
+			// 退回普通 TCP 时连接照常可用
+			socks5test.EchoTCP(t, c, []byte("mptcp"))
+		})
+	}
+}
+
+// mptcpLogLine 返回 buf 中 dir 方向的 MPTCP 日志的结果部分
+func mptcpLogLine(t *testing.T, buf *syncBuffer, dir string) string {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		for line := range strings.Lines(buf.String()) {
+			if _, v, ok := strings.Cut(line, "MPTCP "+dir+" "); ok {
+				return strings.TrimSpace(v[strings.LastIndex(v, ": ")+2:])
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no MPTCP %s line in log:\n%s", dir, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMPTCPServer(t *testing.T) {
+	want := kernelMPTCP(t)
+	buf := captureLog(t, true)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.ListenMPTCP = true
+		s.DialMPTCP = true
+	})
+	dst, accepted := listenMPTCP(t, true)
+
+	// 客户端也启用 MPTCP，经代理 CONNECT 到同样启用 MPTCP 的目标
+	var d net.Dialer
+	d.SetMultipathTCP(true)
+	c, err := d.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := multipath(t, c); got != want {
+		t.Fatalf("client MultipathTCP() = %v, want %v", got, want)
+	}
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	core.NewNegotiationRequest([]byte{core.MethodNone}).WriteTo(c)
+	if _, err := core.NewNegotiationReplyFrom(c); err != nil {
+		t.Fatal(err)
+	}
+	atyp, addr, port, _ := core.ParseAddress(dst)
+	core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(c)
+	if rp, err := core.NewReplyFrom(c); err != nil || rp.Rep != core.RepSuccess {
+		t.Fatalf("CONNECT: %+v %v", rp, err)
+	}
+	c.Write([]byte("hello\n"))
+	if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("echo %q: %v", line, err)
+	}
+	if got := multipath(t, <-accepted); got != want {
+		t.Fatalf("outbound MultipathTCP() = %v, want %v", got, want)
+	}
+	for _, dir := range []string{"from", "to"} {
+		if got := mptcpLogLine(t, buf, dir); got != strconv.FormatBool(want) {
+			t.Fatalf("MPTCP %s logged %q, want %v", dir, got, want)
+		}
+	}
+}
+
+// 未启用时不记录 MPTCP，普通客户端照常转发
+func TestMPTCPDisabledNoLog(t *testing.T) {
+	buf := captureLog(t, true)
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("plain"))
+	c.Close()
+	if strings.Contains(buf.String(), "MPTCP") {
+		t.Fatalf("MPTCP logged while disabled:\n%s", buf)
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// minAttemptTimeout 多地址拨号时每次尝试的最短超时，总超时不足时以剩余时间为准
+const minAttemptTimeout = 2 * time.Second
+
+// attemptTimeout 没有总截止时间时除最后一个地址外每次尝试的超时，黑洞地址不会阻塞到内核的 SYN 超时
+const attemptTimeout = 5 * time.Second
+
+// interleaveFamilies 从第一个地址的地址族开始交替排列 IPv4 和 IPv6 地址，同族内保持原有顺序
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var first, other []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (ips[0].IP.To4() != nil) {
+			first = append(first, ip)
+		} else {
+			other = append(other, ip)
+		}
+	}
+	if len(other) == 0 {
+		return ips
+	}
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(other); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(other) {
+			out = append(out, other[i])
+		}
+	}
+	return out
+}
+
+// dialAddrs 依次连接解析得到的地址，第一个成功的连接即返回；deadline 非零时为总截止时间，
+// 每次尝试的超时为剩余时间在剩余地址间的平分（不少于 minAttemptTimeout），一个不可达的地址不会耗尽整个拨号超时；
+// deadline 为零时除最后一个地址外每次尝试以 attemptTimeout 为限。
+// 尝试的次数记入 ctx 携带的 DialInfo.Attempts，全部失败时返回 dialErrorRank 最高的错误
+func (d *DirectDialer) dialAddrs(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network string, localIP net.IP, ips []net.IPAddr, port string, deadline time.Time) (net.Conn, error) {
+	info := DialInfoFromContext(ctx)
+	var candidates []net.IPAddr
+	var firstErr error
+	for _, ip := range ips {
+		if d.AllowedEgress != nil {
+			if err := d.AllowedEgress.check(network, localIP, ip.IP); err != nil {
+				firstErr = cmp.Or(firstErr, err)
+				continue
+			}
+		}
+		candidates = append(candidates, ip)
+	}
+	if len(candidates) == 0 {
+		return nil, firstErr
+	}
+	var best error
+	for i, ip := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, cmp.Or(best, err)
+		}
+		if info != nil {
+			info.Attempts = i + 1
+		}
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, cmp.Or(best, context.DeadlineExceeded)
+			}
+			timeout := remaining / time.Duration(len(candidates)-i)
+			timeout = min(max(timeout, minAttemptTimeout), remaining)
+			actx, cancel = context.WithTimeout(ctx, timeout)
+		} else if i < len(candidates)-1 {
+			actx, cancel = context.WithTimeout(ctx, attemptTimeout)
+		}
+		c, err := dial(actx, network, net.JoinHostPort(ip.String(), port))
+		cancel()
+		if err == nil {
+			if d.NAT64 != nil {
+				c = &nat64Conn{Conn: c, nat64: d.NAT64}
+			}
+			return c, nil
+		}
+		if best == nil || dialErrorRank(err) > dialErrorRank(best) {
+			best = err
+		}
+	}
+	return nil, best
+}
+
+// dialErrorRank 多地址拨号全部失败时按它选择返回的错误：拒绝连接说明主机可达，最能说明问题，
+// 其次是主机不可达等其他错误、超时，网络不可达和被出站策略跳过的地址最不具体
+func dialErrorRank(err error) int {
+	if errors.Is(err, ErrEgressForbidden) {
+		return 0
+	}
+	switch RepForError(err) {
+	case RepConnectionRefused:
+		return 4
+	case RepTTLExpired:
+		return 2
+	case RepNetworkUnreachable:
+		return 1
+	}
+	return 3
+}
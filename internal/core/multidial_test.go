@@ -0,0 +1,277 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	out := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		out[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return out
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	for _, tc := range []struct {
+		in, want []string
+	}{
+		{[]string{"192.0.2.1"}, []string{"192.0.2.1"}},
+		{[]string{"192.0.2.1", "192.0.2.2"}, []string{"192.0.2.1", "192.0.2.2"}},
+		{[]string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}, []string{"192.0.2.1", "2001:db8::1", "192.0.2.2"}},
+		{[]string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"}, []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}},
+		{[]string{"192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8::3"}, []string{"192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+	} {
+		var got []string
+		for _, ip := range interleaveFamilies(ipAddrs(tc.in...)) {
+			got = append(got, ip.IP.String())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("interleaveFamilies(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// 各类拨号错误，形式与 net.Dialer 返回的一致
+var (
+	errRefused     = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	errNetUnreach  = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}
+	errHostUnreach = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}
+	errTimeout     = &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}
+)
+
+func TestDialErrorRank(t *testing.T) {
+	// 从最不具体到最具体
+	order := []error{fmt.Errorf("%w: 192.0.2.1", ErrEgressForbidden), errNetUnreach, errTimeout, errHostUnreach, errRefused}
+	for i := 1; i < len(order); i++ {
+		if dialErrorRank(order[i-1]) >= dialErrorRank(order[i]) {
+			t.Errorf("dialErrorRank(%v) = %d, not below dialErrorRank(%v) = %d", order[i-1], dialErrorRank(order[i-1]), order[i], dialErrorRank(order[i]))
+		}
+	}
+}
+
+// fakeDial 按地址返回预设的错误，没有预设错误的地址连接成功；记录每次尝试的地址和超时
+type fakeDial struct {
+	errs map[string]error
+
+	mu       sync.Mutex
+	addrs    []string
+	timeouts []time.Duration
+}
+
+func (f *fakeDial) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	f.mu.Lock()
+	f.addrs = append(f.addrs, addr)
+	var timeout time.Duration
+	if dl, ok := ctx.Deadline(); ok {
+		timeout = time.Until(dl)
+	}
+	f.timeouts = append(f.timeouts, timeout)
+	f.mu.Unlock()
+	if err := f.errs[addr]; err != nil {
+		return nil, err
+	}
+	c, s := net.Pipe()
+	s.Close()
+	return c, nil
+}
+
+func TestDialAddrsDeadThenLive(t *testing.T) {
+	f := &fakeDial{errs: map[string]error{"192.0.2.1:80": errTimeout}}
+	info := &DialInfo{}
+	ctx := WithDialInfo(context.Background(), info)
+	d := &DirectDialer{}
+	c, err := d.dialAddrs(ctx, f.dial, "tcp", nil, ipAddrs("192.0.2.1", "192.0.2.2"), "80", time.Now().Add(10*time.Second))
+	if err != nil {
+		t.Fatalf("dialAddrs: %v", err)
+	}
+	c.Close()
+	if !slices.Equal(f.addrs, []string{"192.0.2.1:80", "192.0.2.2:80"}) || info.Attempts != 2 {
+		t.Fatalf("tried %q, Attempts %d", f.addrs, info.Attempts)
+	}
+	// 第一次尝试只用到总超时的一半，剩余的时间都留给最后一个地址
+	if got := f.timeouts[0]; got < 4*time.Second || got > 5*time.Second {
+		t.Fatalf("first attempt timeout %v, want about 5s", got)
+	}
+	if got := f.timeouts[1]; got < 9*time.Second || got > 10*time.Second {
+		t.Fatalf("last attempt timeout %v, want the remaining 10s", got)
+	}
+
+	// 第一个地址可用时不再尝试其他地址
+	f = &fakeDial{}
+	info = &DialInfo{}
+	c, err = d.dialAddrs(WithDialInfo(context.Background(), info), f.dial, "tcp", nil, ipAddrs("192.0.2.1", "192.0.2.2"), "80", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if len(f.addrs) != 1 || info.Attempts != 1 {
+		t.Fatalf("tried %q with timeouts %v, Attempts %d", f.addrs, f.timeouts, info.Attempts)
+	}
+}
+
+// 没有总截止时间（-dial-timeout 0 且没有 -request-timeout）时，除最后一个地址外每次尝试以 attemptTimeout 为限
+func TestDialAddrsNoDeadline(t *testing.T) {
+	f := &fakeDial{errs: map[string]error{"192.0.2.1:80": errTimeout, "192.0.2.2:80": errTimeout}}
+	c, err := (&DirectDialer{}).dialAddrs(context.Background(), f.dial, "tcp", nil, ipAddrs("192.0.2.1", "192.0.2.2", "192.0.2.3"), "80", time.Time{})
+	if err != nil {
+		t.Fatalf("dialAddrs: %v", err)
+	}
+	c.Close()
+	if len(f.timeouts) != 3 {
+		t.Fatalf("tried %q", f.addrs)
+	}
+	for _, timeout := range f.timeouts[:2] {
+		if timeout < attemptTimeout-100*time.Millisecond || timeout > attemptTimeout {
+			t.Fatalf("attempt timeouts %v, want %v before the last address", f.timeouts, attemptTimeout)
+		}
+	}
+	if f.timeouts[2] != 0 {
+		t.Fatalf("last attempt timeout %v, want none", f.timeouts[2])
+	}
+	// 只有一个地址时同样不限制
+	f = &fakeDial{}
+	c, err = (&DirectDialer{}).dialAddrs(context.Background(), f.dial, "tcp", nil, ipAddrs("192.0.2.1"), "80", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if f.timeouts[0] != 0 {
+		t.Fatalf("single attempt timeout %v, want none", f.timeouts[0])
+	}
+}
+
+// 黑洞的第一个地址在 attemptTimeout 后放弃，经第二个地址连接成功
+func TestDialAddrsNoDeadlineBlackhole(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for attemptTimeout")
+	}
+	f := &fakeDial{}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "192.0.2.1:80" {
+			<-ctx.Done()
+			return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+		}
+		return f.dial(ctx, network, addr)
+	}
+	start := time.Now()
+	c, err := (&DirectDialer{}).dialAddrs(context.Background(), dial, "tcp", nil, ipAddrs("192.0.2.1", "192.0.2.2"), "80", time.Time{})
+	if err != nil {
+		t.Fatalf("dialAddrs: %v", err)
+	}
+	c.Close()
+	if d := time.Since(start); d < attemptTimeout || d > attemptTimeout+2*time.Second {
+		t.Fatalf("connected after %v, want about %v", d, attemptTimeout)
+	}
+}
+
+func TestDialAddrsMinAttemptTimeout(t *testing.T) {
+	f := &fakeDial{errs: map[string]error{"192.0.2.1:80": errTimeout, "192.0.2.2:80": errTimeout, "192.0.2.3:80": errTimeout}}
+	d := &DirectDialer{}
+	d.dialAddrs(context.Background(), f.dial, "tcp", nil, ipAddrs("192.0.2.1", "192.0.2.2", "192.0.2.3"), "80", time.Now().Add(3*time.Second))
+	// 平分的 1s 小于 minAttemptTimeout，按 2s；之后以剩余时间为准
+	if len(f.timeouts) != 3 || f.timeouts[0] < minAttemptTimeout-100*time.Millisecond || f.timeouts[0] > minAttemptTimeout {
+		t.Fatalf("attempt timeouts %v", f.timeouts)
+	}
+	for _, timeout := range f.timeouts[1:] {
+		if timeout > 3*time.Second {
+			t.Fatalf("attempt timeouts %v exceed the overall deadline", f.timeouts)
+		}
+	}
+}
+
+func TestDialAddrsReportedError(t *testing.T) {
+	ips := ipAddrs("192.0.2.1", "192.0.2.2", "192.0.2.3")
+	for _, tc := range []struct {
+		name string
+		errs []error
+		want error
+		rep  byte
+	}{
+		{"refused wins over timeout", []error{errTimeout, errRefused, errNetUnreach}, errRefused, RepConnectionRefused},
+		{"host unreachable wins over timeout", []error{errNetUnreach, errTimeout, errHostUnreach}, errHostUnreach, RepHostUnreachable},
+		{"timeout wins over network unreachable", []error{errNetUnreach, errTimeout, errNetUnreach}, errTimeout, RepTTLExpired},
+		{"first of equal rank", []error{errNetUnreach, errNetUnreach, errNetUnreach}, errNetUnreach, RepNetworkUnreachable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeDial{errs: map[string]error{}}
+			for i, err := range tc.errs {
+				f.errs[net.JoinHostPort(ips[i].IP.String(), "80")] = err
+			}
+			info := &DialInfo{}
+			_, err := (&DirectDialer{}).dialAddrs(WithDialInfo(context.Background(), info), f.dial, "tcp", nil, ips, "80", time.Now().Add(10*time.Second))
+			if err != tc.want || RepForError(err) != tc.rep {
+				t.Fatalf("dialAddrs = %v (REP %#x), want %v (REP %#x)", err, RepForError(err), tc.want, tc.rep)
+			}
+			if info.Attempts != len(ips) {
+				t.Fatalf("Attempts = %d, want %d", info.Attempts, len(ips))
+			}
+		})
+	}
+}
+
+// fixedResolver 把任何域名解析为同样的地址
+type fixedResolver []net.IPAddr
+
+func (r fixedResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	return r, nil
+}
+
+// 域名解析出一个不可达的地址和一个可用的地址，拨号经第二个地址成功
+func TestDirectDialerDeadAndLiveAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// 127.0.0.2 上没有监听，连接被拒绝
+	for _, tc := range []struct {
+		name     string
+		ips      []net.IPAddr
+		attempts int
+		rep      byte
+	}{
+		{"dead first", ipAddrs("127.0.0.2", "127.0.0.1"), 2, RepSuccess},
+		{"live first", ipAddrs("127.0.0.1", "127.0.0.2"), 1, RepSuccess},
+		{"all dead", ipAddrs("127.0.0.2", "127.0.0.3"), 2, RepConnectionRefused},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &DirectDialer{Timeout: 5 * time.Second, Resolver: fixedResolver(tc.ips)}
+			info := &DialInfo{}
+			c, err := d.DialContext(WithDialInfo(context.Background(), info), "tcp", net.JoinHostPort("multi.example", port))
+			if tc.rep == RepSuccess {
+				if err != nil {
+					t.Fatalf("dial: %v", err)
+				}
+				if got := c.RemoteAddr().String(); got != l.Addr().String() {
+					t.Fatalf("connected to %s, want %s", got, l.Addr())
+				}
+				c.Close()
+			} else if RepForError(err) != tc.rep {
+				t.Fatalf("dial = %v (REP %#x), want REP %#x", err, RepForError(err), tc.rep)
+			}
+			if info.Attempts != tc.attempts {
+				t.Fatalf("Attempts = %d, want %d", info.Attempts, tc.attempts)
+			}
+		})
+	}
+}
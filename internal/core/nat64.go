@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// WellKnownNAT64Prefix RFC 6052 的知名前缀 64:ff9b::/96
+var WellKnownNAT64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// NAT64 按 RFC 6052 在 IPv4 地址和 NAT64 前缀下的 IPv6 地址之间转换
+type NAT64 struct {
+	Prefix netip.Prefix // 长度为 32、40、48、56、64 或 96
+}
+
+// NewNAT64 校验前缀长度后创建 NAT64
+func NewNAT64(prefix netip.Prefix) (*NAT64, error) {
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return nil, fmt.Errorf("NAT64 prefix %s is not an IPv6 prefix", prefix)
+	}
+	switch prefix.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("NAT64 prefix %s must be /32, /40, /48, /56, /64 or /96", prefix)
+	}
+	return &NAT64{Prefix: prefix.Masked()}, nil
+}
+
+// Synthesize 将 IPv4 地址嵌入前缀，得到 NAT64 的 IPv6 地址
+func (n *NAT64) Synthesize(v4 netip.Addr) netip.Addr {
+	b := n.Prefix.Addr().As16()
+	v := v4.As4()
+	// RFC 6052 2.2：第 64-71 位（字节 8）保留为 0，IPv4 地址跳过该字节
+	pos := n.Prefix.Bits() / 8
+	for _, x := range v {
+		if pos == 8 {
+			pos++
+		}
+		b[pos] = x
+		pos++
+	}
+	return netip.AddrFrom16(b)
+}
+
+// Extract 取出嵌入在前缀中的 IPv4 地址，不在前缀内时返回 false
+func (n *NAT64) Extract(ip netip.Addr) (netip.Addr, bool) {
+	ip = ip.Unmap()
+	if !ip.Is6() || !n.Prefix.Contains(ip) {
+		return netip.Addr{}, false
+	}
+	b := ip.As16()
+	var v [4]byte
+	pos := n.Prefix.Bits() / 8
+	for i := range v {
+		if pos == 8 {
+			pos++
+		}
+		v[i] = b[pos]
+		pos++
+	}
+	return netip.AddrFrom4(v), true
+}
+
+// mapIPs 将 IPv4 地址替换为合成的 IPv6 地址，IPv6 地址保持不变
+func (n *NAT64) mapIPs(ips []net.IPAddr) []net.IPAddr {
+	out := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		if a, ok := netip.AddrFromSlice(ip.IP); ok && a.Unmap().Is4() {
+			ip = net.IPAddr{IP: net.IP(n.Synthesize(a.Unmap()).AsSlice())}
+		}
+		out = append(out, ip)
+	}
+	return out
+}
+
+// unmapAddr 把 NAT64 前缀内的地址还原为 IPv4 地址，其他地址原样返回
+func (n *NAT64) unmapAddr(a net.Addr) net.Addr {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if v4, ok := n.Extract(a.AddrPort().Addr()); ok {
+			return net.TCPAddrFromAddrPort(netip.AddrPortFrom(v4, uint16(a.Port)))
+		}
+	case *net.UDPAddr:
+		if v4, ok := n.Extract(a.AddrPort().Addr()); ok {
+			return net.UDPAddrFromAddrPort(netip.AddrPortFrom(v4, uint16(a.Port)))
+		}
+	}
+	return a
+}
+
+// nat64Conn 经 NAT64 建立的连接，RemoteAddr 报告还原后的 IPv4 地址
+type nat64Conn struct {
+	net.Conn
+	nat64 *NAT64
+}
+
+func (c *nat64Conn) RemoteAddr() net.Addr {
+	return c.nat64.unmapAddr(c.Conn.RemoteAddr())
+}
+
+// DiscoverNAT64Prefix 按 RFC 7050 解析 ipv4only.arpa 的 AAAA 记录，找出 DNS64 使用的前缀
+func DiscoverNAT64Prefix(ctx context.Context, r Resolver) (netip.Prefix, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ips, err := r.LookupIPAddr(ctx, "ipv4only.arpa")
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	wka := []netip.Addr{netip.MustParseAddr("192.0.0.170"), netip.MustParseAddr("192.0.0.171")}
+	for _, ip := range ips {
+		a, ok := netip.AddrFromSlice(ip.IP)
+		if !ok || !a.Is6() || a.Is4In6() {
+			continue
+		}
+		for _, bits := range []int{96, 64, 56, 48, 40, 32} {
+			p, _ := a.Prefix(bits)
+			n := &NAT64{Prefix: p}
+			if v4, ok := n.Extract(a); ok && (v4 == wka[0] || v4 == wka[1]) {
+				return p, nil
+			}
+		}
+	}
+	return netip.Prefix{}, errors.New("no NAT64 prefix found in the AAAA records of ipv4only.arpa")
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// negativeCacheMax 负缓存的条目上限，满时先清理过期的条目，仍然满时不再缓存新的失败
+const negativeCacheMax = 10000
+
+// NegativeCache 缓存目标域名解析的失败结果：NXDOMAIN 和超时分别缓存 NXDomainTTL、TimeoutTTL，
+// 期间同一域名的请求立即以缓存的错误失败，过期后重新解析；同一域名并发的解析合并为一次，
+// 一个解析不了的域名的突发请求不会各自等待解析器。成功的结果不缓存，其他错误（如 SERVFAIL）也不缓存
+type NegativeCache struct {
+	NXDomainTTL time.Duration // 0 表示不缓存 NXDOMAIN
+	TimeoutTTL  time.Duration // 0 表示不缓存超时，通常比 NXDomainTTL 短
+
+	mu       sync.Mutex
+	entries  map[resolveKey]negativeEntry
+	inflight map[resolveKey]*lookupCall
+
+	lookups     atomic.Int64
+	coalesced   atomic.Int64
+	nxHits      atomic.Int64
+	timeoutHits atomic.Int64
+}
+
+// NegativeCacheStats 负缓存的计数
+type NegativeCacheStats struct {
+	Lookups      int64 `json:"lookups"`       // 交给解析器的查询
+	Coalesced    int64 `json:"coalesced"`     // 合并到进行中查询的请求
+	NXDomainHits int64 `json:"nxdomain_hits"` // 命中缓存的 NXDOMAIN 而立即失败的请求
+	TimeoutHits  int64 `json:"timeout_hits"`  // 命中缓存的超时而立即失败的请求
+	Entries      int   `json:"entries"`
+}
+
+// resolveKey 缓存的键，不同路由可以使用不同的解析器
+type resolveKey struct {
+	r    Resolver
+	host string
+}
+
+type negativeEntry struct {
+	err     error
+	timeout bool
+	expires time.Time
+}
+
+// lookupCall 进行中的一次解析，done 关闭后 ips、err 可读
+type lookupCall struct {
+	done chan struct{}
+	ips  []net.IPAddr
+	err  error
+}
+
+// NewNegativeCache 创建负缓存
+func NewNegativeCache(nxTTL, timeoutTTL time.Duration) *NegativeCache {
+	return &NegativeCache{
+		NXDomainTTL: nxTTL,
+		TimeoutTTL:  timeoutTTL,
+		entries:     make(map[resolveKey]negativeEntry),
+		inflight:    make(map[resolveKey]*lookupCall),
+	}
+}
+
+// Resolver 返回经 c 缓存失败结果、每次解析最多 timeout（0 表示不限制）的解析器；
+// c 为 nil 时只限制超时，timeout 也为 0 时直接返回 next
+func (c *NegativeCache) Resolver(next Resolver, timeout time.Duration) Resolver {
+	if c == nil && timeout <= 0 {
+		return next
+	}
+	return &cachingResolver{cache: c, next: next, timeout: timeout}
+}
+
+// Stats 返回负缓存的计数
+func (c *NegativeCache) Stats() NegativeCacheStats {
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+	return NegativeCacheStats{
+		Lookups:      c.lookups.Load(),
+		Coalesced:    c.coalesced.Load(),
+		NXDomainHits: c.nxHits.Load(),
+		TimeoutHits:  c.timeoutHits.Load(),
+		Entries:      n,
+	}
+}
+
+// cachingResolver NegativeCache.Resolver 返回的解析器
+type cachingResolver struct {
+	cache   *NegativeCache
+	next    Resolver
+	timeout time.Duration
+}
+
+func (r *cachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c := r.cache
+	if c == nil || net.ParseIP(host) != nil {
+		return r.lookup(ctx, host)
+	}
+	key := resolveKey{r.next, strings.ToLower(strings.TrimSuffix(host, "."))}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if time.Now().Before(e.expires) {
+			c.mu.Unlock()
+			if e.timeout {
+				c.timeoutHits.Add(1)
+			} else {
+				c.nxHits.Add(1)
+			}
+			return nil, e.err
+		}
+		delete(c.entries, key)
+	}
+	call, ok := c.inflight[key]
+	if ok {
+		c.coalesced.Add(1)
+	} else {
+		call = &lookupCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		c.lookups.Add(1)
+		// 合并的查询不随发起它的请求取消，由 timeout 和解析器自身的超时结束
+		go func() {
+			call.ips, call.err = r.lookup(context.WithoutCancel(ctx), host)
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.store(key, call.err)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+		return slices.Clone(call.ips), call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup 以 timeout 为限解析，超过时返回 IsTimeout 的 *net.DNSError
+func (r *cachingResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if r.timeout <= 0 {
+		return r.next.LookupIPAddr(ctx, host)
+	}
+	tctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	ips, err := r.next.LookupIPAddr(tctx, host)
+	if err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		return nil, &net.DNSError{Err: fmt.Sprintf("resolution timed out after %s", r.timeout), Name: host, IsTimeout: true}
+	}
+	return ips, err
+}
+
+// store 按错误的种类缓存失败结果，调用方持有 c.mu
+func (c *NegativeCache) store(key resolveKey, err error) {
+	if err == nil {
+		return
+	}
+	var de *net.DNSError
+	var e negativeEntry
+	switch {
+	case errors.As(err, &de) && de.IsNotFound:
+		e = negativeEntry{err: err, expires: time.Now().Add(c.NXDomainTTL)}
+		if c.NXDomainTTL <= 0 {
+			return
+		}
+	case isTimeout(err):
+		e = negativeEntry{err: err, timeout: true, expires: time.Now().Add(c.TimeoutTTL)}
+		if c.TimeoutTTL <= 0 {
+			return
+		}
+	default:
+		return
+	}
+	if len(c.entries) >= negativeCacheMax {
+		now := time.Now()
+		maps.DeleteFunc(c.entries, func(_ resolveKey, e negativeEntry) bool { return !now.Before(e.expires) })
+		if len(c.entries) >= negativeCacheMax {
+			return
+		}
+	}
+	c.entries[key] = e
+}
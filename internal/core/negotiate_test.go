@@ -0,0 +1,83 @@
+package core_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"socks5/internal/core"
+)
+
+// rw 以 in 为输入、out 为输出的 io.ReadWriter，不是 net.Conn
+type rw struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (x *rw) Read(b []byte) (int, error)  { return x.in.Read(b) }
+func (x *rw) Write(b []byte) (int, error) { return x.out.Write(b) }
+
+func userPassServer(t *testing.T) *core.Server {
+	t.Helper()
+	s, err := core.NewClassicServer("127.0.0.1:0", "127.0.0.1", "alice", "secret", 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// userPassHello 方法协商（只提供用户名密码）加子协商的客户端字节
+func userPassHello(user, pass string) []byte {
+	b := []byte{core.Ver, 1, core.MethodUsernamePassword, core.UserPassVer, byte(len(user))}
+	b = append(b, user...)
+	b = append(b, byte(len(pass)))
+	return append(b, pass...)
+}
+
+func TestNegotiateReadWriter(t *testing.T) {
+	s := userPassServer(t)
+	x := &rw{in: bytes.NewReader(userPassHello("alice", "secret"))}
+	if err := s.Negotiate(x); err != nil {
+		t.Fatalf("Negotiate: %v", err)
+	}
+	want := []byte{core.Ver, core.MethodUsernamePassword, core.UserPassVer, core.UserPassStatusSuccess}
+	if !bytes.Equal(x.out.Bytes(), want) {
+		t.Fatalf("replies % x, want % x", x.out.Bytes(), want)
+	}
+
+	x = &rw{in: bytes.NewReader(userPassHello("alice", "wrong"))}
+	var ae *core.AuthError
+	if err := s.Negotiate(x); !errors.As(err, &ae) || ae.User != "alice" || !errors.Is(err, core.ErrUserPassAuth) {
+		t.Fatalf("Negotiate with a wrong password = %v, want *AuthError for alice", err)
+	}
+}
+
+func TestNegotiateNetConn(t *testing.T) {
+	s := userPassServer(t)
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+	// net.Pipe 没有缓冲，写入和读取应答须在不同的协程中
+	go peer.Write(userPassHello("alice", "secret"))
+	go io.Copy(io.Discard, peer)
+	if err := s.Negotiate(c); err != nil {
+		t.Fatalf("Negotiate on a net.Conn: %v", err)
+	}
+
+	// 会话上协商时结果记录在会话中
+	c2, peer2 := net.Pipe()
+	defer peer2.Close()
+	// net.Pipe 没有缓冲，写入和读取应答须在不同的协程中
+	go peer2.Write(userPassHello("alice", "secret"))
+	go io.Copy(io.Discard, peer2)
+	sess := core.NewSession(c2)
+	defer sess.Close()
+	if err := s.Negotiate(sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.User != "alice" || sess.Method != core.MethodUsernamePassword {
+		t.Fatalf("session user %q method 0x%02x", sess.User, sess.Method)
+	}
+}
@@ -0,0 +1,67 @@
+package core_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// 客户端把协商、认证、请求和首批数据一次发出：失败应答之后服务端先读掉剩余数据再关闭，
+// 客户端晚些读取仍能收到应答，而不是被 RST 丢弃
+func TestPipelinedFailureReply(t *testing.T) {
+	closed := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		return l.Addr().String()
+	}()
+	payload := bytes.Repeat([]byte("GET / HTTP/1.1\r\n"), 512)
+	for _, tc := range []struct {
+		name   string
+		method byte
+		pass   string
+		want   []byte // 客户端依次收到的字节
+	}{
+		{"bad password", core.MethodUsernamePassword, "wrong", []byte{core.Ver, core.MethodUsernamePassword, core.UserPassVer, core.UserPassStatusFailure}},
+		{"no acceptable method", core.MethodNone, "", []byte{core.Ver, core.MethodUnsupportAll}},
+		{"connect refused", core.MethodUsernamePassword, "p", []byte{core.Ver, core.MethodUsernamePassword, core.UserPassVer, core.UserPassStatusSuccess, core.Ver, core.RepConnectionRefused}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := socks5test.NewServer(t, socks5test.WithAuth("u", "p"))
+			var msg bytes.Buffer
+			core.NewNegotiationRequest([]byte{tc.method}).WriteTo(&msg)
+			if tc.method == core.MethodUsernamePassword {
+				core.NewUserPassNegotiationRequest([]byte("u"), []byte(tc.pass)).WriteTo(&msg)
+			}
+			atyp, addr, port, _ := core.ParseAddress(closed)
+			core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(&msg)
+			msg.Write(payload)
+
+			c, err := net.Dial("tcp", ts.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, err := c.Write(msg.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			// 等服务端处理完并关闭，再开始读取
+			time.Sleep(200 * time.Millisecond)
+			c.SetReadDeadline(time.Now().Add(3 * time.Second))
+			got, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatalf("read after the failure reply: %v (got %x)", err, got)
+			}
+			if !bytes.HasPrefix(got, tc.want) {
+				t.Fatalf("received %x, want prefix %x", got, tc.want)
+			}
+		})
+	}
+}
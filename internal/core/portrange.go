@@ -0,0 +1,185 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrLocalPortsExhausted is the error when no port in the local port range could be bound for an outbound socket
+var ErrLocalPortsExhausted = errors.New("local port range is exhausted")
+
+// DefaultPortRangeAttempts 每次拨号最多尝试绑定的端口数
+const DefaultPortRangeAttempts = 32
+
+// PortRange 出站套接字的本地端口范围：每个连接从范围内随机选一个本进程未占用的端口绑定，
+// 端口已被其他程序占用（EADDRINUSE，或与 TIME_WAIT 中的连接四元组相同而 EADDRNOTAVAIL）时换一个，
+// 最多尝试 Attempts 次（0 为 DefaultPortRangeAttempts）；TCP 套接字设置 SO_REUSEADDR，刚关闭的端口可以立即再用；
+// 都失败时拨号返回 ErrLocalPortsExhausted。TCP 和 UDP 的端口分别计数，可并发使用
+type PortRange struct {
+	Min, Max uint16
+	Attempts int
+
+	mu        sync.Mutex
+	used      map[portKey]struct{}
+	exhausted atomic.Int64
+}
+
+type portKey struct {
+	udp  bool
+	port uint16
+}
+
+// PortRangeStats 端口范围的使用情况
+type PortRangeStats struct {
+	Min       uint16 `json:"min"`
+	Max       uint16 `json:"max"`
+	TCPInUse  int    `json:"tcp_in_use"`
+	UDPInUse  int    `json:"udp_in_use"`
+	Exhausted int64  `json:"exhausted"` // 因范围耗尽而失败的拨号
+}
+
+// ParsePortRange 解析 N-M 形式的端口范围
+func ParsePortRange(s string) (*PortRange, error) {
+	lo, hi, err := parsePortRange(s)
+	if err != nil {
+		return nil, err
+	}
+	return &PortRange{Min: lo, Max: hi}, nil
+}
+
+// Size 返回范围内的端口数
+func (p *PortRange) Size() int {
+	return int(p.Max) - int(p.Min) + 1
+}
+
+// Contains 报告 port 是否在范围内
+func (p *PortRange) Contains(port int) bool {
+	return port >= int(p.Min) && port <= int(p.Max)
+}
+
+// Stats 返回当前占用的端口数和累计的耗尽次数
+func (p *PortRange) Stats() PortRangeStats {
+	st := PortRangeStats{Min: p.Min, Max: p.Max, Exhausted: p.exhausted.Load()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k := range p.used {
+		if k.udp {
+			st.UDPInUse++
+		} else {
+			st.TCPInUse++
+		}
+	}
+	return st
+}
+
+func (p *PortRange) reserve(k portKey) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.used[k]; ok {
+		return false
+	}
+	if p.used == nil {
+		p.used = map[portKey]struct{}{}
+	}
+	p.used[k] = struct{}{}
+	return true
+}
+
+func (p *PortRange) release(k portKey) {
+	p.mu.Lock()
+	delete(p.used, k)
+	p.mu.Unlock()
+}
+
+// dial 以范围内的本地端口拨号，nd.LocalAddr 的 IP 保留；其端口在范围内时只尝试该端口（用于复用 UDP 的源端口），
+// 否则随机选取。返回的连接关闭时归还端口
+func (p *PortRange) dial(ctx context.Context, nd net.Dialer, network, addr string) (net.Conn, error) {
+	k := portKey{udp: network[:3] == "udp"}
+	var ip net.IP
+	var zone string
+	want := 0
+	switch a := nd.LocalAddr.(type) {
+	case *net.TCPAddr:
+		ip, zone, want = a.IP, a.Zone, a.Port
+	case *net.UDPAddr:
+		ip, zone, want = a.IP, a.Zone, a.Port
+	}
+	if !k.udp {
+		control := nd.Control
+		nd.Control = func(network, address string, rc syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, rc); err != nil {
+					return err
+				}
+			}
+			return setReuseAddr(rc)
+		}
+	}
+	try := func(port uint16) (net.Conn, error) {
+		k.port = port
+		if k.udp {
+			nd.LocalAddr = &net.UDPAddr{IP: ip, Port: int(port), Zone: zone}
+		} else {
+			nd.LocalAddr = &net.TCPAddr{IP: ip, Port: int(port), Zone: zone}
+		}
+		c, err := nd.DialContext(ctx, network, addr)
+		if err != nil {
+			p.release(k)
+			return nil, err
+		}
+		return &portConn{Conn: c, p: p, k: k}, nil
+	}
+	if p.Contains(want) {
+		if !p.reserve(portKey{udp: k.udp, port: uint16(want)}) {
+			return nil, fmt.Errorf("local port %d: %w", want, syscall.EADDRINUSE)
+		}
+		return try(uint16(want))
+	}
+	size := p.Size()
+	attempts := min(cmp.Or(p.Attempts, DefaultPortRangeAttempts), size)
+	start := rand.IntN(size)
+	tried := 0
+	for i := 0; i < size && tried < attempts; i++ {
+		port := uint16(int(p.Min) + (start+i)%size)
+		if !p.reserve(portKey{udp: k.udp, port: port}) {
+			continue
+		}
+		tried++
+		c, err := try(port)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return c, err
+		}
+	}
+	p.exhausted.Add(1)
+	log.Printf("Warning: %s %s: no free local port in %d-%d (%d bind attempts failed, the rest are in use by the proxy)", network, addr, p.Min, p.Max, tried)
+	return nil, fmt.Errorf("%s %s: %w", network, addr, ErrLocalPortsExhausted)
+}
+
+// portConn 占用范围内端口的连接，关闭时归还端口
+type portConn struct {
+	net.Conn
+	p    *PortRange
+	k    portKey
+	once sync.Once
+}
+
+func (c *portConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.p.release(c.k) })
+	return err
+}
+
+func (c *portConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
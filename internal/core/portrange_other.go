@@ -0,0 +1,9 @@
+//go:build !linux
+
+package core
+
+import "syscall"
+
+func setReuseAddr(rc syscall.RawConn) error {
+	return nil
+}
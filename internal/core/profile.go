@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Profile 一个附加监听器的策略：认证方法、凭据、白名单和支持的命令只对该监听器接受的连接生效，
+// 出站（拨号器、路由、解析）、配额、黑名单、封禁、统计、审计和访问日志与服务端共享；
+// 服务端自己的监听器（Serve 的 l）和其他传输层继续使用 Server 上的同名字段
+type Profile struct {
+	// Label 监听器的名称，出现在错误日志、访问日志和 StatsSnapshot 中
+	Label string
+	// Method、Auth、UserName、Password 与 Server 的同名字段含义相同
+	Method   byte
+	Auth     Authenticator
+	UserName string
+	Password string
+	// SupportedCommands 该监听器接受的命令，含 CmdUDP 时经服务端的 UDP 套接字转发
+	SupportedCommands []byte
+	// Egress 非 nil 时该监听器的 CONNECT 和 UDP 流量按它出站，代替服务端的 Dialer、Routes 和 Egress
+	Egress *ProfileEgress
+
+	mu           sync.RWMutex
+	allowedIPs   map[string]struct{}
+	allowedCIDRs []*net.IPNet
+
+	conns        atomic.Int64
+	active       atomic.Int64
+	authFailures atomic.Int64
+}
+
+// ProfileEgress 附加监听器的出站设置；拨号超时、解析器、地址族、DSCP、本地端口范围和 AllowedEgress 仍取自服务端
+type ProfileEgress struct {
+	// Upstream 非 nil 时经该上游出站，为 *HealthCheckedUpstream 且不可用、允许回落时改为直连；nil 表示直连
+	Upstream Dialer
+	// Name 上游的名称，记入访问日志的 route，直连时记为 direct
+	Name string
+	// LocalIP 直连出站使用的本地 IP，nil 表示由系统选择
+	LocalIP net.IP
+	// Mark 非 0 时为直连的出站套接字设置 fwmark，用于按 fwmark 的策略路由
+	Mark int
+}
+
+// supportsUDP 判断该出站设置能否转发 UDP
+func (e *ProfileEgress) supportsUDP() bool {
+	u, ok := e.Upstream.(UDPCapable)
+	return !ok || u.SupportsUDP()
+}
+
+// profileRoute 按监听器的出站设置返回拨号器和记入访问日志的出站方式
+func (s *Server) profileRoute(e *ProfileEgress) (Dialer, *Route) {
+	if e.Upstream != nil && !fallbackToDirect(e.Upstream) {
+		return e.Upstream, &Route{Name: e.Name}
+	}
+	d := s.directDialer()
+	d.Egress = nil
+	if e.LocalIP != nil {
+		d.Egress = &EgressTable{Default: e.LocalIP}
+	}
+	d.Mark = e.Mark
+	if e.Upstream != nil {
+		return d, &Route{Name: e.Name + ">direct"}
+	}
+	return d, routeDirect
+}
+
+// ProfileStats 一个附加监听器的计数
+type ProfileStats struct {
+	Connections  int64 `json:"connections"`
+	Active       int64 `json:"active"`
+	AuthFailures int64 `json:"auth_failures"`
+}
+
+// SetWhitelist 在运行时原子替换该监听器的白名单，为空表示允许所有 IP；服务端的黑名单和封禁仍然生效
+func (p *Profile) SetWhitelist(whiteList []string) {
+	ips, cidrs := parseIPList("whitelist of listener "+p.Label, whiteList)
+	p.mu.Lock()
+	p.allowedIPs, p.allowedCIDRs = ips, cidrs
+	p.mu.Unlock()
+}
+
+// Whitelist 返回该监听器当前生效的白名单条目，形式与 Server.Whitelist 相同
+func (p *Profile) Whitelist() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return ipListEntries(p.allowedIPs, p.allowedCIDRs)
+}
+
+// IsAllowed 检查 IP 是否在该监听器的白名单中，没有设置白名单时允许所有 IP
+func (p *Profile) IsAllowed(ip net.IP) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.allowedIPs) == 0 && len(p.allowedCIDRs) == 0 {
+		return true
+	}
+	ip = unmapIP(ip)
+	if _, ok := p.allowedIPs[ip.String()]; ok {
+		return true
+	}
+	for _, ipNet := range p.allowedCIDRs {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 返回该监听器的计数
+func (p *Profile) Stats() ProfileStats {
+	return ProfileStats{
+		Connections:  p.conns.Load(),
+		Active:       p.active.Load(),
+		AuthFailures: p.authFailures.Load(),
+	}
+}
+
+// ListenProfile 以 p 的策略在 addr 上接受 SOCKS5 连接，随 Serve 启动、随 Shutdown 停止；
+// 设置了 TLSConfig 时同样运行在 TLS 之上。p 含 CmdUDP 时服务端须绑定了 UDP 套接字
+func (s *Server) ListenProfile(addr string, p *Profile) (net.Addr, error) {
+	if p.Label == "" {
+		return nil, errors.New("listener profile without label")
+	}
+	tl, err := s.listenTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	var l net.Listener = tl
+	s.profilesMu.Lock()
+	if slices.ContainsFunc(s.profiles, func(q *Profile) bool { return q.Label == p.Label }) {
+		s.profilesMu.Unlock()
+		l.Close()
+		return nil, fmt.Errorf("duplicate listener profile %q", p.Label)
+	}
+	s.profiles = append(s.profiles, p)
+	s.profilesMu.Unlock()
+	bound := l.Addr()
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	s.AddComponent(Component{
+		Name:  "listener " + p.Label,
+		Stage: StageAccept,
+		Start: func(ctx context.Context) error {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return s.serveErr(err)
+				}
+				go func() {
+					p.conns.Add(1)
+					p.active.Add(1)
+					defer p.active.Add(-1)
+					if err := s.serveConn(ctx, c, p); err != nil {
+						var ae *AuthError
+						if errors.As(err, &ae) {
+							p.authFailures.Add(1)
+						}
+						s.LogServeError(fmt.Errorf("[%s] %w", p.Label, err))
+					}
+				}()
+			}
+		},
+		Stop: l.Close,
+	})
+	return bound, nil
+}
+
+// Profiles 返回 ListenProfile 登记的监听器策略
+func (s *Server) Profiles() []*Profile {
+	s.profilesMu.Lock()
+	defer s.profilesMu.Unlock()
+	return slices.Clone(s.profiles)
+}
+
+// hasProfiles 报告是否登记了附加监听器
+func (s *Server) hasProfiles() bool {
+	s.profilesMu.Lock()
+	defer s.profilesMu.Unlock()
+	return len(s.profiles) > 0
+}
+
+// profileOf 返回会话所属监听器的策略，nil 表示使用服务端自己的设置
+func profileOf(sess *Session) *Profile {
+	if sess == nil {
+		return nil
+	}
+	return sess.Profile
+}
+
+// allows 按 p（为 nil 时按服务端）的白名单检查 IP
+func (s *Server) allows(p *Profile, ip net.IP) bool {
+	if p != nil {
+		return p.IsAllowed(ip)
+	}
+	return s.IsAllowed(ip)
+}
+
+// method 返回 p（为 nil 时为服务端）要求的认证方法
+func (s *Server) method(p *Profile) byte {
+	if p != nil {
+		return p.Method
+	}
+	return s.Method
+}
+
+// auth 返回 p（为 nil 时为服务端）的认证器
+func (s *Server) auth(p *Profile) Authenticator {
+	if p != nil {
+		return p.Auth
+	}
+	return s.Auth
+}
+
+// commands 返回 p（为 nil 时为服务端）支持的命令
+func (s *Server) commands(p *Profile) []byte {
+	if p != nil {
+		return p.SupportedCommands
+	}
+	return s.SupportedCommands
+}
@@ -0,0 +1,312 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded is the error when a user has used up the traffic quota of the current period
+	ErrQuotaExceeded = errors.New("traffic quota exceeded")
+	// ErrNoQuota is the error when a user has no traffic quota
+	ErrNoQuota = errors.New("user has no traffic quota")
+)
+
+// QuotaTable 按用户统计每个周期的上下行字节数（TCP 转发和 UDP 数据报的载荷），用量达到 Limits 中的上限后
+// 该用户的新请求以 REP 0x02 拒绝；Terminate 为 true 时已有的转发和 UDP 关联在下一次传输数据时被关闭
+// Period 为 day、week（周一开始）、month 时在 Location（nil 为 UTC）的日历边界重置，
+// 为时长（如 720h）时从第一次统计起每隔这么久重置；用量保存在 Path 中，重启后继续计算，可并发使用
+type QuotaTable struct {
+	Limits    map[string]int64
+	Period    string
+	Location  *time.Location
+	Terminate bool
+	Path      string
+
+	mu    sync.Mutex
+	users map[string]*quotaState
+	dirty bool
+}
+
+// quotaState 一个用户在当前周期的用量，也是保存到文件中的格式
+type quotaState struct {
+	Used  int64     `json:"used"`
+	Start time.Time `json:"period_start"`
+}
+
+// QuotaUsage 一个用户的用量和上限
+type QuotaUsage struct {
+	User        string    `json:"user"`
+	Used        int64     `json:"used"`
+	Limit       int64     `json:"limit"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+}
+
+// NewQuotaTable 创建配额表，Path 非空且文件存在时载入其中的用量，不在 limits 中的用户被忽略
+func NewQuotaTable(limits map[string]int64, period string, loc *time.Location, path string) (*QuotaTable, error) {
+	if err := ValidQuotaPeriod(period); err != nil {
+		return nil, err
+	}
+	q := &QuotaTable{Limits: limits, Period: period, Location: loc, Path: path, users: map[string]*quotaState{}}
+	if path == "" {
+		return q, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved map[string]*quotaState
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for user, st := range saved {
+		if _, ok := limits[user]; ok && st != nil {
+			q.users[user] = st
+		}
+	}
+	return q, nil
+}
+
+// ParseBytes 解析正的字节数，可带 K、M、G、T（1024 进制）后缀，如 10G
+func ParseBytes(s string) (int64, error) {
+	v, shift := s, 0
+	if i := strings.IndexAny(strings.ToUpper(v), "KMGT"); i >= 0 && i == len(v)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", strings.ToUpper(v)[i]) + 1)
+		v = v[:i]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid byte count %q", s)
+	}
+	return n << shift, nil
+}
+
+// ValidQuotaPeriod 检查周期是否为 day、week、month 或正的时长
+func ValidQuotaPeriod(period string) error {
+	switch period {
+	case "day", "week", "month":
+		return nil
+	}
+	if d, err := time.ParseDuration(period); err != nil || d <= 0 {
+		return fmt.Errorf("invalid quota period %q, expected day, week, month or a duration", period)
+	}
+	return nil
+}
+
+// periodStart 返回 now 所在周期的开始，prev 为上次记录的周期开始（用于按时长的周期）
+func (q *QuotaTable) periodStart(now, prev time.Time) time.Time {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t := now.In(loc)
+	switch q.Period {
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case "week":
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	d, _ := time.ParseDuration(q.Period)
+	if prev.IsZero() || now.Before(prev) {
+		return t
+	}
+	return prev.Add(now.Sub(prev) / d * d).In(loc)
+}
+
+// periodEnd 返回从 start 开始的周期的结束
+func (q *QuotaTable) periodEnd(start time.Time) time.Time {
+	switch q.Period {
+	case "day":
+		return start.AddDate(0, 0, 1)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	d, _ := time.ParseDuration(q.Period)
+	return start.Add(d)
+}
+
+// state 返回用户当前周期的用量，跨过周期边界时清零，调用方持有锁；没有配额的用户返回 nil
+func (q *QuotaTable) state(user string, now time.Time) *quotaState {
+	if _, ok := q.Limits[user]; !ok {
+		return nil
+	}
+	st := q.users[user]
+	if st == nil {
+		st = &quotaState{Start: q.periodStart(now, time.Time{})}
+		q.users[user] = st
+		q.dirty = true
+	}
+	if start := q.periodStart(now, st.Start); !start.Equal(st.Start) {
+		st.Used, st.Start = 0, start
+		q.dirty = true
+	}
+	return st
+}
+
+// Exceeded 返回用户是否已用完当前周期的配额，nil 的 QuotaTable 和没有配额的用户返回 false
+func (q *QuotaTable) Exceeded(user string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := q.state(user, time.Now())
+	return st != nil && st.Used >= q.Limits[user]
+}
+
+// Has 返回用户是否有配额，nil 的 QuotaTable 返回 false
+func (q *QuotaTable) Has(user string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.Limits[user]
+	return ok
+}
+
+// add 为用户计入 n 字节，返回计入后是否已用完配额
+func (q *QuotaTable) add(user string, n int) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := q.state(user, time.Now())
+	if st == nil {
+		return false
+	}
+	st.Used += int64(n)
+	q.dirty = true
+	return st.Used >= q.Limits[user]
+}
+
+// Usage 返回所有有配额的用户在当前周期的用量，按用户名排序
+func (q *QuotaTable) Usage() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	list := make([]QuotaUsage, 0, len(q.Limits))
+	for user, limit := range q.Limits {
+		st := q.state(user, now)
+		list = append(list, QuotaUsage{User: user, Used: st.Used, Limit: limit, PeriodStart: st.Start, PeriodEnd: q.periodEnd(st.Start)})
+	}
+	slices.SortFunc(list, func(a, b QuotaUsage) int { return strings.Compare(a.User, b.User) })
+	return list
+}
+
+// SetLimits 在运行时替换各用户的上限，仍有配额的用户保留当前周期的用量
+func (q *QuotaTable) SetLimits(limits map[string]int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Limits = limits
+	for user := range q.users {
+		if _, ok := limits[user]; !ok {
+			delete(q.users, user)
+			q.dirty = true
+		}
+	}
+}
+
+// Reset 把用户当前周期的用量清零，用户没有配额时返回 ErrNoQuota
+func (q *QuotaTable) Reset(user string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := q.state(user, time.Now())
+	if st == nil {
+		return fmt.Errorf("%q: %w", user, ErrNoQuota)
+	}
+	st.Used = 0
+	q.dirty = true
+	return nil
+}
+
+// Save 用量有变化时写入 Path（先写临时文件再改名），Path 为空时不做任何事
+func (q *QuotaTable) Save() error {
+	if q.Path == "" {
+		return nil
+	}
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	b, err := json.MarshalIndent(q.users, "", "  ")
+	q.dirty = false
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.Path), filepath.Base(q.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), q.Path)
+}
+
+// checkQuota 用户已用完配额时以 REP 0x02 拒绝请求并记录访问日志
+func (s *Server) checkQuota(sess *Session, r *Request) error {
+	if !s.Quotas.Exceeded(sess.User) {
+		return nil
+	}
+	err := fmt.Errorf("user %q: %w", sess.User, ErrQuotaExceeded)
+	rec := newAccessRecord(sess, r)
+	rec.Rep = RepNotAllowed
+	rec.finish(s.AccessLog, err)
+	r.Reject(sess, RepNotAllowed)
+	return &RequestError{clientError{Client: sess.RemoteAddr(), Err: err}}
+}
+
+// quotaWriter 把写出的字节计入用户的配额，用完且 Terminate 为 true 时调用 cut 结束转发
+type quotaWriter struct {
+	w    io.Writer
+	q    *QuotaTable
+	user string
+	cut  func()
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if w.q.add(w.user, n) && w.q.Terminate {
+		w.cut()
+		return n, fmt.Errorf("user %q: %w", w.user, ErrQuotaExceeded)
+	}
+	return n, err
+}
+
+// quotaUser 返回连接的用户，没有配额时返回 false
+func (s *Server) quotaUser(c net.Conn) (string, bool) {
+	sess := SessionFromConn(c)
+	if sess == nil {
+		return "", false
+	}
+	return sess.User, s.Quotas.Has(sess.User)
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata" // America/New_York 的夏令时规则不依赖系统的时区数据
+)
+
+func TestQuotaCalendarPeriods(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	east8 := time.FixedZone("UTC+8", 8*3600)
+	for _, tc := range []struct {
+		name       string
+		period     string
+		loc        *time.Location
+		now        time.Time
+		start, end time.Time
+	}{
+		{"day utc", "day", nil,
+			time.Date(2026, 3, 8, 23, 59, 59, 0, time.UTC),
+			time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		// UTC 的 3 月 8 日 17 时在 UTC+8 已是 9 日
+		{"day in zone", "day", east8,
+			time.Date(2026, 3, 8, 17, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 9, 0, 0, 0, 0, east8), time.Date(2026, 3, 10, 0, 0, 0, 0, east8)},
+		{"week from sunday", "week", nil,
+			time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"week on monday midnight", "week", nil,
+			time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"month across year in zone", "month", east8,
+			time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, east8), time.Date(2027, 2, 1, 0, 0, 0, 0, east8)},
+		{"february", "month", nil,
+			time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// 夏令时开始的那天只有 23 小时
+		{"day of dst start", "day", newYork,
+			time.Date(2026, 3, 8, 20, 0, 0, 0, newYork),
+			time.Date(2026, 3, 8, 0, 0, 0, 0, newYork), time.Date(2026, 3, 9, 0, 0, 0, 0, newYork)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := &QuotaTable{Period: tc.period, Location: tc.loc}
+			start := q.periodStart(tc.now, time.Time{})
+			if !start.Equal(tc.start) {
+				t.Fatalf("periodStart(%v) = %v, want %v", tc.now, start, tc.start)
+			}
+			if end := q.periodEnd(start); !end.Equal(tc.end) {
+				t.Fatalf("periodEnd(%v) = %v, want %v", start, end, tc.end)
+			}
+			// 已记录的周期开始不影响日历周期
+			if again := q.periodStart(tc.now, tc.start.Add(-time.Hour)); !again.Equal(tc.start) {
+				t.Fatalf("periodStart with a previous start = %v, want %v", again, tc.start)
+			}
+		})
+	}
+	q := &QuotaTable{Period: "day", Location: newYork}
+	start := q.periodStart(time.Date(2026, 3, 8, 12, 0, 0, 0, newYork), time.Time{})
+	if d := q.periodEnd(start).Sub(start); d != 23*time.Hour {
+		t.Fatalf("day of dst start lasts %v, want 23h", d)
+	}
+}
+
+func TestQuotaDurationPeriod(t *testing.T) {
+	q := &QuotaTable{Period: "1h"}
+	first := time.Date(2026, 5, 1, 10, 20, 0, 0, time.UTC)
+	// 第一次统计时从当时开始
+	if got := q.periodStart(first, time.Time{}); !got.Equal(first) {
+		t.Fatalf("first period starts at %v, want %v", got, first)
+	}
+	for _, tc := range []struct {
+		after time.Duration
+		want  time.Duration
+	}{
+		{59 * time.Minute, 0},
+		{time.Hour, time.Hour},
+		{150 * time.Minute, 2 * time.Hour},
+	} {
+		if got := q.periodStart(first.Add(tc.after), first); !got.Equal(first.Add(tc.want)) {
+			t.Errorf("%v after the start: period starts at %v, want %v", tc.after, got, first.Add(tc.want))
+		}
+	}
+	// 时钟回拨到记录的开始之前时重新开始
+	back := first.Add(-time.Minute)
+	if got := q.periodStart(back, first); !got.Equal(back) {
+		t.Fatalf("clock moved back: period starts at %v, want %v", got, back)
+	}
+	if end := q.periodEnd(first); !end.Equal(first.Add(time.Hour)) {
+		t.Fatalf("periodEnd = %v", end)
+	}
+}
+
+// 跨过周期边界时用量清零
+func TestQuotaStateRollover(t *testing.T) {
+	q := &QuotaTable{Limits: map[string]int64{"alice": 100}, Period: "day", users: map[string]*quotaState{}}
+	now := time.Date(2026, 5, 1, 23, 59, 0, 0, time.UTC)
+	st := q.state("alice", now)
+	st.Used = 100
+	if st := q.state("alice", now.Add(30*time.Second)); st.Used != 100 {
+		t.Fatalf("used %d within the day, want 100", st.Used)
+	}
+	st = q.state("alice", now.Add(time.Minute))
+	if st.Used != 0 || !st.Start.Equal(time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("after midnight: %+v", st)
+	}
+	if q.state("bob", now) != nil {
+		t.Fatal("state for a user without a quota")
+	}
+}
+
+func TestQuotaExceededAndReset(t *testing.T) {
+	q, err := NewQuotaTable(map[string]int64{"alice": 100}, "day", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.add("alice", 99) || q.Exceeded("alice") {
+		t.Fatal("exceeded below the limit")
+	}
+	if !q.add("alice", 1) || !q.Exceeded("alice") {
+		t.Fatal("not exceeded at the limit")
+	}
+	if err := q.Reset("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if q.Exceeded("alice") || q.Usage()[0].Used != 0 {
+		t.Fatalf("after Reset: %+v", q.Usage())
+	}
+	if err := q.Reset("bob"); !errors.Is(err, ErrNoQuota) {
+		t.Fatalf("Reset(bob) = %v, want ErrNoQuota", err)
+	}
+	if q.add("bob", 1000) || q.Exceeded("bob") || q.Has("bob") {
+		t.Fatal("user without a quota is limited")
+	}
+	var nilTable *QuotaTable
+	if nilTable.Has("alice") || nilTable.Exceeded("alice") {
+		t.Fatal("nil table limits users")
+	}
+}
+
+// 用量写入文件后由新的配额表载入，不再有配额的用户被忽略
+func TestQuotaPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	q, err := NewQuotaTable(map[string]int64{"alice": 1000, "bob": 1000}, "720h", nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.add("alice", 300)
+	q.add("bob", 5)
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved := q.Usage()
+
+	// 没有变化时不重写文件
+	os.Remove(path)
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Save rewrote an unchanged table: %v", err)
+	}
+	q.add("alice", 0) // 计入即标记为有变化
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewQuotaTable(map[string]int64{"alice": 500, "carol": 10}, "720h", nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := r.Usage()
+	if len(u) != 2 || u[0].User != "alice" || u[0].Used != 300 || u[0].Limit != 500 || !u[0].PeriodStart.Equal(saved[0].PeriodStart) {
+		t.Fatalf("loaded %+v, saved %+v", u, saved)
+	}
+	if u[1].User != "carol" || u[1].Used != 0 {
+		t.Fatalf("new user %+v", u[1])
+	}
+	if r.Has("bob") {
+		t.Fatal("user dropped from the limits was loaded")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewQuotaTable(map[string]int64{"alice": 1}, "day", nil, path); err == nil {
+		t.Fatal("corrupt quota file accepted")
+	}
+	if _, err := NewQuotaTable(nil, "fortnight", nil, ""); err == nil {
+		t.Fatal("invalid period accepted")
+	}
+}
+
+// 运行时替换上限与连接查询配额并发进行，-race 下不应报告数据竞争
+func TestQuotaSetLimitsConcurrent(t *testing.T) {
+	q, err := NewQuotaTable(map[string]int64{"alice": 100}, "day", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.add("alice", 10)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := range 1000 {
+			if i%2 == 0 {
+				q.SetLimits(map[string]int64{"alice": 100})
+			} else {
+				q.SetLimits(map[string]int64{"bob": 100})
+			}
+		}
+	})
+	for range 1000 {
+		q.Has("alice")
+		q.Exceeded("alice")
+	}
+	wg.Wait()
+	// 最后一次替换去掉了 alice，其用量一并删除
+	q.SetLimits(map[string]int64{"alice": 100})
+	if u := q.Usage(); len(u) != 1 || u[0].Used != 0 {
+		t.Fatalf("usage after limits were removed and restored: %+v", u)
+	}
+}
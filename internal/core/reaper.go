@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleReaped is the error when the idle reaper closes a relay that moved no bytes for IdleReapAfter
+var ErrIdleReaped = errors.New("idle reaped")
+
+// relay 登记中的一个 TCP 转发或 UDP 关联，up 和 down 为 TCP 转发已转发的字节数
+type relay struct {
+	sess  *Session
+	conns []net.Conn
+	udp   bool // UDP 关联，空闲由 UDPTimeout 处理，不参与收割
+	assoc *UDPAssociation
+	up    atomic.Int64
+	down  atomic.Int64
+	top   topCursor // 已计入 TopDestinations 的字节数
+
+	mu     sync.Mutex
+	reason error // 被收割或清理时关闭的原因
+
+	// 上次扫描时的字节总数和它最近一次变化的时间，只由收割协程访问
+	seen   int64
+	active time.Time
+}
+
+// relayRegistry 进行中的 TCP 转发和 UDP 关联，IdleReapAfter 非 0 时第一次登记启动收割协程，
+// 设置了 TopDestinations 时启动计入排行的协程
+type relayRegistry struct {
+	m       sync.Map // *relay -> struct{}
+	once    sync.Once
+	topOnce sync.Once
+}
+
+// trackRelay 登记一个转发，conns 的第一个为客户端连接，结束时须调用 untrackRelay
+func (s *Server) trackRelay(sess *Session, conns ...net.Conn) *relay {
+	r := &relay{sess: sess, conns: conns, active: time.Now()}
+	s.relays.m.Store(r, struct{}{})
+	if s.IdleReapAfter > 0 {
+		s.relays.once.Do(func() { go s.reapIdle(s.Context()) })
+	}
+	s.startTopFlush()
+	return r
+}
+
+// trackAssociation 登记一个 UDP 关联，c 为控制连接，结束时须调用 untrackRelay
+func (s *Server) trackAssociation(sess *Session, c net.Conn, a *UDPAssociation) *relay {
+	r := &relay{sess: sess, conns: []net.Conn{c}, udp: true, assoc: a}
+	s.relays.m.Store(r, struct{}{})
+	s.startTopFlush()
+	return r
+}
+
+// RelayCounts 返回进行中的 TCP 转发和 UDP 关联数
+func (s *Server) RelayCounts() (tcp, udp int) {
+	s.relays.m.Range(func(k, _ any) bool {
+		if k.(*relay).udp {
+			udp++
+		} else {
+			tcp++
+		}
+		return true
+	})
+	return tcp, udp
+}
+
+// untrackRelay 注销转发，返回它被收割或清理的原因，正常结束时返回 nil
+func (s *Server) untrackRelay(r *relay) error {
+	s.relays.m.Delete(r)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reason
+}
+
+// close 以 reason 关闭转发的所有连接，已被关闭过时返回 false
+func (r *relay) close(reason error) bool {
+	r.mu.Lock()
+	if r.reason != nil {
+		r.mu.Unlock()
+		return false
+	}
+	r.reason = reason
+	r.mu.Unlock()
+	for _, c := range r.conns {
+		c.Close()
+	}
+	return true
+}
+
+// reapIdle 定期扫描登记的转发，关闭两个方向的字节数超过 IdleReapAfter 都没有变化的转发，直到 ctx 取消
+// 与每次读写设置截止时间的空闲超时不同，它只看字节计数，对端不再收发却不关闭的连接也能发现
+func (s *Server) reapIdle(ctx context.Context) {
+	t := time.NewTicker(min(max(s.IdleReapAfter/4, time.Second), time.Minute))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.relays.m.Range(func(k, _ any) bool {
+				r := k.(*relay)
+				if r.udp {
+					return true
+				}
+				if n := r.up.Load() + r.down.Load(); n != r.seen {
+					r.seen, r.active = n, now
+					return true
+				}
+				if now.Sub(r.active) >= s.IdleReapAfter {
+					r.close(ErrIdleReaped)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// countingWriter 把写出的字节数累加到 n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,78 @@
+package core_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestCredentialsNeverLogged(t *testing.T) {
+	const (
+		user  = "redact-user"
+		pass  = "hunter2-pass-9f3a"
+		wrong = "wrong-pass-77c1"
+	)
+	buf := captureLog(t, true)
+	ts := socks5test.NewServer(t, socks5test.WithAuth(user, pass), func(s *core.Server) {
+		s.Use(core.LogRequests(nil))
+		s.AccessLog = core.NewAccessLogger(buf)
+		s.Audit = core.NewAuditLogger(core.NewJSONAuditSink(buf))
+	})
+	echo := socks5test.EchoTCPServer(t)
+	auth := &socks5test.Auth{User: user, Password: pass}
+	c := socks5test.Connect(t, ts.Addr, auth, echo)
+	socks5test.EchoTCP(t, c, []byte("logged traffic"))
+	c.Close()
+	ctl, relay := socks5test.Associate(t, ts.Addr, auth)
+	socks5test.EchoUDP(t, relay, socks5test.EchoUDPServer(t), []byte("logged datagram"))
+	ctl.Close()
+
+	// 错误的密码：认证失败的日志和审计记录同样不带密码
+	bad, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(bad)
+	if _, err := core.NewNegotiationReplyFrom(bad); err != nil {
+		t.Fatal(err)
+	}
+	core.NewUserPassNegotiationRequest([]byte(user), []byte(wrong)).WriteTo(bad)
+	if r, err := core.NewUserPassNegotiationReplyFrom(bad); err != nil || r.Status == core.UserPassStatusSuccess {
+		t.Fatalf("wrong password accepted: %v, %v", r, err)
+	}
+	bad.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ts.Stats.AuthFailures.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ts.Shutdown()
+
+	out := buf.String()
+	if !strings.Contains(out, user) || !strings.Contains(out, "<redacted>") {
+		t.Fatalf("debug log does not show the negotiation:\n%s", out)
+	}
+	for _, secret := range []string{pass, wrong} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("log contains the password %q:\n%s", secret, out)
+		}
+	}
+}
+
+func TestUserPassRequestFormatting(t *testing.T) {
+	r := core.NewUserPassNegotiationRequest([]byte("u"), []byte("p4ssw0rd-secret"))
+	for _, s := range []string{r.String(), fmt.Sprint(r), fmt.Sprintf("%v", r), fmt.Sprintf("%s", r)} {
+		if strings.Contains(s, "p4ssw0rd-secret") {
+			t.Fatalf("formatted request contains the password: %s", s)
+		}
+		// 长度同样不显示
+		if strings.Contains(s, "15") {
+			t.Fatalf("formatted request reveals the password length: %s", s)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+)
+
+// ErrRejected is the error when RejectHandler or CommandFilterHandler refuses a request
+var ErrRejected = errors.New("request rejected by handler")
+
+// RejectHandler 完成协商后以 Rep 拒绝所有请求，不连接任何目标，用于维护窗口或蜜罐监听
+// UDP ASSOCIATE 在请求时即被拒绝，收到的数据报一律丢弃
+type RejectHandler struct {
+	Rep byte // 为 0（成功）时使用 RepServerFailure
+}
+
+func (h *RejectHandler) TCPHandle(s *Server, c net.Conn, r *Request) error {
+	return h.TCPHandleContext(s.Context(), s, c, r)
+}
+
+func (h *RejectHandler) UDPHandle(s *Server, addr *net.UDPAddr, d *Datagram) error {
+	return h.UDPHandleContext(s.Context(), s, addr, d)
+}
+
+func (h *RejectHandler) TCPHandleContext(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+	rep := h.Rep
+	if rep == RepSuccess {
+		rep = RepServerFailure
+	}
+	return rejectRequest(s, c, r, rep)
+}
+
+func (h *RejectHandler) UDPHandleContext(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+	if Debug {
+		log.Printf("UDP %s from %s: %v", d.Address(), addr, ErrRejected)
+	}
+	return nil
+}
+
+// CommandFilterHandler 以 Rep 拒绝 Commands 中的命令，其余请求交给 Next（nil 时使用 DefaultHandle）
+// 拒绝 CmdUDP 时，未关联的数据报同样被丢弃
+type CommandFilterHandler struct {
+	Next     Handler
+	Commands []byte
+	Rep      byte // 为 0（成功）时使用 RepCommandNotSupported
+}
+
+// RejectCommands 返回以 CommandFilterHandler 拒绝指定命令的中间件
+func RejectCommands(cmds ...byte) Middleware {
+	return func(next Handler) Handler {
+		return &CommandFilterHandler{Next: next, Commands: cmds}
+	}
+}
+
+func (h *CommandFilterHandler) TCPHandle(s *Server, c net.Conn, r *Request) error {
+	return h.TCPHandleContext(s.Context(), s, c, r)
+}
+
+func (h *CommandFilterHandler) UDPHandle(s *Server, addr *net.UDPAddr, d *Datagram) error {
+	return h.UDPHandleContext(s.Context(), s, addr, d)
+}
+
+func (h *CommandFilterHandler) TCPHandleContext(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+	if slices.Contains(h.Commands, r.Cmd) {
+		rep := h.Rep
+		if rep == RepSuccess {
+			rep = RepCommandNotSupported
+		}
+		return rejectRequest(s, c, r, rep)
+	}
+	return callTCP(ctx, h.next(), s, c, r)
+}
+
+func (h *CommandFilterHandler) UDPHandleContext(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
+	if slices.Contains(h.Commands, CmdUDP) {
+		if Debug {
+			log.Printf("UDP %s from %s: %v", d.Address(), addr, ErrRejected)
+		}
+		return nil
+	}
+	return callUDP(ctx, h.next(), s, addr, d)
+}
+
+func (h *CommandFilterHandler) next() Handler {
+	if h.Next == nil {
+		return &DefaultHandle{}
+	}
+	return h.Next
+}
+
+// rejectRequest 以 rep 应答请求并记录访问日志，透明代理的连接不写应答
+func rejectRequest(s *Server, c net.Conn, r *Request, rep byte) error {
+	var w io.Writer = c
+	if sess := SessionFromConn(c); sess != nil && sess.Transparent {
+		w = io.Discard
+	}
+	err := fmt.Errorf("%s %s: %w", cmdName(r.Cmd), r.Address(), ErrRejected)
+	rec := newAccessRecord(c, r)
+	rec.Rep = rep
+	rec.finish(s.AccessLog, err)
+	if werr := r.Reject(w, rep); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package core_test
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// blackholeAddr 返回一个丢弃 SYN 的回环地址：监听的 backlog 为 0 且从不 accept，
+// 占满接受队列之后内核丢弃新的 SYN，连接一直等待重传
+func blackholeAddr(t *testing.T) string {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sa.(*syscall.SockaddrInet4).Port))
+	for {
+		c, err := net.DialTimeout("tcp", addr, 200*time.Millisecond)
+		if err != nil {
+			return addr
+		}
+		t.Cleanup(func() { c.Close() })
+	}
+}
+
+// 经直连拨号器连接黑洞地址，RequestTimeout 到期时立即失败，而不是等到全局的拨号超时
+func TestRequestTimeoutBlackholeAddress(t *testing.T) {
+	dst := blackholeAddr(t)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.DialTimeout = 60
+		s.RequestTimeout = 300 * time.Millisecond
+	})
+	start := time.Now()
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, dst, core.RepTTLExpired).Close()
+	prompt(t, start, 300*time.Millisecond)
+}
@@ -0,0 +1,139 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// blackholeDialer 像发往黑洞地址的连接一样一直等待，直到 ctx 结束
+type blackholeDialer struct{}
+
+func (blackholeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+}
+
+// blockingResolver 解析一直等待到 ctx 结束
+type blockingResolver struct{}
+
+func (blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// prompt 检查从 start 起的耗时不少于 min 且远小于全局拨号超时
+func prompt(t *testing.T, start time.Time, min time.Duration) {
+	t.Helper()
+	if d := time.Since(start); d < min || d > min+2*time.Second {
+		t.Fatalf("failed after %v, want about %v", d, min)
+	}
+}
+
+// RequestTimeout 限制拨号，全局的拨号超时远大于它
+func TestRequestTimeoutBlackhole(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Dialer = blackholeDialer{}
+		s.DialTimeout = 60
+		s.RequestTimeout = 200 * time.Millisecond
+	})
+	start := time.Now()
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, "192.0.2.1:80", core.RepTTLExpired).Close()
+	prompt(t, start, 200*time.Millisecond)
+}
+
+// RequestTimeout 同样限制域名解析
+func TestRequestTimeoutResolve(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Resolver = blockingResolver{}
+		s.DialTimeout = 60
+		s.RequestTimeout = 200 * time.Millisecond
+	})
+	start := time.Now()
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, "slow.example:80", core.RepTTLExpired).Close()
+	prompt(t, start, 200*time.Millisecond)
+}
+
+// 处理器以自己的 context 调用 Request.Connect，拨号中途取消或到期时立即失败
+func TestConnectContextMidDial(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ctx     func(context.Context, <-chan struct{}) (context.Context, context.CancelFunc)
+		rep     byte
+		wantErr error
+	}{
+		{"cancel", func(parent context.Context, dialing <-chan struct{}) (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(parent)
+			// 拨号开始后再等一会儿取消，确认取消发生在拨号中途
+			go func() {
+				<-dialing
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}()
+			return ctx, cancel
+		}, core.RepServerFailure, context.Canceled},
+		{"deadline", func(parent context.Context, _ <-chan struct{}) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(parent, 100*time.Millisecond)
+		}, core.RepTTLExpired, core.ErrRequestDeadline},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			relayed := make(chan struct{}, 1)
+			errc := make(chan error, 1)
+			ts := socks5test.NewServer(t, socks5test.WithHandler(&core.HandlerFuncs{
+				TCP: func(ctx context.Context, s *core.Server, c net.Conn, r *core.Request) error {
+					watch := make(chan struct{}, 1)
+					dctx, cancel := tc.ctx(ctx, watch)
+					defer cancel()
+					rc, err := r.Connect(dctx, c, dialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+						watch <- struct{}{}
+						return blackholeDialer{}.DialContext(ctx, network, addr)
+					}))
+					if rc != nil {
+						rc.Close()
+						relayed <- struct{}{}
+					}
+					errc <- err
+					return err
+				},
+			}))
+			start := time.Now()
+			socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, "192.0.2.1:80", tc.rep).Close()
+			prompt(t, start, 100*time.Millisecond)
+			if err := <-errc; !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Connect = %v, want %v", err, tc.wantErr)
+			}
+			select {
+			case <-relayed:
+				t.Fatal("Connect returned a connection")
+			default:
+			}
+		})
+	}
+}
+
+// 拨号器不理会 ctx、在到期后才返回连接时，连接被关闭，应答 RepTTLExpired 而不是成功
+func TestConnectLateDialResult(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	late := make(chan net.Conn, 1)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.RequestTimeout = 100 * time.Millisecond
+		s.Dialer = dialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, echo)
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			late <- c
+			return c, err
+		})
+	})
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, echo, core.RepTTLExpired).Close()
+	c := <-late
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("late connection not closed: %v", err)
+	}
+}
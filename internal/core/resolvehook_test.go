@@ -0,0 +1,76 @@
+package core_test
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// hookResolve 在测试期间替换包级 Resolve：echo.invalid 解析到 127.0.0.1，其他名称解析失败
+func hookResolve(t *testing.T) *atomic.Int64 {
+	var calls atomic.Int64
+	old := core.Resolve
+	core.Resolve = func(network, addr string) (net.Addr, error) {
+		calls.Add(1)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if host != "echo.invalid" {
+			return nil, &net.DNSError{Err: "blocked by hook", Name: host, IsNotFound: true}
+		}
+		return net.ResolveTCPAddr(network, net.JoinHostPort("127.0.0.1", port))
+	}
+	t.Cleanup(func() { core.Resolve = old })
+	return &calls
+}
+
+func TestResolveHookConnect(t *testing.T) {
+	calls := hookResolve(t)
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+
+	// .invalid 不会被真实 DNS 解析，连接成功说明经过了替换的 Resolve
+	c := socks5test.Connect(t, ts.Addr, nil, net.JoinHostPort("echo.invalid", port))
+	socks5test.EchoTCP(t, c, []byte("via hook"))
+	if calls.Load() == 0 {
+		t.Fatal("CONNECT did not consult core.Resolve")
+	}
+
+	// 被替换的 Resolve 拒绝的名称不会退回到系统解析
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, net.JoinHostPort("localhost", port), core.RepHostUnreachable)
+}
+
+func TestResolveHookDialTCP(t *testing.T) {
+	hookResolve(t)
+	echo := socks5test.EchoTCPServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+	c, err := core.DialTCP("tcp", "", net.JoinHostPort("echo.invalid", port), socks5test.Timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.RemoteAddr().String(); got != echo {
+		t.Fatalf("dialed %s, want %s", got, echo)
+	}
+	if _, err := core.DialTCP("tcp", "", net.JoinHostPort("localhost", port), socks5test.Timeout); err == nil || !strings.Contains(err.Error(), "blocked by hook") {
+		t.Fatalf("DialTCP(localhost) = %v, want the hook's error", err)
+	}
+}
+
+// 未设置 Resolve 时为 nil，域名目标由默认的解析器解析
+func TestResolveDefaultNil(t *testing.T) {
+	if core.Resolve != nil {
+		t.Fatal("core.Resolve is set by default")
+	}
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+	c := socks5test.Connect(t, ts.Addr, nil, net.JoinHostPort("localhost", port))
+	socks5test.EchoTCP(t, c, []byte("default"))
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"cmp"
+	"sync"
+	"sync/atomic"
+)
+
+// 默认的 UDP 处理协程数和队列长度
+const (
+	DefaultUDPWorkers = 128
+	DefaultUDPQueue   = 5000
+)
+
+// Resources 转发使用的缓冲池和 UDP 处理协程，默认每个 Server 各有一份
+// 同一进程内运行多个 Server 时可以把同一个 Resources 赋给它们共享，协程在第一个使用者开始服务时启动，
+// 最后一个使用者停止后退出；其中一个 Server 关闭不影响其他 Server
+type Resources struct {
+	UDPWorkers int // UDP 处理协程数，0 表示 DefaultUDPWorkers，开始服务后修改不生效
+	UDPQueue   int // 等待处理的数据报队列长度，0 表示 DefaultUDPQueue，队列满时丢弃新数据报
+
+	tcpBufs sync.Pool    // 32KB，TCP 转发
+	udpBufs sync.Pool    // 64KB，UDP 数据报
+	udpOut  atomic.Int64 // 已取出、尚未归还的 UDP 缓冲区数
+
+	mu   sync.Mutex
+	refs int
+	work chan *udpTask
+}
+
+// NewResources 创建使用默认参数的 Resources
+func NewResources() *Resources {
+	return &Resources{}
+}
+
+func (r *Resources) getTCPBuf() []byte {
+	if b, ok := r.tcpBufs.Get().([]byte); ok {
+		return b
+	}
+	return make([]byte, 32*1024)
+}
+
+func (r *Resources) putTCPBuf(b []byte) {
+	r.tcpBufs.Put(b)
+}
+
+func (r *Resources) getUDPBuf() []byte {
+	r.udpOut.Add(1)
+	if b, ok := r.udpBufs.Get().([]byte); ok {
+		return b[:cap(b)]
+	}
+	return make([]byte, 65507)
+}
+
+func (r *Resources) putUDPBuf(b []byte) {
+	r.udpOut.Add(-1)
+	r.udpBufs.Put(b)
+}
+
+// acquire 登记一个 UDP 使用者并返回任务队列，第一个使用者启动处理协程
+func (r *Resources) acquire() chan<- *udpTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refs == 0 {
+		r.work = make(chan *udpTask, cmp.Or(r.UDPQueue, DefaultUDPQueue))
+		for range cmp.Or(r.UDPWorkers, DefaultUDPWorkers) {
+			go func(ch <-chan *udpTask) {
+				for t := range ch {
+					handleUDPTask(t.s, t)
+				}
+			}(r.work)
+		}
+	}
+	r.refs++
+	return r.work
+}
+
+// release 注销一个 UDP 使用者，调用方保证之后不再向队列发送，最后一个使用者关闭队列使处理协程退出
+func (r *Resources) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refs--
+	if r.refs == 0 {
+		close(r.work)
+		r.work = nil
+	}
+}
@@ -0,0 +1,90 @@
+package core_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestTwoServers(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		name := "private"
+		if shared {
+			name = "shared"
+		}
+		t.Run(name, func(t *testing.T) {
+			res := core.NewResources()
+			res.UDPWorkers = 2
+			withRes := func(s *core.Server) {
+				if shared {
+					s.Resources = res
+				}
+			}
+			a := socks5test.NewServer(t, socks5test.WithAuth("alice", "a-pass"), withRes)
+			b := socks5test.NewServer(t, socks5test.WithAuth("bob", "b-pass"), withRes)
+			alice := &socks5test.Auth{User: "alice", Password: "a-pass"}
+			bob := &socks5test.Auth{User: "bob", Password: "b-pass"}
+			tcpEcho, udpEcho := socks5test.EchoTCPServer(t), socks5test.EchoUDPServer(t)
+
+			// 每个服务端只接受自己的凭据
+			for _, c := range []struct {
+				ts   *socks5test.Server
+				good *socks5test.Auth
+				bad  *socks5test.Auth
+			}{{a, alice, bob}, {b, bob, alice}} {
+				conn := socks5test.Connect(t, c.ts.Addr, c.good, tcpEcho)
+				socks5test.EchoTCP(t, conn, []byte("mine"))
+				conn.Close()
+				if !authFails(t, c.ts.Addr, c.bad) {
+					t.Fatalf("%s accepted %s's credentials", c.good.User, c.bad.User)
+				}
+				if n := c.ts.Stats.AuthFailures.Load(); n != 1 {
+					t.Fatalf("%s AuthFailures = %d, want 1", c.good.User, n)
+				}
+			}
+
+			// 关联在 b 上保持，关闭 a 后 b 的 TCP 和 UDP 都继续工作
+			ctl, relay := socks5test.Associate(t, b.Addr, bob)
+			defer ctl.Close()
+			socks5test.EchoUDP(t, relay, udpEcho, []byte("before"))
+			if err := a.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := net.DialTimeout("tcp", a.Addr, time.Second); err == nil {
+				t.Fatal("a still accepting after Shutdown")
+			}
+			socks5test.EchoUDP(t, relay, udpEcho, []byte("after"))
+			conn := socks5test.Connect(t, b.Addr, bob, tcpEcho)
+			socks5test.EchoTCP(t, conn, []byte("still serving"))
+			ctl2, relay2 := socks5test.Associate(t, b.Addr, bob)
+			defer ctl2.Close()
+			socks5test.EchoUDP(t, relay2, udpEcho, []byte("new association"))
+		})
+	}
+}
+
+// authFails 以 auth 连接 proxy，返回用户名密码认证是否被拒绝
+func authFails(t *testing.T, proxy string, auth *socks5test.Auth) bool {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+	if _, err := core.NewNegotiationReplyFrom(c); err != nil {
+		t.Fatal(err)
+	}
+	core.NewUserPassNegotiationRequest([]byte(auth.User), []byte(auth.Password)).WriteTo(c)
+	r, err := core.NewUserPassNegotiationReplyFrom(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待服务端计入失败，以便调用方检查计数
+	c.Read(make([]byte, 1))
+	return r.Status != core.UserPassStatusSuccess
+}
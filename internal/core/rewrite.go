@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RewriteFunc 改写请求的目标，返回新的请求（nil 表示不改写）或错误（以 REP 0x02 拒绝）
+// CONNECT 请求在协商后、交给 Handle 之前调用；UDP 中每个数据报的目标以 Cmd 为 CmdUDP 的请求调用
+type RewriteFunc func(r *Request, client net.Addr) (*Request, error)
+
+// RewriteMap 返回按表改写目标的 RewriteFunc，键和值都是 host:port，不在表中的目标保持不变
+func RewriteMap(m map[string]string) (RewriteFunc, error) {
+	to := make(map[string]*Request, len(m))
+	for from, dst := range m {
+		a, addr, port, err := ParseAddress(dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite target %q: %w", dst, err)
+		}
+		if a == ATYPDomain {
+			addr = addr[1:]
+		}
+		to[strings.ToLower(from)] = NewRequest(CmdConnect, a, addr, port)
+	}
+	return func(r *Request, client net.Addr) (*Request, error) {
+		t, ok := to[strings.ToLower(r.Address())]
+		if !ok {
+			return nil, nil
+		}
+		return &Request{Ver: r.Ver, Cmd: r.Cmd, Atyp: t.Atyp, DstAddr: t.DstAddr, DstPort: t.DstPort}, nil
+	}, nil
+}
+
+// rewriteRequest 对 CONNECT 请求调用 Rewrite，改写时在 Session 中记录原始目标
+func (s *Server) rewriteRequest(sess *Session, r *Request) (*Request, error) {
+	if s.Rewrite == nil || r.Cmd != CmdConnect {
+		return r, nil
+	}
+	orig := r.Address()
+	r1, err := s.Rewrite(r, sess.RemoteAddr())
+	if err != nil {
+		return nil, fmt.Errorf("rewrite %s: %w", orig, err)
+	}
+	if r1 == nil {
+		return r, nil
+	}
+	if r1.Address() != orig {
+		sess.OrigDst = orig
+	}
+	return r1, nil
+}
+
+// rewriteOrReject 改写请求，Rewrite 返回错误时向客户端应答 REP 0x02 并记录访问日志
+func (s *Server) rewriteOrReject(sess *Session, r *Request) (*Request, error) {
+	r1, err := s.rewriteRequest(sess, r)
+	if err == nil {
+		return r1, nil
+	}
+	if !sess.Transparent {
+		r.Reject(sess, RepNotAllowed)
+	}
+	s.Audit.event(AuditDenied, sess.RemoteAddr(), sess.User, r.Address(), "rewrite", err.Error())
+	rec := newAccessRecord(sess, r)
+	rec.Rep = RepNotAllowed
+	rec.finish(s.AccessLog, err)
+	return nil, err
+}
+
+// rewriteDatagram 对数据报的目标调用 Rewrite，改写时返回原始目标，用于应答数据报的来源地址
+func (s *Server) rewriteDatagram(addr *net.UDPAddr, d *Datagram) (string, error) {
+	if s.Rewrite == nil {
+		return "", nil
+	}
+	orig := d.Address()
+	r, err := s.Rewrite(&Request{Ver: Ver, Cmd: CmdUDP, Atyp: d.Atyp, DstAddr: d.DstAddr, DstPort: d.DstPort}, addr)
+	if err != nil {
+		return "", fmt.Errorf("rewrite udp %s: %w", orig, err)
+	}
+	if r == nil || r.Address() == orig {
+		return "", nil
+	}
+	d.Atyp, d.DstAddr, d.DstPort = r.Atyp, r.DstAddr, r.DstPort
+	return orig, nil
+}
+
+type origDstKey struct{}
+
+// withOrigDst 返回携带数据报原始目标的 context
+func withOrigDst(ctx context.Context, orig string) context.Context {
+	return context.WithValue(ctx, origDstKey{}, orig)
+}
+
+// origDstFromContext 取出数据报被改写前的目标，未改写时为空
+func origDstFromContext(ctx context.Context) string {
+	orig, _ := ctx.Value(origDstKey{}).(string)
+	return orig
+}
@@ -0,0 +1,401 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBlocked is the error when the routing table blocks the destination
+var ErrBlocked = errors.New("destination is blocked by the routing table")
+
+// Route 出站方式：直连、经由命名上游或拒绝
+type Route struct {
+	Name     string   // "direct"、"block" 或上游名称，记录在访问日志中
+	Dialer   Dialer   // 上游拨号器，直连和拒绝时为 nil
+	Resolver Resolver // 直连时使用的解析器，nil 表示使用服务端的 Resolver
+	Block    bool
+	// DialTimeout 非 0 时代替服务端的 DialTimeout，由规则的 timeout= 选项设置
+	DialTimeout time.Duration
+	// IdleTimeout 非 0 时代替服务端的 TCPTimeout，负数表示不限制，由规则的 idle= 选项设置
+	IdleTimeout time.Duration
+	// DSCP 非 0 时代替服务端的 DSCP 标记直连的出站套接字，由规则的 dscp= 选项设置
+	DSCP int
+	// MaxSessionDuration 非 0 时限制 TCP 转发的最长存续时间，与服务端和用户的限制取最小值，由规则的 lifetime= 选项设置
+	MaxSessionDuration time.Duration
+}
+
+var (
+	routeDirect = &Route{Name: "direct"}
+	routeBlock  = &Route{Name: "block", Block: true}
+)
+
+// RouteRule 将目标（CIDR、域名后缀或任意）及可选端口范围映射到出站方式
+type RouteRule struct {
+	CIDR    *net.IPNet // 匹配 IP 字面量目标
+	Suffix  string     // 匹配域名目标，为空且 CIDR 为 nil 时匹配任意目标
+	PortMin uint16     // 端口范围，都为 0 表示任意端口
+	PortMax uint16
+	Route   *Route
+	Source  string // 规则的来源（LoadRoutesFile 中为 文件:行号），用于审计日志
+}
+
+// RoutingTable 按顺序匹配的路由表，每个 CONNECT 和每个 UDP 目标在拨号前查表，未匹配时使用 Default
+// 与出站策略表一样，CIDR 规则只匹配 IP 字面量目标，域名后缀规则只匹配域名目标
+type RoutingTable struct {
+	Rules   []RouteRule
+	Default *Route
+
+	named []*HealthCheckedUpstream // LoadRoutesFile 中定义的命名上游，包括没有被规则引用的
+}
+
+// LoadRoutesFile 读取路由文件，支持 # 注释和空行，每行为以下之一：
+//
+//	upstream <名称> <上游URL> [udp] [fallback]  定义命名上游，URL 格式同 NewUpstream，fallback 表示不可用时回落到直连
+//	resolver <名称> <描述>                       定义命名解析器，描述格式同 NewResolver
+//	route <目标> [端口] <出站> [选项...]         目标为 CIDR、IP、域名后缀或 *，端口为 N 或 N-M
+//	default <出站> [选项...]                    必须出现且只出现一次
+//
+// 出站为 direct、block 或已定义的上游名称，direct 可以写成 direct@<解析器名称> 以使用指定的解析器
+// 选项：timeout=时长 为出站拨号超时（如 3s、1m），代替 Server.DialTimeout；
+// idle=时长 为转发的空闲超时，代替 Server.TCPTimeout，idle=0 表示不限制；
+// dscp=值 为直连出站套接字的 DSCP 标记（EF、AF11 等名称或 0-63），代替 Server.DSCP；
+// lifetime=时长 为 TCP 转发的最长存续时间，与 Server.MaxSessionDuration 等限制一起取最小值
+func LoadRoutesFile(path string) (*RoutingTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type pending struct {
+		line    int
+		rule    RouteRule
+		name    string
+		timeout time.Duration
+		idle    time.Duration
+		life    time.Duration
+		dscp    int
+	}
+	upstreams := map[string]*Route{}
+	var named []*HealthCheckedUpstream
+	resolvers := map[string]Resolver{}
+	var rules []pending
+	var def *pending
+
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		var timeout, idle, life time.Duration
+		dscp := 0
+		for fields[0] == "route" || fields[0] == "default" {
+			k, v, ok := strings.Cut(fields[len(fields)-1], "=")
+			if !ok || len(fields) < 2 {
+				break
+			}
+			if k == "dscp" {
+				var err error
+				if dscp, err = ParseDSCP(v); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+				}
+				fields = fields[:len(fields)-1]
+				continue
+			}
+			d, err := time.ParseDuration(v)
+			switch {
+			case k == "timeout" && err == nil && d > 0:
+				timeout = d
+			case k == "idle" && err == nil && d > 0:
+				idle = d
+			case k == "idle" && err == nil && d == 0:
+				idle = -1
+			case k == "lifetime" && err == nil && d > 0:
+				life = d
+			case k == "timeout" || k == "idle" || k == "lifetime":
+				return nil, fmt.Errorf("%s:%d: invalid %s %q", path, line, k, v)
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown option %q", path, line, k)
+			}
+			fields = fields[:len(fields)-1]
+		}
+		switch fields[0] {
+		case "upstream":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%s:%d: expected \"upstream <name> <URL> [udp] [fallback]\"", path, line)
+			}
+			var udp, fb bool
+			for _, opt := range fields[3:] {
+				switch opt {
+				case "udp":
+					udp = true
+				case "fallback":
+					fb = true
+				default:
+					return nil, fmt.Errorf("%s:%d: unknown upstream option %q", path, line, opt)
+				}
+			}
+			name := fields[1]
+			if name == "direct" || name == "block" {
+				return nil, fmt.Errorf("%s:%d: upstream name %q is reserved", path, line, name)
+			}
+			if _, ok := upstreams[name]; ok {
+				return nil, fmt.Errorf("%s:%d: upstream %q is already defined", path, line, name)
+			}
+			d, err := NewUpstream(fields[2], udp)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			h := NewHealthCheckedUpstream(name, d, fb)
+			upstreams[name] = &Route{Name: name, Dialer: h}
+			named = append(named, h)
+		case "resolver":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%s:%d: expected \"resolver <name> <spec>\"", path, line)
+			}
+			if _, ok := resolvers[fields[1]]; ok {
+				return nil, fmt.Errorf("%s:%d: resolver %q is already defined", path, line, fields[1])
+			}
+			r, err := NewResolver(fields[2], DefaultDNSTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			resolvers[fields[1]] = r
+		case "route":
+			if len(fields) != 3 && len(fields) != 4 {
+				return nil, fmt.Errorf("%s:%d: expected \"route <destination> [port] <route>\"", path, line)
+			}
+			rule, err := parseRouteDest(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			if len(fields) == 4 {
+				if rule.PortMin, rule.PortMax, err = parsePortRange(fields[2]); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+				}
+			}
+			rules = append(rules, pending{line: line, rule: rule, name: fields[len(fields)-1], timeout: timeout, idle: idle, life: life, dscp: dscp})
+		case "default":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: expected \"default <route>\"", path, line)
+			}
+			if def != nil {
+				return nil, fmt.Errorf("%s:%d: default route is already set on line %d", path, line, def.line)
+			}
+			def = &pending{line: line, name: fields[1], timeout: timeout, idle: idle, life: life, dscp: dscp}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", path, line, fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if def == nil {
+		return nil, fmt.Errorf("%s: a default route is required", path)
+	}
+
+	lookupRoute := func(p *pending) (*Route, error) {
+		if res, ok := strings.CutPrefix(p.name, "direct@"); ok {
+			r, ok := resolvers[res]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: undefined resolver %q", path, p.line, res)
+			}
+			return &Route{Name: "direct", Resolver: r}, nil
+		}
+		switch p.name {
+		case "direct":
+			return routeDirect, nil
+		case "block":
+			return routeBlock, nil
+		}
+		if r, ok := upstreams[p.name]; ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("%s:%d: undefined upstream %q", path, p.line, p.name)
+	}
+	lookup := func(p *pending) (*Route, error) {
+		r, err := lookupRoute(p)
+		if err != nil || p.timeout == 0 && p.idle == 0 && p.life == 0 && p.dscp == 0 {
+			return r, err
+		}
+		// 同一出站可以被多条规则以不同超时和标记引用，带选项的规则使用副本
+		r1 := *r
+		r1.DialTimeout, r1.IdleTimeout, r1.MaxSessionDuration, r1.DSCP = p.timeout, p.idle, p.life, p.dscp
+		return &r1, nil
+	}
+	t := &RoutingTable{named: named}
+	if t.Default, err = lookup(def); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		p := &rules[i]
+		p.rule.Source = fmt.Sprintf("%s:%d", path, p.line)
+		if p.rule.Route, err = lookup(p); err != nil {
+			return nil, err
+		}
+		t.Rules = append(t.Rules, p.rule)
+	}
+	return t, nil
+}
+
+// parseRouteDest 解析规则的目标部分：CIDR、IP、域名后缀或 *
+func parseRouteDest(s string) (RouteRule, error) {
+	if s == "*" {
+		return RouteRule{}, nil
+	}
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		return RouteRule{CIDR: unmapIPNet(ipNet)}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return RouteRule{CIDR: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	suffix := strings.ToLower(strings.TrimPrefix(s, "*"))
+	if strings.Trim(suffix, ".") == "" {
+		return RouteRule{}, fmt.Errorf("invalid destination %q", s)
+	}
+	return RouteRule{Suffix: suffix}, nil
+}
+
+// parsePortRange 解析 N 或 N-M 形式的端口范围
+func parsePortRange(s string) (uint16, uint16, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		hi = lo
+	}
+	min, err1 := strconv.ParseUint(lo, 10, 16)
+	max, err2 := strconv.ParseUint(hi, 10, 16)
+	if err1 != nil || err2 != nil || min == 0 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return uint16(min), uint16(max), nil
+}
+
+// BlocksDomain 只用域名后缀规则检查 addr（域名:端口）：第一条匹配的后缀规则为 block 时返回 true，
+// 没有后缀规则匹配时返回 false，不回落到 * 规则和 Default；用于按 SNI 再次检查以 IP 连接的目标
+func (t *RoutingTable) BlocksDomain(addr string) bool {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(h) != nil {
+		return false
+	}
+	port, _ := strconv.ParseUint(p, 10, 16)
+	h = strings.ToLower(strings.TrimSuffix(h, "."))
+	for _, r := range t.Rules {
+		if r.Suffix == "" || r.PortMin != 0 && (port < uint64(r.PortMin) || port > uint64(r.PortMax)) {
+			continue
+		}
+		if matchDomainSuffix(h, r.Suffix) {
+			return r.Route.Block
+		}
+	}
+	return false
+}
+
+// Match 返回目标地址（host:port）的出站方式
+func (t *RoutingTable) Match(addr string) *Route {
+	if i := t.matchRule(addr); i >= 0 {
+		return t.Rules[i].Route
+	}
+	return t.Default
+}
+
+// Rule 描述匹配目标地址的规则：规则的 Source，没有 Source 时为 "rule <序号>"，未匹配任何规则时为 "default"
+func (t *RoutingTable) Rule(addr string) string {
+	i := t.matchRule(addr)
+	switch {
+	case i < 0:
+		return "default"
+	case t.Rules[i].Source == "":
+		return fmt.Sprintf("rule %d", i)
+	}
+	return t.Rules[i].Source
+}
+
+// matchRule 返回第一条匹配目标地址的规则的下标，没有时返回 -1
+func (t *RoutingTable) matchRule(addr string) int {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return -1
+	}
+	port, _ := strconv.ParseUint(p, 10, 16)
+	ip := unmapIP(net.ParseIP(h))
+	h = strings.ToLower(strings.TrimSuffix(h, "."))
+	for i, r := range t.Rules {
+		if r.PortMin != 0 && (port < uint64(r.PortMin) || port > uint64(r.PortMax)) {
+			continue
+		}
+		switch {
+		case r.CIDR != nil:
+			if ip != nil && r.CIDR.Contains(ip) {
+				return i
+			}
+		case r.Suffix != "":
+			if ip == nil && matchDomainSuffix(h, r.Suffix) {
+				return i
+			}
+		default:
+			return i
+		}
+	}
+	return -1
+}
+
+// Upstreams 返回路由表中的所有命名上游，包括路由文件中定义了但没有被规则引用的
+func (t *RoutingTable) Upstreams() []*HealthCheckedUpstream {
+	list := slices.Clone(t.named)
+	for _, r := range append([]*Route{t.Default}, t.routes()...) {
+		if h, ok := r.Dialer.(*HealthCheckedUpstream); ok && !slices.Contains(list, h) {
+			list = append(list, h)
+		}
+	}
+	return list
+}
+
+// Upstream 返回名为 name 的命名上游，没有时返回 nil
+func (t *RoutingTable) Upstream(name string) *HealthCheckedUpstream {
+	for _, h := range t.Upstreams() {
+		if h.Name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// routes 返回各规则的出站方式
+func (t *RoutingTable) routes() []*Route {
+	routes := make([]*Route, 0, len(t.Rules))
+	for _, r := range t.Rules {
+		routes = append(routes, r.Route)
+	}
+	return routes
+}
+
+// supportsUDP 判断是否至少有一条路由能转发 UDP
+func (t *RoutingTable) supportsUDP() bool {
+	for _, r := range append([]*Route{t.Default}, t.routes()...) {
+		if r.Block {
+			continue
+		}
+		if u, ok := r.Dialer.(UDPCapable); !ok || u.SupportsUDP() {
+			return true
+		}
+	}
+	return false
+}
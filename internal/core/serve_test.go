@@ -0,0 +1,65 @@
+package core_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+)
+
+func TestServeReturnsNilAfterShutdown(t *testing.T) {
+	s := newTestServer(t)
+	s.Resolver = core.NewDNSResolver([]string{"127.0.0.1:1"}, "udp", time.Second)
+	// 附加的监听组件在关闭时同样不报告 Accept、Read 的错误
+	if _, err := s.ListenDNS("127.0.0.1:0", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListenTransparent("127.0.0.1:0", core.TransparentRedirect); err != nil {
+		t.Fatal(err)
+	}
+	done := startServer(t, s)
+	if s.ShuttingDown() {
+		t.Fatal("ShuttingDown() = true while serving")
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if err := waitServe(t, done); err != nil {
+		t.Fatalf("Serve after Shutdown = %v, want nil", err)
+	}
+}
+
+func TestServeReturnsListenerFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		close func(l net.Listener, pc *net.UDPConn)
+	}{
+		{"tcp", func(l net.Listener, _ *net.UDPConn) { l.Close() }},
+		{"udp", func(_ net.Listener, pc *net.UDPConn) { pc.Close() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			l, pc, err := s.Listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- s.Serve(l, pc) }()
+			<-s.Ready()
+			// 不经 Shutdown 关闭监听，Serve 报告真实的故障
+			tc.close(l, pc)
+			err = waitServe(t, done)
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("Serve = %v, want an error wrapping net.ErrClosed", err)
+			}
+			if !s.ShuttingDown() {
+				t.Fatal("ShuttingDown() = false after Serve failed")
+			}
+			if err := s.Shutdown(); err != nil {
+				t.Fatalf("Shutdown after failure = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// serveOverPipe 在 net.Pipe 的一端运行 ServeConn，client 在另一端扮演客户端，返回 ServeConn 的结果
+func serveOverPipe(t *testing.T, s *core.Server, client func(c net.Conn)) error {
+	t.Helper()
+	sc, cc := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(context.Background(), sc) }()
+	client(cc)
+	cc.Close()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("ServeConn did not return")
+		return nil
+	}
+}
+
+// pipeHello 完成无认证的方法协商
+func pipeHello(t *testing.T, c net.Conn) {
+	t.Helper()
+	if _, err := core.NewNegotiationRequest([]byte{core.MethodNone}).WriteTo(c); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := core.NewNegotiationReplyFrom(c); err != nil || r.Method != core.MethodNone {
+		t.Fatalf("negotiation reply %v, %v", r, err)
+	}
+}
+
+// pipeRequest 发送 CONNECT 请求并读取应答
+func pipeRequest(t *testing.T, c net.Conn, dst string) *core.Reply {
+	t.Helper()
+	atyp, addr, port, err := core.ParseAddress(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atyp == core.ATYPDomain {
+		addr = addr[1:]
+	}
+	if _, err := core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(c); err != nil {
+		t.Fatal(err)
+	}
+	rp, err := core.NewReplyFrom(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestServeConnPipeConnect(t *testing.T) {
+	s := newTestServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	err := serveOverPipe(t, s, func(c net.Conn) {
+		pipeHello(t, c)
+		if rp := pipeRequest(t, c, echo); rp.Rep != core.RepSuccess {
+			t.Fatalf("reply %s", core.RepName(rp.Rep))
+		}
+		socks5test.EchoTCP(t, c, []byte("over a pipe"))
+	})
+	// 客户端在转发完成后关闭连接，会话正常结束
+	if err != nil {
+		t.Fatalf("ServeConn = %v, want nil", err)
+	}
+	if n := s.Stats.Connections.Load(); n != 1 {
+		t.Fatalf("Connections = %d", n)
+	}
+}
+
+func TestServeConnPipeErrors(t *testing.T) {
+	closed := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		return l.Addr().String()
+	}()
+	for _, tc := range []struct {
+		name   string
+		auth   bool
+		client func(t *testing.T, c net.Conn)
+		check  func(err error) bool
+		is     error
+	}{
+		{
+			name: "handshake",
+			client: func(t *testing.T, c net.Conn) {
+				c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+			},
+			check: func(err error) bool { var e *core.HandshakeError; return errors.As(err, &e) },
+			is:    core.ErrVersion,
+		},
+		{
+			name: "auth",
+			auth: true,
+			client: func(t *testing.T, c net.Conn) {
+				core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+				core.NewNegotiationReplyFrom(c)
+				core.NewUserPassNegotiationRequest([]byte("eve"), []byte("guess")).WriteTo(c)
+				core.NewUserPassNegotiationReplyFrom(c)
+			},
+			check: func(err error) bool { var e *core.AuthError; return errors.As(err, &e) && e.User == "eve" },
+			is:    core.ErrUserPassAuth,
+		},
+		{
+			name: "request",
+			client: func(t *testing.T, c net.Conn) {
+				pipeHello(t, c)
+				c.Write([]byte{core.Ver, core.CmdConnect, 0x00, 0x09, 0, 0})
+				io.Copy(io.Discard, c)
+			},
+			check: func(err error) bool { var e *core.RequestError; return errors.As(err, &e) },
+		},
+		{
+			name: "dial",
+			client: func(t *testing.T, c net.Conn) {
+				pipeHello(t, c)
+				if rp := pipeRequest(t, c, closed); rp.Rep != core.RepConnectionRefused {
+					t.Errorf("reply %s", core.RepName(rp.Rep))
+				}
+			},
+			check: func(err error) bool { var e *core.DialError; return errors.As(err, &e) && e.Dst == closed },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if tc.auth {
+				s.Method = core.MethodUsernamePassword
+				s.UserName, s.Password = "admin", "secret"
+			}
+			err := serveOverPipe(t, s, func(c net.Conn) { tc.client(t, c) })
+			if err == nil || !tc.check(err) {
+				t.Fatalf("ServeConn = %T %v", err, err)
+			}
+			if tc.is != nil && !errors.Is(err, tc.is) {
+				t.Fatalf("ServeConn = %v, want it to wrap %v", err, tc.is)
+			}
+		})
+	}
+}
+
+func TestServeConnContextCancel(t *testing.T) {
+	s := newTestServer(t)
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(ctx, sc) }()
+	// 客户端不发送任何数据，取消 ctx 后 ServeConn 立即关闭连接返回
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeConn ignored the cancelled context")
+	}
+	if _, err := cc.Read(make([]byte, 1)); err == nil {
+		t.Fatal("pipe still open after ServeConn returned")
+	}
+}
@@ -1,18 +1,20 @@
 package core
 
 import (
-	"encoding/binary"
+	"cmp"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/txthinking/runnergroup"
 )
 
 var (
@@ -20,26 +22,27 @@ var (
 	ErrUnsupportCmd = errors.New("Unsupport Command")
 	// ErrUserPassAuth is the error when got invalid username or password
 	ErrUserPassAuth = errors.New("Invalid Username or Password for Auth")
+	// ErrUnsupportMethod is the error when none of the client's methods is acceptable
+	ErrUnsupportMethod = errors.New("Unsupport Method")
+	// ErrNotWhitelisted is the error when the client IP is not in the whitelist
+	ErrNotWhitelisted = errors.New("not in whitelist")
+	// ErrIdleTimeout is the error when a relayed connection receives nothing within TCPTimeout
+	ErrIdleTimeout = errors.New("idle timeout")
+	// ErrWriteStall is the error when a relayed peer stops reading and a write does not finish within TCPTimeout
+	ErrWriteStall = errors.New("write stalled")
 )
 
-// tcpBufPool 32KB buffer for TCP copy
-var tcpBufPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 32*1024)
-	},
-}
+// DefaultHandshakeTimeout 客户端完成协商和发送请求的默认时限，防止不发数据的连接一直占用协程和文件描述符
+const DefaultHandshakeTimeout = 10 * time.Second
 
-// udpBufPool 64KB buffer for UDP packets
-var udpBufPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 65507)
-	},
-}
+// udpWriteTimeout UDP 应答写入客户端的最长等待时间，超时的数据报被丢弃
+const udpWriteTimeout = 5 * time.Second
 
 // Server is socks5 server wrapper
 type Server struct {
 	UserName          string
 	Password          string
+	Auth              Authenticator // 非 nil 时代替 UserName/Password 校验用户名密码认证，Method 需为 MethodUsernamePassword
 	Method            byte
 	SupportedCommands []byte
 	Addr              string
@@ -48,30 +51,318 @@ type Server struct {
 	UDPExchanges      *sync.Map
 	TCPTimeout        int
 	UDPTimeout        int
+	DialTimeout       int               // 出站拨号超时（秒），0 表示不限制
+	Dialer            Dialer            // 出站拨号器，nil 表示直接连接目标
+	Resolver          Resolver          // 直接连接和解析 UDP 客户端地址时使用，NewClassicServer 默认为 net.DefaultResolver
+	Hosts             *Hosts            // 直接连接时在 DNS 之前查询的静态映射
+	ResolveTimeout    time.Duration     // 非 0 时目标域名的一次解析（含解析器的重试）最多这么久，超过时请求以 REP 0x06 失败
+	NegativeCache     *NegativeCache    // 非 nil 时缓存目标域名解析的失败结果并合并并发的解析
+	NAT64             *NAT64            // 直接连接时经 NAT64 前缀访问 IPv4 目标
+	Family            AddrFamily        // 直接连接时的地址族策略
+	Egress            *EgressTable      // 直接连接时按目标选择本地源地址
+	Routes            *RoutingTable     // 按目标选择直连、上游或拒绝，非 nil 时 Dialer 不再使用
+	AccessLog         *AccessLogger     // 访问日志，nil 表示不记录
+	TopDestinations   *TopDestinations  // 非 nil 时按目标主机累计转发的字节数和连接数
+	Rewrite           RewriteFunc       // 改写 CONNECT 和 UDP 数据报的目标，nil 表示不改写
+	Bandwidth         *BandwidthLimiter // 所有 TCP 转发和 UDP 交换共享的总速率上限，nil 表示不限速
 	Handle            Handler
-	AssociatedUDP     *sync.Map
+	AssociatedUDP     *sync.Map // 客户端 UDP 地址 -> *UDPAssociation
 	UDPSrc            *sync.Map
-	RunnerGroup       *runnergroup.RunnerGroup
 	LimitUDP          bool
+	TLSConfig         *tls.Config // 非 nil 时 SOCKS 控制连接运行在 TLS 之上
+	// Certs TLSConfig 使用的证书来源，非 nil 时 StatsSnapshot 报告证书的剩余有效天数
+	Certs CertProvider
+	// 双向 TLS 下已验证证书的客户端默认免密码，为 true 时仍需用户名密码认证
+	RequirePasswordWithCert bool
+	// CommandAuth 非 nil 时按命令决定是否要求认证，在请求解析后检查，未认证的连接发送要求认证的命令时以 REP 0x02 拒绝，
+	// 未列出的命令要求认证；Method 为 MethodUsernamePassword 且有命令为 AuthNone 时，客户端提供用户名密码方法仍优先使用，
+	// 只提供无认证方法的客户端以无认证继续协商
+	CommandAuth map[byte]AuthRequirement
+	// OnDialError 出站拨号失败时在该连接的 goroutine 上调用，rep 为映射后的应答码（UDP 不发送应答，仅供分类）
+	// sess 在无会话的连接或未关联的数据报上为 nil，回调会阻塞该连接，应尽快返回
+	OnDialError func(sess *Session, dst string, rep byte, err error, elapsed time.Duration)
+	// OnUDPExchangeClose 非 nil 时在 UDP 交换结束时以它最终的计数调用，sess 为所属关联的会话（可为 nil），
+	// 在交换的读协程中调用，不应阻塞
+	OnUDPExchangeClose func(sess *Session, st UDPExchangeStats)
+	// MaxSessionDuration 非 0 时每个 TCP 转发和 UDP 关联最多存续这么久，到期后先半关闭、宽限期后强制关闭
+	MaxSessionDuration time.Duration
+	// IdleReapAfter 非 0 时后台定期扫描进行中的 TCP 转发，两个方向都这么久没有转发字节的被关闭并计入 Stats.IdleReaped，
+	// 不依赖 TCPTimeout 的读写截止时间，默认不启用
+	IdleReapAfter time.Duration
+	// UserMaxSessionDuration 按用户设置的最长存续时间，与 MaxSessionDuration 和路由规则的 lifetime= 选项一起取最小值
+	UserMaxSessionDuration map[string]time.Duration
+	// HandshakeTimeout 非 0 时 TLS 握手、方法协商、用户名密码子协商和读取请求必须在这么久之内完成，
+	// 否则断开连接并计入 Stats.HandshakeTimeouts，NewClassicServer 默认为 DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+	// RequestTimeout 非 0 时 DefaultHandle 处理 CONNECT 请求的域名解析和拨号合计最多这么久，到期时应答 RepTTLExpired，
+	// 到期后才建立的连接不再使用；与 DialTimeout 和路由规则的 timeout 选项同时生效，取先到期的，不限制之后的转发
+	RequestTimeout time.Duration
+	// UDPPacketRate 非零时每个 UDP 关联发往远端的数据报按令牌桶限速，超过的在转发前丢弃并计入 Stats.UDPRateLimited
+	// 和关联的计数，远端的应答不受限制；UserUDPPacketRate 非 nil 且对关联的用户返回 true 时以它的结果代替
+	UDPPacketRate     PacketRate
+	UserUDPPacketRate func(user string) (PacketRate, bool)
+	// UDPMatchIPOnly 为 true 时，声明端口为 0 的关联学习到来源后，同一 IP 其他端口的数据报也属于该关联，
+	// 默认要求 IP 和端口都一致
+	UDPMatchIPOnly bool
+	// UDPRequireAssociatedIP 为 true 时，来源 IP 当前没有任何 UDP 关联的数据报被丢弃并计入 Stats.UDPUnassociated，
+	// 端口不必一致；LimitUDP 为 false 时防止任何主机不经 TCP 握手就借中继向任意目标发包
+	UDPRequireAssociatedIP bool
+	// AllowBadUserPassVersion 为 true 时用户名密码子协商的版本字节也接受 0x05，应答仍为 0x01，
+	// 出现次数计入 Stats.BadUserPassVersion；默认只接受 RFC 1929 规定的 0x01
+	AllowBadUserPassVersion bool
+	// HandshakeLogWindow、HandshakeLogThreshold 控制 LogServeError 对协商和请求阶段错误的聚合：
+	// 每个来源 IP 每个窗口只逐条输出前 HandshakeLogThreshold 条，为 0 时分别使用
+	// DefaultHandshakeLogWindow、DefaultHandshakeLogThreshold，阈值为负数时全部汇总
+	HandshakeLogWindow    time.Duration
+	HandshakeLogThreshold int
+	// ErrorLogSampler 非 nil 时拨号失败按目标、认证失败按来源 IP 抽样输出，见 ErrorLogSampler
+	ErrorLogSampler *ErrorLogSampler
+	// Bans 非 nil 时反复发送非 SOCKS5 数据或格式错误请求（ErrVersion、ErrBadRequest）的来源被暂时封禁，
+	// 封禁期间的连接直接关闭并计入 Stats.BannedConns；BanExemptWhitelisted 为 true 时白名单中的来源不会被封禁
+	Bans                 *BanTable
+	BanExemptWhitelisted bool
+	// Tarpit 非 nil 时不在白名单中或处于封禁中的连接先被拖住一段时间再关闭，Shutdown 时立即关闭
+	Tarpit *Tarpit
+	// SNIPeek 非 0 时 CONNECT 转发开始前最多等这么久读取客户端的第一个 TLS 记录，取出 ClientHello 中的 SNI
+	// 记入 Session.SNI 和访问日志，读到的字节随后原样转发；非 TLS 流量和超时不受影响，最多延迟首个上行数据 SNIPeek
+	SNIPeek time.Duration
+	// SNIFilter 非 nil 时对取到的 SNI 再检查一次，参数为 SNI:目标端口，形式与 DestinationFilter 的 allow 相同；
+	// 返回 false 时不转发 ClientHello，直接关闭连接（应答已经发出）
+	SNIFilter func(addr string) bool
+	// HTTPHostPeek 非 0 时目标端口在 HTTPHostPorts（nil 表示只有 80）中的 CONNECT 转发开始前最多等这么久读取
+	// 第一个 HTTP 请求头，取出 Host 记入 Session.HTTPHost 和访问日志（没有取到时记为 "-"），读到的字节随后原样转发
+	HTTPHostPeek  time.Duration
+	HTTPHostPorts []uint16
+	// HostFilter 与 SNIFilter 相同，检查的是取到的 HTTP Host
+	HostFilter func(addr string) bool
+	// DSCP 非 0 时为直连的出站套接字和 UDP 套接字设置 IP_TOS/IPV6_TCLASS（DSCP 左移两位），
+	// 路由规则的 dscp= 选项和 UserDSCP 中的用户（优先）覆盖出站套接字的值；平台不支持时记录一次警告，流量不带标记
+	DSCP     int
+	UserDSCP map[string]int
+	// LocalPorts 非 nil 时直连的出站 TCP 和 UDP 套接字只使用该范围内的本地端口，范围耗尽的请求以 REP 0x01 失败，
+	// 使用情况见 StatsSnapshot
+	LocalPorts *PortRange
+	// AllowedEgress 非 nil 时直连只经允许的本地接口和地址出站，其余目标以 REP 0x03 拒绝，Egress 中的地址同样受限
+	AllowedEgress *EgressAllowlist
+	// Quotas 非 nil 时按用户统计每个周期的流量，用完配额的用户的新请求以 REP 0x02 拒绝
+	Quotas *QuotaTable
+	// LoadShed 非 nil 时文件描述符用量过高期间新请求以 REP 0x01 拒绝，计入 Stats.LoadShedRequests
+	LoadShed *LoadShedder
+	// Audit 非 nil 时记录认证成败、白名单和访问控制拒绝等安全相关事件，封禁和解除由 BanTable.Audit 记录
+	Audit *AuditLogger
+	// Trace 非 nil 时把匹配客户端的握手字节记录到文件，见 TraceRecorder
+	Trace *TraceRecorder
+	// Faults 非 nil 时注入延迟、失败应答、UDP 丢包和转发中断，用于弹性测试，见 FaultInjector
+	Faults *FaultInjector
+	// ListenMPTCP、DialMPTCP 为 true 时 Listen、ListenProfile 的 TCP 监听和直连的出站连接使用 Multipath TCP，
+	// 内核或对端不支持时自动退回普通 TCP；Debug 时记录每个连接是否实际使用了 MPTCP
+	ListenMPTCP bool
+	DialMPTCP   bool
+	// FastOpenQueue 非 0 时 Listen、ListenProfile 的 TCP 监听启用 TCP Fast Open，值为等待完成握手的 TFO 连接队列长度；
+	// DialFastOpen 为 true 时直连的出站 TCP 连接尝试 TFO。仅 Linux，内核拒绝时记录警告并使用普通 TCP
+	FastOpenQueue int
+	DialFastOpen  bool
+	// ClientKeepAlive 非 nil 时接受客户端连接后立即按它设置 TCP keepalive，与出站拨号器的 keepalive 无关；
+	// nil 保持 Go 对接受的连接的默认设置。UDP 关联的控制连接随后改用 controlKeepAlive
+	ClientKeepAlive *net.KeepAliveConfig
+	// Stats 累计计数
+	Stats ServerStats
 
-	// 白名单优化：支持精确IP和CIDR网段
+	// 白名单优化：支持精确IP和CIDR网段，运行时替换请使用 SetWhitelist
 	AllowedIPs   map[string]struct{}
 	AllowedCIDRs []*net.IPNet
+	whitelistMu  sync.RWMutex // 同时保护黑名单
+	deniedIPs    map[string]struct{}
+	deniedCIDRs  []*net.IPNet
+
+	// ListenProfile 登记的附加监听器
+	profilesMu sync.Mutex
+	profiles   []*Profile
+
+	// 缓冲池和 UDP 处理协程，nil 时在首次使用时创建私有的一份，多个 Server 可共享同一个
+	Resources   *Resources
+	resOnce     sync.Once
+	udpInflight sync.WaitGroup // 已入队、尚未处理完的数据报
+	udpLearn    udpLearning    // 等待学习来源地址的 UDP 关联
+	udpIPs      udpAssocIPs    // 持有 UDP 关联的客户端 IP
+
+	hsLog     *ErrorLogSampler // 协商和请求阶段错误的聚合，见 handshakeSampler
+	hsLogOnce sync.Once
+	relays    relayRegistry // 进行中的 TCP 转发和 UDP 关联
+	active    atomic.Int64  // 进行中的客户端连接，见 ActiveConns
 
-	// UDP 并发处理通道
-	udpWorkCh chan *udpTask
+	// 实际绑定的地址，Serve 开始后有效
+	addrMu    sync.RWMutex
+	tcpAddr   net.Addr
+	udpAddr   net.Addr
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// 服务端生命周期，Shutdown 时取消
+	ctxOnce sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// 中间件，首次处理请求时套在 Handle 外层
+	middleware []Middleware
+	chainOnce  sync.Once
+	chained    Handler
+
+	// 关闭钩子，见 RegisterOnShutdown
+	hooks shutdownHooks
+	// Serve 启动、Shutdown 按阶段停止的组件，见 AddComponent
+	lc lifecycle
 }
 
 // udpTask 封装 UDP 处理任务
 type udpTask struct {
+	s    *Server
 	addr *net.UDPAddr
 	buf  []byte
 	n    int
 }
 
+// UDPExchange 客户端到一个远端的 UDP 交换，在 UDPExchanges 中以客户端地址加远端地址为键，
+// 直连时远端地址取拨号后的实际地址，域名和字面量形式的目标作为别名指向同一个交换
 type UDPExchange struct {
 	ClientAddr *net.UDPAddr
 	RemoteConn net.Conn
+	// Dst 客户端第一次请求的目标
+	Dst string
+
+	udpExchangeCounters
+
+	mu     sync.Mutex
+	keys   []string
+	closed bool
+}
+
+// register 以 key 将交换登记到 m，交换已结束时返回 false
+func (ue *UDPExchange) register(m *sync.Map, key string) bool {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	if ue.closed {
+		return false
+	}
+	ue.keys = append(ue.keys, key)
+	m.Store(key, ue)
+	return true
+}
+
+// unregister 从 m 删除交换的所有键，已被新交换替换的键保持不变
+func (ue *UDPExchange) unregister(m *sync.Map) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	ue.closed = true
+	for _, k := range ue.keys {
+		m.CompareAndDelete(k, ue)
+	}
+	ue.keys = nil
+}
+
+// udpExchangeKey 返回以远端实际地址归一化的交换键，远端不是 UDP 地址时返回 key
+func udpExchangeKey(src string, remote net.Addr, key string) string {
+	ua, ok := remote.(*net.UDPAddr)
+	if !ok {
+		return key
+	}
+	ap := ua.AddrPort()
+	return src + netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+}
+
+// UDPAssociation 一个 UDP ASSOCIATE 关联，控制连接结束时 Done 关闭且只关闭一次，
+// 该客户端地址的数据报发送随即失败，所属交换的读协程立即退出
+type UDPAssociation struct {
+	Session *Session // 控制连接的会话，非 ServeConn 建立的连接为 nil
+
+	// clientAddr 客户端声明端口为 0 时在关联发布之后才学习到，读写都经原子操作
+	clientAddr atomic.Pointer[net.UDPAddr]
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	active    atomic.Int64 // 最近一次活动（数据报或控制连接数据）的 UnixNano
+	exchanges udpAssocExchanges
+	limiter   *packetLimiter // 关联建立时按用户选定的数据报速率上限，nil 表示不限制
+	limited   atomic.Int64   // 超过速率上限而丢弃的数据报
+}
+
+// ClientAddr 返回客户端的 UDP 地址，客户端声明端口为 0 时在收到该 IP 的第一个数据报之前为 nil
+func (a *UDPAssociation) ClientAddr() *net.UDPAddr {
+	return a.clientAddr.Load()
+}
+
+// LastActive 返回关联最近一次收发数据报或控制连接收到数据的时间
+func (a *UDPAssociation) LastActive() time.Time {
+	return time.Unix(0, a.active.Load())
+}
+
+func (a *UDPAssociation) touch() {
+	a.active.Store(time.Now().UnixNano())
+}
+
+// Done 返回关联结束时关闭的通道
+func (a *UDPAssociation) Done() <-chan struct{} {
+	return a.ctx.Done()
+}
+
+// Closed 报告关联是否已结束
+func (a *UDPAssociation) Closed() bool {
+	return a.ctx.Err() != nil
+}
+
+// controlKeepAlive UDP 关联控制连接的 TCP keepalive，无 FIN 消失的客户端约 1 分钟后被发现
+var controlKeepAlive = net.KeepAliveConfig{Enable: true, Idle: 15 * time.Second, Interval: 15 * time.Second, Count: 4}
+
+// holdAssociation 保持关联直到控制连接关闭，期间客户端发送的数据被丢弃
+// 控制连接开启 TCP keepalive；UDPTimeout 非 0 时关联在这么久内既无数据报往来、控制连接也未收到数据则返回 ErrIdleTimeout
+func (s *Server) holdAssociation(c net.Conn, a *UDPAssociation) error {
+	if tc, ok := underlyingConn(c).(*net.TCPConn); ok {
+		tc.SetKeepAliveConfig(controlKeepAlive)
+	}
+	timeout := time.Duration(s.UDPTimeout) * time.Second
+	if sess := SessionFromConn(c); sess != nil && timeout > 0 {
+		sess.setIdleTimeout(timeout)
+	}
+	a.touch()
+	b := make([]byte, 512)
+	for {
+		if timeout > 0 {
+			c.SetReadDeadline(a.LastActive().Add(timeout))
+		}
+		_, err := c.Read(b)
+		switch {
+		case err == nil:
+			a.touch()
+		case !isTimeout(err):
+			return nil
+		case time.Since(a.LastActive()) >= timeout:
+			return ErrIdleTimeout
+		}
+	}
+}
+
+// underlyingConn 去掉 Session 和 TLS 的包装，返回底层的传输层连接
+func underlyingConn(c net.Conn) net.Conn {
+	for {
+		switch x := c.(type) {
+		case *Session:
+			c = x.Conn
+		case *tls.Conn:
+			c = x.NetConn()
+		case *traceConn:
+			c = x.Conn
+		default:
+			return c
+		}
+	}
+}
+
+// association 返回客户端 UDP 地址所属的关联，未关联时尝试学习声明端口为 0 的关联，都没有时返回 nil
+func (s *Server) association(addr *net.UDPAddr) *UDPAssociation {
+	if v, ok := s.AssociatedUDP.Load(addr.String()); ok {
+		return v.(*UDPAssociation)
+	}
+	return s.learnAssociation(addr)
 }
 
 func NewClassicServer(addr, ip, username, password string, tcpTimeout, udpTimeout int, whiteList []string) (*Server, error) {
@@ -79,38 +370,19 @@ func NewClassicServer(addr, ip, username, password string, tcpTimeout, udpTimeou
 	if err != nil {
 		return nil, err
 	}
-	saddr, err := Resolve("udp", net.JoinHostPort(ip, p))
+	saddr, err := resolveUDPAddr(context.Background(), net.DefaultResolver, net.JoinHostPort(ip, p))
 	if err != nil {
 		return nil, err
 	}
+	if err := validateCredential(username, password); err != nil {
+		return nil, err
+	}
 	m := MethodNone
 	if username != "" && password != "" {
 		m = MethodUsernamePassword
 	}
 
-	// 解析白名单：区分普通IP和CIDR网段
-	allowedIPs := make(map[string]struct{})
-	var allowedCIDRs []*net.IPNet
-
-	for _, s := range whiteList {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
-		}
-		// 尝试解析为 CIDR (e.g. 192.168.1.0/24)
-		_, ipNet, err := net.ParseCIDR(s)
-		if err == nil {
-			allowedCIDRs = append(allowedCIDRs, ipNet)
-			continue
-		}
-		// 尝试解析为普通 IP (e.g. 1.2.3.4)
-		ip := net.ParseIP(s)
-		if ip != nil {
-			allowedIPs[ip.String()] = struct{}{}
-			continue
-		}
-		log.Printf("Warning: Invalid whitelist entry skipped: %s", s)
-	}
+	allowedIPs, allowedCIDRs := ParseWhitelist(whiteList)
 
 	s := &Server{
 		Method:            m,
@@ -122,23 +394,77 @@ func NewClassicServer(addr, ip, username, password string, tcpTimeout, udpTimeou
 		UDPExchanges:      &sync.Map{},
 		TCPTimeout:        tcpTimeout,
 		UDPTimeout:        udpTimeout,
+		DialTimeout:       int(DefaultDialTimeout / time.Second),
+		HandshakeTimeout:  DefaultHandshakeTimeout,
+		Resolver:          net.DefaultResolver,
 		AssociatedUDP:     &sync.Map{},
 		UDPSrc:            &sync.Map{},
-		RunnerGroup:       runnergroup.New(),
 		AllowedIPs:        allowedIPs,
 		AllowedCIDRs:      allowedCIDRs,
-		udpWorkCh:         make(chan *udpTask, 5000), // 缓冲区大小可调整
+		ready:             make(chan struct{}),
 	}
 	return s, nil
 }
 
+// ParseWhitelist 解析白名单条目：区分普通IP和CIDR网段，无效条目记录警告后跳过
+func ParseWhitelist(whiteList []string) (map[string]struct{}, []*net.IPNet) {
+	return parseIPList("whitelist", whiteList)
+}
+
+// parseIPList 解析 IP 和 CIDR 条目，kind 用于无效条目的警告
+func parseIPList(kind string, list []string) (map[string]struct{}, []*net.IPNet) {
+	ips := make(map[string]struct{})
+	var cidrs []*net.IPNet
+
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		// CIDR (e.g. 192.168.1.0/24) 或普通 IP (e.g. 1.2.3.4)，IPv4-mapped 地址按 IPv4 记录
+		if p, single, ok := parseIPEntry(s); ok {
+			if single {
+				ips[p.Addr().String()] = struct{}{}
+			} else {
+				cidrs = append(cidrs, prefixIPNet(p))
+			}
+			continue
+		}
+		log.Printf("Warning: Invalid %s entry skipped: %s", kind, s)
+	}
+	return ips, cidrs
+}
+
+// SetWhitelist 在运行时原子替换白名单，正在进行的连接不受影响
+func (s *Server) SetWhitelist(whiteList []string) {
+	allowedIPs, allowedCIDRs := ParseWhitelist(whiteList)
+	s.whitelistMu.Lock()
+	s.AllowedIPs = allowedIPs
+	s.AllowedCIDRs = allowedCIDRs
+	s.whitelistMu.Unlock()
+}
+
+// inWhitelist 检查 IP 是否明确列在白名单中，没有设置白名单时返回 false
+func (s *Server) inWhitelist(ip net.IP) bool {
+	s.whitelistMu.RLock()
+	empty := len(s.AllowedIPs) == 0 && len(s.AllowedCIDRs) == 0
+	s.whitelistMu.RUnlock()
+	return !empty && s.IsAllowed(ip)
+}
+
 // IsAllowed 检查 IP 是否在白名单中
 func (s *Server) IsAllowed(ip net.IP) bool {
+	s.whitelistMu.RLock()
+	defer s.whitelistMu.RUnlock()
+
 	// 如果没有设置白名单，默认允许所有
 	if len(s.AllowedIPs) == 0 && len(s.AllowedCIDRs) == 0 {
 		return true
 	}
 
+	// 双栈监听时 IPv4 客户端可能以 ::ffff:a.b.c.d 出现，统一按 IPv4 比较
+	ip = unmapIP(ip)
+
 	// 1. 精确匹配 (O(1))
 	if _, ok := s.AllowedIPs[ip.String()]; ok {
 		return true
@@ -153,210 +479,616 @@ func (s *Server) IsAllowed(ip net.IP) bool {
 	return false
 }
 
+// Negotiate 完成认证方法协商和子协商，错误为 *HandshakeError 或 *AuthError
+// rw 为 *Session 时认证结果记录在其中，其他 net.Conn 和 io.ReadWriter 先包装为新的会话，协商的结果随之丢弃
 func (s *Server) Negotiate(rw io.ReadWriter) error {
-	rq, err := NewNegotiationRequestFrom(rw)
+	sess, ok := rw.(*Session)
+	if !ok {
+		c, ok := rw.(net.Conn)
+		if !ok {
+			c = rwConn{rw}
+		}
+		sess = NewSession(c)
+	}
+	return s.negotiate(sess)
+}
+
+// negotiate 在 sess 上完成协商，已验证客户端证书的会话在未要求密码时协商为 MethodNone
+func (s *Server) negotiate(sess *Session) error {
+	client := sess.RemoteAddr()
+	handshakeErr := func(err error) error {
+		return &HandshakeError{clientError{Client: client, Err: err}}
+	}
+	rq, err := NewNegotiationRequestFrom(sess)
 	if err != nil {
-		return err
+		return handshakeErr(err)
 	}
-	var got bool
-	var m byte
-	for _, m = range rq.Methods {
-		if m == s.Method {
-			got = true
-		}
+	p := sess.Profile
+	method := s.method(p)
+	if sess.CertUser != "" && !s.RequirePasswordWithCert {
+		method = MethodNone
+	}
+	// 认证要求按命令区分时，命令要到请求阶段才知道，不提供用户名密码方法的客户端先以无认证继续
+	if method == MethodUsernamePassword && s.allowsAnonymous() && !slices.Contains(rq.Methods, method) {
+		method = MethodNone
 	}
-	if !got {
+	// 蜜罐接受任何客户端：不支持用户名密码认证时退回无认证
+	if _, ok := s.auth(p).(*HoneypotCredentials); ok && method == MethodUsernamePassword && !slices.Contains(rq.Methods, method) {
+		method = MethodNone
+	}
+	if !slices.Contains(rq.Methods, method) {
+		sess.rejected.Store(true)
 		rp := NewNegotiationReply(MethodUnsupportAll)
-		if _, err := rp.WriteTo(rw); err != nil {
-			return err
+		if _, err := rp.WriteTo(sess); err != nil {
+			return handshakeErr(err)
 		}
+		return handshakeErr(ErrUnsupportMethod)
 	}
-	rp := NewNegotiationReply(s.Method)
-	if _, err := rp.WriteTo(rw); err != nil {
-		return err
+	rp := NewNegotiationReply(method)
+	if _, err := rp.WriteTo(sess); err != nil {
+		return handshakeErr(err)
 	}
+	sess.Method = method
+	sess.User = sess.CertUser
 
-	if s.Method == MethodUsernamePassword {
-		urq, err := NewUserPassNegotiationRequestFrom(rw)
+	if method == MethodUsernamePassword {
+		urq, err := newUserPassNegotiationRequestFrom(sess, s.AllowBadUserPassVersion)
 		if err != nil {
-			return err
+			return handshakeErr(err)
+		}
+		if urq.Ver != UserPassVer {
+			s.Stats.BadUserPassVersion.Add(1)
 		}
-		if string(urq.Uname) != s.UserName || string(urq.Passwd) != s.Password {
+		if !s.authenticate(sess, string(urq.Uname), string(urq.Passwd)) {
+			sess.rejected.Store(true)
 			urp := NewUserPassNegotiationReply(UserPassStatusFailure)
-			if _, err := urp.WriteTo(rw); err != nil {
-				return err
+			if _, err := urp.WriteTo(sess); err != nil {
+				return handshakeErr(err)
 			}
-			return ErrUserPassAuth
+			s.Audit.event(AuditAuthFailure, client, string(urq.Uname), "", "", "")
+			return &AuthError{clientError: clientError{Client: client, Err: ErrUserPassAuth}, User: string(urq.Uname)}
 		}
 		urp := NewUserPassNegotiationReply(UserPassStatusSuccess)
-		if _, err := urp.WriteTo(rw); err != nil {
-			return err
+		if _, err := urp.WriteTo(sess); err != nil {
+			return handshakeErr(err)
+		}
+		if sess.User == "" {
+			sess.User = string(urq.Uname)
+		}
+		detail := ""
+		if until, ok := sess.Meta.Get(PreviousPasswordKey); ok {
+			detail = fmt.Sprintf("previous password, valid until %v", until)
 		}
+		s.Audit.event(AuditAuthSuccess, client, string(urq.Uname), "", "", detail)
+	} else if sess.CertUser != "" {
+		s.Audit.event(AuditAuthSuccess, client, sess.CertUser, "", "", "certificate")
 	}
 	return nil
 }
 
+// authenticate 校验用户名密码：Auth 非 nil 时交给它（实现了 SessionAuthenticator 时调用 AuthenticateSession），
+// 否则与 UserName、Password 比较；附加监听器接受的会话使用其 Profile 的设置
+func (s *Server) authenticate(sess *Session, user, password string) bool {
+	auth, name, pass := s.Auth, s.UserName, s.Password
+	if p := sess.Profile; p != nil {
+		auth, name, pass = p.Auth, p.UserName, p.Password
+	}
+	if sa, ok := auth.(SessionAuthenticator); ok {
+		return sa.AuthenticateSession(sess, user, password)
+	}
+	if auth != nil {
+		return auth.Authenticate(user, password)
+	}
+	return user == name && password == pass
+}
+
+// GetRequest 读取请求，不支持的命令以 REP 0x07 应答，错误为 *RequestError
+// rw 为附加监听器接受的 *Session 时按其 Profile 的 SupportedCommands 检查
 func (s *Server) GetRequest(rw io.ReadWriter) (*Request, error) {
 	r, err := NewRequestFrom(rw)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{clientError{Client: clientAddr(rw), Err: err}}
 	}
-	var supported bool
-	if slices.Contains(s.SupportedCommands, r.Cmd) {
-		supported = true
-	}
-	if !supported {
-		var p *Reply
-		if r.Atyp == ATYPIPv4 || r.Atyp == ATYPDomain {
-			p = NewReply(RepCommandNotSupported, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
-		} else {
-			p = NewReply(RepCommandNotSupported, ATYPIPv6, []byte(net.IPv6zero), []byte{0x00, 0x00})
-		}
-		if _, err := p.WriteTo(rw); err != nil {
-			return nil, err
+	sess, _ := rw.(*Session)
+	if !slices.Contains(s.commands(profileOf(sess)), r.Cmd) {
+		if err := r.Reject(rw, RepCommandNotSupported); err != nil {
+			return nil, &RequestError{clientError{Client: clientAddr(rw), Err: err}}
 		}
-		return nil, ErrUnsupportCmd
+		return nil, &RequestError{clientError{Client: clientAddr(rw), Err: fmt.Errorf("%s: %w", CmdName(r.Cmd), ErrUnsupportCmd)}}
 	}
 	return r, nil
 }
 
+// ListenAndServe 绑定 TCP 和 UDP 监听后开始服务，h 为 nil 时使用 DefaultHandle
 func (s *Server) ListenAndServe(h Handler) error {
-	if h == nil {
-		s.Handle = &DefaultHandle{}
-	} else {
+	if h != nil {
 		s.Handle = h
 	}
-	addr, err := net.ResolveTCPAddr("tcp", s.Addr)
+	l, pc, err := s.Listen()
 	if err != nil {
 		return err
 	}
-	l, err := net.ListenTCP("tcp", addr)
+	return s.Serve(l, pc)
+}
+
+// Listen 绑定 TCP 监听和 UDP 套接字但不开始服务，便于在 Serve 之前降权等操作
+// SupportedCommands 不含 CmdUDP 时不绑定 UDP 套接字，返回的 *net.UDPConn 为 nil
+func (s *Server) Listen() (*net.TCPListener, *net.UDPConn, error) {
+	l, err := s.listenTCP(s.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(s.SupportedCommands, CmdUDP) {
+		return l, nil, nil
+	}
+	pc, err := s.listenUDP()
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	s.markUDP(pc)
+	return l, pc, nil
+}
+
+// listenTCP 绑定 TCP 监听，ListenMPTCP 为 true 时启用 Multipath TCP，FastOpenQueue 非 0 时启用 TCP Fast Open
+func (s *Server) listenTCP(addr string) (*net.TCPListener, error) {
+	var lc net.ListenConfig
+	lc.SetMultipathTCP(s.ListenMPTCP)
+	if s.FastOpenQueue > 0 {
+		lc.Control = fastOpenListenControl(s.FastOpenQueue)
+	}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
 	if err != nil {
+		return nil, err
+	}
+	return l.(*net.TCPListener), nil
+}
+
+// listenUDP 绑定 UDP 套接字：Addr 未指定 IP 而 ServerAddr 指定了 IP 时绑定到该 IP，
+// 多网卡主机上应答数据报的源地址因此与 ASSOCIATE 应答中通告的地址一致；
+// 该 IP 不在本机（如 1:1 NAT 后的公网地址）时记录警告并退回到 Addr
+func (s *Server) listenUDP() (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sa, ok := s.ServerAddr.(*net.UDPAddr)
+	if ok && !specificIP(addr.IP) && specificIP(sa.IP) {
+		pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: sa.IP, Port: addr.Port, Zone: sa.Zone})
+		if err == nil {
+			return pc, nil
+		}
+		log.Printf("Warning: cannot bind UDP to advertised address %s (%v), binding %s instead; replies may come from a different source IP", sa.IP, err, addr)
+	}
+	return net.ListenUDP("udp", addr)
+}
+
+// specificIP 报告 ip 是否为具体地址，nil 和 0.0.0.0、:: 返回 false
+func specificIP(ip net.IP) bool {
+	return len(ip) != 0 && !ip.IsUnspecified()
+}
+
+// Serve 在已绑定的 TCP 监听和 UDP 套接字上提供服务，阻塞直到出错或 Shutdown，Shutdown 引起的返回为 nil
+// 设置了 TLSConfig 时，TCP 监听会先包装为 TLS；pc 为 nil 时不提供 UDP 转发
+// 已经调用过 Serve 时立即返回 ErrAlreadyServing，不改变服务端的状态，l 和 pc 由调用方关闭
+func (s *Server) Serve(l net.Listener, pc *net.UDPConn) error {
+	if err := s.lc.claim(); err != nil {
 		return err
 	}
-	s.RunnerGroup.Add(&runnergroup.Runner{
-		Start: func() error {
+	s.hooks.serving.Add(1)
+	defer s.hooks.serving.Done()
+	if s.Handle == nil {
+		s.Handle = &DefaultHandle{}
+	}
+	s.UDPConn = pc
+	var udpAddr net.Addr
+	if pc != nil {
+		udpAddr = pc.LocalAddr()
+	}
+	s.setBoundAddrs(l.Addr(), udpAddr)
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	s.AddComponent(Component{
+		Name:  "tcp",
+		Stage: StageAccept,
+		Start: func(ctx context.Context) error {
 			for {
-				c, err := l.AcceptTCP()
+				c, err := l.Accept()
 				if err != nil {
-					return err
+					return s.serveErr(err)
 				}
-				go func(c *net.TCPConn) {
-					defer c.Close()
-					// 优化：TCP 连接入口检查白名单
-					clientIP := c.RemoteAddr().(*net.TCPAddr).IP
-					if !s.IsAllowed(clientIP) {
-						log.Printf("TCP Connection rejected from %s (not in whitelist)", clientIP)
-						return
+				go func() {
+					if err := s.ServeConn(ctx, c); err != nil {
+						s.LogServeError(err)
 					}
-
-					if err := s.Negotiate(c); err != nil {
-						return
-					}
-					r, err := s.GetRequest(c)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					if err := s.Handle.TCPHandle(s, c, r); err != nil {
-						log.Println(err)
-					}
-				}(c)
+				}()
 			}
 		},
-		Stop: func() error {
-			return l.Close()
-		},
+		Stop: l.Close,
 	})
 
-	addr1, err := net.ResolveUDPAddr("udp", s.Addr)
+	if pc != nil {
+		res := s.res()
+		// 关闭顺序：先以读截止时间结束读循环（只有它向队列发送，不会发送到已关闭的队列），
+		// 再等待已入队的数据报处理完，最后关闭套接字，排空期间的应答仍能发出
+		s.AddComponent(Component{
+			Name:  "udp",
+			Stage: StageAccept,
+			Start: func(ctx context.Context) error {
+				work := res.acquire()
+				defer res.release()
+				for {
+					b := res.getUDPBuf()
+					n, addr, err := pc.ReadFromUDP(b)
+					if err != nil {
+						res.putUDPBuf(b)
+						return s.serveErr(err)
+					}
+
+					s.udpInflight.Add(1)
+					select {
+					case work <- &udpTask{s: s, addr: addr, buf: b, n: n}:
+					default:
+						s.udpInflight.Done()
+						res.putUDPBuf(b)
+						s.Stats.UDPQueueFull.Add(1)
+						if Debug {
+							log.Println("UDP worker queue full, dropping packet")
+						}
+					}
+				}
+			},
+			Stop: func() error {
+				return pc.SetReadDeadline(time.Now())
+			},
+		})
+		s.AddComponent(Component{
+			Name:  "udp-drain",
+			Stage: StageDrain,
+			Stop: func() error {
+				s.udpInflight.Wait()
+				return nil
+			},
+		})
+		s.AddComponent(Component{
+			Name:  "udp-socket",
+			Stage: StageSocket,
+			Stop:  pc.Close,
+		})
+	}
+	err := s.runComponents()
+	// 因组件出错而关闭时同样执行关闭完成的钩子
 	if err != nil {
-		l.Close()
+		s.finishShutdown()
+	}
+	return err
+}
+
+// ServeConn 在单个客户端连接上运行完整的 SOCKS5 会话：白名单检查、协商、请求解析和转发，返回时连接已关闭
+// 除监听器接受的连接外，也可用于自行接受的连接或其他传输层提供的任意 net.Conn（如 QUIC 流、net.Pipe），
+// 远端地址不是 IP 地址时跳过白名单检查
+// 返回的错误与监听器接受的连接相同：HandshakeError、AuthError、RequestError、DialError 或 RelayError，
+// 白名单拒绝时包装 ErrNotWhitelisted，黑名单拒绝时包装 ErrBlacklisted
+func (s *Server) ServeConn(ctx context.Context, c net.Conn) error {
+	return s.serveConn(ctx, c, nil)
+}
+
+// serveConn 按 p 的策略运行 ServeConn，p 为 nil 时使用服务端自己的设置
+func (s *Server) serveConn(ctx context.Context, c net.Conn, p *Profile) error {
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	s.Stats.Connections.Add(1)
+	defer c.Close()
+	// ctx 取消或服务端关闭时立即关闭连接，协商和转发都会随之结束
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopServer := context.AfterFunc(s.Context(), cancel)
+	defer stopServer()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	s.setClientKeepAlive(c)
+	if Debug && s.ListenMPTCP {
+		logMPTCP("from", c)
+	}
+	// 优化：TCP 连接入口检查白名单
+	clientIP := remoteIP(c)
+	if clientIP != nil && !s.allows(p, clientIP) {
+		s.Audit.event(AuditDenied, c.RemoteAddr(), "", "", "whitelist", "")
+		s.tarpit(ctx, c)
+		return fmt.Errorf("TCP connection rejected from %s: %w", clientIP, ErrNotWhitelisted)
+	}
+	if clientIP != nil && s.IsDenied(clientIP) {
+		s.Audit.event(AuditDenied, c.RemoteAddr(), "", "", "blacklist", "")
+		s.tarpit(ctx, c)
+		return fmt.Errorf("TCP connection rejected from %s: %w", clientIP, ErrBlacklisted)
+	}
+	// 封禁中的来源不输出日志，封禁时已记录
+	if clientIP != nil && s.Bans != nil && s.Bans.Banned(clientIP) {
+		s.Stats.BannedConns.Add(1)
+		s.tarpit(ctx, c)
+		return nil
+	}
+
+	sess := NewSession(c)
+	sess.Profile = p
+	ctx = WithMetadata(ctx, sess.Meta)
+	defer func() {
+		if sess.rejected.Load() {
+			lingerClose(c)
+		}
+	}()
+	// 握手阶段的读截止时间，读取请求后清除，转发由 TCPTimeout 控制
+	if s.HandshakeTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+	// TLS 模式下先完成握手，失败的连接不会进入 SOCKS 协商
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			s.Stats.countHandshakeError(err)
+			return fmt.Errorf("TLS handshake with %s failed: %w", c.RemoteAddr(), err)
+		}
+		// ACME 的 TLS-ALPN-01 验证连接在握手后即结束
+		if isACMEChallenge(tc) {
+			return nil
+		}
+	}
+	if cs, ok := c.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		sess.CertUser = certIdentity(cs.ConnectionState())
+	}
+	// 跟踪记录 TLS 解密后的 SOCKS 字节，到写出应答为止
+	var tc *traceConn
+	if s.Trace != nil && s.Trace.Match(clientIP) {
+		if tc = s.Trace.start(c); tc != nil {
+			sess.Conn = tc
+			defer tc.finish()
+		}
+	}
+
+	if err := s.negotiate(sess); err != nil {
+		s.Stats.countHandshakeError(err)
+		s.recordMalformed(c, err)
 		return err
 	}
-	s.UDPConn, err = net.ListenUDP("udp", addr1)
+	r, err := s.GetRequest(sess)
 	if err != nil {
-		l.Close()
+		s.Stats.countHandshakeError(err)
+		s.recordMalformed(c, err)
 		return err
 	}
+	if s.HandshakeTimeout > 0 {
+		c.SetReadDeadline(time.Time{})
+	}
+	if tc != nil {
+		tc.requestRead()
+	}
+	if err := s.checkCommandAuth(sess, r); err != nil {
+		return err
+	}
+	if s.shedLoad(sess, r) {
+		return nil
+	}
+	if err := s.checkQuota(sess, r); err != nil {
+		return err
+	}
+	if r, err = s.rewriteOrReject(sess, r); err != nil {
+		return err
+	}
+	return s.handleTCP(ctx, sess, r)
+}
 
-	// 优化：启动 UDP Worker Pool (128个并发)
-	numWorkers := 128
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			for task := range s.udpWorkCh {
-				handleUDPTask(s, task)
+// rejectLinger 写出失败应答后等待客户端关闭的最长时间
+const rejectLinger = time.Second
+
+// lingerClose 写出失败应答后平缓地关闭连接：先半关闭写方向，再读掉客户端已发送（如紧跟认证发来的请求）的数据，
+// 直到客户端关闭或超过 rejectLinger；接收缓冲区留有未读数据时直接 Close 会发出 RST，客户端可能收不到应答
+func lingerClose(c net.Conn) {
+	closeWrite(c)
+	c.SetReadDeadline(time.Now().Add(rejectLinger))
+	io.Copy(io.Discard, io.LimitReader(c, 64*1024))
+	c.Close()
+}
+
+// udpUnsupported 检查出站拨号器和客户端连接所在的传输层能否支持 UDP ASSOCIATE
+// 传输层连接可以实现 UDPCapable 声明不支持（如 QUIC 流）
+func (s *Server) udpUnsupported(c net.Conn) error {
+	sess := SessionFromConn(c)
+	if p := profileOf(sess); p != nil && p.Egress != nil {
+		if !p.Egress.supportsUDP() {
+			return ErrUDPNotSupported
+		}
+	} else if !s.SupportsUDP() {
+		return ErrUDPNotSupported
+	}
+	if sess != nil {
+		c = sess.Conn
+	}
+	if u, ok := c.(UDPCapable); ok && !u.SupportsUDP() {
+		return ErrUDPTransport
+	}
+	return nil
+}
+
+// remoteIP 返回连接对端的 IP，非 TCP 连接返回 nil
+func remoteIP(c net.Conn) net.IP {
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// setBoundAddrs 记录实际绑定的地址并通知 Ready
+// 监听端口为 0 时，用实际的 UDP 端口修正 ASSOCIATE 应答中的地址；UDP 绑定到具体 IP 而通告地址未指定 IP 时通告该 IP，
+// 两者是不同的具体 IP 时记录警告，严格的客户端会丢弃来源与通告地址不符的应答
+func (s *Server) setBoundAddrs(tcpAddr, udpAddr net.Addr) {
+	s.addrMu.Lock()
+	s.tcpAddr = tcpAddr
+	s.udpAddr = udpAddr
+	if ua, ok := udpAddr.(*net.UDPAddr); ok {
+		if sa, ok := s.ServerAddr.(*net.UDPAddr); ok {
+			ip, zone := sa.IP, sa.Zone
+			switch {
+			case !specificIP(ip) && specificIP(ua.IP):
+				ip, zone = ua.IP, ua.Zone
+			case specificIP(ip) && specificIP(ua.IP) && !ip.Equal(ua.IP):
+				log.Printf("Warning: UDP socket bound to %s but ASSOCIATE replies advertise %s", ua.IP, ip)
 			}
-		}()
+			if sa.Port != ua.Port || !ip.Equal(sa.IP) {
+				s.ServerAddr = &net.UDPAddr{IP: ip, Port: ua.Port, Zone: zone}
+			}
+		}
 	}
+	s.addrMu.Unlock()
+	s.readyOnce.Do(func() { close(s.ready) })
+}
 
-	s.RunnerGroup.Add(&runnergroup.Runner{
-		Start: func() error {
-			for {
-				b := udpBufPool.Get().([]byte)
-				b = b[:cap(b)] // Reset length
+// TCPAddr 返回实际绑定的 TCP 地址，Ready 关闭之前返回 nil
+func (s *Server) TCPAddr() net.Addr {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
+	return s.tcpAddr
+}
 
-				n, addr, err := s.UDPConn.ReadFromUDP(b)
-				if err != nil {
-					udpBufPool.Put(b)
-					return err
-				}
+// UDPAddr 返回实际绑定的 UDP 地址，Ready 关闭之前返回 nil
+func (s *Server) UDPAddr() net.Addr {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
+	return s.udpAddr
+}
 
-				select {
-				case s.udpWorkCh <- &udpTask{addr: addr, buf: b, n: n}:
-				default:
-					udpBufPool.Put(b)
-					if Debug {
-						log.Println("UDP worker queue full, dropping packet")
-					}
-				}
-			}
-		},
-		Stop: func() error {
-			close(s.udpWorkCh)
-			return s.UDPConn.Close()
-		},
+// res 返回 Resources，未设置时创建私有的一份
+func (s *Server) res() *Resources {
+	s.resOnce.Do(func() {
+		if s.Resources == nil {
+			s.Resources = NewResources()
+		}
 	})
-	return s.RunnerGroup.Wait()
+	return s.Resources
+}
+
+// Ready 返回一个在监听地址绑定完成后关闭的通道
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
 }
 
 // handleUDPTask 处理单个 UDP 任务
 func handleUDPTask(s *Server, t *udpTask) {
-	defer udpBufPool.Put(t.buf)
+	defer s.udpInflight.Done()
+	defer s.res().putUDPBuf(t.buf)
+	// 关闭过程中队列里剩下的数据报直接丢弃
+	if s.Context().Err() != nil {
+		return
+	}
 
-	// 优化：UDP 包入口检查白名单
-	if !s.IsAllowed(t.addr.IP) {
+	// 优化：UDP 包入口检查白名单和黑名单，经附加监听器建立了关联的来源已通过其监听器的白名单
+	if !s.IsAllowed(t.addr.IP) && !(s.hasProfiles() && s.hasAssocIP(t.addr.IP)) || s.IsDenied(t.addr.IP) {
 		if Debug {
 			log.Printf("UDP Packet rejected from %s", t.addr.IP)
 		}
 		return
 	}
+	if s.UDPRequireAssociatedIP && !s.hasAssocIP(t.addr.IP) {
+		s.Stats.UDPUnassociated.Add(1)
+		if Debug {
+			log.Printf("UDP packet from %s dropped: no UDP association from this IP", t.addr)
+		}
+		return
+	}
 
 	d, err := NewDatagramFromBytes(t.buf[0:t.n])
 	if err != nil {
+		s.Stats.UDPMalformed.Add(1)
+		if Debug {
+			log.Printf("UDP packet from %s dropped: %v", t.addr, err)
+		}
 		return
 	}
 	if d.Frag != 0x00 {
+		s.Stats.UDPMalformed.Add(1)
 		return
 	}
-	if err := s.Handle.UDPHandle(s, t.addr, d); err != nil {
-		log.Println(err)
+	ctx := s.Context()
+	if a := s.association(t.addr); a != nil && a.Session != nil {
+		ctx = WithMetadata(ctx, a.Session.Meta)
+	}
+	orig, err := s.rewriteDatagram(t.addr, d)
+	if err != nil {
+		if Debug {
+			log.Println(err)
+		}
+		return
+	}
+	if orig != "" {
+		ctx = withOrigDst(ctx, orig)
+	}
+	if err := s.handleUDP(ctx, t.addr, d); err != nil {
+		s.LogServeError(err)
 	}
 }
 
+// Context 返回服务端的生命周期 context，Shutdown 时取消
+func (s *Server) Context() context.Context {
+	s.ctxOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+	return s.ctx
+}
+
+// Shutdown 先执行 RegisterOnShutdown 注册的钩子并关闭所有连接，再按阶段停止组件（停止接受、排空、后台任务、关闭套接字），
+// 等待 Serve 返回后执行 RegisterOnShutdownDone 注册的钩子；可并发多次调用，都在关闭完成后返回，
+// 返回组件 Stop 的错误
 func (s *Server) Shutdown() error {
-	return s.RunnerGroup.Done()
+	err := s.stopComponents()
+	s.hooks.serving.Wait()
+	s.finishShutdown()
+	return err
 }
 
 type Handler interface {
-	TCPHandle(*Server, *net.TCPConn, *Request) error
+	TCPHandle(*Server, net.Conn, *Request) error
 	UDPHandle(*Server, *net.UDPAddr, *Datagram) error
 }
 
+// ContextHandler 是可选接口，Handle 实现它时服务端优先调用带 context 的方法
+// TCP 的 ctx 在服务端关闭或该连接的会话结束时取消，UDP 的 ctx 在服务端关闭时取消
+type ContextHandler interface {
+	TCPHandleContext(context.Context, *Server, net.Conn, *Request) error
+	UDPHandleContext(context.Context, *Server, *net.UDPAddr, *Datagram) error
+}
+
+// handleTCP 将请求交给套上中间件的 Handle
+func (s *Server) handleTCP(ctx context.Context, c net.Conn, r *Request) error {
+	err := callTCP(ctx, s.handler(), s, c, r)
+	s.auditDenied(c, r, err)
+	return err
+}
+
+// handleUDP 将数据报交给套上中间件的 Handle
+func (s *Server) handleUDP(ctx context.Context, addr *net.UDPAddr, d *Datagram) error {
+	return callUDP(ctx, s.handler(), s, addr, d)
+}
+
+// callTCP 调用 h 处理请求，h 实现 ContextHandler 时优先使用
+func callTCP(ctx context.Context, h Handler, s *Server, c net.Conn, r *Request) error {
+	if ch, ok := h.(ContextHandler); ok {
+		return ch.TCPHandleContext(ctx, s, c, r)
+	}
+	return h.TCPHandle(s, c, r)
+}
+
+// callUDP 调用 h 处理数据报，h 实现 ContextHandler 时优先使用
+func callUDP(ctx context.Context, h Handler, s *Server, addr *net.UDPAddr, d *Datagram) error {
+	if ch, ok := h.(ContextHandler); ok {
+		return ch.UDPHandleContext(ctx, s, addr, d)
+	}
+	return h.UDPHandle(s, addr, d)
+}
+
 type DefaultHandle struct {
 }
 
-// idleTimeoutConn 包装连接以支持 io.CopyBuffer
+// idleTimeoutConn 包装连接以支持 io.CopyBuffer，每次读写前设置截止时间
+// 读超时返回 ErrIdleTimeout，对端不读导致写超时返回 ErrWriteStall
 type idleTimeoutConn struct {
 	net.Conn
 	timeout time.Duration
@@ -368,151 +1100,449 @@ func (c *idleTimeoutConn) Read(b []byte) (int, error) {
 			return 0, err
 		}
 	}
-	return c.Conn.Read(b)
+	n, err := c.Conn.Read(b)
+	if isTimeout(err) {
+		err = fmt.Errorf("%w: %w", ErrIdleTimeout, err)
+	}
+	return n, err
 }
 
-func (h *DefaultHandle) TCPHandle(s *Server, c *net.TCPConn, r *Request) error {
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Write(b)
+	if isTimeout(err) {
+		err = fmt.Errorf("%w: %w", ErrWriteStall, err)
+	}
+	return n, err
+}
+
+// isTimeout 判断是否为截止时间到达引起的错误
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// TCPHandle 等同于使用服务端生命周期 context 的 TCPHandleContext
+func (h *DefaultHandle) TCPHandle(s *Server, c net.Conn, r *Request) error {
+	return h.TCPHandleContext(s.Context(), s, c, r)
+}
+
+// TCPHandleContext 处理 CONNECT 和 UDP ASSOCIATE，ctx 取消时中止拨号并关闭两端连接
+func (h *DefaultHandle) TCPHandleContext(ctx context.Context, s *Server, c net.Conn, r *Request) error {
+	rec := newAccessRecord(c, r)
 	if r.Cmd == CmdConnect {
-		rc, err := r.Connect(c)
+		d, route := s.route(SessionFromConn(c), r.Address())
+		if route != nil {
+			rec.Route = route.Name
+		}
+		if s.Faults != nil {
+			d = s.Faults.Dialer(d)
+		}
+		info := &DialInfo{}
+		var w io.Writer = c
+		if sess := SessionFromConn(c); sess != nil && sess.Transparent {
+			w = io.Discard
+		}
+		dctx := WithDialInfo(ctx, info)
+		if _, ok := DialTimeoutFromContext(ctx); !ok && route != nil && route.DialTimeout > 0 {
+			dctx = WithDialTimeout(dctx, route.DialTimeout)
+		}
+		if _, ok := DSCPFromContext(ctx); !ok {
+			if v, ok := s.dscpFor(SessionFromConn(c), route); ok {
+				dctx = WithDSCP(dctx, v)
+			}
+		}
+		cancel := context.CancelFunc(func() {})
+		if s.RequestTimeout > 0 {
+			dctx, cancel = context.WithTimeout(dctx, s.RequestTimeout)
+		}
+		start := time.Now()
+		rc, err := r.Connect(dctx, w, d)
+		elapsed := time.Since(start)
+		cancel()
+		rec.Resolve = info.Resolve
+		rec.DialMS = elapsed.Milliseconds()
+		rec.DialTries = info.Attempts
 		if err != nil {
-			return err
+			rec.Rep = RepForError(err)
+			if s.OnDialError != nil {
+				s.OnDialError(SessionFromConn(c), r.Address(), rec.Rep, err, elapsed)
+			}
+			rec.finish(s.AccessLog, err)
+			return &DialError{clientError: clientError{Client: c.RemoteAddr(), Err: err}, Dst: r.Address()}
 		}
 		defer rc.Close()
+		stop := context.AfterFunc(ctx, func() {
+			rc.Close()
+			c.Close()
+		})
+		defer stop()
+		if la := rc.LocalAddr(); la != nil {
+			rec.Egress = la.String()
+		}
+		if Debug && s.DialMPTCP {
+			logMPTCP("to", rc)
+		}
 
 		// 优化：使用 io.CopyBuffer 实现零拷贝转发
-		directTransfer := func(dst net.Conn, src net.Conn, timeout int) {
-			buf := tcpBufPool.Get().([]byte)
-			defer tcpBufPool.Put(buf)
-			srcWrapped := &idleTimeoutConn{Conn: src, timeout: time.Duration(timeout) * time.Second}
-			_, _ = io.CopyBuffer(dst, srcWrapped, buf)
+		// 返回的错误只保留空闲超时和写阻塞，对端关闭等正常结束返回 nil
+		// count 为本转发的计数，total 为服务端的累计
+		directTransfer := func(dst net.Conn, src net.Conn, timeout time.Duration, count, total *atomic.Int64) (int64, error) {
+			buf := s.res().getTCPBuf()
+			defer s.res().putTCPBuf(buf)
+			srcWrapped := &idleTimeoutConn{Conn: src, timeout: timeout}
+			var w io.Writer = &idleTimeoutConn{Conn: dst, timeout: timeout}
+			if s.Bandwidth != nil {
+				w = &limitedWriter{w: w, l: s.Bandwidth, ctx: ctx}
+			}
+			if user, ok := s.quotaUser(c); ok {
+				w = &quotaWriter{w: w, q: s.Quotas, user: user, cut: func() { rc.Close(); c.Close() }}
+			}
+			w = &countingWriter{w: &countingWriter{w: w, n: total}, n: count}
+			n, err := io.CopyBuffer(w, srcWrapped, buf)
+			if errors.Is(err, ErrIdleTimeout) || errors.Is(err, ErrWriteStall) || errors.Is(err, ErrQuotaExceeded) {
+				return n, err
+			}
+			return n, nil
 		}
 
-		go directTransfer(c, rc, s.TCPTimeout)
-		directTransfer(rc, c, s.TCPTimeout)
+		// 空闲超时在转发开始时按路由规则选定
+		idle := time.Duration(s.TCPTimeout) * time.Second
+		if route != nil && route.IdleTimeout != 0 {
+			idle = max(route.IdleTimeout, 0)
+		}
+		if sess := SessionFromConn(c); sess != nil {
+			sess.setIdleTimeout(idle)
+		}
+		lt := s.startLifetime(SessionFromConn(c), route, c, rc)
+		rel := s.trackRelay(SessionFromConn(c), c, rc)
+		type result struct {
+			n   int64
+			err error
+		}
+		down := make(chan result, 1)
+		// 任一方向结束（包括写阻塞）即关闭两端，等待另一方向退出以统计字节数
+		go func() {
+			n, err := directTransfer(c, rc, idle, &rel.down, &s.Stats.BytesDown)
+			rc.Close()
+			c.Close()
+			down <- result{n, err}
+		}()
+		peeked, err1 := s.peekRelay(c, r, rec)
+		rel.top.start(s.TopDestinations, topHost(SessionFromConn(c), r))
+		if err1 == nil && len(peeked) > 0 {
+			_, err1 = rc.Write(peeked)
+			s.Stats.BytesUp.Add(int64(len(peeked)))
+		}
+		if err1 == nil {
+			rec.BytesUp, err1 = directTransfer(rc, c, idle, &rel.up, &s.Stats.BytesUp)
+			rec.BytesUp += int64(len(peeked))
+		}
+		rc.Close()
+		c.Close()
+		res := <-down
+		rec.BytesDown = res.n
+		s.flushTop(rel)
+		// 只有先结束的方向可能返回超时错误，另一方向随后因连接关闭返回 nil
+		err = cmp.Or(err1, res.err)
+		if lt.stop() {
+			err = ErrLifetimeExceeded
+		}
+		if reason := s.untrackRelay(rel); reason != nil {
+			err = reason
+		}
+		s.countRelayEnd(err)
+		rec.finish(s.AccessLog, err)
+		if err != nil {
+			return &RelayError{clientError{Client: c.RemoteAddr(), Err: fmt.Errorf("%s: %w", r.Address(), err)}}
+		}
 		return nil
 	}
 	if r.Cmd == CmdUDP {
-		caddr, err := r.UDP(c, s.ServerAddr)
+		if err := s.udpUnsupported(c); err != nil {
+			p := NewReply(RepCommandNotSupported, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
+			if _, err := p.WriteTo(c); err != nil {
+				return &RequestError{clientError{Client: c.RemoteAddr(), Err: err}}
+			}
+			rec.Rep = RepCommandNotSupported
+			rec.finish(s.AccessLog, err)
+			return &RequestError{clientError{Client: c.RemoteAddr(), Err: err}}
+		}
+		caddr, err := r.UDP(c, s.ServerAddr, s.resolver())
 		if err != nil {
-			return err
+			rec.Rep = RepHostUnreachable
+			rec.finish(s.AccessLog, err)
+			return &RequestError{clientError{Client: c.RemoteAddr(), Err: err}}
+		}
+		rec.Dst = caddr.String()
+		ua, _ := caddr.(*net.UDPAddr)
+		assoc := &UDPAssociation{Session: SessionFromConn(c)}
+		assoc.limiter = newPacketLimiter(s.packetRateFor(assoc.Session))
+		assoc.ctx, assoc.cancel = context.WithCancel(ctx)
+		defer assoc.cancel()
+		if ua != nil {
+			s.addAssocIP(ua.IP)
+			defer s.removeAssocIP(ua.IP)
+		}
+		if ua != nil && ua.Port == 0 {
+			// 端口未知，由该 IP 的第一个数据报确定
+			s.addLearning(ua.IP, assoc)
+			defer s.removeLearning(ua.IP, assoc)
+		} else {
+			assoc.clientAddr.Store(ua)
+			s.AssociatedUDP.Store(caddr.String(), assoc)
+			// 只删除自己的登记，同一地址的新关联不受影响
+			defer s.AssociatedUDP.CompareAndDelete(caddr.String(), assoc)
+		}
+		// 关联被取消（服务端关闭或配额用完）时关闭控制连接
+		stop := context.AfterFunc(assoc.ctx, func() { c.Close() })
+		defer stop()
+		lt := s.startLifetime(SessionFromConn(c), nil, c)
+		rel := s.trackAssociation(SessionFromConn(c), c, assoc)
+		err = s.holdAssociation(c, assoc)
+		if lt.stop() {
+			err = ErrLifetimeExceeded
+		}
+		if reason := s.untrackRelay(rel); reason != nil {
+			err = reason
+		}
+		s.countRelayEnd(err)
+		// 仍在关闭中的交换按此刻的计数计入
+		totals, up, down := assoc.Totals()
+		rec.UDP, rec.BytesUp, rec.BytesDown = &totals, up, down
+		rec.finish(s.AccessLog, err)
+		if err != nil {
+			return &RelayError{clientError{Client: c.RemoteAddr(), Err: fmt.Errorf("udp %s: %w", caddr, err)}}
 		}
-		ch := make(chan byte)
-		defer close(ch)
-		s.AssociatedUDP.Store(caddr.String(), ch)
-		defer s.AssociatedUDP.Delete(caddr.String())
-		io.Copy(io.Discard, c) // Keep TCP connection alive
 		return nil
 	}
-	return ErrUnsupportCmd
+	return &RequestError{clientError{Client: c.RemoteAddr(), Err: ErrUnsupportCmd}}
 }
 
+// UDPHandle 等同于使用服务端生命周期 context 的 UDPHandleContext
 func (h *DefaultHandle) UDPHandle(s *Server, addr *net.UDPAddr, d *Datagram) error {
+	return h.UDPHandleContext(s.Context(), s, addr, d)
+}
+
+// UDPHandleContext 转发一个数据报，ctx 取消或所属关联结束时中止拨号并结束该目标的转发
+func (h *DefaultHandle) UDPHandleContext(ctx context.Context, s *Server, addr *net.UDPAddr, d *Datagram) error {
 	src := addr.String()
-	var ch chan byte
-	if s.LimitUDP {
-		any, ok := s.AssociatedUDP.Load(src)
-		if !ok {
-			return fmt.Errorf("Address %s not associated", src)
+	assoc := s.association(addr)
+	if assoc == nil && s.LimitUDP {
+		s.Stats.UDPUnassociated.Add(1)
+		return &RelayError{clientError{Client: addr, Err: fmt.Errorf("Address %s not associated", src)}}
+	}
+	var sess *Session
+	if assoc != nil {
+		sess = assoc.Session
+		// 超过速率上限的数据报在解析目标和拨号之前丢弃
+		if !assoc.limiter.allow() {
+			assoc.limited.Add(1)
+			s.Stats.UDPRateLimited.Add(1)
+			return nil
 		}
-		ch = any.(chan byte)
 	}
 
 	send := func(ue *UDPExchange, data []byte) error {
-		select {
-		case <-ch:
-			return fmt.Errorf("Association closed")
-		default:
-			_, err := ue.RemoteConn.Write(data)
-			return err
+		if assoc != nil && assoc.Closed() {
+			ue.dropped.Add(1)
+			return &RelayError{clientError{Client: addr, Err: errors.New("Association closed")}}
+		}
+		if assoc != nil {
+			assoc.touch()
+		}
+		if err := s.Bandwidth.WaitN(ctx, len(data)); err != nil {
+			return &RelayError{clientError{Client: addr, Err: err}}
+		}
+		if _, err := ue.RemoteConn.Write(data); err != nil {
+			return &RelayError{clientError{Client: addr, Err: err}}
 		}
+		ue.countSent(len(data))
+		s.Stats.BytesUp.Add(int64(len(data)))
+		if sess != nil && s.Quotas.add(sess.User, len(data)) && s.Quotas.Terminate && assoc != nil {
+			assoc.cancel()
+		}
+		return nil
 	}
 
 	dst := d.Address()
-	if any, ok := s.UDPExchanges.Load(src + dst); ok {
+	key := src + dst
+	if any, ok := s.UDPExchanges.Load(key); ok {
 		ue := any.(*UDPExchange)
 		return send(ue, d.Data)
 	}
 
-	var laddr string
-	if any, ok := s.UDPSrc.Load(src + dst); ok {
-		laddr = any.(string)
+	var rc net.Conn
+	var err error
+	orig := origDstFromContext(ctx)
+	ud, route := s.route(sess, dst)
+	if _, ok := DSCPFromContext(ctx); !ok {
+		if v, ok := s.dscpFor(sess, route); ok {
+			ctx = WithDSCP(ctx, v)
+		}
+	}
+	dd, direct := ud.(*DirectDialer)
+	cached := false
+	start := time.Now()
+	if direct {
+		// 优先复用该 src+dst 之前使用的本地地址，保持源端口不变
+		egress := dd.Egress.LocalAddr(dst)
+		laddr := egress
+		if any, ok := s.UDPSrc.Load(key); ok {
+			laddr, cached = any.(string), true
+		}
+		rc, err = dd.DialFrom(ctx, "udp", laddr, dst)
+		if err != nil && cached {
+			// 之前的本地地址可能已被占用，改用出口地址并记录，源端口因此改变
+			cerr := err
+			rc, err = dd.DialFrom(ctx, "udp", egress, dst)
+			cached = false
+			if err == nil {
+				log.Printf("Warning: UDP %s from %s: bind to previous local address %s failed (%v), using %s", dst, src, laddr, cerr, rc.LocalAddr())
+			}
+		}
+	} else {
+		rc, err = ud.DialContext(ctx, "udp", dst)
 	}
-	rc, err := DialUDP("udp", laddr, dst)
 	if err != nil {
-		rc, err = DialUDP("udp", "", dst)
-		if err != nil {
-			return err
+		if route != nil {
+			err = fmt.Errorf("udp %s via route %s: %w", dst, route.Name, err)
 		}
-		laddr = ""
+		if s.OnDialError != nil {
+			s.OnDialError(sess, dst, RepForError(err), err, time.Since(start))
+		}
+		return &DialError{clientError: clientError{Client: addr, Err: err}, Dst: dst}
 	}
-	if laddr == "" {
-		s.UDPSrc.Store(src+dst, rc.LocalAddr().String())
+
+	// 直连时以远端实际地址归一化，同一远端以另一种形式请求过且交换仍在时复用该交换
+	nkey := key
+	if direct {
+		nkey = udpExchangeKey(src, rc.RemoteAddr(), key)
+	}
+	if any, ok := s.UDPExchanges.Load(nkey); ok && nkey != key {
+		ue := any.(*UDPExchange)
+		if ue.register(s.UDPExchanges, key) {
+			rc.Close()
+			s.UDPSrc.Store(key, ue.RemoteConn.LocalAddr().String())
+			return send(ue, d.Data)
+		}
+	}
+	if direct && !cached {
+		// 交换已结束时沿用该远端之前的本地地址
+		if any, ok := s.UDPSrc.Load(nkey); ok && nkey != key && any.(string) != rc.LocalAddr().String() {
+			if rc1, err := dd.DialFrom(ctx, "udp", any.(string), rc.RemoteAddr().String()); err == nil {
+				rc.Close()
+				rc = rc1
+			} else {
+				log.Printf("Warning: UDP %s from %s: bind to previous local address %s failed (%v), using %s", dst, src, any, err, rc.LocalAddr())
+			}
+		}
+		laddr := rc.LocalAddr().String()
+		s.UDPSrc.Store(nkey, laddr)
+		s.UDPSrc.Store(key, laddr)
 	}
 
 	ue := &UDPExchange{
 		ClientAddr: addr,
 		RemoteConn: rc,
+		Dst:        dst,
 	}
+	ue.started = time.Now()
+	ue.active.Store(ue.started.UnixNano())
+	ue.top.start(s.TopDestinations, hostOf(dst))
 
 	if err := send(ue, d.Data); err != nil {
 		ue.RemoteConn.Close()
 		return err
 	}
-	s.UDPExchanges.Store(src+dst, ue)
+	ue.register(s.UDPExchanges, nkey)
+	if nkey != key {
+		ue.register(s.UDPExchanges, key)
+	}
+	if assoc != nil {
+		assoc.addExchange(ue)
+	}
 
+	// 读协程在服务端关闭或所属关联结束时退出
+	rctx, cancel := context.WithCancel(ctx)
+	stopAssoc := func() bool { return false }
+	if assoc != nil {
+		stopAssoc = context.AfterFunc(assoc.ctx, cancel)
+	}
 	go func(ue *UDPExchange, dst string) {
+		stop := context.AfterFunc(rctx, func() { ue.RemoteConn.Close() })
 		defer func() {
+			stop()
+			stopAssoc()
+			cancel()
 			ue.RemoteConn.Close()
-			s.UDPExchanges.Delete(ue.ClientAddr.String() + dst)
+			ue.unregister(s.UDPExchanges)
+			ue.top.flush(s.TopDestinations, ue.sentBytes.Load(), ue.recvBytes.Load())
+			st := ue.Stats()
+			if assoc != nil {
+				assoc.removeExchange(ue, st)
+			}
+			if s.OnUDPExchangeClose != nil {
+				s.OnUDPExchangeClose(sess, st)
+			}
 		}()
-		b := udpBufPool.Get().([]byte)
-		defer udpBufPool.Put(b)
+		// 应答数据报的来源地址：远端的 UDP 地址，目标被改写或远端不是 UDP 地址时为客户端请求的目标
+		var a byte
+		var addr, port []byte
+		if ua, ok := ue.RemoteConn.RemoteAddr().(*net.UDPAddr); ok && orig == "" {
+			a, addr, port = AddrPortToATYP(ua.AddrPort())
+		} else {
+			var err error
+			if a, addr, port, err = AddrToATYP(stringAddr(cmp.Or(orig, dst))); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		// 数据报头只构造一次，应答直接读到缓冲区中报头之后，转发时不再逐个分配
+		hdr := NewDatagram(a, addr, port, nil).Bytes()
+		b := s.res().getUDPBuf()
+		defer s.res().putUDPBuf(b)
+		b = b[:cap(b)]
+		copy(b, hdr)
 
+		// rctx 取消时 AfterFunc 关闭远端连接，Read 立即返回
 		for {
-			select {
-			case <-ch:
+			if s.UDPTimeout != 0 {
+				ue.RemoteConn.SetDeadline(time.Now().Add(time.Duration(s.UDPTimeout) * time.Second))
+			}
+			n, err := ue.RemoteConn.Read(b[len(hdr):])
+			if err != nil {
 				return
-			default:
-				if s.UDPTimeout != 0 {
-					ue.RemoteConn.SetDeadline(time.Now().Add(time.Duration(s.UDPTimeout) * time.Second))
-				}
-				buf := b[:cap(b)]
-				n, err := ue.RemoteConn.Read(buf)
-				if err != nil {
-					return
-				}
+			}
 
-				// 优化：从 RemoteAddr 直接获取 IP/Port，避免 ParseAddress
-				var a byte
-				var addr, port []byte
-
-				if udpAddr, ok := ue.RemoteConn.RemoteAddr().(*net.UDPAddr); ok {
-					if ip4 := udpAddr.IP.To4(); ip4 != nil {
-						a = ATYPIPv4
-						addr = ip4
-					} else {
-						a = ATYPIPv6
-						addr = udpAddr.IP
-					}
-					port = make([]byte, 2)
-					binary.BigEndian.PutUint16(port, uint16(udpAddr.Port))
-				} else {
-					var err error
-					a, addr, port, err = ParseAddress(dst)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					if a == ATYPDomain {
-						addr = addr[1:]
+			if assoc != nil {
+				assoc.touch()
+			}
+			if err := s.Bandwidth.WaitN(rctx, n); err != nil {
+				return
+			}
+			if sess != nil && s.Quotas.add(sess.User, n) && s.Quotas.Terminate && assoc != nil {
+				assoc.cancel()
+			}
+			// 套接字被所有交换共享，发送缓冲区满时只丢弃本数据报，不阻塞该交换
+			s.UDPConn.SetWriteDeadline(time.Now().Add(udpWriteTimeout))
+			if _, err := s.UDPConn.WriteToUDP(b[:len(hdr)+n], ue.ClientAddr); err != nil {
+				if isTimeout(err) {
+					s.Stats.UDPReplyDropped.Add(1)
+					ue.replyDropped.Add(1)
+					if Debug {
+						log.Printf("UDP reply to %s dropped: %v", ue.ClientAddr, err)
 					}
+					continue
 				}
-
-				d1 := NewDatagram(a, addr, port, buf[0:n])
-				if _, err := s.UDPConn.WriteToUDP(d1.Bytes(), ue.ClientAddr); err != nil {
-					return
-				}
+				return
 			}
+			s.Stats.BytesDown.Add(int64(n))
+			ue.countRecv(n)
 		}
 	}(ue, dst)
 	return nil
@@ -4,12 +4,14 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 )
 
 var (
 	ErrVersion         = errors.New("Invalid Version")
 	ErrUserPassVersion = errors.New("Invalid Version of Username Password Auth")
 	ErrBadRequest      = errors.New("Bad Request")
+	ErrBadDomain       = errors.New("Bad Domain")
 )
 
 func NewNegotiationRequestFrom(r io.Reader) (*NegotiationRequest, error) {
@@ -27,14 +29,15 @@ func NewNegotiationRequestFrom(r io.Reader) (*NegotiationRequest, error) {
 	if _, err := io.ReadFull(r, ms); err != nil {
 		return nil, err
 	}
-	if Debug {
-		log.Printf("Got NegotiationRequest: %#v %#v %#v\n", bb[0], bb[1], ms)
-	}
-	return &NegotiationRequest{
+	nr := &NegotiationRequest{
 		Ver:      bb[0],
 		NMethods: bb[1],
 		Methods:  ms,
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", nr)
+	}
+	return nr, nil
 }
 
 func NewNegotiationReply(method byte) *NegotiationReply {
@@ -50,43 +53,45 @@ func (r *NegotiationReply) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent NegotiationReply: %#v %#v\n", r.Ver, r.Method)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
 
 func NewUserPassNegotiationRequestFrom(r io.Reader) (*UserPassNegotiationRequest, error) {
+	return newUserPassNegotiationRequestFrom(r, false)
+}
+
+// newUserPassNegotiationRequestFrom 读取用户名密码子协商请求，lenient 为 true 时版本字节也接受 0x05
+// （部分老旧 Java 库和 IoT 固件误发 SOCKS 版本号），返回的请求保留客户端发送的版本
+func newUserPassNegotiationRequestFrom(r io.Reader, lenient bool) (*UserPassNegotiationRequest, error) {
 	var bb [2]byte // 优化
 	if _, err := io.ReadFull(r, bb[:]); err != nil {
 		return nil, err
 	}
-	if bb[0] != UserPassVer {
+	if bb[0] != UserPassVer && !(lenient && bb[0] == Ver) {
 		return nil, ErrUserPassVersion
 	}
-	if bb[1] == 0 {
-		return nil, ErrBadRequest
-	}
+	// RFC 1929 允许长度为 0 的用户名和密码，是否接受由 Authenticator 决定
 	ub := make([]byte, int(bb[1])+1)
 	if _, err := io.ReadFull(r, ub); err != nil {
 		return nil, err
 	}
-	if ub[int(bb[1])] == 0 {
-		return nil, ErrBadRequest
-	}
 	p := make([]byte, int(ub[int(bb[1])]))
 	if _, err := io.ReadFull(r, p); err != nil {
 		return nil, err
 	}
-	if Debug {
-		log.Printf("Got UserPassNegotiationRequest: %#v %#v %#v %#v %#v\n", bb[0], bb[1], ub[:int(bb[1])], ub[int(bb[1])], p)
-	}
-	return &UserPassNegotiationRequest{
+	ur := &UserPassNegotiationRequest{
 		Ver:    bb[0],
 		Ulen:   bb[1],
 		Uname:  ub[:int(bb[1])],
 		Plen:   ub[int(bb[1])],
 		Passwd: p,
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", ur)
+	}
+	return ur, nil
 }
 
 func NewUserPassNegotiationReply(status byte) *UserPassNegotiationReply {
@@ -102,7 +107,7 @@ func (r *UserPassNegotiationReply) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent UserPassNegotiationReply: %#v %#v \n", r.Ver, r.Status)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
@@ -139,6 +144,9 @@ func NewRequestFrom(r io.Reader) (*Request, error) {
 		if _, err := io.ReadFull(r, addr); err != nil {
 			return nil, err
 		}
+		if !validDomain(addr) {
+			return nil, ErrBadDomain
+		}
 		addr = append(dal[:], addr...)
 	default:
 		return nil, ErrBadRequest
@@ -147,17 +155,27 @@ func NewRequestFrom(r io.Reader) (*Request, error) {
 	if _, err := io.ReadFull(r, port); err != nil {
 		return nil, err
 	}
-	if Debug {
-		log.Printf("Got Request: %#v %#v %#v %#v %#v %#v\n", bb[0], bb[1], bb[2], bb[3], addr, port)
-	}
-	return &Request{
+	req := &Request{
 		Ver:     bb[0],
 		Cmd:     bb[1],
 		Rsv:     bb[2],
 		Atyp:    bb[3],
 		DstAddr: addr,
 		DstPort: port,
-	}, nil
+	}
+	if Debug {
+		log.Printf("Got %s\n", req)
+	}
+	return req, nil
+}
+
+// NewReplyFromAddr 以 a 作为 BND.ADDR/BND.PORT 创建应答，a 为 nil 或无法转换时报告 0.0.0.0:0
+func NewReplyFromAddr(rep byte, a net.Addr) *Reply {
+	atyp, addr, port, err := AddrToATYP(a)
+	if err != nil {
+		return NewReply(rep, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
+	}
+	return NewReply(rep, atyp, addr, port)
 }
 
 func NewReply(rep byte, atyp byte, bndaddr []byte, bndport []byte) *Reply {
@@ -180,7 +198,7 @@ func (r *Reply) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 	if Debug {
-		log.Printf("Sent Reply: %#v %#v %#v %#v %#v %#v\n", r.Ver, r.Rep, r.Rsv, r.Atyp, r.BndAddr, r.BndPort)
+		log.Printf("Sent %s\n", r)
 	}
 	return int64(i), nil
 }
@@ -219,6 +237,9 @@ func NewDatagramFromBytes(bb []byte) (*Datagram, error) {
 			return nil, ErrBadRequest
 		}
 		addr = bb[minl-int(l) : minl]
+		if !validDomain(addr) {
+			return nil, ErrBadDomain
+		}
 		addr = append([]byte{l}, addr...)
 	default:
 		return nil, ErrBadRequest
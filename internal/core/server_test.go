@@ -0,0 +1,80 @@
+package core_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestConnectEcho(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, nil, echo)
+	socks5test.EchoTCP(t, c, []byte("hello"))
+	socks5test.EchoTCP(t, c, []byte(strings.Repeat("x", 100000)))
+}
+
+func TestConnectDomain(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoTCPServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+	c := socks5test.Connect(t, ts.Addr, nil, net.JoinHostPort("localhost", port))
+	socks5test.EchoTCP(t, c, []byte("by name"))
+}
+
+func TestConnectAuth(t *testing.T) {
+	ts := socks5test.NewServer(t, socks5test.WithAuth("alice", "secret"))
+	echo := socks5test.EchoTCPServer(t)
+	c := socks5test.Connect(t, ts.Addr, &socks5test.Auth{User: "alice", Password: "secret"}, echo)
+	socks5test.EchoTCP(t, c, []byte("authenticated"))
+	if n := ts.Stats.AuthFailures.Load(); n != 0 {
+		t.Fatalf("AuthFailures = %d", n)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, closed, core.RepConnectionRefused)
+}
+
+func TestUnsupportedCommand(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.SupportedCommands = []byte{core.CmdConnect} })
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdBind, "127.0.0.1:1", core.RepCommandNotSupported)
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdUDP, "0.0.0.0:0", core.RepCommandNotSupported)
+	if ts.UDPAddr != "" {
+		t.Fatalf("UDP bound at %s without CmdUDP", ts.UDPAddr)
+	}
+}
+
+// rejectHandler 拒绝所有请求
+type rejectHandler struct{}
+
+func (rejectHandler) TCPHandle(s *core.Server, c net.Conn, r *core.Request) error {
+	core.NewReply(core.RepNotAllowed, core.ATYPIPv4, net.IPv4zero.To4(), []byte{0, 0}).WriteTo(c)
+	return nil
+}
+
+func (rejectHandler) UDPHandle(*core.Server, *net.UDPAddr, *core.Datagram) error { return nil }
+
+func TestCustomHandler(t *testing.T) {
+	ts := socks5test.NewServer(t, socks5test.WithHandler(rejectHandler{}))
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdConnect, socks5test.EchoTCPServer(t), core.RepNotAllowed)
+}
+
+func TestAssociateEchoUDP(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	echo := socks5test.EchoUDPServer(t)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay, echo, []byte("datagram"))
+	socks5test.EchoUDP(t, relay, echo, []byte("second"))
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session 表示一个客户端控制连接，记录握手阶段得到的认证信息
+// Session 本身实现 net.Conn，Handler 收到的连接即为 *Session，可用 SessionFromConn 取回
+type Session struct {
+	net.Conn
+	// User 认证得到的用户名：用户名密码认证的用户名或客户端证书的身份，匿名时为空
+	User string
+	// Method 协商得到的认证方法
+	Method byte
+	// CertUser 已验证客户端证书中的身份（CN 或 SAN），未使用双向 TLS 时为空
+	CertUser string
+	// Transparent 为 true 表示连接来自透明代理监听，客户端不讲 SOCKS 协议，不应向其写应答
+	Transparent bool
+	// OrigDst Rewrite 改写前的请求目标，未改写时为空
+	OrigDst string
+	// SNI 启用 Server.SNIPeek 时从 CONNECT 转发的 TLS ClientHello 中取得的服务器名，其他情况为空
+	SNI string
+	// HTTPHost 启用 Server.HTTPHostPeek 时从明文 HTTP 请求头中取得的 Host（不含端口），其他情况为空
+	HTTPHost string
+	// Meta 中间件和钩子在各阶段之间传递数据的存储，会话结束时写入访问日志
+	Meta *Metadata
+	// Profile 接受连接的附加监听器的策略，服务端自己的监听器接受的连接为 nil
+	Profile *Profile
+
+	deadline atomic.Int64 // 最长存续时间到期时间（UnixNano），0 表示不限制
+	idle     atomic.Int64 // 转发使用的空闲超时，0 表示不限制
+	rejected atomic.Bool  // 已写出失败应答，ServeConn 返回前平缓关闭连接
+}
+
+// NewSession 包装客户端连接
+func NewSession(c net.Conn) *Session {
+	return &Session{Conn: c, Meta: &Metadata{}}
+}
+
+// Deadline 返回最长存续时间到期的时间，未限制或转发尚未开始时返回零值
+func (sess *Session) Deadline() time.Time {
+	if ns := sess.deadline.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Remaining 返回距离最长存续时间到期的剩余时间，未限制时 ok 为 false
+func (sess *Session) Remaining() (d time.Duration, ok bool) {
+	t := sess.Deadline()
+	if t.IsZero() {
+		return 0, false
+	}
+	return max(time.Until(t), 0), true
+}
+
+func (sess *Session) setDeadline(t time.Time) {
+	sess.deadline.Store(t.UnixNano())
+}
+
+// IdleTimeout 返回转发开始时按服务端配置和路由规则选定的空闲超时，0 表示不限制
+func (sess *Session) IdleTimeout() time.Duration {
+	return time.Duration(sess.idle.Load())
+}
+
+func (sess *Session) setIdleTimeout(d time.Duration) {
+	sess.idle.Store(int64(d))
+}
+
+// Metadata 会话级的键值存储，可并发使用，nil 的 Metadata 读取为空、写入被忽略
+//
+// 生命周期：TCP 会话的 Metadata 随 Session 在接受连接时创建，到 Handle 返回、访问日志写出后不再使用；
+// UDP ASSOCIATE 的 Metadata 就是其控制连接的 Session.Meta，关联存续期间同一客户端地址的所有数据报
+// 通过 MetadataFromContext 共享它，关联结束后新到的数据报取不到
+// 写入访问日志的值需要能被 encoding/json 编码
+type Metadata struct {
+	mu sync.RWMutex
+	m  map[string]any
+}
+
+// Set 设置 key 的值
+func (m *Metadata) Set(key string, v any) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = make(map[string]any)
+	}
+	m.m[key] = v
+}
+
+// Get 返回 key 的值
+func (m *Metadata) Get(key string) (any, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.m[key]
+	return v, ok
+}
+
+// Delete 删除 key
+func (m *Metadata) Delete(key string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.m, key)
+}
+
+// Snapshot 返回当前内容的副本，为空时返回 nil
+func (m *Metadata) Snapshot() map[string]any {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.m) == 0 {
+		return nil
+	}
+	out := make(map[string]any, len(m.m))
+	for k, v := range m.m {
+		out[k] = v
+	}
+	return out
+}
+
+type metadataKey struct{}
+
+// WithMetadata 返回携带 m 的 context
+func WithMetadata(ctx context.Context, m *Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// MetadataFromContext 取出 ctx 携带的 Metadata，没有时返回 nil（可直接调用其方法）
+func MetadataFromContext(ctx context.Context) *Metadata {
+	m, _ := ctx.Value(metadataKey{}).(*Metadata)
+	return m
+}
+
+// rwConn 让不是 net.Conn 的 io.ReadWriter 可以包装为 Session：地址为 nil，不支持截止时间，Close 不做任何事
+type rwConn struct{ io.ReadWriter }
+
+func (rwConn) Close() error                     { return nil }
+func (rwConn) LocalAddr() net.Addr              { return nil }
+func (rwConn) RemoteAddr() net.Addr             { return nil }
+func (rwConn) SetDeadline(time.Time) error      { return nil }
+func (rwConn) SetReadDeadline(time.Time) error  { return nil }
+func (rwConn) SetWriteDeadline(time.Time) error { return nil }
+
+// SessionFromConn 返回连接对应的 Session，不是由 Server 创建的连接返回 nil
+func SessionFromConn(c net.Conn) *Session {
+	sess, _ := c.(*Session)
+	return sess
+}
+
+// certIdentity 从已验证的客户端证书中提取身份：优先 CN，其次第一个 DNS SAN，最后第一个邮箱
+func certIdentity(cs tls.ConnectionState) string {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := cs.VerifiedChains[0][0]
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	if len(leaf.EmailAddresses) > 0 {
+		return leaf.EmailAddresses[0]
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"log"
+	"sync"
+)
+
+// shutdownHooks 关闭钩子，分两个阶段各执行一次
+type shutdownHooks struct {
+	mu        sync.Mutex
+	begin     []func()
+	done      []func()
+	beginOnce sync.Once
+	doneOnce  sync.Once
+	serving   sync.WaitGroup
+}
+
+// RegisterOnShutdown 注册在关闭开始时调用的函数，此时监听器尚未关闭，可用于从服务发现注销等
+// 钩子按注册顺序同步执行，panic 会被恢复并记录
+// Shutdown 调用或 Serve 因错误返回时触发，无论触发多少次都只执行一次
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.begin = append(s.hooks.begin, f)
+}
+
+// RegisterOnShutdownDone 注册在关闭完成时调用的函数，此时监听器已关闭、Serve 已返回，可用于刷新统计等
+// 执行方式与 RegisterOnShutdown 相同
+func (s *Server) RegisterOnShutdownDone(f func()) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.done = append(s.hooks.done, f)
+}
+
+// ShuttingDown 报告是否已请求关闭（调用了 Shutdown 或 Serve 已返回）
+// 监听循环据此区分关闭引起的 Accept、Read 错误和运行中的真实故障
+func (s *Server) ShuttingDown() bool {
+	return s.Context().Err() != nil
+}
+
+// serveErr 在已请求关闭时吞掉监听循环的错误，Serve 因而在正常关闭后返回 nil
+func (s *Server) serveErr(err error) error {
+	if s.ShuttingDown() {
+		return nil
+	}
+	return err
+}
+
+// beginShutdown 执行关闭开始阶段的钩子并取消服务端 context
+func (s *Server) beginShutdown() {
+	s.hooks.beginOnce.Do(func() {
+		s.hooks.mu.Lock()
+		hs := s.hooks.begin
+		s.hooks.mu.Unlock()
+		runShutdownHooks("begin", hs)
+	})
+	s.Context()
+	s.cancel()
+}
+
+// finishShutdown 执行关闭完成阶段的钩子
+func (s *Server) finishShutdown() {
+	s.hooks.doneOnce.Do(func() {
+		s.hooks.mu.Lock()
+		hs := s.hooks.done
+		s.hooks.mu.Unlock()
+		runShutdownHooks("done", hs)
+	})
+}
+
+func runShutdownHooks(phase string, hs []func()) {
+	for _, f := range hs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("shutdown hook (%s) panic: %v", phase, r)
+				}
+			}()
+			f()
+		}()
+	}
+}
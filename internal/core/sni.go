@@ -0,0 +1,128 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ErrSNIDenied is the error when SNIFilter rejects the server name of a relayed TLS connection
+var ErrSNIDenied = errors.New("TLS server name is denied")
+
+// maxClientHelloRecord TLS 记录的最大长度（2^14 加上压缩和加密的余量），更长的不是合法的 ClientHello
+const maxClientHelloRecord = 16384 + 2048
+
+// peekClientHello 在 timeout 内读取客户端的第一个 TLS 记录并从 ClientHello 中取出 SNI，
+// 返回已读取的字节（调用方必须先转发给目标）；首字节不是握手记录、超时或格式不对时立即停止，SNI 为空
+func peekClientHello(c net.Conn, timeout time.Duration) ([]byte, string) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	var hdr [5]byte
+	n, err := io.ReadFull(c, hdr[:1])
+	if err != nil || hdr[0] != 0x16 {
+		return hdr[:n], ""
+	}
+	m, err := io.ReadFull(c, hdr[1:])
+	if err != nil || hdr[1] != 3 {
+		return hdr[:1+m], ""
+	}
+	l := int(hdr[3])<<8 | int(hdr[4])
+	if l > maxClientHelloRecord {
+		return hdr[:], ""
+	}
+	buf := make([]byte, 5+l)
+	copy(buf, hdr[:])
+	k, _ := io.ReadFull(c, buf[5:])
+	buf = buf[:5+k]
+	if k < l {
+		return buf, ""
+	}
+	return buf, parseSNI(buf[5:])
+}
+
+// parseSNI 从握手消息中解析 ClientHello 的 server_name 扩展，不是 ClientHello 或没有 SNI 时返回空
+func parseSNI(b []byte) string {
+	s := cryptobyte.String(b)
+	var msgType uint8
+	var hello cryptobyte.String
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&hello) {
+		return ""
+	}
+	var sessionID, ciphers, compression, exts cryptobyte.String
+	if !hello.Skip(2+32) ||
+		!hello.ReadUint8LengthPrefixed(&sessionID) ||
+		!hello.ReadUint16LengthPrefixed(&ciphers) ||
+		!hello.ReadUint8LengthPrefixed(&compression) ||
+		!hello.ReadUint16LengthPrefixed(&exts) {
+		return ""
+	}
+	for !exts.Empty() {
+		var typ uint16
+		var ext cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&ext) {
+			return ""
+		}
+		if typ != 0 {
+			continue
+		}
+		var names cryptobyte.String
+		if !ext.ReadUint16LengthPrefixed(&names) {
+			return ""
+		}
+		for !names.Empty() {
+			var nameType uint8
+			var name cryptobyte.String
+			if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+				return ""
+			}
+			if nameType == 0 && validDomain(name) {
+				return string(name)
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// peekRelay 在 CONNECT 转发开始前按配置读取客户端的 TLS ClientHello 或 HTTP 请求头，把取到的 SNI 或 Host
+// 记入 rec 和会话，返回已读取、必须先转发给目标的字节；SNIFilter 或 HostFilter 拒绝时返回错误
+func (s *Server) peekRelay(c net.Conn, r *Request, rec *AccessRecord) ([]byte, error) {
+	sess := SessionFromConn(c)
+	port := strconv.Itoa(int(r.Port()))
+	switch {
+	case s.HTTPHostPeek > 0 && slices.Contains(s.httpHostPorts(), r.Port()):
+		peeked, host := peekHTTPHost(c, s.HTTPHostPeek)
+		rec.HTTPHost = cmp.Or(host, "-")
+		if host == "" {
+			return peeked, nil
+		}
+		if sess != nil {
+			sess.HTTPHost = host
+		}
+		if s.HostFilter != nil && !s.HostFilter(net.JoinHostPort(host, port)) {
+			return nil, fmt.Errorf("%s: %w", host, ErrHostDenied)
+		}
+		return peeked, nil
+	case s.SNIPeek > 0:
+		peeked, sni := peekClientHello(c, s.SNIPeek)
+		if sni == "" {
+			return peeked, nil
+		}
+		rec.SNI = sni
+		if sess != nil {
+			sess.SNI = sni
+		}
+		if s.SNIFilter != nil && !s.SNIFilter(net.JoinHostPort(sni, port)) {
+			return nil, fmt.Errorf("%s: %w", sni, ErrSNIDenied)
+		}
+		return peeked, nil
+	}
+	return nil, nil
+}
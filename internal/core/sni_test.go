@@ -0,0 +1,144 @@
+package core_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// tlsThroughProxy 经代理 CONNECT 到 srv，以 serverName 完成真实的 TLS 握手并发出一个 HTTP 请求
+func tlsThroughProxy(t *testing.T, proxy string, srv *httptest.Server, serverName string) error {
+	t.Helper()
+	c := socks5test.Connect(t, proxy, nil, srv.Listener.Addr().String())
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tc := tls.Client(c, &tls.Config{ServerName: serverName, RootCAs: pool})
+	defer tc.Close()
+	tc.SetDeadline(time.Now().Add(socks5test.Timeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("GET", "https://"+serverName+"/", nil)
+	if err := req.Write(tc); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(tc), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello over tls" {
+		t.Fatalf("body %q", body)
+	}
+	return nil
+}
+
+func TestSNIPeekRealHandshake(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "hello over tls")
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // 被拒绝的握手在服务端记为 EOF
+	srv.StartTLS()
+	defer srv.Close()
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.SNIPeek = time.Second
+		s.AccessLog = core.NewAccessLogger(buf)
+		s.SNIFilter = func(addr string) bool { return !strings.HasPrefix(addr, "blocked.example.com:") }
+	})
+
+	// httptest 的证书对 example.com 有效，SNI 为 example.com，IPv4 目标的日志也能看到域名
+	if err := tlsThroughProxy(t, ts.Addr, srv, "example.com"); err != nil {
+		t.Fatalf("handshake through proxy: %v", err)
+	}
+	recs := waitAccessLog(t, buf, 1)
+	if recs[0].SNI != "example.com" {
+		t.Fatalf("access log SNI = %q, want example.com", recs[0].SNI)
+	}
+
+	// SNIFilter 拒绝时 ClientHello 不被转发，握手失败，日志记录原因
+	if err := tlsThroughProxy(t, ts.Addr, srv, "blocked.example.com"); err == nil {
+		t.Fatal("handshake with a denied SNI succeeded")
+	}
+	recs = waitAccessLog(t, buf, 2)
+	if recs[1].SNI != "blocked.example.com" || !strings.Contains(recs[1].Error, core.ErrSNIDenied.Error()) {
+		t.Fatalf("denied record: sni %q, error %q", recs[1].SNI, recs[1].Error)
+	}
+}
+
+// serverFirst 接受一个连接，先发出问候再回显，模拟由服务端先发言的协议
+func serverFirst(t *testing.T, greeting string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, greeting)
+		io.Copy(c, c)
+	}()
+	return l.Addr().String()
+}
+
+func TestSNIPeekPassThrough(t *testing.T) {
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.SNIPeek = 200 * time.Millisecond
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	echo := socks5test.EchoTCPServer(t)
+
+	// 非 TLS 数据、以握手记录类型开头但版本不对的数据、被截断的 TLS 记录头都原样转发
+	for _, msg := range [][]byte{
+		[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		{0x16, 0x00, 0x01, 0x02, 0x03, 0x04},
+		{0x00, 0xff, 0x16, 0x03, 0x01},
+	} {
+		c := socks5test.Connect(t, ts.Addr, nil, echo)
+		socks5test.EchoTCP(t, c, msg)
+		c.Close()
+	}
+
+	// 服务端先发言时客户端在 SNIPeek 内什么都不发：问候不被延迟，超时后的数据照常转发
+	c := socks5test.Connect(t, ts.Addr, nil, serverFirst(t, "220 ready\r\n"))
+	c.SetDeadline(time.Now().Add(socks5test.Timeout))
+	start := time.Now()
+	br := bufio.NewReader(c)
+	line, err := br.ReadString('\n')
+	if err != nil || line != "220 ready\r\n" {
+		t.Fatalf("greeting %q, %v", line, err)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Fatalf("greeting delayed %v by the peek", d)
+	}
+	time.Sleep(300 * time.Millisecond)
+	io.WriteString(c, "QUIT\r\n")
+	if line, err = br.ReadString('\n'); err != nil || line != "QUIT\r\n" {
+		t.Fatalf("after peek timeout got %q, %v", line, err)
+	}
+	c.Close()
+
+	for _, rec := range waitAccessLog(t, buf, 4) {
+		if rec.SNI != "" || rec.Error != "" {
+			t.Fatalf("pass-through record: sni %q, error %q", rec.SNI, rec.Error)
+		}
+	}
+}
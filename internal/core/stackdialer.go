@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// StackDialer 将用户态协议栈（如 gVisor netstack）适配为出站拨号器
+// 协议栈只需按 IP 地址建立连接，域名由 Resolver 解析后依次尝试各地址
+//
+// 以 gVisor 为例：
+//
+//	d := &core.StackDialer{
+//		DialTCP: func(ctx context.Context, ap netip.AddrPort) (net.Conn, error) {
+//			return gonet.DialContextTCP(ctx, stack, fullAddress(ap), protoNumber(ap))
+//		},
+//		DialUDP: func(ctx context.Context, ap netip.AddrPort) (net.Conn, error) {
+//			return gonet.DialUDP(stack, nil, ptr(fullAddress(ap)), protoNumber(ap))
+//		},
+//		Resolver: resolverThroughTheStack,
+//	}
+//	server.Dialer = d
+//
+// 返回的连接不必是 *net.TCPConn/*net.UDPConn，LocalAddr 无法表示为 host:port 时成功应答报告 0.0.0.0:0
+type StackDialer struct {
+	DialTCP  func(ctx context.Context, addr netip.AddrPort) (net.Conn, error)
+	DialUDP  func(ctx context.Context, addr netip.AddrPort) (net.Conn, error) // nil 表示不支持 UDP
+	Resolver Resolver                                                         // nil 时使用系统解析器，注意它不经过该协议栈
+}
+
+func (d *StackDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := d.DialTCP
+	if network == "udp" {
+		dial = d.DialUDP
+	}
+	if dial == nil {
+		if network == "udp" {
+			return nil, ErrUDPNotSupported
+		}
+		return nil, errors.New("the network stack has no TCP dialer")
+	}
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid port", Addr: addr}
+	}
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		r := d.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		if ips, err = lookupIPAddr(ctx, r, host); err != nil {
+			return nil, err
+		}
+	}
+	for _, ip := range ips {
+		a, ok := netip.AddrFromSlice(ip.IP)
+		if !ok {
+			continue
+		}
+		var c net.Conn
+		c, err = dial(ctx, netip.AddrPortFrom(a.Unmap(), uint16(port)))
+		if err == nil {
+			return c, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = &net.AddrError{Err: "no usable address", Addr: addr}
+	}
+	return nil, err
+}
+
+func (d *StackDialer) SupportsUDP() bool {
+	return d.DialUDP != nil
+}
@@ -0,0 +1,112 @@
+package core_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// pipeStack 以 net.Pipe 模拟用户态协议栈：每次拨号返回管道的一端，另一端由回显的“远端”处理，
+// 管道每次 Write 对应一次 Read，可以当作已连接的 UDP 套接字
+type pipeStack struct {
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (p *pipeStack) dial(network string) func(context.Context, netip.AddrPort) (net.Conn, error) {
+	return func(_ context.Context, ap netip.AddrPort) (net.Conn, error) {
+		p.mu.Lock()
+		p.dialed = append(p.dialed, network+" "+ap.String())
+		p.mu.Unlock()
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			b := make([]byte, 64*1024)
+			for {
+				n, err := remote.Read(b)
+				if err != nil {
+					return
+				}
+				if _, err := remote.Write(b[:n]); err != nil {
+					return
+				}
+			}
+		}()
+		return local, nil
+	}
+}
+
+func (p *pipeStack) calls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.dialed...)
+}
+
+func TestStackDialerEndToEnd(t *testing.T) {
+	stack := &pipeStack{}
+	d := &core.StackDialer{
+		DialTCP:  stack.dial("tcp"),
+		DialUDP:  stack.dial("udp"),
+		Resolver: staticResolver{"svc.stack": {net.ParseIP("10.9.9.9")}},
+	}
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.Dialer = d })
+
+	// 管道的本地地址不是 host:port，成功应答报告 0.0.0.0:0
+	c, rp := socks5test.Handshake(t, ts.Addr, nil, core.CmdConnect, "svc.stack:80")
+	if rp.Rep != core.RepSuccess || rp.Address() != "0.0.0.0:0" {
+		t.Fatalf("reply %s bound %s, want RepSuccess 0.0.0.0:0", core.RepName(rp.Rep), rp.Address())
+	}
+	socks5test.EchoTCP(t, c, []byte("through the pipe stack"))
+	c.Close()
+
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay, "[2001:db8::53]:53", []byte("udp through the stack"))
+
+	calls := stack.calls()
+	want := map[string]bool{"tcp 10.9.9.9:80": false, "udp [2001:db8::53]:53": false}
+	for _, c := range calls {
+		want[c] = true
+	}
+	for k, seen := range want {
+		if !seen {
+			t.Fatalf("stack dials %q, missing %q", calls, k)
+		}
+	}
+}
+
+func TestStackDialerWithoutUDP(t *testing.T) {
+	stack := &pipeStack{}
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.Dialer = &core.StackDialer{DialTCP: stack.dial("tcp")} })
+	socks5test.ExpectRep(t, ts.Addr, nil, core.CmdUDP, "0.0.0.0:0", core.RepCommandNotSupported)
+	c := socks5test.Connect(t, ts.Addr, nil, "192.0.2.1:443")
+	socks5test.EchoTCP(t, c, []byte("tcp still works"))
+}
+
+func TestStackDialerErrors(t *testing.T) {
+	d := &core.StackDialer{
+		DialTCP: func(context.Context, netip.AddrPort) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: io.ErrClosedPipe}
+		},
+		Resolver: staticResolver{},
+	}
+	ctx := context.Background()
+	if _, err := d.DialContext(ctx, "udp", "10.0.0.1:53"); err != core.ErrUDPNotSupported {
+		t.Fatalf("UDP without DialUDP = %v", err)
+	}
+	if _, err := d.DialContext(ctx, "tcp", "missing.stack:80"); err == nil {
+		t.Fatal("dial to an unresolvable name succeeded")
+	}
+	if _, err := d.DialContext(ctx, "tcp", "10.0.0.1:http"); err == nil {
+		t.Fatal("dial with a named port succeeded")
+	}
+	if _, err := d.DialContext(ctx, "tcp", "10.0.0.1:80"); err == nil {
+		t.Fatal("failing stack dial succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package core_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// trickle 每隔 200ms 写入一个字节，使该方向不会因空闲超时结束，直到写入失败
+func trickle(c net.Conn) {
+	for {
+		if _, err := c.Write([]byte{'.'}); err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// flood 不断写入直到失败
+func flood(c net.Conn) {
+	b := make([]byte, 64*1024)
+	for {
+		if _, err := c.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+// peerServer 在本地监听，对每个接受的连接运行 handle
+func peerServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRelayWriteStall(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		peer   func(net.Conn) // 目标
+		client func(net.Conn) // 客户端
+	}{
+		// 目标不读：客户端的上行数据写入目标时阻塞
+		{"destination", trickle, func(c net.Conn) {
+			go io.Copy(io.Discard, c)
+			flood(c)
+		}},
+		// 客户端不读：目标的下行数据写入客户端时阻塞
+		{"client", flood, trickle},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &syncBuffer{}
+			ts := socks5test.NewServer(t, func(s *core.Server) {
+				s.TCPTimeout = 1
+				s.AccessLog = core.NewAccessLogger(buf)
+			})
+			c := socks5test.Connect(t, ts.Addr, nil, peerServer(t, tc.peer))
+			done := make(chan struct{})
+			go func() {
+				tc.client(c)
+				close(done)
+			}()
+			// 缓冲区写满后 1s 内写入不能完成，两端都被关闭
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("relay not torn down after the peer stopped reading")
+			}
+			rec := waitAccessLog(t, buf, 1)[0]
+			if !strings.Contains(rec.Error, core.ErrWriteStall.Error()) {
+				t.Fatalf("access log error = %q, want %q", rec.Error, core.ErrWriteStall)
+			}
+			if rec.BytesUp == 0 || rec.BytesDown == 0 {
+				t.Fatalf("bytes up %d down %d, want both counted", rec.BytesUp, rec.BytesDown)
+			}
+		})
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.TCPTimeout = 1
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	c := socks5test.Connect(t, ts.Addr, nil, peerServer(t, func(c net.Conn) { io.Copy(io.Discard, c) }))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("idle relay not closed")
+	}
+	rec := waitAccessLog(t, buf, 1)[0]
+	if !strings.Contains(rec.Error, core.ErrIdleTimeout.Error()) {
+		t.Fatalf("access log error = %q, want %q", rec.Error, core.ErrIdleTimeout)
+	}
+}
+
+// 不读取应答的 UDP 客户端不会阻塞共享套接字上发给其他客户端的应答；回环上多出的数据报由内核在接收端丢弃，
+// 发送方的写截止时间通常不会触发，这里只检查其他关联不受影响
+func TestUDPReplyToStalledClient(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	// 目标对每个数据报回复 64 个满载的数据报
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		b := make([]byte, 65507)
+		for {
+			_, addr, err := pc.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			for range 64 {
+				pc.WriteToUDP(b[:60000], addr)
+			}
+		}
+	}()
+
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	stalled := listenUDP(t)
+	for range 32 {
+		udpSend(t, stalled, relay, pc.LocalAddr().String(), []byte("amplify"))
+	}
+
+	ctl2, relay2 := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl2.Close()
+	socks5test.EchoUDP(t, relay2, socks5test.EchoUDPServer(t), []byte("not blocked"))
+}
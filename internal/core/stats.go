@@ -0,0 +1,142 @@
+package core
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats 服务端的累计计数，各字段可并发读取
+type ServerStats struct {
+	Connections            atomic.Int64 // 接受的客户端连接，包括被白名单、黑名单和封禁拒绝的
+	AuthFailures           atomic.Int64 // 认证失败的连接
+	BytesUp                atomic.Int64 // 转发给目标的字节数（TCP 转发和 UDP 数据报的载荷）
+	BytesDown              atomic.Int64 // 转发给客户端的字节数
+	UDPQueueFull           atomic.Int64 // 处理队列已满而丢弃的客户端数据报
+	UDPReplyDropped        atomic.Int64 // 发送缓冲区满而丢弃的应答数据报
+	HandshakeTimeouts      atomic.Int64 // 协商或请求阶段超过 HandshakeTimeout 被断开的连接
+	HandshakeErrors        atomic.Int64 // 协商或请求阶段的其他协议错误，不含超时和认证失败
+	UDPMalformed           atomic.Int64 // 格式错误、域名非法或分片而被丢弃的客户端数据报
+	BadUserPassVersion     atomic.Int64 // 子协商版本为 0x05、被 AllowBadUserPassVersion 放行的请求
+	HandshakeLogSuppressed atomic.Int64 // 超过 HandshakeLogThreshold、未逐条输出的协商和请求阶段错误
+	BannedConns            atomic.Int64 // 来源处于封禁中而直接关闭的连接
+	UDPUnassociated        atomic.Int64 // LimitUDP 或 UDPRequireAssociatedIP 丢弃的不属于任何关联的数据报
+	LoadShedEvents         atomic.Int64 // LoadShed 开始卸载负载的次数
+	LoadShedRequests       atomic.Int64 // 卸载负载期间被拒绝的请求
+	IdleTimeouts           atomic.Int64 // 因空闲超时结束的 TCP 转发和 UDP 关联
+	LifetimeExpired        atomic.Int64 // 因达到最长存续时间被关闭的 TCP 转发和 UDP 关联
+	IdleReaped             atomic.Int64 // 被 IdleReapAfter 收割的 TCP 转发
+	TFOAttempts            atomic.Int64 // 设置了 TCP_FASTOPEN_CONNECT 的出站连接，是否实际携带数据取决于 cookie 和目标
+	TFORefused             atomic.Int64 // 内核拒绝 TCP_FASTOPEN_CONNECT、以普通 TCP 拨号的出站连接
+	UDPRateLimited         atomic.Int64 // 超过关联的数据报速率上限而丢弃的客户端数据报
+	ErrorLogSuppressed     atomic.Int64 // 被 ErrorLogSampler 抑制、未逐条输出的拨号和认证失败
+}
+
+// StatsSnapshot ServerStats 某一时刻的值
+type StatsSnapshot struct {
+	Connections            int64 `json:"connections"`
+	AuthFailures           int64 `json:"auth_failures"`
+	BytesUp                int64 `json:"bytes_up"`
+	BytesDown              int64 `json:"bytes_down"`
+	UDPQueueFull           int64 `json:"udp_queue_full"`
+	UDPReplyDropped        int64 `json:"udp_reply_dropped"`
+	HandshakeTimeouts      int64 `json:"handshake_timeouts"`
+	HandshakeErrors        int64 `json:"handshake_errors"`
+	UDPMalformed           int64 `json:"udp_malformed"`
+	BadUserPassVersion     int64 `json:"bad_userpass_version"`
+	HandshakeLogSuppressed int64 `json:"handshake_log_suppressed"`
+	BannedConns            int64 `json:"banned_conns"`
+	UDPUnassociated        int64 `json:"udp_unassociated"`
+	LoadShedEvents         int64 `json:"load_shed_events"`
+	LoadShedRequests       int64 `json:"load_shed_requests"`
+	IdleTimeouts           int64 `json:"idle_timeouts"`
+	LifetimeExpired        int64 `json:"lifetime_expired"`
+	IdleReaped             int64 `json:"idle_reaped"`
+	TFOAttempts            int64 `json:"tfo_attempts"`
+	TFORefused             int64 `json:"tfo_refused"`
+	UDPRateLimited         int64 `json:"udp_rate_limited"`
+	ErrorLogSuppressed     int64 `json:"error_log_suppressed"`
+	// LocalPorts Server.LocalPorts 的使用情况，只由 Server.StatsSnapshot 填写
+	LocalPorts *PortRangeStats `json:"local_ports,omitempty"`
+	// CertDaysLeft Server.Certs 当前证书的剩余有效天数，只由 Server.StatsSnapshot 填写
+	CertDaysLeft *float64 `json:"cert_days_left,omitempty"`
+	// Faults Server.Faults 已注入的故障数，只由 Server.StatsSnapshot 填写
+	Faults *FaultStats `json:"faults,omitempty"`
+	// Listeners 附加监听器按 Label 的计数，只由 Server.StatsSnapshot 填写，上面的计数包括它们
+	Listeners map[string]ProfileStats `json:"listeners,omitempty"`
+	// NegativeCache Server.NegativeCache 的计数，只由 Server.StatsSnapshot 填写
+	NegativeCache *NegativeCacheStats `json:"dns_negative_cache,omitempty"`
+}
+
+// Snapshot 返回当前计数
+func (st *ServerStats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Connections:            st.Connections.Load(),
+		AuthFailures:           st.AuthFailures.Load(),
+		BytesUp:                st.BytesUp.Load(),
+		BytesDown:              st.BytesDown.Load(),
+		UDPQueueFull:           st.UDPQueueFull.Load(),
+		UDPReplyDropped:        st.UDPReplyDropped.Load(),
+		HandshakeTimeouts:      st.HandshakeTimeouts.Load(),
+		HandshakeErrors:        st.HandshakeErrors.Load(),
+		UDPMalformed:           st.UDPMalformed.Load(),
+		BadUserPassVersion:     st.BadUserPassVersion.Load(),
+		HandshakeLogSuppressed: st.HandshakeLogSuppressed.Load(),
+		BannedConns:            st.BannedConns.Load(),
+		UDPUnassociated:        st.UDPUnassociated.Load(),
+		LoadShedEvents:         st.LoadShedEvents.Load(),
+		LoadShedRequests:       st.LoadShedRequests.Load(),
+		IdleTimeouts:           st.IdleTimeouts.Load(),
+		LifetimeExpired:        st.LifetimeExpired.Load(),
+		IdleReaped:             st.IdleReaped.Load(),
+		TFOAttempts:            st.TFOAttempts.Load(),
+		TFORefused:             st.TFORefused.Load(),
+		UDPRateLimited:         st.UDPRateLimited.Load(),
+		ErrorLogSuppressed:     st.ErrorLogSuppressed.Load(),
+	}
+}
+
+// StatsSnapshot 返回 Stats 的当前计数，以及本地端口范围等服务端状态
+func (s *Server) StatsSnapshot() StatsSnapshot {
+	st := s.Stats.Snapshot()
+	if s.LocalPorts != nil {
+		ps := s.LocalPorts.Stats()
+		st.LocalPorts = &ps
+	}
+	if s.Certs != nil {
+		if t := s.Certs.NotAfter(); !t.IsZero() {
+			days := time.Until(t).Hours() / 24
+			st.CertDaysLeft = &days
+		}
+	}
+	if s.Faults != nil {
+		fs := s.Faults.Stats()
+		st.Faults = &fs
+	}
+	if s.NegativeCache != nil {
+		ns := s.NegativeCache.Stats()
+		st.NegativeCache = &ns
+	}
+	if profiles := s.Profiles(); len(profiles) > 0 {
+		st.Listeners = make(map[string]ProfileStats, len(profiles))
+		for _, p := range profiles {
+			st.Listeners[p.Label] = p.Stats()
+		}
+	}
+	return st
+}
+
+// countHandshakeError 按超时、认证失败和协议错误分别统计协商、请求阶段的错误
+func (st *ServerStats) countHandshakeError(err error) {
+	var he *HandshakeError
+	var re *RequestError
+	var ae *AuthError
+	switch {
+	case isTimeout(err):
+		st.HandshakeTimeouts.Add(1)
+	case errors.As(err, &ae):
+		st.AuthFailures.Add(1)
+	case errors.As(err, &he) || errors.As(err, &re):
+		st.HandshakeErrors.Add(1)
+	}
+}
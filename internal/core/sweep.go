@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+)
+
+// ErrSessionRevoked is the error when a running relay or UDP association is closed because its client or user lost access
+var ErrSessionRevoked = errors.New("session revoked")
+
+// SweepResult 一次清理关闭的会话
+type SweepResult struct {
+	Closed  int      `json:"closed"`            // 关闭的 TCP 转发和 UDP 关联数
+	Clients []string `json:"clients,omitempty"` // 涉及的客户端 IP，已排序
+	Users   []string `json:"users,omitempty"`   // 涉及的用户，已排序
+}
+
+// Sweep 关闭 match 返回 true 的进行中 TCP 转发和 UDP 关联，访问日志记为 session revoked，
+// 结果写入日志和审计日志（Rule 为 reason）；sess 在非 ServeConn 建立的连接上为 nil，ip 在远端地址不是 IP 时为 nil
+// 只遍历登记表，不持有白名单、封禁表等的锁，清理期间新连接照常处理
+func (s *Server) Sweep(reason string, match func(ip net.IP, sess *Session) bool) SweepResult {
+	var res SweepResult
+	clients := map[string]struct{}{}
+	users := map[string]struct{}{}
+	s.relays.m.Range(func(k, _ any) bool {
+		r := k.(*relay)
+		ip := addrIP(r.conns[0].RemoteAddr())
+		if !match(ip, r.sess) || !r.close(ErrSessionRevoked) {
+			return true
+		}
+		res.Closed++
+		if ip != nil {
+			clients[unmapIP(ip).String()] = struct{}{}
+		}
+		if r.sess != nil && r.sess.User != "" {
+			users[r.sess.User] = struct{}{}
+		}
+		return true
+	})
+	res.Clients = slices.Sorted(maps.Keys(clients))
+	res.Users = slices.Sorted(maps.Keys(users))
+	detail := fmt.Sprintf("closed %d", res.Closed)
+	if len(res.Clients) > 0 {
+		detail += "; clients " + strings.Join(res.Clients, ",")
+	}
+	if len(res.Users) > 0 {
+		detail += "; users " + strings.Join(res.Users, ",")
+	}
+	if res.Closed > 0 {
+		log.Printf("Sweep (%s): %s", reason, detail)
+	}
+	s.Audit.event(AuditSweep, nil, "", "", reason, detail)
+	return res
+}
+
+// SweepDenied 按当前的访问控制清理会话：来源 IP 不在白名单中（白名单非空时）、在黑名单中或处于封禁中，
+// 或 Auth 为 *StaticCredentials 时以用户名密码登录的用户已被删除；修改白名单、黑名单、封禁表或删除用户后调用；
+// 附加监听器接受的会话按其 Profile 的白名单和 Auth 检查
+func (s *Server) SweepDenied(reason string) SweepResult {
+	return s.Sweep(reason, func(ip net.IP, sess *Session) bool {
+		p := profileOf(sess)
+		if ip != nil && (!s.allows(p, ip) || s.IsDenied(ip) || s.Bans != nil && s.Bans.Banned(ip)) {
+			return true
+		}
+		creds, _ := s.auth(p).(*StaticCredentials)
+		return creds != nil && sess != nil && sess.Method == MethodUsernamePassword && sess.CertUser == "" && !creds.Has(sess.User)
+	})
+}
+
+// addrIP 返回 TCP 或 UDP 地址的 IP，其他地址返回 nil
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultTarpitMaxConns = 100
+	DefaultTarpitMaxHold  = time.Minute
+	DefaultTarpitInterval = 10 * time.Second
+)
+
+// Tarpit 拖住被拒绝的连接：不立即关闭，而是每隔 Interval 读取并丢弃一个字节，直到客户端断开或超过 MaxHold，
+// 使扫描器不会立即重试；同时拖住的连接数超过 MaxConns 时新连接直接关闭，避免反被耗尽资源
+// 各项为 0 时使用对应的默认值
+type Tarpit struct {
+	MaxConns int
+	MaxHold  time.Duration
+	Interval time.Duration
+
+	active atomic.Int64
+}
+
+// Active 返回当前被拖住的连接数
+func (t *Tarpit) Active() int64 {
+	return t.active.Load()
+}
+
+// hold 拖住连接直到客户端断开、超过 MaxHold 或 ctx 取消，已达到 MaxConns 时立即返回
+func (t *Tarpit) hold(ctx context.Context, c net.Conn) {
+	if t.active.Add(1) > int64(cmp.Or(t.MaxConns, DefaultTarpitMaxConns)) {
+		t.active.Add(-1)
+		return
+	}
+	defer t.active.Add(-1)
+	interval := cmp.Or(t.Interval, DefaultTarpitInterval)
+	deadline := time.Now().Add(cmp.Or(t.MaxHold, DefaultTarpitMaxHold))
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var b [1]byte
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			return
+		}
+		c.SetReadDeadline(now.Add(min(interval, deadline.Sub(now))))
+		if _, err := c.Read(b[:]); err != nil && !isTimeout(err) {
+			return
+		}
+		timer.Reset(min(interval, time.Until(deadline)))
+	}
+}
+
+// tarpit 在设置了 Tarpit 时拖住被拒绝的连接，ctx 随 Shutdown 取消
+func (s *Server) tarpit(ctx context.Context, c net.Conn) {
+	if s.Tarpit != nil {
+		s.Tarpit.hold(ctx, c)
+	}
+}
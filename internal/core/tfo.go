@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrFastOpenUnsupported is the error when TCP Fast Open is not supported on this platform
+var ErrFastOpenUnsupported = errors.New("TCP Fast Open is not supported on this platform")
+
+// 内核拒绝 TFO 时只警告一次，之后的连接照常使用普通 TCP
+var (
+	tfoListenWarnOnce sync.Once
+	tfoDialWarnOnce   sync.Once
+)
+
+// fastOpenListenControl 返回为监听套接字设置 TCP_FASTOPEN（队列长度 queue）的 net.ListenConfig.Control，
+// 内核拒绝时记录一次警告，监听照常建立
+func fastOpenListenControl(queue int) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		if err := setFastOpen(rc, queue); err != nil {
+			tfoListenWarnOnce.Do(func() {
+				log.Printf("Warning: cannot enable TCP Fast Open on %s listener: %v", address, err)
+			})
+		}
+		return nil
+	}
+}
+
+// fastOpenDialControl 返回先执行 control（可为 nil）再为出站套接字设置 TCP_FASTOPEN_CONNECT 的 net.Dialer.Control，
+// UDP 套接字不受影响。设置成功计入 st.TFOAttempts，内核拒绝时计入 st.TFORefused 并记录一次警告，拨号照常进行；st 可以为 nil
+func fastOpenDialControl(st *ServerStats, control func(network, address string, rc syscall.RawConn) error) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, rc); err != nil {
+				return err
+			}
+		}
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		err := setFastOpenConnect(rc)
+		if st != nil {
+			if err != nil {
+				st.TFORefused.Add(1)
+			} else {
+				st.TFOAttempts.Add(1)
+			}
+		}
+		if err != nil {
+			tfoDialWarnOnce.Do(func() {
+				log.Printf("Warning: cannot enable TCP Fast Open on outbound %s sockets, dialing without it: %v", network, err)
+			})
+		}
+		return nil
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setFastOpen 为监听套接字设置 TCP_FASTOPEN，queue 为尚未完成三次握手的 TFO 连接的队列长度
+func setFastOpen(rc syscall.RawConn, queue int) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, queue)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setFastOpenConnect 为出站套接字设置 TCP_FASTOPEN_CONNECT：有缓存的 cookie 时 SYN 推迟到第一次写并携带数据，
+// 没有 cookie 或目标不支持时内核按普通三次握手连接
+func setFastOpenConnect(rc syscall.RawConn) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// CheckFastOpen 检查 net.ipv4.tcp_fastopen 是否允许监听（listen）和出站（dial）使用 TCP Fast Open，
+// 不允许时套接字选项可以设置成功但不起作用
+func CheckFastOpen(listen, dial bool) error {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/tcp_fastopen")
+	if err != nil {
+		return err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("net.ipv4.tcp_fastopen: %w", err)
+	}
+	switch {
+	case listen && v&2 == 0:
+		return fmt.Errorf("net.ipv4.tcp_fastopen is %d, server side TFO needs bit 2 set", v)
+	case dial && v&1 == 0:
+		return fmt.Errorf("net.ipv4.tcp_fastopen is %d, client side TFO needs bit 1 set", v)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package core
+
+import "syscall"
+
+func setFastOpen(rc syscall.RawConn, queue int) error {
+	return ErrFastOpenUnsupported
+}
+
+func setFastOpenConnect(rc syscall.RawConn) error {
+	return ErrFastOpenUnsupported
+}
+
+// CheckFastOpen 在 Linux 以外的平台上总是返回 ErrFastOpenUnsupported
+func CheckFastOpen(listen, dial bool) error {
+	return ErrFastOpenUnsupported
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// DefaultCertWatchInterval CertReloader.Watch 检查证书文件是否变化的默认间隔
+const DefaultCertWatchInterval = 10 * time.Second
+
+// CertProvider 服务端证书的来源，CertReloader 和 ACMECerts 实现它
+type CertProvider interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// NotAfter 返回当前证书的到期时间，还没有证书时返回零值
+	NotAfter() time.Time
+}
+
+// CertReloader 持有可在运行时替换的证书，供 tls.Config.GetCertificate 使用
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader 加载证书和私钥，加载失败时返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload 重新读取证书文件，失败时保留旧证书
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.CertFile, cr.KeyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+	cr.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate 返回当前证书，证书过期时尝试从文件重新加载
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := cr.cert.Load()
+	if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+		if err := cr.Reload(); err != nil {
+			log.Printf("Warning: certificate expired and reload failed: %v", err)
+		} else {
+			cert = cr.cert.Load()
+		}
+	}
+	return cert, nil
+}
+
+// NotAfter 返回当前证书的到期时间
+func (cr *CertReloader) NotAfter() time.Time {
+	if cert := cr.cert.Load(); cert != nil && cert.Leaf != nil {
+		return cert.Leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+// certFiles 证书和私钥文件的修改时间和大小，用于发现文件被替换
+type certFiles struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+func (cr *CertReloader) stat() certFiles {
+	var st certFiles
+	if fi, err := os.Stat(cr.CertFile); err == nil {
+		st.certMod, st.certSize = fi.ModTime(), fi.Size()
+	}
+	if fi, err := os.Stat(cr.KeyFile); err == nil {
+		st.keyMod, st.keySize = fi.ModTime(), fi.Size()
+	}
+	return st
+}
+
+// Watch 每隔 interval 检查证书和私钥文件，修改时间或大小变化时重新加载，直到 ctx 取消
+// 加载失败（如只替换了其中一个文件）时保留旧证书、输出警告，并在之后每次检查时重试
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	last := cr.stat()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		st := cr.stat()
+		if st == last {
+			continue
+		}
+		if err := cr.Reload(); err != nil {
+			log.Printf("Warning: certificate files changed but reload failed, keeping previous certificate: %v", err)
+			continue
+		}
+		last = st
+		log.Printf("Certificate reloaded from %s, expires %s", cr.CertFile, cr.NotAfter().Format(time.RFC3339))
+	}
+}
+
+// NewTLSConfig 构造服务端 TLS 配置
+// minVersion 为 "1.0"、"1.1"、"1.2" 或 "1.3"，为空时使用 1.2；cipherSuites 为空时使用 Go 默认套件
+// certs 为 *ACMECerts 时在 ALPN 中声明 acme-tls/1，使同一监听可以应答 TLS-ALPN-01 验证
+func NewTLSConfig(certs CertProvider, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	v, err := ParseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := ParseCipherSuites(cipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     v,
+		CipherSuites:   ids,
+	}
+	if _, ok := certs.(*ACMECerts); ok {
+		cfg.NextProtos = []string{acme.ALPNProto}
+	}
+	return cfg, nil
+}
+
+// ParseTLSVersion 将 "1.2" 形式的版本号转换为 tls.VersionTLS12 等常量
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", s)
+}
+
+// ParseCipherSuites 将套件名称（如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256）转换为 ID
+// 只接受 Go 认为安全的套件，TLS 1.3 的套件不可配置
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// EnableClientAuth 要求客户端出示由 caFile 中 CA 签发的证书，序列号在 revoked 中的证书被拒绝
+func EnableClientAuth(cfg *tls.Config, caFile string, revoked map[string]struct{}) error {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("%s: no certificates found", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(revoked) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if _, ok := revoked[serialKey(cert.SerialNumber)]; ok {
+					return fmt.Errorf("client certificate %s (serial %s) is revoked", cert.Subject.CommonName, serialKey(cert.SerialNumber))
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// LoadRevokedSerials 从 CRL 文件（PEM 或 DER）和序列号黑名单文件加载被吊销的证书序列号
+// 黑名单文件每行一个十六进制序列号，允许冒号分隔、# 注释和空行
+func LoadRevokedSerials(crlFile, denyFile string) (map[string]struct{}, error) {
+	revoked := make(map[string]struct{})
+	if crlFile != "" {
+		b, err := os.ReadFile(crlFile)
+		if err != nil {
+			return nil, err
+		}
+		if block, _ := pem.Decode(b); block != nil {
+			b = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", crlFile, err)
+		}
+		for _, rc := range crl.RevokedCertificateEntries {
+			revoked[serialKey(rc.SerialNumber)] = struct{}{}
+		}
+	}
+	if denyFile != "" {
+		b, err := os.ReadFile(denyFile)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(b), "\n") {
+			if j := strings.IndexByte(line, '#'); j >= 0 {
+				line = line[:j]
+			}
+			line = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(line), ":", ""))
+			if line == "" {
+				continue
+			}
+			n, ok := new(big.Int).SetString(line, 16)
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: invalid serial number %q", denyFile, i+1, line)
+			}
+			revoked[serialKey(n)] = struct{}{}
+		}
+	}
+	return revoked, nil
+}
+
+// serialKey 将证书序列号格式化为小写十六进制
+func serialKey(n *big.Int) string {
+	return n.Text(16)
+}
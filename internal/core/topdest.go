@@ -0,0 +1,221 @@
+package core
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// topFlushInterval 进行中的转发和 UDP 交换把新转发的字节计入 TopDestinations 的间隔
+const topFlushInterval = 10 * time.Second
+
+// TopDestinations 按目标主机（已知时为 SNI、HTTP Host 或请求的域名，否则为 IP）累计转发的字节数和连接数，
+// 最多跟踪 Max 个主机，满时淘汰最久没有更新的；每隔 HalfLife 所有计数减半，报告反映的是最近的流量而不是启动以来的总量
+// 字节在转发结束时计入，存续较长的转发每隔 10s 计入一次，可并发使用
+type TopDestinations struct {
+	Max      int
+	HalfLife time.Duration // 0 表示不衰减
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element // 值为 *topEntry
+	lru       list.List                // 最近更新的在前
+	lastDecay time.Time
+
+	evicted atomic.Int64
+}
+
+type topEntry struct {
+	host  string
+	conns float64
+	up    float64
+	down  float64
+}
+
+// DestinationStats 一个目标主机衰减后的计数
+type DestinationStats struct {
+	Host      string `json:"host"`
+	Bytes     int64  `json:"bytes"`
+	BytesUp   int64  `json:"bytes_up"`
+	BytesDown int64  `json:"bytes_down"`
+	Conns     int64  `json:"conns"`
+}
+
+// TopDestinationsReport 管理接口和统计转储输出的排行
+type TopDestinationsReport struct {
+	Tracked      int                `json:"tracked"`
+	Evicted      int64              `json:"evicted"` // 因跟踪的主机达到上限而淘汰的主机
+	HalfLife     string             `json:"half_life,omitempty"`
+	Destinations []DestinationStats `json:"destinations"`
+}
+
+// NewTopDestinations 创建目标主机排行，max 不大于 0 时为 1024
+func NewTopDestinations(max int, halfLife time.Duration) *TopDestinations {
+	if max <= 0 {
+		max = 1024
+	}
+	return &TopDestinations{Max: max, HalfLife: halfLife, entries: make(map[string]*list.Element), lastDecay: time.Now()}
+}
+
+// add 把 host 的连接数和字节数计入排行，host 为空时忽略
+func (t *TopDestinations) add(host string, conns, up, down int64) {
+	if t == nil || host == "" || conns == 0 && up == 0 && down == 0 {
+		return
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decay(time.Now())
+	el, ok := t.entries[host]
+	if ok {
+		t.lru.MoveToFront(el)
+	} else {
+		if len(t.entries) >= t.Max {
+			old := t.lru.Back()
+			t.lru.Remove(old)
+			delete(t.entries, old.Value.(*topEntry).host)
+			t.evicted.Add(1)
+		}
+		el = t.lru.PushFront(&topEntry{host: host})
+		t.entries[host] = el
+	}
+	e := el.Value.(*topEntry)
+	e.conns += float64(conns)
+	e.up += float64(up)
+	e.down += float64(down)
+}
+
+// decay 按经过的半衰期数把所有计数减半，减到不足 1 字节且没有连接的主机被删除，调用方持有 t.mu
+func (t *TopDestinations) decay(now time.Time) {
+	if t.HalfLife <= 0 {
+		return
+	}
+	k := int(now.Sub(t.lastDecay) / t.HalfLife)
+	if k <= 0 {
+		return
+	}
+	t.lastDecay = t.lastDecay.Add(time.Duration(k) * t.HalfLife)
+	f := 1 / float64(uint64(1)<<min(k, 63))
+	for el := t.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*topEntry)
+		e.conns *= f
+		e.up *= f
+		e.down *= f
+		if e.up+e.down < 1 && e.conns < 0.5 {
+			t.lru.Remove(el)
+			delete(t.entries, e.host)
+		}
+		el = next
+	}
+}
+
+// Top 返回按字节总数从大到小的前 n 个主机，n 不大于 0 时返回全部
+func (t *TopDestinations) Top(n int) TopDestinationsReport {
+	t.mu.Lock()
+	t.decay(time.Now())
+	out := make([]DestinationStats, 0, len(t.entries))
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*topEntry)
+		d := DestinationStats{Host: e.host, BytesUp: int64(e.up), BytesDown: int64(e.down), Conns: int64(e.conns + 0.5)}
+		d.Bytes = d.BytesUp + d.BytesDown
+		out = append(out, d)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(out, func(a, b DestinationStats) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(b.Conns, a.Conns), strings.Compare(a.Host, b.Host))
+	})
+	rep := TopDestinationsReport{Tracked: len(out), Evicted: t.evicted.Load()}
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	rep.Destinations = out
+	if t.HalfLife > 0 {
+		rep.HalfLife = t.HalfLife.String()
+	}
+	return rep
+}
+
+// topCursor 已计入 TopDestinations 的字节数，每次计入差值
+type topCursor struct {
+	mu       sync.Mutex
+	host     string
+	up, down int64
+}
+
+// flush 把 up、down 相对上次计入的增量记到 host 下，host 尚未确定时不计入
+func (c *topCursor) flush(t *TopDestinations, up, down int64) {
+	c.mu.Lock()
+	host := c.host
+	du, dd := up-c.up, down-c.down
+	if host != "" {
+		c.up, c.down = up, down
+	}
+	c.mu.Unlock()
+	if host != "" {
+		t.add(host, 0, du, dd)
+	}
+}
+
+// start 确定目标主机并计入一次连接
+func (c *topCursor) start(t *TopDestinations, host string) {
+	if t == nil {
+		return
+	}
+	c.mu.Lock()
+	c.host = host
+	c.mu.Unlock()
+	t.add(host, 1, 0, 0)
+}
+
+// topHost 返回 CONNECT 转发在排行中的主机：SNI、HTTP Host、请求的域名，都没有时为目标 IP
+func topHost(sess *Session, r *Request) string {
+	if sess != nil {
+		if h := cmp.Or(sess.SNI, sess.HTTPHost); h != "" {
+			return h
+		}
+	}
+	return r.Host()
+}
+
+// flushTop 把转发和 UDP 交换新转发的字节计入排行
+func (s *Server) flushTop(r *relay) {
+	if r.assoc == nil {
+		r.top.flush(s.TopDestinations, r.up.Load(), r.down.Load())
+		return
+	}
+	r.assoc.exchanges.mu.Lock()
+	live := slices.Clone(r.assoc.exchanges.live)
+	r.assoc.exchanges.mu.Unlock()
+	for _, ue := range live {
+		ue.top.flush(s.TopDestinations, ue.sentBytes.Load(), ue.recvBytes.Load())
+	}
+}
+
+// startTopFlush 设置了 TopDestinations 时在第一次登记转发时启动 flushTopLoop
+func (s *Server) startTopFlush() {
+	if s.TopDestinations != nil {
+		s.relays.topOnce.Do(func() { go s.flushTopLoop(s.Context()) })
+	}
+}
+
+// flushTopLoop 每隔 topFlushInterval 把进行中的转发新转发的字节计入排行，直到 ctx 取消
+func (s *Server) flushTopLoop(ctx context.Context) {
+	t := time.NewTicker(topFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.relays.m.Range(func(k, _ any) bool {
+				s.flushTop(k.(*relay))
+				return true
+			})
+		}
+	}
+}
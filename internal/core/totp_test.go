@@ -0,0 +1,141 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录 B 中 SHA1 的种子 "12345678901234567890" 的 base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// 附录 B 的 8 位验证码取后 6 位
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tc.unix, 0))
+		if err != nil || got != tc.code {
+			t.Errorf("TOTPCode at %d = %q, %v; want %q", tc.unix, got, err, tc.code)
+		}
+	}
+	if _, err := TOTPCode("too short", time.Now()); err != ErrTOTPSecret {
+		t.Errorf("invalid secret: %v", err)
+	}
+}
+
+func TestVerifyTOTPClockSkew(t *testing.T) {
+	key, _ := decodeTOTPSecret(rfc6238Secret)
+	// 服务端时钟位于某一步的中间
+	now := time.Unix(30*1_000_000+15, 0)
+	for _, tc := range []struct {
+		skew time.Duration // 客户端时钟减去服务端时钟
+		ok   bool
+	}{
+		{0, true},
+		{-15 * time.Second, true}, // 上一步的最后一秒
+		{14 * time.Second, true},  // 同一步的最后一秒
+		{-30 * time.Second, true},
+		{30 * time.Second, true},
+		{-44 * time.Second, true},
+		{44 * time.Second, true},
+		{-46 * time.Second, false}, // 两步之前
+		{46 * time.Second, false},  // 两步之后
+		{-5 * time.Minute, false},
+		{5 * time.Minute, false},
+	} {
+		code := totpCode(key, totpStep(now.Add(tc.skew)))
+		step, ok := verifyTOTP(key, code, now, 0)
+		if ok != tc.ok {
+			t.Errorf("client clock %v off: accepted %v, want %v", tc.skew, ok, tc.ok)
+		}
+		if ok && step != totpStep(now.Add(tc.skew)) {
+			t.Errorf("client clock %v off: accepted step %d, want %d", tc.skew, step, totpStep(now.Add(tc.skew)))
+		}
+	}
+	if _, ok := verifyTOTP(key, "", now, 0); ok {
+		t.Error("empty code accepted")
+	}
+	if _, ok := verifyTOTP(key, totpCode(key, totpStep(now))+"0", now, 0); ok {
+		t.Error("code with a trailing digit accepted")
+	}
+}
+
+func TestVerifyTOTPReplay(t *testing.T) {
+	key, _ := decodeTOTPSecret(rfc6238Secret)
+	now := time.Unix(30*1_000_000+15, 0)
+	cur := totpStep(now)
+	step, ok := verifyTOTP(key, totpCode(key, cur), now, 0)
+	if !ok || step != cur {
+		t.Fatalf("first use: %d, %v", step, ok)
+	}
+	// 同一验证码在窗口内的任何时刻都不能再次使用
+	for _, later := range []time.Duration{0, 10 * time.Second, 40 * time.Second} {
+		if _, ok := verifyTOTP(key, totpCode(key, cur), now.Add(later), step); ok {
+			t.Errorf("replay %v later accepted", later)
+		}
+	}
+	// 接受过的步之前的验证码也失效，之后的仍然有效
+	if _, ok := verifyTOTP(key, totpCode(key, cur-1), now, step); ok {
+		t.Error("earlier code accepted after a later one")
+	}
+	if next, ok := verifyTOTP(key, totpCode(key, cur+1), now, step); !ok || next != cur+1 {
+		t.Errorf("next step: %d, %v", next, ok)
+	}
+}
+
+func TestStaticCredentialsTOTP(t *testing.T) {
+	c, err := NewStaticCredentials(map[string]string{"admin": "s3cret", "plain": "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTOTP("admin", rfc6238Secret); err != nil {
+		t.Fatal(err)
+	}
+	code := func(d time.Duration) string {
+		s, _ := TOTPCode(rfc6238Secret, time.Now().Add(d))
+		return s
+	}
+	for _, pw := range []string{"s3cret", "s3cret:", "s3cret" + code(0), "wrong:" + code(0), "s3cret:" + code(-2*TOTPPeriod)} {
+		if c.Authenticate("admin", pw) {
+			t.Errorf("admin accepted with %q", pw)
+		}
+	}
+	// 错误的密码不消耗验证码
+	if !c.Authenticate("admin", "s3cret:"+code(0)) {
+		t.Fatal("static password and current code rejected")
+	}
+	if c.Authenticate("admin", "s3cret:"+code(0)) {
+		t.Fatal("replayed code accepted")
+	}
+	if c.Authenticate("admin", "s3cret:"+code(-TOTPPeriod)) {
+		t.Fatal("previous step accepted after the current one")
+	}
+	next := code(TOTPPeriod)
+	if !c.Authenticate("admin", "s3cret:"+next) {
+		t.Fatal("next step rejected")
+	}
+
+	// 重新加载后密钥未变的用户保留重放记录
+	reloaded, _ := NewStaticCredentials(map[string]string{"admin": "s3cret", "plain": "pw"})
+	reloaded.SetTOTP("admin", rfc6238Secret)
+	c.Replace(reloaded)
+	if c.Authenticate("admin", "s3cret:"+next) {
+		t.Fatal("replayed code accepted after reload")
+	}
+
+	// 没有密钥的用户只校验密码，密码中的冒号是密码的一部分
+	if !c.Authenticate("plain", "pw") || c.Authenticate("plain", "pw:"+code(0)) {
+		t.Fatal("user without a TOTP secret is not checked by password alone")
+	}
+	if err := c.SetTOTP("admin", ""); err != nil || !c.Authenticate("admin", "s3cret") {
+		t.Fatalf("removing the secret: %v", err)
+	}
+}
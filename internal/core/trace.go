@@ -0,0 +1,411 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTraceMaxFiles TraceRecorder.MaxFiles 的默认值
+const DefaultTraceMaxFiles = 100
+
+// traceMaxBytes 单个连接最多记录的字节数，超过后停止记录，防止不按协议发送数据的客户端写出大文件
+const traceMaxBytes = 64 * 1024
+
+// traceSuffix 跟踪文件的扩展名，只有这样命名的文件参与按数量清理
+const traceSuffix = ".trace"
+
+// TraceRecorder 把匹配客户端的握手字节逐次记录到 Dir 下每个连接一个的文件中，供排查客户端兼容问题：
+// 范围是协商、认证、请求和第一个应答，不含转发的数据；用户名密码认证的密码默认替换为同样长度的 '*'
+//
+// 文件格式为文本：首行以 "# socks5 trace" 开头，记录客户端、服务端地址和开始时间，
+// 其后每行一次读写："<距开始的秒数> <c>s|s>c> <十六进制字节>"，可用 DecodeTrace 解码为具名的 SOCKS 消息
+type TraceRecorder struct {
+	// Dir 跟踪文件所在目录
+	Dir string
+	// MaxFiles 目录中最多保留的跟踪文件数，超过时删除最旧的，0 表示不限制
+	MaxFiles int
+	// IncludeCredentials 为 true 时原样记录密码（不安全，文件须妥善保管）
+	IncludeCredentials bool
+
+	ips  map[string]struct{}
+	nets []*net.IPNet
+	mu   sync.Mutex // 创建和清理文件
+}
+
+// NewTraceRecorder 创建记录到 dir 的 TraceRecorder，clients 为 IP 或 CIDR 列表，为空时记录所有客户端
+func NewTraceRecorder(dir string, clients []string, maxFiles int, includeCredentials bool) (*TraceRecorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	t := &TraceRecorder{Dir: dir, MaxFiles: maxFiles, IncludeCredentials: includeCredentials}
+	t.ips, t.nets = ParseWhitelist(clients)
+	return t, nil
+}
+
+// Match 报告是否记录来自 ip 的连接，远端地址不是 IP 地址的连接只在不限制客户端时记录
+func (t *TraceRecorder) Match(ip net.IP) bool {
+	if len(t.ips) == 0 && len(t.nets) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	ip = unmapIP(ip)
+	if _, ok := t.ips[ip.String()]; ok {
+		return true
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// start 为 c 创建跟踪文件并返回记录读写的包装连接，创建失败时返回 nil
+func (t *TraceRecorder) start(c net.Conn) *traceConn {
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s%s", now.UTC().Format("20060102T150405.000000000"), traceFileAddr(c.RemoteAddr()), traceSuffix)
+	t.mu.Lock()
+	f, err := os.OpenFile(filepath.Join(t.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err == nil {
+		t.prune()
+	}
+	t.mu.Unlock()
+	if err != nil {
+		log.Printf("Trace error: %v", err)
+		return nil
+	}
+	creds := "redacted"
+	if t.IncludeCredentials {
+		creds = "included"
+	}
+	tc := &traceConn{Conn: c, f: f, w: bufio.NewWriter(f), start: now, redact: !t.IncludeCredentials}
+	fmt.Fprintf(tc.w, "# socks5 trace client=%s server=%s start=%s credentials=%s\n", c.RemoteAddr(), c.LocalAddr(), now.Format(time.RFC3339Nano), creds)
+	return tc
+}
+
+// traceFileAddr 把地址转为可用作文件名的形式
+func traceFileAddr(a net.Addr) string {
+	if a == nil {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == ':' || r == '/' || r == '\\' || r == '[' || r == ']' || r == '%' {
+			return '_'
+		}
+		return r
+	}, a.String())
+}
+
+// prune 删除超出 MaxFiles 的最旧跟踪文件，文件名以时间开头，按名称排序即按时间排序，调用方持有锁
+func (t *TraceRecorder) prune() {
+	if t.MaxFiles <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(t.Dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), traceSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= t.MaxFiles {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-t.MaxFiles] {
+		os.Remove(filepath.Join(t.Dir, name))
+	}
+}
+
+// traceConn 记录握手阶段读写的连接，写出请求的应答或达到 traceMaxBytes 后停止记录，此后只是透传
+type traceConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	n       int
+	replied bool // 已读取请求，下一次写出的是应答
+	redact  bool
+
+	// 用户名密码认证请求的解析状态，用于替换密码字节
+	selected bool // 已写出方法选择应答
+	method   byte // 服务端选择的方法
+	upPos    int  // 在认证请求中的位置，-1 表示已读完
+	ulen     int
+	plen     int
+}
+
+func (c *traceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record("c>s", b[:n], true)
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record("s>c", b[:n], false)
+	}
+	return n, err
+}
+
+// record 写出一次读写，客户端发来的用户名密码认证请求按需替换密码
+func (c *traceConn) record(dir string, b []byte, fromClient bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return
+	}
+	if fromClient && c.redact && c.method == MethodUsernamePassword && c.upPos >= 0 {
+		b = c.redactPassword(b)
+	}
+	if !fromClient && !c.selected && len(b) >= 2 {
+		c.selected, c.method = true, b[1]
+	}
+	if c.n+len(b) > traceMaxBytes {
+		fmt.Fprintf(c.w, "# truncated after %d bytes\n", c.n)
+		c.stop()
+		return
+	}
+	c.n += len(b)
+	fmt.Fprintf(c.w, "%.6f %s %s\n", time.Since(c.start).Seconds(), dir, hex.EncodeToString(b))
+	if !fromClient && c.replied {
+		c.stop()
+	}
+}
+
+// redactPassword 返回把落在认证请求 PASSWD 字段中的字节替换为 '*' 的副本，并推进解析状态
+func (c *traceConn) redactPassword(b []byte) []byte {
+	out := slices.Clone(b)
+	for i, v := range b {
+		switch p := c.upPos; {
+		case p == 1:
+			c.ulen = int(v)
+		case p == 2+c.ulen:
+			c.plen = int(v)
+		case p > 2+c.ulen:
+			out[i] = '*'
+		}
+		c.upPos++
+		if c.upPos > 2+c.ulen && c.upPos >= 3+c.ulen+c.plen {
+			c.upPos = -1
+			break
+		}
+	}
+	return out
+}
+
+// requestRead 在读取请求后调用，记录到下一次写出（应答）为止
+func (c *traceConn) requestRead() {
+	c.mu.Lock()
+	c.replied = true
+	c.mu.Unlock()
+}
+
+// finish 停止记录并关闭跟踪文件，可重复调用
+func (c *traceConn) finish() {
+	c.mu.Lock()
+	c.stop()
+	c.mu.Unlock()
+}
+
+// stop 刷新并关闭跟踪文件，调用方持有锁
+func (c *traceConn) stop() {
+	if c.f == nil {
+		return
+	}
+	c.w.Flush()
+	c.f.Close()
+	c.f = nil
+}
+
+// CloseWrite 半关闭底层连接，使 closeWrite 在包装后仍能半关闭
+func (c *traceConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// SupportsUDP 透传底层连接的 UDPCapable
+func (c *traceConn) SupportsUDP() bool {
+	u, ok := c.Conn.(UDPCapable)
+	return !ok || u.SupportsUDP()
+}
+
+// traceRecord 跟踪文件中的一行
+type traceRecord struct {
+	at         float64
+	fromClient bool
+	data       []byte
+}
+
+// traceStream 一个方向按顺序拼接的字节，offsets[i] 和 times[i] 为第 i 次读写的起始偏移和时间，用于查找消息开始的时间
+type traceStream struct {
+	bytes.Buffer
+	offsets []int
+	times   []float64
+}
+
+func (s *traceStream) add(r traceRecord) {
+	s.offsets = append(s.offsets, s.Len())
+	s.times = append(s.times, r.at)
+	s.Write(r.data)
+}
+
+// timeAt 返回偏移 off 处的字节被读写的时间
+func (s *traceStream) timeAt(off int) float64 {
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i] > off }) - 1
+	return s.times[max(i, 0)]
+}
+
+// traceMessage 解码得到的一条消息
+type traceMessage struct {
+	at   float64
+	dir  string
+	text string
+}
+
+// DecodeTrace 读取 TraceRecorder 写出的跟踪文件，把两个方向的字节按协议解码为具名的 SOCKS 消息，
+// 按时间顺序写到 w；无法解码的剩余字节以十六进制输出
+func DecodeTrace(w io.Writer, r io.Reader) error {
+	var header string
+	var notes []string
+	var client, server traceStream
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 4*traceMaxBytes)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			if header == "" {
+				header = text
+			} else {
+				notes = append(notes, text)
+			}
+			continue
+		}
+		f := strings.Fields(text)
+		if len(f) != 3 || f[1] != "c>s" && f[1] != "s>c" {
+			return fmt.Errorf("line %d: malformed trace record", line)
+		}
+		at, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		data, err := hex.DecodeString(f[2])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		rec := traceRecord{at: at, fromClient: f[1] == "c>s", data: data}
+		if rec.fromClient {
+			client.add(rec)
+		} else {
+			server.add(rec)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if header == "" {
+		return fmt.Errorf("not a socks5 trace file")
+	}
+	fmt.Fprintln(w, header)
+	msgs := append(decodeTraceClient(&client, &server), decodeTraceServer(&server)...)
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at < msgs[j].at })
+	for _, m := range msgs {
+		if _, err := fmt.Fprintf(w, "%.6f %s %s\n", m.at, m.dir, m.text); err != nil {
+			return err
+		}
+	}
+	for _, n := range notes {
+		if _, err := fmt.Fprintln(w, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTraceClient 解码客户端发送的协商请求、认证请求和请求，是否有认证请求由服务端选择的方法决定
+func decodeTraceClient(client, server *traceStream) []traceMessage {
+	var msgs []traceMessage
+	total := client.Len()
+	next := func(parse func(io.Reader) (fmt.Stringer, error)) bool {
+		off := total - client.Len()
+		m, err := parse(&client.Buffer)
+		if err != nil {
+			return false
+		}
+		msgs = append(msgs, traceMessage{at: client.timeAt(off), dir: "c>s", text: m.String()})
+		return true
+	}
+	ok := next(func(r io.Reader) (fmt.Stringer, error) { return NewNegotiationRequestFrom(r) })
+	if ok && server.Len() >= 2 && server.Bytes()[1] == MethodUsernamePassword {
+		ok = next(func(r io.Reader) (fmt.Stringer, error) { return newUserPassNegotiationRequestFrom(r, true) })
+	}
+	if ok {
+		next(func(r io.Reader) (fmt.Stringer, error) { return NewRequestFrom(r) })
+	}
+	return append(msgs, traceRest(client, total, "c>s")...)
+}
+
+// decodeTraceServer 解码服务端写出的方法选择应答、认证应答和应答
+func decodeTraceServer(server *traceStream) []traceMessage {
+	var msgs []traceMessage
+	total := server.Len()
+	next := func(parse func(io.Reader) (fmt.Stringer, error)) bool {
+		off := total - server.Len()
+		m, err := parse(&server.Buffer)
+		if err != nil {
+			return false
+		}
+		msgs = append(msgs, traceMessage{at: server.timeAt(off), dir: "s>c", text: m.String()})
+		return true
+	}
+	nr, err := NewNegotiationReplyFrom(&server.Buffer)
+	if err != nil {
+		return traceRest(server, total, "s>c")
+	}
+	msgs = append(msgs, traceMessage{at: server.timeAt(0), dir: "s>c", text: nr.String()})
+	ok := nr.Method != MethodUnsupportAll
+	if ok && nr.Method == MethodUsernamePassword {
+		ok = next(func(r io.Reader) (fmt.Stringer, error) { return NewUserPassNegotiationReplyFrom(r) })
+	}
+	if ok {
+		next(func(r io.Reader) (fmt.Stringer, error) { return NewReplyFrom(r) })
+	}
+	return append(msgs, traceRest(server, total, "s>c")...)
+}
+
+// traceRest 把未能解码的剩余字节作为一条消息返回
+func traceRest(s *traceStream, total int, dir string) []traceMessage {
+	if s.Len() == 0 {
+		return nil
+	}
+	off := total - s.Len()
+	return []traceMessage{{at: s.timeAt(off), dir: dir, text: fmt.Sprintf("undecoded %d bytes: %x", s.Len(), s.Bytes())}}
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+)
+
+// ErrTransparentUnsupported is the error when transparent proxying is requested on a platform other than Linux
+var ErrTransparentUnsupported = errors.New("transparent proxy mode is only supported on Linux")
+
+// TransparentMode 透明代理取得原始目标地址的方式
+type TransparentMode int
+
+const (
+	// TransparentRedirect iptables REDIRECT，通过 SO_ORIGINAL_DST 取得原始目标
+	TransparentRedirect TransparentMode = iota
+	// TransparentTProxy iptables TPROXY，监听套接字设置 IP_TRANSPARENT，连接的本地地址即原始目标
+	TransparentTProxy
+)
+
+// ParseTransparentMode 解析 redirect 或 tproxy
+func ParseTransparentMode(s string) (TransparentMode, error) {
+	switch s {
+	case "redirect":
+		return TransparentRedirect, nil
+	case "tproxy":
+		return TransparentTProxy, nil
+	}
+	return 0, fmt.Errorf("unknown transparent mode %q, expected redirect or tproxy", s)
+}
+
+// ListenTransparent 在 addr 上监听被 iptables 重定向的 TCP 连接，作为组件随 Serve 接收连接
+// 客户端不使用 SOCKS 协议，服务端跳过协商，按原始目标合成 CONNECT 请求后交给 Handle
+func (s *Server) ListenTransparent(addr string, mode TransparentMode) (net.Addr, error) {
+	l, err := listenTransparent(addr, mode)
+	if err != nil {
+		return nil, err
+	}
+	s.AddComponent(Component{
+		Name:  "transparent",
+		Stage: StageAccept,
+		Start: func(ctx context.Context) error {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return s.serveErr(err)
+				}
+				go s.ServeTransparentConn(c, mode)
+			}
+		},
+		Stop: l.Close,
+	})
+	return l.Addr(), nil
+}
+
+// ServeTransparentConn 处理一个被透明重定向的连接，白名单和访问日志使用真实的客户端地址
+func (s *Server) ServeTransparentConn(c net.Conn, mode TransparentMode) {
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	s.Stats.Connections.Add(1)
+	defer c.Close()
+	ctx, cancel := context.WithCancel(s.Context())
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	if clientIP := remoteIP(c); clientIP != nil && !s.IsAllowed(clientIP) {
+		s.Audit.event(AuditDenied, c.RemoteAddr(), "", "", "whitelist", "")
+		log.Printf("TCP Connection rejected from %s (not in whitelist)", clientIP)
+		return
+	}
+	if clientIP := remoteIP(c); clientIP != nil && s.IsDenied(clientIP) {
+		s.Audit.event(AuditDenied, c.RemoteAddr(), "", "", "blacklist", "")
+		log.Printf("TCP Connection rejected from %s (in blacklist)", clientIP)
+		return
+	}
+	dst, err := originalDst(c, mode)
+	if err != nil {
+		log.Printf("Transparent connection from %s: %v", c.RemoteAddr(), err)
+		return
+	}
+	// 直接连到透明端口的连接没有被重定向，原始目标就是自己，转发会形成环路
+	if la, ok := c.LocalAddr().(*net.TCPAddr); ok && la.IP.Equal(dst.IP) && la.Port == dst.Port {
+		log.Printf("Transparent connection from %s was not redirected, closing", c.RemoteAddr())
+		return
+	}
+	a, addr, port, err := AddrToATYP(dst)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sess := NewSession(c)
+	sess.Transparent = true
+	ctx = WithMetadata(ctx, sess.Meta)
+	r := NewRequest(CmdConnect, a, addr, port)
+	if s.shedLoad(sess, r) {
+		return
+	}
+	if r, err = s.rewriteOrReject(sess, r); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := s.handleTCP(ctx, sess, r); err != nil {
+		s.LogServeError(err)
+	}
+}
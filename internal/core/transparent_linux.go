@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenTransparent 监听 TCP，TPROXY 模式下为监听套接字设置 IP_TRANSPARENT（需要 CAP_NET_ADMIN）
+func listenTransparent(addr string, mode TransparentMode) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if mode == TransparentTProxy {
+		lc.Control = func(network, address string, rc syscall.RawConn) error {
+			var serr error
+			err := rc.Control(func(fd uintptr) {
+				if network == "tcp6" {
+					serr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+					return
+				}
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst 取得被重定向连接的原始目标地址
+func originalDst(c net.Conn, mode TransparentMode) (*net.TCPAddr, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("transparent connection is not a TCP connection")
+	}
+	if mode == TransparentTProxy {
+		return tc.LocalAddr().(*net.TCPAddr), nil
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dst *net.TCPAddr
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		la := tc.LocalAddr().(*net.TCPAddr)
+		if la.IP.To4() != nil {
+			// SO_ORIGINAL_DST 返回 sockaddr_in，借用 IPv6Mreq 的 20 字节缓冲区读取
+			var mreq *unix.IPv6Mreq
+			mreq, serr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if serr != nil {
+				return
+			}
+			b := mreq.Multiaddr
+			ip := netip.AddrFrom4([4]byte{b[4], b[5], b[6], b[7]})
+			dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(b[2])<<8|uint16(b[3])))
+			return
+		}
+		// IP6T_SO_ORIGINAL_DST 返回 sockaddr_in6，借用 IPv6MTUInfo 读取
+		var info *unix.IPv6MTUInfo
+		info, serr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+		if serr != nil {
+			return
+		}
+		p := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+		ip := netip.AddrFrom16(info.Addr.Addr)
+		dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p[0])<<8|uint16(p[1])))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errors.Is(serr, unix.ENOENT) {
+		return nil, errors.New("connection was not redirected, no original destination")
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return dst, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package core
+
+import "net"
+
+func listenTransparent(addr string, mode TransparentMode) (net.Listener, error) {
+	return nil, ErrTransparentUnsupported
+}
+
+func originalDst(c net.Conn, mode TransparentMode) (*net.TCPAddr, error) {
+	return nil, ErrTransparentUnsupported
+}
@@ -1,61 +1,49 @@
 package core
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"log"
 	"net"
+	"net/netip"
 )
 
-func (r *Request) UDP(c net.Conn, serverAddr net.Addr) (net.Addr, error) {
+// UDP 回应 UDP ASSOCIATE 请求并返回客户端声明的 UDP 地址，域名地址用 res 解析（nil 时使用包级 Resolve）
+// 声明的端口为 0 时返回的端口也为 0，客户端多在 NAT 之后，实际的源端口要等收到数据报才能确定；
+// 声明的 IP 为全零时不论端口都取控制连接的 IP，端口为 0 时声明的域名也一样
+func (r *Request) UDP(c net.Conn, serverAddr net.Addr, res Resolver) (net.Addr, error) {
 	var clientAddr net.Addr
 	var err error
 
 	// 优化：使用类型断言避免字符串解析
-	if bytes.Equal(r.DstPort, []byte{0x00, 0x00}) {
-		if tcpAddr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
-			clientAddr = &net.UDPAddr{
-				IP:   tcpAddr.IP,
-				Port: tcpAddr.Port,
-				Zone: tcpAddr.Zone,
-			}
+	if ap, ok := r.AddrPort(); ok && !ap.Addr().IsUnspecified() {
+		clientAddr = net.UDPAddrFromAddrPort(ap)
+	} else if ok || r.Port() == 0 {
+		if ca, ok := netAddrPort(c.RemoteAddr()); ok {
+			clientAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ca.Addr().Unmap(), r.Port()))
 		} else {
-			clientAddr, err = net.ResolveUDPAddr("udp", c.RemoteAddr().String())
+			err = errors.New("Invalid address")
 		}
 	} else {
-		clientAddr, err = net.ResolveUDPAddr("udp", r.Address())
+		clientAddr, err = resolveUDPAddr(context.Background(), res, r.Address())
 	}
 
 	if err != nil {
-		var p *Reply
-		if r.Atyp == ATYPIPv4 || r.Atyp == ATYPDomain {
-			p = NewReply(RepHostUnreachable, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
-		} else {
-			p = NewReply(RepHostUnreachable, ATYPIPv6, []byte(net.IPv6zero), []byte{0x00, 0x00})
-		}
-		if _, err = p.WriteTo(c); err != nil {
-			return nil, err
+		if werr := r.Reject(c, RepHostUnreachable); werr != nil {
+			return nil, werr
 		}
 		return nil, err
 	}
 	if Debug {
 		log.Println("Client wants to start UDP talk use", clientAddr.String())
 	}
-	a, addr, port, err := ParseAddress(serverAddr.String())
+	a, addr, port, err := AddrToATYP(serverAddr)
 	if err != nil {
-		var p *Reply
-		if r.Atyp == ATYPIPv4 || r.Atyp == ATYPDomain {
-			p = NewReply(RepHostUnreachable, ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x00})
-		} else {
-			p = NewReply(RepHostUnreachable, ATYPIPv6, []byte(net.IPv6zero), []byte{0x00, 0x00})
-		}
-		if _, err = p.WriteTo(c); err != nil {
-			return nil, err
+		if werr := r.Reject(c, RepHostUnreachable); werr != nil {
+			return nil, werr
 		}
 		return nil, err
 	}
-	if a == ATYPDomain {
-		addr = addr[1:]
-	}
 	p := NewReply(RepSuccess, a, addr, port)
 	if _, err := p.WriteTo(c); err != nil {
 		return nil, err
@@ -0,0 +1,65 @@
+package core_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// 回环网段的任意地址在 Linux 上都是本机地址：监听所有地址、通告 127.0.0.2，
+// 应答数据报的源地址必须是通告的地址，而不是按路由选出的 127.0.0.1
+func TestUDPReplySourceMatchesAdvertised(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Addr = "0.0.0.0:0"
+		s.ServerAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)}
+	})
+	if got := ts.Server.UDPAddr().(*net.UDPAddr).IP; !got.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Fatalf("UDP bound to %s, want 127.0.0.2", got)
+	}
+	_, port, _ := net.SplitHostPort(ts.TCPAddr().String())
+	ctl, relay := socks5test.Associate(t, net.JoinHostPort("127.0.0.1", port), nil)
+	defer ctl.Close()
+	if !relay.IP.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Fatalf("ASSOCIATE advertised %s", relay)
+	}
+
+	uc := listenUDP(t)
+	udpSend(t, uc, relay, socks5test.EchoUDPServer(t), []byte("source check"))
+	uc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 65535)
+	n, from, err := uc.ReadFromUDP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != relay.String() {
+		t.Fatalf("reply came from %s, advertised %s", from, relay)
+	}
+	if d, err := core.NewDatagramFromBytes(b[:n]); err != nil || string(d.Data) != "source check" {
+		t.Fatalf("reply %v, %v", d, err)
+	}
+}
+
+// 通告的地址不在本机时退回到监听地址，并记录警告
+func TestUDPAdvertisedNotLocal(t *testing.T) {
+	logs := captureLog(t, false)
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Addr = "0.0.0.0:0"
+		s.ServerAddr = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	})
+	if got := ts.Server.UDPAddr().(*net.UDPAddr).IP; !got.IsUnspecified() {
+		t.Fatalf("UDP bound to %s, want the unspecified address", got)
+	}
+	if out := logs.String(); !strings.Contains(out, "cannot bind UDP to advertised address 192.0.2.1") {
+		t.Fatalf("no warning in the log:\n%s", out)
+	}
+	_, port, _ := net.SplitHostPort(ts.TCPAddr().String())
+	ctl, relay := socks5test.Associate(t, net.JoinHostPort("127.0.0.1", port), nil)
+	defer ctl.Close()
+	if !relay.IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("ASSOCIATE advertised %s, want the configured 192.0.2.1", relay)
+	}
+}
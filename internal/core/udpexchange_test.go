@@ -0,0 +1,98 @@
+package core_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// whoamiUDP 以发送方地址应答每个数据报，返回其地址
+func whoamiUDP(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	pc := listenUDP(t)
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			_, addr, err := pc.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			pc.WriteToUDP([]byte(addr.String()), addr)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr)
+}
+
+func TestUDPExchangeReusedAcrossForms(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.Resolver = staticResolver{"whoami.test": {net.IPv4(127, 0, 0, 1)}}
+	})
+	peer := whoamiUDP(t)
+	port := strconv.Itoa(peer.Port)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	uc := listenUDP(t)
+
+	// 同一远端的 IP、域名和 IPv4-mapped 三种写法
+	var source string
+	for _, dst := range []string{
+		net.JoinHostPort("127.0.0.1", port),
+		net.JoinHostPort("whoami.test", port),
+		net.JoinHostPort("::ffff:127.0.0.1", port),
+	} {
+		udpSend(t, uc, relay, dst, []byte("who am i"))
+		d := udpRecv(t, uc, 2*time.Second)
+		if d == nil {
+			t.Fatalf("no reply for %s", dst)
+		}
+		// 应答的来源是远端的实际地址
+		if d.Address() != peer.String() {
+			t.Fatalf("reply for %s from %s, want %s", dst, d.Address(), peer)
+		}
+		if source == "" {
+			source = string(d.Data)
+		} else if string(d.Data) != source {
+			t.Fatalf("%s reached the peer from %s, earlier forms from %s", dst, d.Data, source)
+		}
+	}
+	if n := exchanges(ts.Server); n != 1 {
+		t.Fatalf("%d exchanges for one remote, want 1", n)
+	}
+	// 三个数据报都计入同一个交换
+	infos := ts.UDPAssociations()
+	if len(infos) != 1 || len(infos[0].Exchanges) != 1 || infos[0].Exchanges[0].SentPackets != 3 {
+		t.Fatalf("associations %+v, want one exchange with 3 sent packets", infos)
+	}
+}
+
+// BenchmarkUDPRelay 经代理往返一个数据报：客户端到中继、中继到远端、远端应答经中继回到客户端，
+// 每个交换的计数在这条路径上只做原子加，与没有计数时的结果相比不应有可测量的差别
+func BenchmarkUDPRelay(b *testing.B) {
+	ts := socks5test.NewServer(b)
+	echo := socks5test.EchoUDPServer(b)
+	ctl, relay := socks5test.Associate(b, ts.Addr, nil)
+	defer ctl.Close()
+	uc, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer uc.Close()
+	atyp, addr, port, _ := core.ParseAddress(echo)
+	pkt := core.NewDatagram(atyp, addr, port, make([]byte, 512)).Bytes()
+	buf := make([]byte, 2048)
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := uc.Write(pkt); err != nil {
+			b.Fatal(err)
+		}
+		uc.SetReadDeadline(time.Now().Add(socks5test.Timeout))
+		if _, err := uc.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
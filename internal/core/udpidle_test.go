@@ -0,0 +1,77 @@
+package core_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// waitNoAssociations 等待关联和交换都被拆除
+func waitNoAssociations(t *testing.T, ts *socks5test.Server, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for time.Now().Before(deadline) && (len(ts.UDPAssociations()) != 0 || exchanges(ts.Server) != 0) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a, e := len(ts.UDPAssociations()), exchanges(ts.Server); a != 0 || e != 0 {
+		t.Fatalf("%d associations and %d exchanges left after %s", a, e, within)
+	}
+}
+
+// 客户端消失而不发送 FIN：控制连接上没有数据、也没有数据报往来，UDPTimeout 后关联和交换被拆除
+func TestUDPAssociationVanishedClient(t *testing.T) {
+	buf := &syncBuffer{}
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.UDPTimeout = 1
+		s.AccessLog = core.NewAccessLogger(buf)
+	})
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	uc := listenUDP(t)
+	echo := socks5test.EchoUDPServer(t)
+
+	// 持续有数据报时关联超过 UDPTimeout 仍然保持
+	for range 6 {
+		udpSend(t, uc, relay, echo, []byte("alive"))
+		if udpRecv(t, uc, time.Second) == nil {
+			t.Fatal("no echo while active")
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+	if n := len(ts.UDPAssociations()); n != 1 {
+		t.Fatalf("%d associations while traffic flows", n)
+	}
+
+	// 之后客户端不再发送任何东西，控制连接也不关闭
+	start := time.Now()
+	waitNoAssociations(t, ts, 3*time.Second)
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Fatalf("association torn down after %s, before UDPTimeout", d)
+	}
+	ctl.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ctl.Read(make([]byte, 1)); err == nil {
+		t.Fatal("control connection still open after the idle timeout")
+	}
+	rec := waitAccessLog(t, buf, 1)[0]
+	if rec.Command != "UDP" || !strings.Contains(rec.Error, core.ErrIdleTimeout.Error()) {
+		t.Fatalf("access log %s error %q, want an idle timeout", rec.Command, rec.Error)
+	}
+}
+
+// 控制连接被重置（RST 而非 FIN）时关联立即结束
+func TestUDPAssociationControlReset(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	uc := listenUDP(t)
+	udpSend(t, uc, relay, socks5test.EchoUDPServer(t), []byte("before reset"))
+	if udpRecv(t, uc, 2*time.Second) == nil {
+		t.Fatal("no echo")
+	}
+	ctl.(*net.TCPConn).SetLinger(0)
+	ctl.Close()
+	waitNoAssociations(t, ts, 2*time.Second)
+}
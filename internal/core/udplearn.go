@@ -0,0 +1,107 @@
+package core
+
+import (
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// udpLearning 声明端口为 0 的 UDP 关联：客户端多在 NAT 之后，UDP 的源端口与控制连接不同，
+// 先以控制连接的 IP 登记，该 IP 发来的第一个未关联数据报确定关联的实际地址
+type udpLearning struct {
+	mu   sync.Mutex
+	byIP map[netip.Addr][]*UDPAssociation
+}
+
+// learnKey 返回登记用的 IP，IPv4-mapped IPv6 按 IPv4 处理，不含区域
+func learnKey(ip net.IP) netip.Addr {
+	a, _ := netip.AddrFromSlice(ip)
+	return a.Unmap().WithZone("")
+}
+
+// addLearning 登记一个等待学习来源地址的关联，ip 为控制连接的 IP
+func (s *Server) addLearning(ip net.IP, a *UDPAssociation) {
+	l := &s.udpLearn
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byIP == nil {
+		l.byIP = make(map[netip.Addr][]*UDPAssociation)
+	}
+	k := learnKey(ip)
+	l.byIP[k] = append(l.byIP[k], a)
+}
+
+// removeLearning 关联结束时注销登记，已学习到的地址一并从 AssociatedUDP 删除
+func (s *Server) removeLearning(ip net.IP, a *UDPAssociation) {
+	l := &s.udpLearn
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := learnKey(ip)
+	l.byIP[k] = slices.DeleteFunc(l.byIP[k], func(x *UDPAssociation) bool { return x == a })
+	if len(l.byIP[k]) == 0 {
+		delete(l.byIP, k)
+	}
+	if ca := a.ClientAddr(); ca != nil {
+		s.AssociatedUDP.CompareAndDelete(ca.String(), a)
+	}
+}
+
+// learnAssociation 为未关联的来源地址寻找同一 IP 上等待学习的关联，按登记先后取第一个并绑定到 addr
+// 没有等待中的关联且 UDPMatchIPOnly 为 true 时，返回同一 IP 上最早的已学习关联
+func (s *Server) learnAssociation(addr *net.UDPAddr) *UDPAssociation {
+	l := &s.udpLearn
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	as := l.byIP[learnKey(addr.IP)]
+	for _, a := range as {
+		if !a.Closed() && a.clientAddr.CompareAndSwap(nil, addr) {
+			s.AssociatedUDP.Store(addr.String(), a)
+			if Debug {
+				log.Printf("UDP association learned client address %s", addr)
+			}
+			return a
+		}
+	}
+	if s.UDPMatchIPOnly && len(as) > 0 {
+		return as[0]
+	}
+	return nil
+}
+
+// udpAssocIPs 当前持有 UDP 关联的客户端 IP 及关联数，UDPRequireAssociatedIP 据此过滤数据报
+type udpAssocIPs struct {
+	mu sync.RWMutex
+	n  map[netip.Addr]int
+}
+
+// addAssocIP 登记一个关联的客户端 IP
+func (s *Server) addAssocIP(ip net.IP) {
+	x := &s.udpIPs
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.n == nil {
+		x.n = make(map[netip.Addr]int)
+	}
+	x.n[learnKey(ip)]++
+}
+
+// removeAssocIP 关联结束时注销登记
+func (s *Server) removeAssocIP(ip net.IP) {
+	x := &s.udpIPs
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	k := learnKey(ip)
+	if x.n[k]--; x.n[k] <= 0 {
+		delete(x.n, k)
+	}
+}
+
+// hasAssocIP 返回 ip 当前是否持有至少一个 UDP 关联
+func (s *Server) hasAssocIP(ip net.IP) bool {
+	x := &s.udpIPs
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.n[learnKey(ip)] > 0
+}
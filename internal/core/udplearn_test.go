@@ -0,0 +1,123 @@
+package core_test
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// 客户端声明 0.0.0.0:0，UDP 从与控制连接不同的端口发出，模拟 NAT 重新分配源端口
+func TestUDPLearnNATSourcePort(t *testing.T) {
+	for _, ipOnly := range []bool{false, true} {
+		name := "strict"
+		if ipOnly {
+			name = "ip-only"
+		}
+		t.Run(name, func(t *testing.T) {
+			ts := socks5test.NewServer(t, func(s *core.Server) {
+				s.LimitUDP = true
+				s.UDPMatchIPOnly = ipOnly
+			})
+			echo := socks5test.EchoUDPServer(t)
+			ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+			defer ctl.Close()
+
+			uc := listenUDP(t)
+			tcpPort := ctl.LocalAddr().(*net.TCPAddr).Port
+			if uc.LocalAddr().(*net.UDPAddr).Port == tcpPort {
+				t.Skip("UDP socket got the control connection's port")
+			}
+			udpSend(t, uc, relay, echo, []byte("first"))
+			if d := udpRecv(t, uc, 2*time.Second); d == nil || string(d.Data) != "first" {
+				t.Fatalf("no echo for the first datagram from the NAT port: %v", d)
+			}
+			as := ts.UDPAssociations()
+			if len(as) != 1 || as[0].UDPAddr != uc.LocalAddr().String() {
+				t.Fatalf("association bound to %+v, want %s", as, uc.LocalAddr())
+			}
+
+			// 学习之后同一 IP 的另一个端口：严格模式下不属于关联，只比较 IP 时属于
+			other := listenUDP(t)
+			udpSend(t, other, relay, echo, []byte("second"))
+			d := udpRecv(t, other, 300*time.Millisecond)
+			if ipOnly && (d == nil || string(d.Data) != "second") {
+				t.Fatalf("ip-only: datagram from another port not relayed: %v", d)
+			}
+			if !ipOnly && d != nil {
+				t.Fatalf("strict: datagram from another port relayed: %q", d.Data)
+			}
+			if n := ts.Stats.UDPUnassociated.Load(); (n == 0) != ipOnly {
+				t.Fatalf("UDPUnassociated = %d", n)
+			}
+		})
+	}
+}
+
+// 学习地址与读取关联列表并发进行，-race 下不应报告数据竞争
+func TestUDPLearnConcurrentListing(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) { s.LimitUDP = true })
+	echo := socks5test.EchoUDPServer(t)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				ts.UDPAssociations()
+			}
+		}
+	})
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	for range 20 {
+		ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+		uc := listenUDP(t)
+		udpSend(t, uc, relay, echo, []byte("x"))
+		if udpRecv(t, uc, 2*time.Second) == nil {
+			t.Fatal("no echo")
+		}
+		ctl.Close()
+	}
+}
+
+// 客户端声明 0.0.0.0:<UDP 端口>，关联按控制连接的 IP 和声明的端口登记，来源过滤不丢弃它的数据报
+func TestUDPDeclaredUnspecifiedIP(t *testing.T) {
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.LimitUDP = true
+		s.UDPRequireAssociatedIP = true
+	})
+	echo := socks5test.EchoUDPServer(t)
+	uc := listenUDP(t)
+	port := uc.LocalAddr().(*net.UDPAddr).Port
+	ctl, rp := socks5test.Handshake(t, ts.Addr, nil, core.CmdUDP, net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+	defer ctl.Close()
+	if rp.Rep != core.RepSuccess {
+		t.Fatalf("UDP ASSOCIATE: %s", core.RepName(rp.Rep))
+	}
+	relay, err := net.ResolveUDPAddr("udp", rp.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relay.IP.IsUnspecified() {
+		relay.IP = net.IPv4(127, 0, 0, 1)
+	}
+	udpSend(t, uc, relay, echo, []byte("declared"))
+	if d := udpRecv(t, uc, 2*time.Second); d == nil || string(d.Data) != "declared" {
+		t.Fatalf("no echo for a client that declared 0.0.0.0:%d: %v", port, d)
+	}
+	if as := ts.UDPAssociations(); len(as) != 1 || as[0].UDPAddr != uc.LocalAddr().String() {
+		t.Fatalf("association bound to %+v, want %s", as, uc.LocalAddr())
+	}
+	if n := ts.Stats.UDPUnassociated.Load(); n != 0 {
+		t.Fatalf("UDPUnassociated = %d", n)
+	}
+}
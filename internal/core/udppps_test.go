@@ -0,0 +1,116 @@
+package core_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// countingUDP 计数收到的数据报，每个数据报应答 replies 次，返回地址和计数
+func countingUDP(t *testing.T, replies int) (string, *atomic.Int64) {
+	t.Helper()
+	pc := listenUDP(t)
+	var n atomic.Int64
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			m, addr, err := pc.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			n.Add(1)
+			for range replies {
+				pc.WriteToUDP(b[:m], addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), &n
+}
+
+// drainReplies 读取应答直到 quiet 内没有新的数据报，返回读到的数量
+func drainReplies(t *testing.T, uc *net.UDPConn, quiet time.Duration) int {
+	t.Helper()
+	n := 0
+	for udpRecv(t, uc, quiet) != nil {
+		n++
+	}
+	return n
+}
+
+func TestUDPPacketRateRelay(t *testing.T) {
+	const burst = 20
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.UDPPacketRate = core.PacketRate{PPS: 50, Burst: burst}
+	})
+	dst, received := countingUDP(t, 3)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	uc := listenUDP(t)
+
+	// 突发以内的连续数据报都被转发，每个的 3 个应答都回到客户端
+	for range burst {
+		udpSend(t, uc, relay, dst, []byte("q"))
+	}
+	emptied := time.Now()
+	if n := drainReplies(t, uc, 300*time.Millisecond); n != 3*burst {
+		t.Fatalf("%d replies to a burst of %d, want %d", n, burst, 3*burst)
+	}
+	if n := received.Load(); n != burst {
+		t.Fatalf("remote received %d of a burst of %d", n, burst)
+	}
+	if n := ts.Stats.UDPRateLimited.Load(); n != 0 {
+		t.Fatalf("UDPRateLimited = %d within the burst", n)
+	}
+
+	// 以约 4 倍的速率持续发送 1s，转发的数量为桶里恢复的令牌加上速率乘以时长
+	received.Store(0)
+	start := time.Now()
+	sent := 0
+	for time.Since(start) < time.Second {
+		udpSend(t, uc, relay, dst, []byte("q"))
+		sent++
+		time.Sleep(5 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	drainReplies(t, uc, 300*time.Millisecond)
+	want := min(burst, 50*start.Sub(emptied).Seconds()) + 50*elapsed.Seconds()
+	if got := float64(received.Load()); got > want+1 || got < 0.9*want {
+		t.Fatalf("forwarded %v of %d datagrams sent over %v, want about %.0f", got, sent, elapsed.Round(time.Millisecond), want)
+	}
+	if dropped := ts.Stats.UDPRateLimited.Load(); dropped != int64(sent)-received.Load() {
+		t.Fatalf("UDPRateLimited = %d, sent %d, forwarded %d", dropped, sent, received.Load())
+	}
+	infos := ts.UDPAssociations()
+	if len(infos) != 1 || infos[0].Totals.RateLimited != ts.Stats.UDPRateLimited.Load() {
+		t.Fatalf("association totals %+v, want %d rate limited", infos, ts.Stats.UDPRateLimited.Load())
+	}
+}
+
+// 用户的设置覆盖全局的速率上限
+func TestUDPPacketRatePerUser(t *testing.T) {
+	ts := socks5test.NewServer(t, socks5test.WithAuth("alice", "secret"), func(s *core.Server) {
+		s.UDPPacketRate = core.PacketRate{PPS: 1, Burst: 2}
+		s.UserUDPPacketRate = func(user string) (core.PacketRate, bool) {
+			return core.PacketRate{PPS: 1, Burst: 10}, user == "alice"
+		}
+	})
+	dst, received := countingUDP(t, 0)
+	ctl, relay := socks5test.Associate(t, ts.Addr, &socks5test.Auth{User: "alice", Password: "secret"})
+	defer ctl.Close()
+	uc := listenUDP(t)
+	for range 15 {
+		udpSend(t, uc, relay, dst, []byte("q"))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for received.Load() < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := received.Load(); n != 10 {
+		t.Fatalf("forwarded %d of 15 back-to-back datagrams, want the user's burst of 10", n)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PacketRate 一个 UDP 关联发往远端的数据报速率上限：平均每秒 PPS 个，最多连续放行 Burst 个，PPS 为 0 表示不限制
+type PacketRate struct {
+	PPS   float64
+	Burst int
+}
+
+// ParsePacketRate 解析 "每秒数据报数[/突发数]"，如 100 或 100/200；突发数省略时等于速率（至少 1），0 表示不限制
+func ParsePacketRate(s string) (PacketRate, error) {
+	v, b, hasBurst := strings.Cut(s, "/")
+	pps, err := strconv.ParseFloat(v, 64)
+	if err != nil || pps < 0 || pps > 1e9 {
+		return PacketRate{}, fmt.Errorf("invalid packet rate %q, expected <packets per second>[/<burst>]", s)
+	}
+	if pps == 0 {
+		if hasBurst {
+			return PacketRate{}, fmt.Errorf("invalid packet rate %q: burst without a rate", s)
+		}
+		return PacketRate{}, nil
+	}
+	r := PacketRate{PPS: pps, Burst: max(int(pps), 1)}
+	if hasBurst {
+		if r.Burst, err = strconv.Atoi(b); err != nil || r.Burst < 1 {
+			return PacketRate{}, fmt.Errorf("invalid packet rate %q: burst must be a positive integer", s)
+		}
+	}
+	return r, nil
+}
+
+// String 返回 ParsePacketRate 接受的形式
+func (r PacketRate) String() string {
+	if r.PPS <= 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(r.PPS, 'f', -1, 64) + "/" + strconv.Itoa(r.Burst)
+}
+
+// packetLimiter 一个 UDP 关联的令牌桶，nil 表示不限制
+type packetLimiter struct {
+	mu     sync.Mutex
+	rate   PacketRate
+	tokens float64
+	last   time.Time
+	now    func() time.Time // 测试中替换的时钟
+}
+
+func newPacketLimiter(r PacketRate) *packetLimiter {
+	if r.PPS <= 0 {
+		return nil
+	}
+	return &packetLimiter{rate: r, tokens: float64(r.Burst), last: time.Now(), now: time.Now}
+}
+
+// allow 取一个令牌，桶空时返回 false
+func (l *packetLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate.PPS, float64(l.rate.Burst))
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// packetRateFor 返回会话用户的数据报速率上限：UserUDPPacketRate 对该用户有设置时使用它，否则为 UDPPacketRate
+func (s *Server) packetRateFor(sess *Session) PacketRate {
+	if sess != nil && sess.User != "" && s.UserUDPPacketRate != nil {
+		if r, ok := s.UserUDPPacketRate(sess.User); ok {
+			return r
+		}
+	}
+	return s.UDPPacketRate
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePacketRate(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want PacketRate
+		ok   bool
+	}{
+		{"0", PacketRate{}, true},
+		{"100", PacketRate{PPS: 100, Burst: 100}, true},
+		{"100/20", PacketRate{PPS: 100, Burst: 20}, true},
+		{"0.5", PacketRate{PPS: 0.5, Burst: 1}, true},
+		{"2.5/5", PacketRate{PPS: 2.5, Burst: 5}, true},
+		{"0/10", PacketRate{}, false},
+		{"100/0", PacketRate{}, false},
+		{"100/x", PacketRate{}, false},
+		{"-1", PacketRate{}, false},
+		{"fast", PacketRate{}, false},
+		{"", PacketRate{}, false},
+	} {
+		got, err := ParsePacketRate(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("ParsePacketRate(%q) = %+v, %v", tc.in, got, err)
+		}
+		if tc.ok {
+			if back, err := ParsePacketRate(got.String()); err != nil || back != got {
+				t.Errorf("ParsePacketRate(%q.String() = %q) = %+v, %v", tc.in, got.String(), back, err)
+			}
+		}
+	}
+}
+
+// fakeLimiter 返回使用假时钟的令牌桶和拨动时钟的函数
+func fakeLimiter(r PacketRate) (*packetLimiter, func(time.Duration)) {
+	l := newPacketLimiter(r)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	l.last = now
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+// passed 连续调用 n 次 allow，返回放行的次数
+func passed(l *packetLimiter, n int) int {
+	ok := 0
+	for range n {
+		if l.allow() {
+			ok++
+		}
+	}
+	return ok
+}
+
+// 新的桶和空闲足够久之后的桶都正好放行 Burst 个连续的数据报，空闲不足时按速率恢复
+func TestPacketLimiterBurst(t *testing.T) {
+	if l := newPacketLimiter(PacketRate{}); l != nil || !l.allow() {
+		t.Fatal("zero rate must not limit")
+	}
+	const burst = 25
+	l, advance := fakeLimiter(PacketRate{PPS: 50, Burst: burst})
+	if n := passed(l, 10*burst); n != burst {
+		t.Fatalf("fresh bucket passed %d back-to-back packets, want %d", n, burst)
+	}
+	advance(200 * time.Millisecond)
+	if n := passed(l, 10*burst); n != 10 {
+		t.Fatalf("after 200ms passed %d back-to-back packets, want 10", n)
+	}
+	advance(time.Hour)
+	if n := passed(l, 10*burst); n != burst {
+		t.Fatalf("refilled bucket passed %d back-to-back packets, want %d", n, burst)
+	}
+}
+
+// 持续超速时放行的数量正好是 Burst 加上速率乘以时长的整数部分，低于速率时全部放行；
+// 时钟每次前进 1/64 秒，令牌数都能精确表示
+func TestPacketLimiterSustained(t *testing.T) {
+	const step = time.Second / 64
+	for _, tc := range []struct {
+		rate         PacketRate
+		steps, offer int // 前进 steps 次，每次发送 offer 个
+		want         int
+	}{
+		{PacketRate{PPS: 128, Burst: 10}, 64, 4, 10 + 126},
+		{PacketRate{PPS: 64, Burst: 1}, 64, 4, 64},
+		{PacketRate{PPS: 0.5, Burst: 3}, 640, 1, 3 + 4},
+		{PacketRate{PPS: 1000, Burst: 1000}, 640, 1, 640}, // 低于速率
+		{PacketRate{PPS: 128, Burst: 128}, 64, 10, 128 + 126},
+	} {
+		l, advance := fakeLimiter(tc.rate)
+		n := 0
+		for range tc.steps {
+			advance(step)
+			n += passed(l, tc.offer)
+		}
+		if n != tc.want {
+			t.Errorf("%s: passed %d of %d packets over %d steps, want %d", tc.rate, n, tc.steps*tc.offer, tc.steps, tc.want)
+		}
+	}
+}
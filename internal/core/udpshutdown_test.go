@@ -0,0 +1,85 @@
+package core_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+func TestShutdownDuringUDPBlast(t *testing.T) {
+	for range 3 {
+		res := core.NewResources()
+		res.UDPWorkers, res.UDPQueue = 4, 64
+		s := newTestServer(t)
+		s.Resources = res
+		done := startServer(t, s)
+		echo := socks5test.EchoUDPServer(t)
+		relay := s.UDPAddr().(*net.UDPAddr)
+
+		// 多个关联同时发送，既有排队中的数据报，也有读协程持有缓冲区的交换
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for range 4 {
+			// 控制连接在测试结束时由 socks5test 关闭
+			socks5test.Associate(t, s.TCPAddr().String(), nil)
+			uc := listenUDP(t)
+			pkt := core.NewDatagram(core.ATYPIPv4, []byte{127, 0, 0, 1}, portBytes(t, echo), make([]byte, 1200)).Bytes()
+			wg.Go(func() {
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if _, err := uc.WriteToUDP(pkt, relay); err != nil {
+						return
+					}
+				}
+			})
+			wg.Go(func() {
+				b := make([]byte, 65535)
+				for {
+					uc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+					if _, _, err := uc.ReadFromUDP(b); err != nil {
+						return
+					}
+				}
+			})
+		}
+		time.Sleep(50 * time.Millisecond)
+		if res.UDPBufsOut() == 0 {
+			t.Fatal("no UDP buffers in use during the blast")
+		}
+		if err := s.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitServe(t, done); err != nil {
+			t.Fatalf("Serve = %v", err)
+		}
+		close(stop)
+		wg.Wait()
+
+		// 交换的读协程在关闭后退出，所有缓冲区都应归还
+		deadline := time.Now().Add(3 * time.Second)
+		for res.UDPBufsOut() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := res.UDPBufsOut(); n != 0 {
+			t.Fatalf("%d UDP buffers not returned after Shutdown", n)
+		}
+	}
+}
+
+// portBytes 返回 host:port 中端口的两字节大端表示
+func portBytes(t *testing.T, addr string) []byte {
+	t.Helper()
+	ap, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte{byte(ap.Port >> 8), byte(ap.Port)}
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// udpExchangeCounters UDPExchange 的计数，数据报路径上只做原子加
+type udpExchangeCounters struct {
+	started      time.Time
+	active       atomic.Int64 // 最近一次收发数据报的 UnixNano
+	sentPackets  atomic.Int64
+	sentBytes    atomic.Int64
+	recvPackets  atomic.Int64
+	recvBytes    atomic.Int64
+	dropped      atomic.Int64
+	replyDropped atomic.Int64
+	top          topCursor // 已计入 TopDestinations 的字节数
+}
+
+// UDPExchangeStats 一个 UDP 交换的计数
+type UDPExchangeStats struct {
+	Client       string `json:"client"`
+	Dst          string `json:"dst"`
+	Remote       string `json:"remote,omitempty"` // 远端的实际地址，经上游转发时为上游的地址
+	SentPackets  int64  `json:"sent_packets"`     // 发往远端的数据报
+	SentBytes    int64  `json:"sent_bytes"`
+	RecvPackets  int64  `json:"recv_packets"` // 转发给客户端的应答数据报
+	RecvBytes    int64  `json:"recv_bytes"`
+	Dropped      int64  `json:"dropped"`       // 所属关联已结束而丢弃的客户端数据报
+	ReplyDropped int64  `json:"reply_dropped"` // 发送缓冲区满而丢弃的应答数据报
+	AgeMS        int64  `json:"age_ms"`
+	IdleMS       int64  `json:"idle_ms"`
+}
+
+// Stats 返回交换当前的计数
+func (ue *UDPExchange) Stats() UDPExchangeStats {
+	now := time.Now()
+	st := UDPExchangeStats{
+		Dst:          ue.Dst,
+		SentPackets:  ue.sentPackets.Load(),
+		SentBytes:    ue.sentBytes.Load(),
+		RecvPackets:  ue.recvPackets.Load(),
+		RecvBytes:    ue.recvBytes.Load(),
+		Dropped:      ue.dropped.Load(),
+		ReplyDropped: ue.replyDropped.Load(),
+		AgeMS:        now.Sub(ue.started).Milliseconds(),
+		IdleMS:       now.Sub(time.Unix(0, ue.active.Load())).Milliseconds(),
+	}
+	if ue.ClientAddr != nil {
+		st.Client = ue.ClientAddr.String()
+	}
+	if ue.RemoteConn != nil {
+		if ra := ue.RemoteConn.RemoteAddr(); ra != nil {
+			st.Remote = ra.String()
+		}
+	}
+	return st
+}
+
+// countSent 计入一个发往远端的数据报
+func (ue *UDPExchange) countSent(n int) {
+	ue.sentPackets.Add(1)
+	ue.sentBytes.Add(int64(n))
+	ue.active.Store(time.Now().UnixNano())
+}
+
+// countRecv 计入一个转发给客户端的应答数据报
+func (ue *UDPExchange) countRecv(n int) {
+	ue.recvPackets.Add(1)
+	ue.recvBytes.Add(int64(n))
+	ue.active.Store(time.Now().UnixNano())
+}
+
+// UDPTotals 一个 UDP 关联所有交换的合计，写入关联的访问日志
+type UDPTotals struct {
+	Exchanges    int   `json:"exchanges"`
+	SentPackets  int64 `json:"sent_packets"`
+	RecvPackets  int64 `json:"recv_packets"`
+	Dropped      int64 `json:"dropped"`
+	ReplyDropped int64 `json:"reply_dropped"`
+	RateLimited  int64 `json:"rate_limited"` // 超过关联的数据报速率上限而丢弃的客户端数据报
+}
+
+// udpAssocExchanges UDPAssociation 进行中的交换和已结束交换的合计
+type udpAssocExchanges struct {
+	mu        sync.Mutex
+	live      []*UDPExchange
+	done      UDPTotals
+	doneBytes [2]int64 // 已结束交换的上行、下行字节
+}
+
+// addExchange 登记关联的一个交换
+func (a *UDPAssociation) addExchange(ue *UDPExchange) {
+	a.exchanges.mu.Lock()
+	defer a.exchanges.mu.Unlock()
+	a.exchanges.live = append(a.exchanges.live, ue)
+}
+
+// removeExchange 交换结束时注销，计数并入关联的合计
+func (a *UDPAssociation) removeExchange(ue *UDPExchange, st UDPExchangeStats) {
+	e := &a.exchanges
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(e.live)
+	if e.live = slices.DeleteFunc(e.live, func(x *UDPExchange) bool { return x == ue }); len(e.live) == n {
+		return
+	}
+	e.done.add(st)
+	e.doneBytes[0] += st.SentBytes
+	e.doneBytes[1] += st.RecvBytes
+}
+
+func (t *UDPTotals) add(st UDPExchangeStats) {
+	t.Exchanges++
+	t.SentPackets += st.SentPackets
+	t.RecvPackets += st.RecvPackets
+	t.Dropped += st.Dropped
+	t.ReplyDropped += st.ReplyDropped
+}
+
+// Exchanges 返回关联进行中的交换的计数
+func (a *UDPAssociation) Exchanges() []UDPExchangeStats {
+	a.exchanges.mu.Lock()
+	live := slices.Clone(a.exchanges.live)
+	a.exchanges.mu.Unlock()
+	out := make([]UDPExchangeStats, len(live))
+	for i, ue := range live {
+		out[i] = ue.Stats()
+	}
+	return out
+}
+
+// Totals 返回关联已结束和进行中的所有交换的合计，以及上行、下行字节数
+func (a *UDPAssociation) Totals() (t UDPTotals, up, down int64) {
+	e := &a.exchanges
+	e.mu.Lock()
+	t, up, down = e.done, e.doneBytes[0], e.doneBytes[1]
+	live := slices.Clone(e.live)
+	e.mu.Unlock()
+	t.RateLimited = a.limited.Load()
+	for _, ue := range live {
+		st := ue.Stats()
+		t.add(st)
+		up += st.SentBytes
+		down += st.RecvBytes
+	}
+	return t, up, down
+}
+
+// UDPAssociationInfo 管理接口列出的一个进行中的 UDP 关联
+type UDPAssociationInfo struct {
+	Client    string             `json:"client"` // 控制连接的客户端地址
+	UDPAddr   string             `json:"udp_addr,omitempty"`
+	User      string             `json:"user,omitempty"`
+	Listener  string             `json:"listener,omitempty"`
+	Totals    UDPTotals          `json:"totals"`
+	Exchanges []UDPExchangeStats `json:"exchanges"`
+}
+
+// UDPAssociations 返回进行中的 UDP 关联及其交换的计数
+func (s *Server) UDPAssociations() []UDPAssociationInfo {
+	var out []UDPAssociationInfo
+	s.relays.m.Range(func(k, _ any) bool {
+		r := k.(*relay)
+		if r.assoc == nil {
+			return true
+		}
+		a := r.assoc
+		info := UDPAssociationInfo{Client: r.conns[0].RemoteAddr().String(), Exchanges: a.Exchanges()}
+		info.Totals, _, _ = a.Totals()
+		if ca := a.ClientAddr(); ca != nil {
+			info.UDPAddr = ca.String()
+		}
+		if r.sess != nil {
+			info.User = r.sess.User
+			if r.sess.Profile != nil {
+				info.Listener = r.sess.Profile.Label
+			}
+		}
+		out = append(out, info)
+		return true
+	})
+	slices.SortFunc(out, func(a, b UDPAssociationInfo) int { return strings.Compare(a.Client, b.Client) })
+	return out
+}
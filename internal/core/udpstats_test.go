@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+)
+
+// BenchmarkUDPExchangeCount 数据报路径上每个数据报的计数开销，并发时所有发送方计入同一个交换
+func BenchmarkUDPExchangeCount(b *testing.B) {
+	b.Run("serial", func(b *testing.B) {
+		ue := &UDPExchange{}
+		b.ReportAllocs()
+		for range b.N {
+			ue.countSent(512)
+			ue.countRecv(512)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		ue := &UDPExchange{}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ue.countSent(512)
+				ue.countRecv(512)
+			}
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package core_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// 控制连接在大量数据报仍在流动时关闭：交换的创建、转发和拆除并发进行，以 -race 运行才有意义
+// 关联结束后继续发送的数据报按未关联的数据报处理，建立的交换在 UDPTimeout 后结束
+func TestUDPTeardownUnderLoad(t *testing.T) {
+	var mu sync.Mutex
+	owned := 0 // 属于关联、已结束的交换
+	ts := socks5test.NewServer(t, func(s *core.Server) {
+		s.UDPTimeout = 1
+		s.OnUDPExchangeClose = func(sess *core.Session, _ core.UDPExchangeStats) {
+			if sess != nil {
+				mu.Lock()
+				owned++
+				mu.Unlock()
+			}
+		}
+	})
+	var dsts []string
+	for range 4 {
+		dsts = append(dsts, socks5test.EchoUDPServer(t))
+	}
+	relay := ts.Server.UDPAddr().(*net.UDPAddr)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		ctl, _ := socks5test.Associate(t, ts.Addr, nil)
+		uc := listenUDP(t)
+		stop := make(chan struct{})
+		wg.Go(func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				udpSend(t, uc, relay, dsts[i%len(dsts)], []byte("flow"))
+			}
+		})
+		wg.Go(func() {
+			b := make([]byte, 65535)
+			for {
+				uc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				if _, _, err := uc.ReadFromUDP(b); err != nil {
+					return
+				}
+			}
+		})
+		// 每个关联在不同时刻结束，发送方在结束之后还继续发送一会儿
+		wg.Go(func() {
+			time.Sleep(20 * time.Millisecond)
+			ctl.Close()
+			time.Sleep(20 * time.Millisecond)
+			close(stop)
+		})
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (len(ts.UDPAssociations()) != 0 || exchanges(ts.Server) != 0) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(ts.UDPAssociations()); n != 0 {
+		t.Fatalf("%d associations left after every control connection closed", n)
+	}
+	if n := exchanges(ts.Server); n != 0 {
+		t.Fatalf("%d exchanges left after every association ended and UDPTimeout passed", n)
+	}
+	mu.Lock()
+	n := owned
+	mu.Unlock()
+	if n == 0 {
+		t.Fatal("no association-owned exchange was torn down")
+	}
+	// 之后仍可建立新的关联，服务端仍可建立新的关联
+	ctl, relay2 := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	socks5test.EchoUDP(t, relay2, dsts[0], []byte("fresh"))
+}
+
+// exchanges 返回 s 中的交换数，同一交换以多个键登记时只计一次
+func exchanges(s *core.Server) int {
+	seen := map[*core.UDPExchange]bool{}
+	s.UDPExchanges.Range(func(_, v any) bool {
+		seen[v.(*core.UDPExchange)] = true
+		return true
+	})
+	return len(seen)
+}
@@ -0,0 +1,88 @@
+package core_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// subnegotiate 以版本字节 ver 发送用户名密码子协商，返回服务端的应答，连接被关闭时返回 nil
+func subnegotiate(t *testing.T, proxy string, ver byte, user, pass string) (net.Conn, *core.UserPassNegotiationReply) {
+	t.Helper()
+	c, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	core.NewNegotiationRequest([]byte{core.MethodUsernamePassword}).WriteTo(c)
+	if _, err := core.NewNegotiationReplyFrom(c); err != nil {
+		t.Fatal(err)
+	}
+	msg := append([]byte{ver, byte(len(user))}, user...)
+	msg = append(append(msg, byte(len(pass))), pass...)
+	c.Write(msg)
+	r, err := core.NewUserPassNegotiationReplyFrom(c)
+	if err != nil {
+		return c, nil
+	}
+	return c, r
+}
+
+func TestAllowBadUserPassVersion(t *testing.T) {
+	echo := socks5test.EchoTCPServer(t)
+	for _, lenient := range []bool{false, true} {
+		name := "strict"
+		if lenient {
+			name = "lenient"
+		}
+		t.Run(name, func(t *testing.T) {
+			ts := socks5test.NewServer(t, socks5test.WithAuth("u", "p"), func(s *core.Server) { s.AllowBadUserPassVersion = lenient })
+
+			// 0x01 总是接受，不计数
+			if _, r := subnegotiate(t, ts.Addr, core.UserPassVer, "u", "p"); r == nil || r.Status != core.UserPassStatusSuccess {
+				t.Fatalf("version 0x01: %v", r)
+			}
+
+			c, r := subnegotiate(t, ts.Addr, core.Ver, "u", "p")
+			if !lenient {
+				if r != nil {
+					t.Fatalf("strict server answered version 0x05 with %v", r)
+				}
+				waitCounter(t, &ts.Stats.HandshakeErrors, 1)
+				if n := ts.Stats.BadUserPassVersion.Load(); n != 0 {
+					t.Fatalf("BadUserPassVersion = %d in strict mode", n)
+				}
+				return
+			}
+			// 应答的版本仍为 0x01，之后的请求照常处理
+			if r == nil || r.Ver != core.UserPassVer || r.Status != core.UserPassStatusSuccess {
+				t.Fatalf("version 0x05: %v", r)
+			}
+			atyp, addr, port, _ := core.ParseAddress(echo)
+			core.NewRequest(core.CmdConnect, atyp, addr, port).WriteTo(c)
+			if rp, err := core.NewReplyFrom(c); err != nil || rp.Rep != core.RepSuccess {
+				t.Fatalf("CONNECT after version 0x05: %v %v", rp, err)
+			}
+			socks5test.EchoTCP(t, c, []byte("lenient"))
+
+			// 错误的密码仍然失败，版本同样计数
+			if _, r := subnegotiate(t, ts.Addr, core.Ver, "u", "wrong"); r == nil || r.Status == core.UserPassStatusSuccess {
+				t.Fatalf("version 0x05 with a wrong password: %v", r)
+			}
+			// 其他版本在两种模式下都被拒绝
+			if _, r := subnegotiate(t, ts.Addr, 0x02, "u", "p"); r != nil {
+				t.Fatalf("version 0x02 answered with %v", r)
+			}
+			if n := ts.Stats.BadUserPassVersion.Load(); n != 2 {
+				t.Fatalf("BadUserPassVersion = %d, want 2", n)
+			}
+			if n := ts.Stats.Snapshot().BadUserPassVersion; n != 2 {
+				t.Fatalf("snapshot BadUserPassVersion = %d, want 2", n)
+			}
+		})
+	}
+}
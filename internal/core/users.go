@@ -0,0 +1,237 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// LoadUsersFile 读取用户文件，每行 "<用户名> <密码> [选项...]"，选项：
+// previous=<旧密码> until=<RFC 3339 时间> 为轮换期间仍然有效的旧密码，到期后只接受新密码；
+// totp=<base32 密钥> 要求客户端在密码后附加 ":验证码"；quota=<字节数> 为该用户每个配额周期的流量上限（见 ParseBytes），
+// 由调用方交给 QuotaTable；udp-pps=<速率>[/<突发>] 代替全局的 UDP 数据报速率上限（见 ParsePacketRate），0 表示不限制；
+// 密码可以是 bcrypt 散列
+// 以 # 开头的行和空行被忽略，用户名和密码不能包含空白
+func LoadUsersFile(path string) (*StaticCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &StaticCredentials{users: make(map[string]*credential)}
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<user> <password> [options]\"", path, line)
+		}
+		user := fields[0]
+		if _, ok := c.users[user]; ok {
+			return nil, fmt.Errorf("%s:%d: user %q is already defined", path, line, user)
+		}
+		if err := c.Set(user, fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		var previous, until, totp string
+		for _, opt := range fields[2:] {
+			k, v, _ := strings.Cut(opt, "=")
+			switch k {
+			case "previous":
+				previous = v
+			case "until":
+				until = v
+			case "totp":
+				totp = v
+			case "quota":
+				n, err := ParseBytes(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+				}
+				c.users[user].quota = n
+			case "udp-pps":
+				r, err := ParsePacketRate(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+				}
+				c.users[user].udpRate = &r
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown option %q", path, line, k)
+			}
+		}
+		if totp != "" {
+			if err := c.SetTOTP(user, totp); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		if (previous == "") != (until == "") {
+			return nil, fmt.Errorf("%s:%d: previous and until must be set together", path, line)
+		}
+		if previous != "" {
+			t, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid until %q", path, line, until)
+			}
+			if err := c.SetPrevious(user, previous, t); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Replace 以 from 的用户表替换当前的用户表，用于重新加载用户文件；
+// TOTP 密钥未变的用户保留上次接受的验证码所在的步，重新加载后同一验证码仍不能再次使用
+func (c *StaticCredentials) Replace(from *StaticCredentials) {
+	from.mu.Lock()
+	users := from.users
+	from.users = nil
+	from.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for user, cr := range users {
+		if old := c.users[user]; old != nil && cr.totp != nil && bytes.Equal(old.totp, cr.totp) {
+			cr.lastStep = old.lastStep
+		}
+	}
+	c.users = users
+}
+
+// ValidateUser 检查用户名和密码能否写入用户文件并在子协商中使用：都不能为空、不能包含空白，
+// 不超过 255 字节的 UTF-8，用户名不以 # 开头；password 为空时只检查用户名
+func ValidateUser(user, password string) error {
+	if user == "" {
+		return errors.New("username is empty")
+	}
+	if strings.HasPrefix(user, "#") || strings.IndexFunc(user, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("username %q must not contain whitespace or start with #", user)
+	}
+	if strings.IndexFunc(password, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("password of user %q must not contain whitespace", user)
+	}
+	return validateStoredPassword(user, password)
+}
+
+// UserInfo 用户文件中一个用户的设置，不包含密码和密钥
+type UserInfo struct {
+	User          string     `json:"user"`
+	Hashed        bool       `json:"hashed"`                   // 密码以 bcrypt 散列保存
+	TOTP          bool       `json:"totp"`                     // 要求 TOTP 验证码
+	PreviousUntil *time.Time `json:"previous_until,omitempty"` // 轮换中的旧密码的失效时间
+	Quota         int64      `json:"quota,omitempty"`          // 用户文件中 quota= 的上限，0 表示没有
+	UDPPPS        string     `json:"udp_pps,omitempty"`        // 用户文件中 udp-pps= 的数据报速率上限，空表示使用全局设置
+}
+
+// Users 返回所有用户的设置，按用户名排序
+func (c *StaticCredentials) Users() []UserInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	list := make([]UserInfo, 0, len(c.users))
+	for user, cr := range c.users {
+		info := UserInfo{User: user, Hashed: IsPasswordHash(cr.password), TOTP: cr.totp != nil, Quota: cr.quota}
+		if cr.udpRate != nil {
+			info.UDPPPS = cr.udpRate.String()
+		}
+		if cr.previous != "" && now.Before(cr.previousUntil) {
+			until := cr.previousUntil
+			info.PreviousUntil = &until
+		}
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b UserInfo) int { return strings.Compare(a.User, b.User) })
+	return list
+}
+
+// SetQuota 设置已有用户的流量上限，0 表示没有；只记录在用户表中，由调用方交给 QuotaTable
+func (c *StaticCredentials) SetQuota(user string, quota int64) error {
+	if quota < 0 {
+		return fmt.Errorf("negative quota for user %q", user)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr := c.users[user]
+	if cr == nil {
+		return fmt.Errorf("user %q does not exist", user)
+	}
+	cr.quota = quota
+	return nil
+}
+
+// Quotas 返回设置了流量上限的用户和上限
+func (c *StaticCredentials) Quotas() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int64)
+	for user, cr := range c.users {
+		if cr.quota > 0 {
+			m[user] = cr.quota
+		}
+	}
+	return m
+}
+
+// UDPPacketRate 返回用户文件中为 user 设置的 UDP 数据报速率上限，没有设置时 ok 为 false
+func (c *StaticCredentials) UDPPacketRate(user string) (r PacketRate, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cr := c.users[user]; cr != nil && cr.udpRate != nil {
+		return *cr.udpRate, true
+	}
+	return PacketRate{}, false
+}
+
+// Clone 返回用户表的副本，修改副本不影响原表，可用 Replace 换回
+func (c *StaticCredentials) Clone() *StaticCredentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := &StaticCredentials{AllowEmpty: c.AllowEmpty, users: make(map[string]*credential, len(c.users))}
+	for user, cr := range c.users {
+		cp := *cr
+		n.users[user] = &cp
+	}
+	return n
+}
+
+// Format 返回 LoadUsersFile 格式的用户文件内容，按用户名排序，已过期的旧密码不再写出
+func (c *StaticCredentials) Format() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var b bytes.Buffer
+	for _, user := range slices.Sorted(maps.Keys(c.users)) {
+		cr := c.users[user]
+		b.WriteString(user + " " + cr.password)
+		if cr.previous != "" && now.Before(cr.previousUntil) {
+			b.WriteString(" previous=" + cr.previous + " until=" + cr.previousUntil.Format(time.RFC3339))
+		}
+		if cr.totp != nil {
+			b.WriteString(" totp=" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(cr.totp))
+		}
+		if cr.quota > 0 {
+			b.WriteString(" quota=" + strconv.FormatInt(cr.quota, 10))
+		}
+		if cr.udpRate != nil {
+			b.WriteString(" udp-pps=" + cr.udpRate.String())
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
@@ -1,39 +1,147 @@
 package core
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 )
 
 // ParseAddress format address x.x.x.x:xx to raw address.
 // addr contains domain length
+// IPv6 字面量需要方括号，区域标识（如 [fe80::1%eth0]:80）被去掉，报文中的地址无法携带区域；
+// IPv4-mapped IPv6 按 IPv4 处理
 func ParseAddress(address string) (a byte, addr []byte, port []byte, err error) {
 	var h, p string
 	h, p, err = net.SplitHostPort(address)
 	if err != nil {
 		return
 	}
-	ip := net.ParseIP(h)
-	if ip4 := ip.To4(); ip4 != nil {
-		a = ATYPIPv4
-		addr = []byte(ip4)
-	} else if ip6 := ip.To16(); ip6 != nil {
-		a = ATYPIPv6
-		addr = []byte(ip6)
+	var i uint64
+	i, err = strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		err = fmt.Errorf("invalid port in %q: %w", address, err)
+		return
+	}
+	if ip, perr := netip.ParseAddr(h); perr == nil {
+		a, addr, port = AddrPortToATYP(netip.AddrPortFrom(ip, uint16(i)))
+		return
 	} else {
+		if len(h) > 255 {
+			err = fmt.Errorf("domain too long in %q", address)
+			return
+		}
 		a = ATYPDomain
 		addr = []byte{byte(len(h))}
 		addr = append(addr, []byte(h)...)
 	}
-	i, _ := strconv.Atoi(p)
 	port = make([]byte, 2)
 	binary.BigEndian.PutUint16(port, uint16(i))
 	return
 }
 
+// AddrToATYP 将 net.Addr 转换为应答和数据报中的 ATYP、地址和端口，返回的域名不含长度字节，可直接传给 NewReply/NewDatagram
+// *net.TCPAddr 和 *net.UDPAddr 直接取 IP（IPv4-mapped IPv6 按 IPv4 处理，IP 为空时为 0.0.0.0，区域标识不写入报文），其他类型按 String() 解析
+func AddrToATYP(a net.Addr) (byte, []byte, []byte, error) {
+	var ip net.IP
+	var p int
+	switch a := a.(type) {
+	case nil:
+		return 0, nil, nil, errors.New("Invalid address")
+	case *net.TCPAddr:
+		if a == nil {
+			return 0, nil, nil, errors.New("Invalid address")
+		}
+		ip, p = a.IP, a.Port
+	case *net.UDPAddr:
+		if a == nil {
+			return 0, nil, nil, errors.New("Invalid address")
+		}
+		ip, p = a.IP, a.Port
+	default:
+		atyp, addr, port, err := ParseAddress(a.String())
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if atyp == ATYPDomain {
+			addr = addr[1:]
+		}
+		return atyp, addr, port, nil
+	}
+	var addr netip.Addr
+	if len(ip) != 0 {
+		var ok bool
+		if addr, ok = netip.AddrFromSlice(ip); !ok {
+			return 0, nil, nil, errors.New("Invalid address")
+		}
+	}
+	atyp, b, port := AddrPortToATYP(netip.AddrPortFrom(addr, uint16(p)))
+	return atyp, b, port, nil
+}
+
+// AddrPortToATYP 将 netip.AddrPort 转换为应答和数据报中的 ATYP、地址和端口，不经过字符串：
+// IPv4-mapped IPv6 按 IPv4 处理，区域标识不写入报文，零值地址为 0.0.0.0
+func AddrPortToATYP(ap netip.AddrPort) (byte, []byte, []byte) {
+	port := binary.BigEndian.AppendUint16(make([]byte, 0, 2), ap.Port())
+	a := ap.Addr().Unmap()
+	switch {
+	case !a.IsValid():
+		return ATYPIPv4, []byte{0x00, 0x00, 0x00, 0x00}, port
+	case a.Is4():
+		b := a.As4()
+		return ATYPIPv4, b[:], port
+	}
+	b := a.As16()
+	return ATYPIPv6, b[:], port
+}
+
+// ATYPToAddrPort 将报文中的 IP 字面量地址转换为 netip.AddrPort，ATYP 为 IPv4 时不会表示为 IPv4-mapped IPv6；
+// 域名、长度与 ATYP 不符的地址或端口返回错误
+func ATYPToAddrPort(atyp byte, addr, port []byte) (netip.AddrPort, error) {
+	if len(port) != 2 {
+		return netip.AddrPort{}, errors.New("Invalid port")
+	}
+	var a netip.Addr
+	switch {
+	case atyp == ATYPIPv4 && len(addr) == net.IPv4len:
+		a = netip.AddrFrom4([4]byte(addr))
+	case atyp == ATYPIPv6 && len(addr) == net.IPv6len:
+		a = netip.AddrFrom16([16]byte(addr))
+	case atyp == ATYPDomain:
+		return netip.AddrPort{}, errors.New("address is a domain")
+	default:
+		return netip.AddrPort{}, errors.New("Invalid address")
+	}
+	return netip.AddrPortFrom(a, binary.BigEndian.Uint16(port)), nil
+}
+
+// netAddrPort 取出 TCP、UDP 地址的 netip.AddrPort，其他类型按 String() 解析，不是 IP 地址时返回 false
+func netAddrPort(a net.Addr) (netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if a != nil {
+			return a.AddrPort(), true
+		}
+	case *net.UDPAddr:
+		if a != nil {
+			return a.AddrPort(), true
+		}
+	case nil:
+	default:
+		ap, err := netip.ParseAddrPort(a.String())
+		return ap, err == nil
+	}
+	return netip.AddrPort{}, false
+}
+
+// stringAddr 以字符串形式给出的 host:port 地址
+type stringAddr string
+
+func (a stringAddr) Network() string { return "" }
+func (a stringAddr) String() string  { return string(a) }
+
 // bytes to address
 // addr contains domain length
 func ParseBytesAddress(b []byte) (a byte, addr []byte, port []byte, err error) {
@@ -100,36 +208,131 @@ func ToAddress(a byte, addr []byte, port []byte) string {
 
 // Address return request address like ip:xx
 func (r *Request) Address() string {
-	var s string
-	if r.Atyp == ATYPDomain {
-		s = bytes.NewBuffer(r.DstAddr[1:]).String()
-	} else {
-		s = net.IP(r.DstAddr).String()
-	}
-	p := strconv.Itoa(int(binary.BigEndian.Uint16(r.DstPort)))
-	return net.JoinHostPort(s, p)
+	return net.JoinHostPort(r.Host(), strconv.Itoa(int(r.Port())))
+}
+
+// Host 返回目标的域名或 IP 字面量，不含端口，IPv6 不带方括号
+func (r *Request) Host() string {
+	return addrHost(r.Atyp, r.DstAddr)
+}
+
+// Port 返回目标端口
+func (r *Request) Port() uint16 {
+	return addrPort(r.DstPort)
+}
+
+// IsDomain 目标是否为域名
+func (r *Request) IsDomain() bool {
+	return r.Atyp == ATYPDomain
+}
+
+// AddrPort 返回 IP 字面量目标的地址，目标为域名时返回 false
+func (r *Request) AddrPort() (netip.AddrPort, bool) {
+	return addrPortOf(r.Atyp, r.DstAddr, r.DstPort)
 }
 
 // Address return request address like ip:xx
 func (r *Reply) Address() string {
-	var s string
-	if r.Atyp == ATYPDomain {
-		s = bytes.NewBuffer(r.BndAddr[1:]).String()
-	} else {
-		s = net.IP(r.BndAddr).String()
-	}
-	p := strconv.Itoa(int(binary.BigEndian.Uint16(r.BndPort)))
-	return net.JoinHostPort(s, p)
+	return net.JoinHostPort(addrHost(r.Atyp, r.BndAddr), strconv.Itoa(int(addrPort(r.BndPort))))
 }
 
 // Address return datagram address like ip:xx
 func (d *Datagram) Address() string {
-	var s string
-	if d.Atyp == ATYPDomain {
-		s = bytes.NewBuffer(d.DstAddr[1:]).String()
-	} else {
-		s = net.IP(d.DstAddr).String()
+	return net.JoinHostPort(d.Host(), strconv.Itoa(int(d.Port())))
+}
+
+// Host 返回目标的域名或 IP 字面量，不含端口，IPv6 不带方括号
+func (d *Datagram) Host() string {
+	return addrHost(d.Atyp, d.DstAddr)
+}
+
+// Port 返回目标端口
+func (d *Datagram) Port() uint16 {
+	return addrPort(d.DstPort)
+}
+
+// IsDomain 目标是否为域名
+func (d *Datagram) IsDomain() bool {
+	return d.Atyp == ATYPDomain
+}
+
+// AddrPort 返回 IP 字面量目标的地址，目标为域名时返回 false
+func (d *Datagram) AddrPort() (netip.AddrPort, bool) {
+	return addrPortOf(d.Atyp, d.DstAddr, d.DstPort)
+}
+
+// addrHost 从报文中的 ATYP 和地址字段得到主机名，域名地址的第一个字节是长度
+func addrHost(atyp byte, addr []byte) string {
+	if atyp == ATYPDomain {
+		if len(addr) == 0 {
+			return ""
+		}
+		return string(addr[1:])
+	}
+	return net.IP(addr).String()
+}
+
+// validDomain 检查报文中的域名：不能含控制字符、空格和 DEL，也不能含冒号、方括号、百分号，
+// 否则拼接出的 host:port 会被误解析为 IPv6 字面量或带区域的地址；非 ASCII 字节（UTF-8 域名）保留
+func validDomain(b []byte) bool {
+	for _, c := range b {
+		if c <= ' ' || c == 0x7f || c == ':' || c == '[' || c == ']' || c == '%' {
+			return false
+		}
 	}
-	p := strconv.Itoa(int(binary.BigEndian.Uint16(d.DstPort)))
-	return net.JoinHostPort(s, p)
+	return true
+}
+
+// addrPort 从两字节的端口字段得到端口，长度不对时返回 0
+func addrPort(port []byte) uint16 {
+	if len(port) != 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(port)
+}
+
+// addrPortOf 将 IP 字面量地址转换为 netip.AddrPort，目标为域名或地址无效时返回 false
+func addrPortOf(atyp byte, addr, port []byte) (netip.AddrPort, bool) {
+	ap, err := ATYPToAddrPort(atyp, addr, port)
+	return ap, err == nil
+}
+
+// unmapIP 将 IPv4-mapped IPv6 地址（::ffff:a.b.c.d）转换为 4 字节形式，其他地址原样返回
+func unmapIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// unmapIPNet 将 ::ffff:a.b.c.d/n（n >= 96）形式的网段转换为等价的 IPv4 网段，
+// 使 v4 网段与 4 字节、16 字节两种表示的 IPv4 地址都能匹配
+func unmapIPNet(n *net.IPNet) *net.IPNet {
+	ones, bits := n.Mask.Size()
+	if bits != 8*net.IPv6len || ones < 96 || n.IP.To4() == nil {
+		return n
+	}
+	return &net.IPNet{IP: n.IP.To4(), Mask: net.CIDRMask(ones-96, 8*net.IPv4len)}
+}
+
+// parseIPEntry 解析白名单、黑名单中的 IP 或 CIDR 条目：CIDR 取网络地址，IPv4-mapped 地址和 ::ffff:a.b.c.d/n（n >= 96）按 IPv4 处理，
+// 带区域标识的地址视为无效；single 表示条目是单个地址
+func parseIPEntry(s string) (p netip.Prefix, single bool, ok bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		if a := p.Addr(); a.Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(a.Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), false, true
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil || a.Zone() != "" {
+		return netip.Prefix{}, false, false
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), true, true
+}
+
+// prefixIPNet 将 netip.Prefix 转换为 *net.IPNet
+func prefixIPNet(p netip.Prefix) *net.IPNet {
+	return &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}
 }
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestValidDomain(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    string
+		ok   bool
+	}{
+		{"plain", "example.com", true},
+		{"single label", "localhost", true},
+		{"trailing dot", "example.com.", true},
+		{"underscore and dash", "_srv-1.example", true},
+		{"utf-8", "例子.测试", true},
+		{"punycode", "xn--fsqu00a.xn--0zwm56d", true},
+		{"high bytes", "\x80\xff\xfe", true},
+		{"ipv4 text", "10.0.0.1", true},
+		{"empty", "", true},
+		{"nul", "exa\x00mple.com", false},
+		{"leading nul", "\x00", false},
+		{"newline", "example.com\r\nHost: evil", false},
+		{"tab", "a\tb", false},
+		{"space", "a b", false},
+		{"del", "a\x7fb", false},
+		{"escape", "\x1b[31m", false},
+		{"colon", "evil.com:25", false},
+		{"ipv6 text", "::1", false},
+		{"bracket", "[::1]", false},
+		{"close bracket", "a]", false},
+		{"zone", "fe80::1%eth0", false},
+		{"percent", "a%2e", false},
+	} {
+		if got := validDomain([]byte(tc.b)); got != tc.ok {
+			t.Errorf("%s: validDomain(%q) = %v, want %v", tc.name, tc.b, got, tc.ok)
+		}
+	}
+	// 每个字节值单独出现在域名中间
+	for c := range 256 {
+		b := []byte{'a', byte(c), 'b'}
+		want := c > ' ' && c != 0x7f && !strings.ContainsRune(":[]%", rune(c))
+		if got := validDomain(b); got != want {
+			t.Errorf("validDomain(%q) = %v, want %v", b, got, want)
+		}
+	}
+}
+
+// 协议解析对不合法的域名返回 ErrBadDomain，合法的域名拼接出的地址能被 net.SplitHostPort 还原
+func FuzzDomainInRequest(f *testing.F) {
+	for _, s := range []string{"example.com", "a:b", "[::1]", "fe80::1%lo", "a\x00b", "\xff\xfe", "x y", "%", strings.Repeat("a", 255)} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, domain []byte) {
+		if len(domain) == 0 || len(domain) > 255 {
+			return
+		}
+		wire := append([]byte{Ver, CmdConnect, 0x00, ATYPDomain, byte(len(domain))}, domain...)
+		wire = append(wire, 0x01, 0xbb)
+		r, err := NewRequestFrom(bytes.NewReader(wire))
+		if !validDomain(domain) {
+			if err != ErrBadDomain {
+				t.Fatalf("request with domain %q: %v, want ErrBadDomain", domain, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("request with domain %q: %v", domain, err)
+		}
+		host, port, err := net.SplitHostPort(r.Address())
+		if err != nil || host != string(domain) || port != "443" {
+			t.Fatalf("Address %q splits into %q %q (%v)", r.Address(), host, port, err)
+		}
+
+		pkt := append([]byte{0, 0, 0, ATYPDomain, byte(len(domain))}, domain...)
+		d, err := NewDatagramFromBytes(append(pkt, 0, 53, 'x'))
+		if err != nil {
+			t.Fatalf("datagram with domain %q: %v", domain, err)
+		}
+		if d.Host() != string(domain) {
+			t.Fatalf("datagram host %q", d.Host())
+		}
+	})
+}
+
+func TestParseIPEntry(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		want   string
+		single bool
+		ok     bool
+	}{
+		{"203.0.113.5", "203.0.113.5/32", true, true},
+		{"::ffff:203.0.113.5", "203.0.113.5/32", true, true},
+		{"::ffff:cb00:7105", "203.0.113.5/32", true, true},
+		{"203.0.113.0/24", "203.0.113.0/24", false, true},
+		{"203.0.113.9/24", "203.0.113.0/24", false, true},
+		{"::ffff:203.0.113.0/120", "203.0.113.0/24", false, true},
+		{"::ffff:203.0.113.9/120", "203.0.113.0/24", false, true},
+		{"::ffff:0.0.0.0/96", "0.0.0.0/0", false, true},
+		// 前缀短于 96 时覆盖的不只是 IPv4，保持 IPv6 网段
+		{"::ffff:0.0.0.0/80", "::/80", false, true},
+		{"2001:db8::1", "2001:db8::1/128", true, true},
+		{"2001:db8::/32", "2001:db8::/32", false, true},
+		{"fe80::1%eth0", "", false, false},
+		{"203.0.113", "", false, false},
+		{"example.com", "", false, false},
+	} {
+		p, single, ok := parseIPEntry(tc.in)
+		if ok != tc.ok || single != tc.single || (ok && p.String() != tc.want) {
+			t.Errorf("parseIPEntry(%q) = %v, %v, %v; want %s, %v, %v", tc.in, p, single, ok, tc.want, tc.single, tc.ok)
+		}
+	}
+}
+
+func TestUnmapIPNet(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"203.0.113.0/24", "203.0.113.0/24"},
+		{"::ffff:203.0.113.0/120", "203.0.113.0/24"},
+		{"::ffff:0.0.0.0/96", "0.0.0.0/0"},
+		{"::ffff:0.0.0.0/80", "::/80"},
+		{"2001:db8::/32", "2001:db8::/32"},
+	} {
+		_, n, err := net.ParseCIDR(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := unmapIPNet(n)
+		if got.String() != tc.want {
+			t.Errorf("unmapIPNet(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+		// 转换后的网段对两种表示的地址给出相同结果
+		for _, ip := range []string{"203.0.113.5", "198.51.100.1"} {
+			v4, mapped := net.ParseIP(ip).To4(), net.ParseIP("::ffff:"+ip)
+			if got.Contains(v4) != got.Contains(mapped) {
+				t.Errorf("unmapIPNet(%s) matches %s and ::ffff:%s differently", tc.in, ip, ip)
+			}
+		}
+	}
+}
+
+func TestIPListMappedForms(t *testing.T) {
+	client := "203.0.113.5"
+	clientForms := map[string]net.IP{
+		"4-byte":     net.ParseIP(client).To4(),
+		"16-byte":    net.ParseIP(client),
+		"mapped":     net.ParseIP("::ffff:" + client),
+		"mapped hex": net.ParseIP("::ffff:cb00:7105"),
+	}
+	for _, entry := range []string{client, "::ffff:" + client, "203.0.113.0/24", "::ffff:203.0.113.0/120"} {
+		s := &Server{}
+		s.SetWhitelist([]string{entry, "192.0.2.1"})
+		s.SetBlacklist([]string{entry})
+		for form, ip := range clientForms {
+			if !s.IsAllowed(ip) {
+				t.Errorf("whitelist %q: %s client %s not allowed", entry, form, ip)
+			}
+			if !s.IsDenied(ip) {
+				t.Errorf("blacklist %q: %s client %s not denied", entry, form, ip)
+			}
+		}
+		for _, other := range []net.IP{net.ParseIP("203.0.114.5"), net.ParseIP("::ffff:203.0.114.5"), net.ParseIP("2001:db8::cb00:7105")} {
+			if s.IsAllowed(other) || s.IsDenied(other) {
+				t.Errorf("entry %q matched unrelated client %s", entry, other)
+			}
+		}
+	}
+}
+
+// conversionAddrs 覆盖各类地址的形式：IPv4、IPv6、IPv4-mapped、未指定地址、带区域标识
+var conversionAddrs = []string{
+	"0.0.0.0", "127.0.0.1", "192.0.2.1", "255.255.255.255",
+	"::", "::1", "2001:db8::1", "fe80::1%eth0", "64:ff9b::c000:201",
+	"::ffff:0.0.0.0", "::ffff:127.0.0.1", "::ffff:192.0.2.1", "::ffff:255.255.255.255",
+}
+
+var conversionPorts = []uint16{0, 1, 80, 1080, 65535}
+
+func TestAddrPortToATYP(t *testing.T) {
+	for _, s := range conversionAddrs {
+		a := netip.MustParseAddr(s)
+		for _, p := range conversionPorts {
+			atyp, addr, port := AddrPortToATYP(netip.AddrPortFrom(a, p))
+			u := a.Unmap()
+			wantATYP, want := ATYPIPv6, u.AsSlice()
+			if u.Is4() {
+				wantATYP = ATYPIPv4
+			}
+			if atyp != wantATYP || !bytes.Equal(addr, want) || len(port) != 2 || uint16(port[0])<<8|uint16(port[1]) != p {
+				t.Errorf("AddrPortToATYP(%s, %d) = %#x %v %v", s, p, atyp, addr, port)
+			}
+			// 与字符串路径的结果一致
+			sa, saddr, sport, err := ParseAddress(netip.AddrPortFrom(a, p).String())
+			if err != nil || sa != atyp || !bytes.Equal(saddr, addr) || !bytes.Equal(sport, port) {
+				t.Errorf("ParseAddress(%s) = %#x %v %v %v, AddrPortToATYP gives %#x %v %v", netip.AddrPortFrom(a, p), sa, saddr, sport, err, atyp, addr, port)
+			}
+			// 与 net.UDPAddr 的路径一致，16 字节形式的 IPv4 也按 IPv4
+			ua := net.UDPAddrFromAddrPort(netip.AddrPortFrom(a, p))
+			if u.Is4() {
+				ua.IP = ua.IP.To16()
+			}
+			ua2, uaddr, uport, err := AddrToATYP(ua)
+			if err != nil || ua2 != atyp || !bytes.Equal(uaddr, addr) || !bytes.Equal(uport, port) {
+				t.Errorf("AddrToATYP(%s) = %#x %v %v %v, want %#x %v %v", ua, ua2, uaddr, uport, err, atyp, addr, port)
+			}
+		}
+	}
+	// 零值地址写作 0.0.0.0
+	if atyp, addr, port := AddrPortToATYP(netip.AddrPort{}); atyp != ATYPIPv4 || !bytes.Equal(addr, []byte{0, 0, 0, 0}) || !bytes.Equal(port, []byte{0, 0}) {
+		t.Errorf("AddrPortToATYP(zero) = %#x %v %v", atyp, addr, port)
+	}
+}
+
+func TestATYPToAddrPort(t *testing.T) {
+	// 往返：去掉映射和区域标识后与原地址相同
+	for _, s := range conversionAddrs {
+		a := netip.MustParseAddr(s)
+		for _, p := range conversionPorts {
+			ap, err := ATYPToAddrPort(AddrPortToATYP(netip.AddrPortFrom(a, p)))
+			if want := netip.AddrPortFrom(a.Unmap().WithZone(""), p); err != nil || ap != want {
+				t.Errorf("round trip of %s:%d = %v %v, want %v", s, p, ap, err, want)
+			}
+		}
+	}
+
+	mapped := netip.MustParseAddr("::ffff:192.0.2.1").As16()
+	port := []byte{0x04, 0x38}
+	for _, tc := range []struct {
+		name       string
+		atyp       byte
+		addr, port []byte
+		want       string // 为空表示应返回错误
+	}{
+		{"ipv4", ATYPIPv4, []byte{192, 0, 2, 1}, port, "192.0.2.1:1080"},
+		{"ipv6", ATYPIPv6, net.ParseIP("2001:db8::1"), port, "[2001:db8::1]:1080"},
+		// 报文中以 ATYPIPv6 发送的 IPv4-mapped 地址保持原样，由调用方决定是否 Unmap
+		{"mapped in ipv6", ATYPIPv6, mapped[:], port, "[::ffff:192.0.2.1]:1080"},
+		{"16-byte ipv4 atyp", ATYPIPv4, mapped[:], port, ""},
+		{"4-byte ipv6 atyp", ATYPIPv6, []byte{192, 0, 2, 1}, port, ""},
+		{"empty address", ATYPIPv4, nil, port, ""},
+		{"domain", ATYPDomain, []byte("example.com"), port, ""},
+		{"unknown atyp", 0x05, []byte{192, 0, 2, 1}, port, ""},
+		{"short port", ATYPIPv4, []byte{192, 0, 2, 1}, []byte{0x04}, ""},
+		{"long port", ATYPIPv4, []byte{192, 0, 2, 1}, []byte{0x00, 0x04, 0x38}, ""},
+	} {
+		ap, err := ATYPToAddrPort(tc.atyp, tc.addr, tc.port)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: got %v, want an error", tc.name, ap)
+		case tc.want != "" && (err != nil || ap.String() != tc.want):
+			t.Errorf("%s: got %v %v, want %s", tc.name, ap, err, tc.want)
+		}
+	}
+	// ATYP 为 IPv4 时得到的是 4 字节地址，不是 IPv4-mapped
+	if ap, _ := ATYPToAddrPort(ATYPIPv4, []byte{192, 0, 2, 1}, port); !ap.Addr().Is4() {
+		t.Errorf("IPv4 ATYP gave %v, want a 4-byte address", ap)
+	}
+}
+
+// 应答数据报的地址：UDP 地址直接转换，对比先格式化为字符串再解析
+func BenchmarkAddrPortToATYP(b *testing.B) {
+	for _, s := range []string{"192.0.2.1:53", "[2001:db8::1]:53", "[::ffff:192.0.2.1]:53"} {
+		ua := net.UDPAddrFromAddrPort(netip.MustParseAddrPort(s))
+		b.Run("netip "+s, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				AddrPortToATYP(ua.AddrPort())
+			}
+		})
+		b.Run("string "+s, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				ParseAddress(ua.String())
+			}
+		})
+	}
+}
+
+// 请求和数据报中的地址：直接转换，对比经 net.IP 格式化为 host:port 的字符串
+func BenchmarkATYPToAddrPort(b *testing.B) {
+	addr, port := net.ParseIP("2001:db8::1"), []byte{0x00, 0x35}
+	b.Run("netip", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			ATYPToAddrPort(ATYPIPv6, addr, port)
+		}
+	})
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			netip.ParseAddrPort(net.JoinHostPort(addr.String(), "53"))
+		}
+	})
+}
@@ -0,0 +1,204 @@
+// Package quicsocks 在 QUIC 上承载 SOCKS5：每个 QUIC 流是一个独立的 SOCKS5 会话（协商、请求、转发）
+// 独立成包以免 core 依赖 quic-go。UDP ASSOCIATE 暂不支持，会收到 RepCommandNotSupported
+package quicsocks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"socks5/internal/core"
+)
+
+// NextProto 是 QUIC 上 SOCKS5 使用的 ALPN 标识
+const NextProto = "socks5"
+
+// ErrNoTLSConfig 未配置 TLS 时无法启用 QUIC
+var ErrNoTLSConfig = errors.New("QUIC requires a TLS certificate")
+
+// quicConfig 服务端和客户端共用的 QUIC 参数
+var quicConfig = &quic.Config{
+	MaxIdleTimeout:  60 * time.Second,
+	KeepAlivePeriod: 20 * time.Second,
+	Allow0RTT:       true,
+}
+
+// Listen 在 addr 上监听 QUIC 并注册为 s 的组件，随 s.Serve 启动、随 s.Shutdown 停止
+// tlsConf 通常与 TLS 监听共用，会被复制并设置 ALPN
+func Listen(s *core.Server, addr string, tlsConf *tls.Config) (net.Addr, error) {
+	if tlsConf == nil {
+		return nil, ErrNoTLSConfig
+	}
+	conf := tlsConf.Clone()
+	conf.NextProtos = []string{NextProto}
+	ln, err := quic.ListenAddrEarly(addr, conf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.AddComponent(core.Component{
+		Name:  "quic",
+		Stage: core.StageAccept,
+		Start: func(ctx context.Context) error {
+			for {
+				qc, err := ln.Accept(context.Background())
+				if err != nil {
+					if s.ShuttingDown() {
+						return nil
+					}
+					return err
+				}
+				go serveQUICConn(s, qc)
+			}
+		},
+		Stop: ln.Close,
+	})
+	return ln.Addr(), nil
+}
+
+// serveQUICConn 为 QUIC 连接上的每个流启动一个 SOCKS5 会话
+func serveQUICConn(s *core.Server, qc *quic.Conn) {
+	if ip := remoteIP(qc.RemoteAddr()); ip != nil && !s.IsAllowed(ip) {
+		s.Audit.Log(&core.AuditEvent{Event: core.AuditDenied, Client: qc.RemoteAddr().String(), Rule: "whitelist"})
+		qc.CloseWithError(0, "not allowed")
+		return
+	}
+	if ip := remoteIP(qc.RemoteAddr()); ip != nil && s.IsDenied(ip) {
+		s.Audit.Log(&core.AuditEvent{Event: core.AuditDenied, Client: qc.RemoteAddr().String(), Rule: "blacklist"})
+		qc.CloseWithError(0, "not allowed")
+		return
+	}
+	for {
+		st, err := qc.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := s.ServeConn(context.Background(), &streamConn{Stream: st, conn: qc}); err != nil {
+				s.LogServeError(err)
+			}
+		}()
+	}
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if ua, ok := addr.(*net.UDPAddr); ok {
+		return ua.IP
+	}
+	return nil
+}
+
+// streamConn 将 QUIC 流适配为 net.Conn
+type streamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close 关闭流的两个方向
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// ConnectionState 暴露 TLS 状态，使双向 TLS 的证书身份同样生效
+func (c *streamConn) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState().TLS
+}
+
+// SupportsUDP QUIC 流上的会话暂不支持 UDP ASSOCIATE
+func (c *streamConn) SupportsUDP() bool {
+	return false
+}
+
+// Dialer 在一个复用的 QUIC 连接上为每个 SOCKS5 会话打开新流，连接断开后自动重连（支持 0-RTT）
+type Dialer struct {
+	Addr      string
+	TLSConfig *tls.Config
+
+	mu       sync.Mutex
+	conn     *quic.Conn
+	sessions tls.ClientSessionCache // 跨重连保留，用于 0-RTT 恢复
+}
+
+// NewClient 返回通过 QUIC 连接 addr 的 SOCKS5 客户端，只支持 CONNECT
+func NewClient(addr, username, password string, tlsConf *tls.Config, tcpTimeout int) (*core.Client, error) {
+	c, err := core.NewClient(addr, username, password, tcpTimeout, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dialer{Addr: c.Server, TLSConfig: tlsConf}
+	c.ServerDialer = d.OpenStream
+	return c, nil
+}
+
+// OpenStream 打开一个新流作为 SOCKS5 控制连接
+func (d *Dialer) OpenStream() (net.Conn, error) {
+	qc, err := d.connection()
+	if err != nil {
+		return nil, err
+	}
+	st, err := qc.OpenStreamSync(context.Background())
+	if err != nil {
+		// 连接可能已失效，重连后再试一次
+		d.reset(qc)
+		if qc, err = d.connection(); err != nil {
+			return nil, err
+		}
+		if st, err = qc.OpenStreamSync(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return &streamConn{Stream: st, conn: qc}, nil
+}
+
+func (d *Dialer) connection() (*quic.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil && d.conn.Context().Err() == nil {
+		return d.conn, nil
+	}
+	conf := &tls.Config{}
+	if d.TLSConfig != nil {
+		conf = d.TLSConfig.Clone()
+	}
+	conf.NextProtos = []string{NextProto}
+	if conf.ServerName == "" {
+		if h, _, err := net.SplitHostPort(d.Addr); err == nil {
+			conf.ServerName = h
+		}
+	}
+	if conf.ClientSessionCache == nil {
+		if d.sessions == nil {
+			d.sessions = tls.NewLRUClientSessionCache(0)
+		}
+		conf.ClientSessionCache = d.sessions
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), core.DefaultDialTimeout)
+	defer cancel()
+	qc, err := quic.DialAddrEarly(ctx, d.Addr, conf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	d.conn = qc
+	return qc, nil
+}
+
+func (d *Dialer) reset(qc *quic.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == qc {
+		d.conn = nil
+	}
+}
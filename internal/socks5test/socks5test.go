@@ -0,0 +1,279 @@
+// Package socks5test 提供在进程内测试 SOCKS5 服务端和基于它的程序的工具：
+// 在随机端口上启动服务端、TCP/UDP 回显服务，以及执行 CONNECT、UDP ASSOCIATE 握手并检查应答码的辅助函数
+// 所有函数只依赖 TB 接口，可用于 *testing.T、*testing.B 或自行实现的 TB
+package socks5test
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"socks5/internal/core"
+)
+
+// Timeout 辅助函数中每次握手和读写的时限
+var Timeout = 5 * time.Second
+
+// TB *testing.T 中本包用到的方法
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Fatalf(format string, args ...any)
+}
+
+// Option 在服务端开始监听前修改它
+type Option func(*core.Server)
+
+// WithAuth 要求用户名密码认证
+func WithAuth(user, password string) Option {
+	return func(s *core.Server) {
+		s.Method = core.MethodUsernamePassword
+		s.UserName, s.Password = user, password
+	}
+}
+
+// WithHandler 使用 h 处理请求
+func WithHandler(h core.Handler) Option {
+	return func(s *core.Server) {
+		s.Handle = h
+	}
+}
+
+// Server 运行中的服务端
+type Server struct {
+	*core.Server
+	Addr    string // 实际监听的 TCP 地址，如 127.0.0.1:41234
+	UDPAddr string // 实际绑定的 UDP 地址，不支持 UDP 时为空
+}
+
+// NewServer 在 127.0.0.1 的随机端口上启动服务端，应用 opts 后开始服务，就绪后返回；测试结束时关闭
+func NewServer(t TB, opts ...Option) *Server {
+	t.Helper()
+	s, err := core.NewClassicServer("127.0.0.1:0", "127.0.0.1", "", "", 0, 0, nil)
+	if err != nil {
+		t.Fatalf("socks5test: create server: %v", err)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	l, pc, err := s.Listen()
+	if err != nil {
+		t.Fatalf("socks5test: listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l, pc) }()
+	t.Cleanup(func() {
+		s.Shutdown()
+		<-done
+	})
+	select {
+	case <-s.Ready():
+	case err := <-done:
+		t.Fatalf("socks5test: serve: %v", err)
+	}
+	ts := &Server{Server: s, Addr: s.TCPAddr().String()}
+	if a := s.UDPAddr(); a != nil {
+		ts.UDPAddr = a.String()
+	}
+	return ts
+}
+
+// EchoTCPServer 启动 TCP 回显服务，返回它的地址，测试结束时关闭
+func EchoTCPServer(t TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("socks5test: echo listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 32*1024)
+				for {
+					n, err := c.Read(b)
+					if n > 0 {
+						c.Write(b[:n])
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// EchoUDPServer 启动 UDP 回显服务，返回它的地址，测试结束时关闭
+func EchoUDPServer(t TB) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("socks5test: echo listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		b := make([]byte, 64*1024)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(b[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// Auth 握手使用的用户名和密码，nil 表示只提供无认证方法
+type Auth struct {
+	User     string
+	Password string
+}
+
+// Handshake 连接 proxy 完成方法协商（auth 非 nil 时提供并执行用户名密码认证），发送 cmd 请求，
+// 返回连接和应答；协商或认证失败时测试失败，连接在测试结束时关闭
+func Handshake(t TB, proxy string, auth *Auth, cmd byte, dst string) (net.Conn, *core.Reply) {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", proxy, Timeout)
+	if err != nil {
+		t.Fatalf("socks5test: dial proxy: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(Timeout))
+	method := core.MethodNone
+	if auth != nil {
+		method = core.MethodUsernamePassword
+	}
+	if _, err := core.NewNegotiationRequest([]byte{method}).WriteTo(c); err != nil {
+		t.Fatalf("socks5test: write negotiation: %v", err)
+	}
+	nr, err := core.NewNegotiationReplyFrom(c)
+	if err != nil {
+		t.Fatalf("socks5test: read negotiation reply: %v", err)
+	}
+	if nr.Method != method {
+		t.Fatalf("socks5test: server selected method 0x%02x, want 0x%02x", nr.Method, method)
+	}
+	if auth != nil {
+		if _, err := core.NewUserPassNegotiationRequest([]byte(auth.User), []byte(auth.Password)).WriteTo(c); err != nil {
+			t.Fatalf("socks5test: write credentials: %v", err)
+		}
+		ur, err := core.NewUserPassNegotiationReplyFrom(c)
+		if err != nil {
+			t.Fatalf("socks5test: read auth reply: %v", err)
+		}
+		if ur.Status != core.UserPassStatusSuccess {
+			t.Fatalf("socks5test: authentication as %q failed", auth.User)
+		}
+	}
+	atyp, addr, port, err := core.ParseAddress(dst)
+	if err != nil {
+		t.Fatalf("socks5test: %v", err)
+	}
+	r := &core.Request{Ver: core.Ver, Cmd: cmd, Atyp: atyp, DstAddr: addr, DstPort: port}
+	if _, err := r.WriteTo(c); err != nil {
+		t.Fatalf("socks5test: write request: %v", err)
+	}
+	rp, err := core.NewReplyFrom(c)
+	if err != nil {
+		t.Fatalf("socks5test: read reply: %v", err)
+	}
+	c.SetDeadline(time.Time{})
+	return c, rp
+}
+
+// ExpectRep 发送 cmd 请求并检查应答码为 rep，返回连接供后续读写
+func ExpectRep(t TB, proxy string, auth *Auth, cmd byte, dst string, rep byte) net.Conn {
+	t.Helper()
+	c, rp := Handshake(t, proxy, auth, cmd, dst)
+	if rp.Rep != rep {
+		t.Fatalf("socks5test: %s %s: got %s, want %s", core.CmdName(cmd), dst, core.RepName(rp.Rep), core.RepName(rep))
+	}
+	return c
+}
+
+// Connect 经 proxy 向 dst 发起 CONNECT，要求成功，返回已建立的隧道
+func Connect(t TB, proxy string, auth *Auth, dst string) net.Conn {
+	t.Helper()
+	return ExpectRep(t, proxy, auth, core.CmdConnect, dst, core.RepSuccess)
+}
+
+// Associate 经 proxy 发起 UDP ASSOCIATE，要求成功，返回控制连接和中继地址
+// 应答中的地址未指定 IP 时使用 proxy 的 IP
+func Associate(t TB, proxy string, auth *Auth) (net.Conn, *net.UDPAddr) {
+	t.Helper()
+	c, rp := Handshake(t, proxy, auth, core.CmdUDP, "0.0.0.0:0")
+	if rp.Rep != core.RepSuccess {
+		t.Fatalf("socks5test: UDP ASSOCIATE: got %s, want RepSuccess", core.RepName(rp.Rep))
+	}
+	relay, err := net.ResolveUDPAddr("udp", rp.Address())
+	if err != nil {
+		t.Fatalf("socks5test: relay address %q: %v", rp.Address(), err)
+	}
+	if relay.IP.IsUnspecified() {
+		host, _, _ := net.SplitHostPort(proxy)
+		relay.IP = net.ParseIP(host)
+	}
+	return c, relay
+}
+
+// EchoTCP 在 c 上写入 msg 并读回同样长度的数据，内容不一致时测试失败
+func EchoTCP(t TB, c net.Conn, msg []byte) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(Timeout))
+	defer c.SetDeadline(time.Time{})
+	if _, err := c.Write(msg); err != nil {
+		t.Fatalf("socks5test: write: %v", err)
+	}
+	got := make([]byte, len(msg))
+	for n := 0; n < len(got); {
+		m, err := c.Read(got[n:])
+		if err != nil {
+			t.Fatalf("socks5test: read after %d bytes: %v", n, err)
+		}
+		n += m
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("socks5test: echoed %q, want %q", got, msg)
+	}
+}
+
+// EchoUDP 经中继 relay 向 dst 发送 msg，等待回显的数据报并检查来源和内容
+func EchoUDP(t TB, relay *net.UDPAddr, dst string, msg []byte) {
+	t.Helper()
+	uc, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		t.Fatalf("socks5test: dial relay: %v", err)
+	}
+	defer uc.Close()
+	atyp, addr, port, err := core.ParseAddress(dst)
+	if err != nil {
+		t.Fatalf("socks5test: %v", err)
+	}
+	if atyp == core.ATYPDomain {
+		addr = addr[1:]
+	}
+	if _, err := uc.Write(core.NewDatagram(atyp, addr, port, msg).Bytes()); err != nil {
+		t.Fatalf("socks5test: send datagram: %v", err)
+	}
+	uc.SetReadDeadline(time.Now().Add(Timeout))
+	b := make([]byte, 64*1024)
+	n, err := uc.Read(b)
+	if err != nil {
+		t.Fatalf("socks5test: read datagram: %v", err)
+	}
+	d, err := core.NewDatagramFromBytes(b[:n])
+	if err != nil {
+		t.Fatalf("socks5test: parse datagram: %v", err)
+	}
+	if !bytes.Equal(d.Data, msg) {
+		t.Fatalf("socks5test: echoed %q from %s, want %q", d.Data, d.Address(), msg)
+	}
+}
@@ -0,0 +1,92 @@
+package socks5test_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"socks5/internal/core"
+	"socks5/internal/socks5test"
+)
+
+// fakeTB 不依赖 testing 的 TB 实现，Fatalf 以 panic 结束当前辅助函数
+type fakeTB struct {
+	cleanups []func()
+	failed   string
+}
+
+type fatal struct{}
+
+func (f *fakeTB) Helper()           {}
+func (f *fakeTB) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.failed = fmt.Sprintf(format, args...)
+	panic(fatal{})
+}
+
+func (f *fakeTB) close() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+// run 执行 fn，返回 fn 中 Fatalf 的消息
+func (f *fakeTB) run(fn func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fatal); !ok {
+				panic(r)
+			}
+			msg = f.failed
+		}
+	}()
+	fn()
+	return ""
+}
+
+func TestHelpersWithCustomTB(t *testing.T) {
+	tb := &fakeTB{}
+	defer tb.close()
+	var ts *socks5test.Server
+	if msg := tb.run(func() { ts = socks5test.NewServer(tb, socks5test.WithAuth("u", "p")) }); msg != "" {
+		t.Fatal(msg)
+	}
+	echo := socks5test.EchoTCPServer(tb)
+	auth := &socks5test.Auth{User: "u", Password: "p"}
+	if msg := tb.run(func() { socks5test.EchoTCP(tb, socks5test.Connect(tb, ts.Addr, auth, echo), []byte("hi")) }); msg != "" {
+		t.Fatal(msg)
+	}
+
+	// 失败通过 Fatalf 报告
+	msg := tb.run(func() { socks5test.Connect(tb, ts.Addr, &socks5test.Auth{User: "u", Password: "bad"}, echo) })
+	if !strings.Contains(msg, "authentication") {
+		t.Fatalf("wrong password reported %q", msg)
+	}
+	msg = tb.run(func() { socks5test.ExpectRep(tb, ts.Addr, auth, core.CmdBind, echo, core.RepSuccess) })
+	if !strings.Contains(msg, "RepCommandNotSupported") {
+		t.Fatalf("unsupported command reported %q", msg)
+	}
+
+	// 清理函数关闭服务端
+	tb.close()
+	tb.cleanups = nil
+	if c, err := net.Dial("tcp", ts.Addr); err == nil {
+		c.Close()
+		t.Fatal("server still accepting after cleanup")
+	}
+}
+
+func TestAssociateEcho(t *testing.T) {
+	ts := socks5test.NewServer(t)
+	if ts.UDPAddr == "" {
+		t.Fatal("UDPAddr is empty")
+	}
+	echo := socks5test.EchoUDPServer(t)
+	ctl, relay := socks5test.Associate(t, ts.Addr, nil)
+	defer ctl.Close()
+	if relay.String() != ts.UDPAddr {
+		t.Fatalf("relay %s, want %s", relay, ts.UDPAddr)
+	}
+	socks5test.EchoUDP(t, relay, echo, []byte("ping"))
+}